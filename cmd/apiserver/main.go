@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/VitalyCone/websocket-messenger/internal/app/apiserver"
+	"github.com/VitalyCone/websocket-messenger/internal/app/ratelimit"
 	"github.com/VitalyCone/websocket-messenger/internal/app/service"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
@@ -27,13 +28,39 @@ func init() {
 }
 
 type ConfigData struct {
-	ApiAddr            string `yaml:"api_addr"`
-	DbUrl              string `yaml:"database_url"`
-	DbDockerUrl        string `yaml:"database_docker_url"`
-	RedisHostUrl       string `yaml:"redis_host"`
-	RedisDockerHostUrl string `yaml:"redis_docker_host"`
-	TestDbUrl          string `yaml:"test_database_url"`
-	TestDbDockerUrl    string `yaml:"test_database_docker_url"`
+	ApiAddr            string   `yaml:"api_addr"`
+	DbUrl              string   `yaml:"database_url"`
+	DbDockerUrl        string   `yaml:"database_docker_url"`
+	RedisHostUrl       string   `yaml:"redis_host"`
+	RedisDockerHostUrl string   `yaml:"redis_docker_host"`
+	TestDbUrl          string   `yaml:"test_database_url"`
+	TestDbDockerUrl    string   `yaml:"test_database_docker_url"`
+	SMTPHost           string   `yaml:"smtp_host"`
+	SMTPPort           string   `yaml:"smtp_port"`
+	SMTPUsername       string   `yaml:"smtp_username"`
+	SMTPPassword       string   `yaml:"smtp_password"`
+	SMTPFrom           string   `yaml:"smtp_from"`
+	Admins             []string `yaml:"admins"`
+	// Content filters of outgoing messages
+	FilterMaxLength            int      `yaml:"filter_max_length"`
+	FilterBannedWords          []string `yaml:"filter_banned_words"`
+	FilterBannedWordsAction    string   `yaml:"filter_banned_words_action"`
+	FilterBlockedDomains       []string `yaml:"filter_blocked_domains"`
+	FilterBlockedDomainsAction string   `yaml:"filter_blocked_domains_action"`
+	FilterRepeatLimit          int      `yaml:"filter_repeat_limit"`
+	FilterRepeatWindow         string   `yaml:"filter_repeat_window"`
+	FilterRepeatAction         string   `yaml:"filter_repeat_action"`
+	// Link previews, zero values take defaults
+	PreviewWorkers  int    `yaml:"preview_workers"`
+	PreviewTimeout  string `yaml:"preview_timeout"`
+	PreviewMaxBytes int64  `yaml:"preview_max_bytes"`
+	// Rate limits per minute, 0 turns a limit off
+	RateLimitAPI        int `yaml:"rate_limit_api"`
+	RateLimitAuth       int `yaml:"rate_limit_auth"`
+	RateLimitMessages   int `yaml:"rate_limit_messages"`
+	RateLimitConnection int `yaml:"rate_limit_connection"`
+	// Reverse proxies allowed to set X-Forwarded-For, none by default
+	TrustedProxies []string `yaml:"trusted_proxies"`
 }
 
 type TokenData struct {
@@ -92,7 +119,45 @@ func main() {
 	}
 
 	configServer = apiserver.NewConfig(cfg.ApiAddr, dbUrl, testDbUrl)
+	configServer.APIRateLimit = ratelimit.PerMinute(cfg.RateLimitAPI)
+	configServer.AuthRateLimit = ratelimit.PerMinute(cfg.RateLimitAuth)
+	configServer.MessageRateLimit = ratelimit.PerMinute(cfg.RateLimitMessages)
+	configServer.ConnectionRateLimit = ratelimit.PerMinute(cfg.RateLimitConnection)
+	configServer.TrustedProxies = cfg.TrustedProxies
 	configService = service.NewConfig(dbUrl, redisUrl, token.Token)
+	configService.SMTP = service.SMTPConfig{
+		Host:     cfg.SMTPHost,
+		Port:     cfg.SMTPPort,
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+		From:     cfg.SMTPFrom,
+	}
+	configService.Admins = cfg.Admins
+	configService.Filters = service.FilterConfig{
+		MaxLength:            cfg.FilterMaxLength,
+		BannedWords:          cfg.FilterBannedWords,
+		BannedWordsAction:    cfg.FilterBannedWordsAction,
+		BlockedDomains:       cfg.FilterBlockedDomains,
+		BlockedDomainsAction: cfg.FilterBlockedDomainsAction,
+		RepeatLimit:          cfg.FilterRepeatLimit,
+		RepeatAction:         cfg.FilterRepeatAction,
+	}
+	if cfg.FilterRepeatWindow != "" {
+		configService.Filters.RepeatWindow, err = time.ParseDuration(cfg.FilterRepeatWindow)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	configService.Previews = service.PreviewConfig{
+		Workers:  cfg.PreviewWorkers,
+		MaxBytes: cfg.PreviewMaxBytes,
+	}
+	if cfg.PreviewTimeout != "" {
+		configService.Previews.Timeout, err = time.ParseDuration(cfg.PreviewTimeout)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+	}
 	service := service.NewService(configService)
 	server := apiserver.NewAPIServer(configServer, service)
 
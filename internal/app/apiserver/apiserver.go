@@ -13,11 +13,20 @@ import (
 	//_ "github.com/swaggo/swag/example/basic/docs"
 )
 
+const (
+	accountPurgeInterval = time.Hour
+	scheduledMessagesInterval = 5 * time.Second
+	expiredMessagesInterval = 30 * time.Second
+	dataExportsInterval = 5 * time.Second
+)
+
 type APIServer struct {
 	config *Config
 	router *gin.Engine
 	services *service.Service
 	srv *http.Server
+	// stops background workers
+	cancel context.CancelFunc
 }
 
 func NewAPIServer(config *Config, service *service.Service) *APIServer {
@@ -29,6 +38,11 @@ func NewAPIServer(config *Config, service *service.Service) *APIServer {
 }
 
 func (s *APIServer) Start(tokenSignedString string) error {
+	// rate limits of IP addresses rely on the client address, only trusted
+	// proxies may set it
+	if err := s.router.SetTrustedProxies(s.config.TrustedProxies); err != nil{
+		return err
+	}
 
 	s.router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},                                       // Разрешенные источники
@@ -44,9 +58,23 @@ func (s *APIServer) Start(tokenSignedString string) error {
 	}
 
 	hub := chat.NewHub(s.services)
+	hub.UseRateLimits(s.config.MessageRateLimit, s.config.ConnectionRateLimit)
 	go hub.Run()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go s.services.Account.RunDeletionPurger(ctx, accountPurgeInterval, hub.DisconnectUser)
+	go s.services.Account.RunExportBuilder(ctx, dataExportsInterval)
+	go s.services.Schedule.RunScheduler(ctx, scheduledMessagesInterval, hub.DeliverMessage, hub.NotifyScheduledFailed)
+	go s.services.Message.RunExpiryPurger(ctx, expiredMessagesInterval, hub.NotifyMessagesExpired)
+	go s.services.Preview.RunPreviewWorkers(ctx, hub.NotifyMessageUpdated)
 	
 	endpoint := endpoints.NewEndpoints(s.services, s.router, hub)
+	endpoint.UseRateLimits(endpoints.RateLimits{
+		API:      s.config.APIRateLimit,
+		Auth:     s.config.AuthRateLimit,
+		Messages: s.config.MessageRateLimit,
+	})
 
 	endpoint.InitRoutes()
 	
@@ -61,6 +89,9 @@ func (s *APIServer) Start(tokenSignedString string) error {
 }
 
 func (s *APIServer) Close(ctx context.Context) error {
+	if s.cancel != nil{
+		s.cancel()
+	}
 	err := s.srv.Shutdown(ctx)
 	if err != nil{
 		return err
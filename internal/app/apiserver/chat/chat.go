@@ -1,10 +1,13 @@
 package chat
 
 import (
+	"context"
+	"math"
 	"net/http"
 	"time"
 
 	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/ratelimit"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
@@ -38,6 +41,9 @@ type Client struct {
 	Username string
 	Conn     *websocket.Conn
 	send     chan model.MessageWS
+	// Replies of the connection to its own messages, never closed
+	notices  chan model.MessageWS
+	limiter  *ratelimit.LocalLimiter
 	hub      *Hub
 }
 
@@ -47,6 +53,8 @@ func NewClient(id string, conn *websocket.Conn, hub *Hub) *Client {
 		Username: id, 
 		Conn: conn, 
 		send: make(chan model.MessageWS, 256), 
+		notices: make(chan model.MessageWS, 16),
+		limiter: ratelimit.NewLocalLimiter(),
 		hub: hub,
 	}
 }
@@ -71,11 +79,48 @@ func (c *Client) Read() {
 			logrus.Errorf("Error: %v", err)
 			break
 		}
+		if retryAfter, limited := c.rateLimited(); limited {
+			c.notify(model.MessageWS{
+				Type:    "rate_limited",
+				ChatID:  msg.ChatID,
+				Content: "too many messages",
+				Data:    gin.H{"retryAfter": int(math.Ceil(retryAfter.Seconds()))},
+			})
+			continue
+		}
 		c.hub.broadcast <- msg
 	}
 	
 }
 
+// rateLimited checks limits of the connection and of the user on every node.
+// Messages are let through if the shared limiter fails.
+func (c *Client) rateLimited() (time.Duration, bool) {
+	ctx := context.Background()
+	result, _ := c.limiter.Allow(ctx, "", c.hub.connectionLimit)
+	if !result.Allowed {
+		return result.RetryAfter, true
+	}
+
+	if c.hub.service.RateLimiter == nil {
+		return 0, false
+	}
+	result, err := c.hub.service.RateLimiter.Allow(ctx, ratelimit.UserKey(MessagesScope, c.Username), c.hub.userLimit)
+	if err != nil {
+		logrus.Errorf("failed to check rate limit of %s : %v", c.Username, err)
+		return 0, false
+	}
+	return result.RetryAfter, !result.Allowed
+}
+
+// notify sends a reply to this connection only, dropping it if the connection lags
+func (c *Client) notify(message model.MessageWS) {
+	select {
+	case c.notices <- message:
+	default:
+	}
+}
+
 // Client goroutine to write messages to client
 func (c *Client) Write() {
 	ticker := time.NewTicker(pingPeriod)
@@ -99,6 +144,11 @@ func (c *Client) Write() {
 					break
 				}
 			}
+		case notice := <-c.notices:
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.Conn.WriteJSON(notice); err != nil {
+				logrus.Println("Error: ", err)
+			}
 		case <-ticker.C:
 			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
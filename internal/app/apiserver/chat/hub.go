@@ -1,14 +1,24 @@
 package chat
 
 import (
+	"errors"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
 	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/ratelimit"
 	"github.com/VitalyCone/websocket-messenger/internal/app/service"
+	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
 
 // Hub is a struct that holds all the clients and the messages that are sent to them
 type Hub struct {
 	service *service.Service
+	// Guards clients for readers outside of Run.
+	mu sync.RWMutex
 	// Registered clients.
 	clients map[string]map[*Client]bool
 	//Unregistered clients.
@@ -17,8 +27,25 @@ type Hub struct {
 	register chan *Client
 	// Inbound messages from the clients.
 	broadcast chan model.MessageWS
+	// Server events for the recipients listed in the event.
+	events chan model.MessageWS
+	// Usernames whose connections have to be closed.
+	disconnect chan string
+	// Limits of messages of a user on every node and of a single connection.
+	userLimit       ratelimit.Limit
+	connectionLimit ratelimit.Limit
+	// Polls whose tallies are waiting to be pushed.
+	pollsMu      sync.Mutex
+	pendingPolls map[uint]bool
 }
 
+// MessagesScope is the rate limit scope of messages sent by users
+const MessagesScope = "messages"
+
+// Tallies of a poll are pushed at most once per pollUpdateDelay, so votes
+// on a popular poll don't flood every client
+const pollUpdateDelay = time.Second
+
 func NewHub(service *service.Service) *Hub {
 	return &Hub{
 		service: service,
@@ -26,9 +53,19 @@ func NewHub(service *service.Service) *Hub {
 		unregister: make(chan *Client),
 		register:   make(chan *Client),
 		broadcast:  make(chan model.MessageWS),
+		events:     make(chan model.MessageWS, 256),
+		disconnect: make(chan string),
+		pendingPolls: make(map[uint]bool),
 	}
 }
 
+// UseRateLimits sets limits of inbound messages, it has to be called before Run.
+// The user limit is shared with POST /messages.
+func (h *Hub) UseRateLimits(perUser, perConnection ratelimit.Limit) {
+	h.userLimit = perUser
+	h.connectionLimit = perConnection
+}
+
 // Core function to run the hub
 func (h *Hub) Run() {
 	for {
@@ -43,6 +80,12 @@ func (h *Hub) Run() {
 		case message := <-h.broadcast:
 			//Check if the message is a type of "message"
 			h.HandleMessage(message)
+			// Deliver a server event.
+		case event := <-h.events:
+			h.HandleEvent(event)
+			// Close all connections of a user.
+		case username := <-h.disconnect:
+			h.RemoveUser(username)
 
 		}
 	}
@@ -50,23 +93,375 @@ func (h *Hub) Run() {
 
 // function check if room exists and if not create it and add client to it
 func (h *Hub) RegisterNewClient(client *Client) {
+	h.mu.Lock()
 	connections := h.clients[client.Username]
 	if connections == nil {
 		connections = make(map[*Client]bool)
 		h.clients[client.Username] = connections
 	}
 	h.clients[client.Username][client] = true
+	h.mu.Unlock()
 
 	logrus.Println("Size of clients: ", len(h.clients[client.Username]))
+	if len(h.clients[client.Username]) == 1 {
+		h.broadcastPresence(client.Username, "online")
+	}
 }
 
 // function to remvoe client from room
 func (h *Hub) RemoveClient(client *Client) {
-	if _, ok := h.clients[client.Username]; ok {
+	h.mu.Lock()
+	if _, ok := h.clients[client.Username][client]; ok {
 		delete(h.clients[client.Username], client)
 		close(client.send)
 		logrus.Println("Removed client")
 	}
+	_, ok := h.clients[client.Username]
+	wentOffline := ok && len(h.clients[client.Username]) == 0
+	if wentOffline {
+		delete(h.clients, client.Username)
+	}
+	h.mu.Unlock()
+
+	if wentOffline {
+		if err := h.service.User.UpdateLastSeen(client.Username); err != nil {
+			logrus.Errorf("failed to update last seen of %s : %v", client.Username, err)
+		}
+		h.broadcastPresence(client.Username, "offline")
+	}
+}
+
+// function to tell online companions of a user that they went online or offline.
+// Users blocked by the user and the ones last seen privacy settings hide it from
+// don't get their presence.
+func (h *Hub) broadcastPresence(username, status string) {
+	companions, err := h.service.Chat.GetCompanions(username)
+	if err != nil {
+		logrus.Errorf("failed to get companions of %s : %v", username, err)
+		return
+	}
+
+	blocked, err := h.service.Block.GetBlockedUsernames(username)
+	if err != nil {
+		logrus.Errorf("failed to get blocked users of %s : %v", username, err)
+		return
+	}
+
+	online := make([]string, 0, len(companions))
+	for _, companion := range companions {
+		if len(h.clients[companion]) == 0 || slices.Contains(blocked, companion) {
+			continue
+		}
+		online = append(online, companion)
+	}
+	if len(online) == 0 {
+		return
+	}
+
+	allowed, _, err := h.service.Privacy.SplitAudience(username, model.PrivacyLastSeen, online)
+	if err != nil {
+		logrus.Errorf("failed to apply privacy settings of %s : %v", username, err)
+		return
+	}
+
+	presence := model.MessageWS{
+		Type:    "presence",
+		Sender:  username,
+		Content: status,
+	}
+	for _, companion := range allowed {
+		h.sendToUser(companion, presence)
+	}
+}
+
+// function to close every connection of a user
+func (h *Hub) RemoveUser(username string) {
+	for client := range h.clients[username] {
+		h.RemoveClient(client)
+	}
+}
+
+// IsOnline reports whether the user has at least one open connection.
+// Safe to call from any goroutine.
+func (h *Hub) IsOnline(username string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients[username]) > 0
+}
+
+// SendEvent queues a server event for delivery to event.Recipients.
+// Safe to call from any goroutine.
+func (h *Hub) SendEvent(event model.MessageWS) {
+	h.events <- event
+}
+
+// DisconnectUser closes every websocket connection of the user.
+// Safe to call from any goroutine.
+func (h *Hub) DisconnectUser(username string) {
+	h.disconnect <- username
+}
+
+// DeliverMessage sends a message created outside of the hub, like a scheduled one,
+// to every member of its chat. Safe to call from any goroutine.
+func (h *Hub) DeliverMessage(message model.Message) {
+	modelChat, err := h.service.Chat.GetChat(message.ChatID)
+	if err != nil {
+		logrus.Errorf("failed to get chat for %d : %v", message.ChatID, err)
+		return
+	}
+
+	recipients, err := h.messageRecipients(modelChat, message)
+	if err != nil {
+		logrus.Errorf("failed to get recipients of %d : %v", message.ID, err)
+		return
+	}
+	loud, muted := h.splitMuted(message.ChatID, recipients)
+	frame := model.MessageWS{
+		Type:         "message",
+		Sender:       message.Sender.Username,
+		Recipients:   loud,
+		ChatID:       message.ChatID,
+		ThreadRootID: message.ThreadRootID,
+		Content:      message.Content,
+		Entities:     entitiesToResponse(message),
+		Data:         messageData(message),
+	}
+	if len(loud) > 0 {
+		h.SendEvent(frame)
+	}
+	if len(muted) > 0 {
+		frame.Recipients = muted
+		frame.Silent = true
+		h.SendEvent(frame)
+	}
+	if mention, ok := mentionEvent(message); ok {
+		h.SendEvent(mention)
+	}
+}
+
+// function to get the "mention" event for every user mentioned in the message.
+// Mentions are delivered apart from the message, even in muted chats.
+// Run delivers it inline, SendEvent from there would block on a full events queue.
+func mentionEvent(message model.Message) (model.MessageWS, bool) {
+	recipients := make([]string, 0, len(message.Mentions))
+	for _, mention := range message.Mentions {
+		if !slices.Contains(recipients, mention.User.Username) {
+			recipients = append(recipients, mention.User.Username)
+		}
+	}
+	if len(recipients) == 0 {
+		return model.MessageWS{}, false
+	}
+
+	return model.MessageWS{
+		Type:         "mention",
+		Sender:       message.Sender.Username,
+		Recipients:   recipients,
+		ChatID:       message.ChatID,
+		ThreadRootID: message.ThreadRootID,
+		Content:      message.Content,
+		Entities:     entitiesToResponse(message),
+		Data:         gin.H{"id": message.ID},
+	}, true
+}
+
+// function to get formatting of the content of a message for a frame
+func entitiesToResponse(message model.Message) []model.EntityResponse {
+	entities := make([]model.EntityResponse, len(message.Entities))
+	for i := range message.Entities {
+		entities[i] = message.Entities[i].ToResponse()
+	}
+	return entities
+}
+
+// function to split recipients of a new message of the chat into the ones who
+// get notified and the ones who muted the chat
+func (h *Hub) splitMuted(chatID uint, recipients []string) ([]string, []string) {
+	mutedUsernames, err := h.service.Chat.GetMutedUsernames(chatID)
+	if err != nil {
+		logrus.Errorf("failed to get muted members of %d : %v", chatID, err)
+		return recipients, nil
+	}
+
+	loud := make([]string, 0, len(recipients))
+	muted := make([]string, 0)
+	for _, recipient := range recipients {
+		if slices.Contains(mutedUsernames, recipient) {
+			muted = append(muted, recipient)
+		} else {
+			loud = append(loud, recipient)
+		}
+	}
+	return loud, muted
+}
+
+// function to get the payload of a frame of a new message
+func messageData(message model.Message) gin.H {
+	data := gin.H{"id": message.ID}
+	if message.Poll != nil {
+		data["poll"] = message.Poll.ToResponse(0)
+	}
+	return data
+}
+
+// function to get usernames a new message is delivered to: members of its chat,
+// or only the ones subscribed to the thread for replies
+func (h *Hub) messageRecipients(modelChat model.Chat, message model.Message) ([]string, error) {
+	if message.ThreadRootID != nil {
+		return h.service.Thread.GetSubscribers(*message.ThreadRootID)
+	}
+
+	recipients := make([]string, 0, len(modelChat.Users))
+	for _, user := range modelChat.Users {
+		recipients = append(recipients, user.Username)
+	}
+	return recipients, nil
+}
+
+// NotifyScheduledFailed tells the sender that their scheduled message could not be sent.
+// Safe to call from any goroutine.
+func (h *Hub) NotifyScheduledFailed(scheduled model.ScheduledMessage) {
+	h.SendEvent(model.MessageWS{
+		Type:       "scheduled_failed",
+		Recipients: []string{scheduled.Sender.Username},
+		ChatID:     scheduled.ChatID,
+		Content:    scheduled.Error,
+		Data:       scheduled.ToResponse(),
+	})
+}
+
+// NotifyMessagesExpired tells members of the chat that its disappearing messages were deleted.
+// Safe to call from any goroutine.
+func (h *Hub) NotifyMessagesExpired(chatID uint, ids []uint) {
+	modelChat, err := h.service.Chat.GetChat(chatID)
+	if err != nil {
+		logrus.Errorf("failed to get chat for %d : %v", chatID, err)
+		return
+	}
+
+	recipients := make([]string, 0, len(modelChat.Users))
+	for _, user := range modelChat.Users {
+		recipients = append(recipients, user.Username)
+	}
+	h.SendEvent(model.MessageWS{
+		Type:       "messages_expired",
+		Recipients: recipients,
+		ChatID:     chatID,
+		Data:       gin.H{"ids": ids},
+	})
+}
+
+// NotifyChatUpdated tells members of the chat that its name, description, avatar or
+// handle changed, the full avatar is left to the chat endpoint. Users of the chat have
+// to be preloaded. Safe to call from any goroutine.
+func (h *Hub) NotifyChatUpdated(modelChat model.Chat) {
+	recipients := make([]string, 0, len(modelChat.Users))
+	for _, user := range modelChat.Users {
+		recipients = append(recipients, user.Username)
+	}
+	h.SendEvent(model.MessageWS{
+		Type:       "chat_updated",
+		Recipients: recipients,
+		ChatID:     modelChat.ID,
+		Data: gin.H{
+			"id":          modelChat.ID,
+			"name":        modelChat.Name,
+			"description": modelChat.Description,
+			"handle":      modelChat.Handle,
+			"avatarThumb": modelChat.AvatarThumb,
+		},
+	})
+}
+
+// NotifyMessageUpdated tells recipients of the message that its link preview was fetched.
+// Safe to call from any goroutine.
+func (h *Hub) NotifyMessageUpdated(message model.Message) {
+	modelChat, err := h.service.Chat.GetChat(message.ChatID)
+	if err != nil {
+		logrus.Errorf("failed to get chat for %d : %v", message.ChatID, err)
+		return
+	}
+	recipients, err := h.messageRecipients(modelChat, message)
+	if err != nil {
+		logrus.Errorf("failed to get recipients of message %d : %v", message.ID, err)
+		return
+	}
+
+	var preview *model.LinkPreviewResponse
+	if message.Preview != nil {
+		previewResp := message.Preview.ToResponse()
+		preview = &previewResp
+	}
+	h.SendEvent(model.MessageWS{
+		Type:       "message_updated",
+		Recipients: recipients,
+		ChatID:     message.ChatID,
+		Data:       gin.H{"id": message.ID, "preview": preview},
+	})
+}
+
+// NotifyPollUpdated pushes tallies of the poll to recipients of its message.
+// Updates within pollUpdateDelay are sent at once. Safe to call from any goroutine.
+func (h *Hub) NotifyPollUpdated(pollID uint) {
+	h.pollsMu.Lock()
+	defer h.pollsMu.Unlock()
+	if h.pendingPolls[pollID] {
+		return
+	}
+	h.pendingPolls[pollID] = true
+
+	time.AfterFunc(pollUpdateDelay, func() {
+		h.pollsMu.Lock()
+		delete(h.pendingPolls, pollID)
+		h.pollsMu.Unlock()
+		h.sendPollUpdate(pollID)
+	})
+}
+
+// function to send the current tallies of the poll
+func (h *Hub) sendPollUpdate(pollID uint) {
+	message, err := h.service.Poll.GetPollMessage(pollID)
+	if err != nil {
+		if !errors.Is(err, service.ErrPollNotFound) {
+			logrus.Errorf("failed to get poll %d : %v", pollID, err)
+		}
+		return
+	}
+	modelChat, err := h.service.Chat.GetChat(message.ChatID)
+	if err != nil {
+		logrus.Errorf("failed to get chat for %d : %v", message.ChatID, err)
+		return
+	}
+	recipients, err := h.messageRecipients(modelChat, message)
+	if err != nil {
+		logrus.Errorf("failed to get recipients of message %d : %v", message.ID, err)
+		return
+	}
+
+	h.SendEvent(model.MessageWS{
+		Type:       "poll_updated",
+		Recipients: recipients,
+		ChatID:     message.ChatID,
+		Data:       gin.H{"id": message.ID, "poll": message.Poll.ToResponse(0)},
+	})
+}
+
+// function to deliver a server event to its recipients
+func (h *Hub) HandleEvent(event model.MessageWS) {
+	for _, recipient := range event.Recipients {
+		h.sendToUser(recipient, event)
+	}
+}
+
+// function to send a message to every connection of a user
+func (h *Hub) sendToUser(username string, message model.MessageWS) {
+	for client := range h.clients[username] {
+		select {
+		case client.send <- message:
+		default:
+			h.RemoveClient(client)
+		}
+	}
 }
 
 // function to handle message based on type of message
@@ -81,41 +476,59 @@ func (h *Hub) HandleMessage(message model.MessageWS) {
 	err = h.service.Message.CreateMessage(&modelMessage)
 	if err != nil {
 		logrus.Errorf("failed to create message for %d : %v", message.ChatID, err)
+		var slowModeErr *service.SlowModeError
+		if errors.As(err, &slowModeErr) {
+			h.sendToUser(message.Sender, model.MessageWS{
+				Type:    "rate_limited",
+				ChatID:  message.ChatID,
+				Content: err.Error(),
+				Data:    gin.H{"retryAfter": int(math.Ceil(slowModeErr.Cooldown.Seconds()))},
+			})
+			return
+		}
+		h.sendToUser(message.Sender, model.MessageWS{
+			Type:    "error",
+			ChatID:  message.ChatID,
+			Content: err.Error(),
+		})
+		return
 	}
-	
-	for _, user := range modelChat.Users {
-		if user.Username != message.Sender {
-			message.Recipients = append(message.Recipients, user.Username)
+	// content filters may have masked the message, markup is parsed into entities
+	message.Content = modelMessage.Content
+	message.ThreadRootID = modelMessage.ThreadRootID
+	message.Entities = entitiesToResponse(modelMessage)
+
+	recipients, err := h.messageRecipients(modelChat, modelMessage)
+	if err != nil {
+		logrus.Errorf("failed to get recipients of %d : %v", modelMessage.ID, err)
+		return
+	}
+	for _, recipient := range recipients {
+		if recipient != message.Sender {
+			message.Recipients = append(message.Recipients, recipient)
 		}
 	}
+	_, muted := h.splitMuted(message.ChatID, message.Recipients)
 	//Check if the message is a type of "message"
 	if message.Type == "message" {
 		for _, recipient := range message.Recipients   {
-			clients := h.clients[recipient]
-			for client := range clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients[message.Sender], client)
-				}
-			}
+			frame := message
+			frame.Silent = slices.Contains(muted, recipient)
+			h.sendToUser(recipient, frame)
+		}
+		if mention, ok := mentionEvent(modelMessage); ok {
+			h.HandleEvent(mention)
 		}
 	}
 
-	//Check if the message is a type of "notification"
+	//Check if the message is a type of "notification", members who muted the chat don't get them
 	if message.Type == "notification" {
 		for _, recipient := range message.Recipients {
-			logrus.Println("Notification: ", message.Content)
-			clients := h.clients[recipient]
-			for client := range clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients[recipient], client)
-				}
+			if slices.Contains(muted, recipient) {
+				continue
 			}
+			logrus.Println("Notification: ", message.Content)
+			h.sendToUser(recipient, message)
 		}
 	}
 
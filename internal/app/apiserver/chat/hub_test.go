@@ -0,0 +1,65 @@
+package chat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/service"
+	"github.com/stretchr/testify/assert"
+)
+
+type stubChatService struct {
+	service.Chat
+	chat model.Chat
+}
+
+func (s stubChatService) GetChat(id uint) (model.Chat, error) {
+	return s.chat, nil
+}
+
+func (s stubChatService) GetMutedUsernames(chatID uint) ([]string, error) {
+	return nil, nil
+}
+
+type stubMessageService struct {
+	service.Message
+	mentioned model.User
+}
+
+func (s stubMessageService) CreateMessage(message *model.Message) error {
+	message.ID = 1
+	message.Mentions = []model.MessageMention{{User: s.mentioned}}
+	return nil
+}
+
+func TestHandleMessage_MentionWithFullEvents(t *testing.T) {
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	hub := NewHub(&service.Service{
+		Chat:    stubChatService{chat: model.Chat{Users: []model.User{alice, bob}}},
+		Message: stubMessageService{mentioned: bob},
+	})
+	bobClient := &Client{Username: "bob", send: make(chan model.MessageWS, 256), hub: hub}
+	hub.clients["bob"] = map[*Client]bool{bobClient: true}
+
+	// nothing drains the events, as when Run is busy handling the message
+	for len(hub.events) < cap(hub.events) {
+		hub.events <- model.MessageWS{Type: "presence"}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		hub.HandleMessage(model.MessageWS{Type: "message", Sender: "alice", ChatID: 1, Content: "hi @bob"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("HandleMessage blocked on the events queue")
+	}
+
+	assert.Len(t, bobClient.send, 2)
+	assert.Equal(t, "message", (<-bobClient.send).Type)
+	assert.Equal(t, "mention", (<-bobClient.send).Type)
+}
@@ -1,9 +1,19 @@
 package apiserver
 
+import "github.com/VitalyCone/websocket-messenger/internal/app/ratelimit"
+
 type Config struct {
 	ApiAddr         string `toml:"bind_addr"`
 	DatabaseURL     string `toml:"db_addr"`
 	TestDatabaseURL string
+	// Rate limits, zero limits turn limiting off
+	APIRateLimit        ratelimit.Limit // requests of a user or an IP address
+	AuthRateLimit       ratelimit.Limit // logins, registrations and password resets of an IP address
+	MessageRateLimit    ratelimit.Limit // messages of a user over REST and websocket
+	ConnectionRateLimit ratelimit.Limit // messages of a single websocket connection
+	// Proxies whose X-Forwarded-For is taken as the client address, IPs or CIDRs.
+	// None by default, the address of the connection is used.
+	TrustedProxies []string
 }
 
 func NewConfig(apiAddr, dbUrl, TestDatabaseURL string) *Config {
@@ -0,0 +1,215 @@
+package endpoints
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// @Summary Delete account
+// @Schemes
+// @Description Schedule deletion of the current account. It can be cancelled until the grace period ends
+// @Security ApiKeyAuth
+// @Tags User
+// @Accept json
+// @Produce json
+// @Param deleteAccountDto body model.DeleteAccountDto true "password confirmation"
+// @Success 202 {object} model.AccountDeletionResponse
+// @Failure 400,401,403 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/account [DELETE]
+func (ep *Endpoints) DeleteAccount(g *gin.Context) {
+	var deleteAccountDto model.DeleteAccountDto
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	if err := g.BindJSON(&deleteAccountDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := deleteAccountDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	deleteAt, err := ep.services.Account.ScheduleDeletion(username, deleteAccountDto.Password)
+	if err != nil {
+		if errors.Is(err, service.ErrWrongPassword) {
+			newErrorResponse(g, http.StatusForbidden, "password is incorrect")
+			return
+		}
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusAccepted, model.AccountDeletionResponse{DeletionScheduledAt: deleteAt})
+}
+
+// @Summary Cancel account deletion
+// @Schemes
+// @Description Cancel a scheduled deletion of the current account
+// @Security ApiKeyAuth
+// @Tags User
+// @Produce json
+// @Success 200 {object} statusResponse
+// @Failure 401,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/account/deletion/cancel [POST]
+func (ep *Endpoints) CancelAccountDeletion(g *gin.Context) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if err := ep.services.Account.CancelDeletion(username); err != nil {
+		if errors.Is(err, service.ErrDeletionNotScheduled) {
+			newErrorResponse(g, http.StatusNotFound, err.Error())
+			return
+		}
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, statusResponse{"account deletion cancelled"})
+}
+
+// @Summary Request data export
+// @Schemes
+// @Description Start building a zip archive with the profile, chats and messages of the current user. Only one export is built at a time
+// @Security ApiKeyAuth
+// @Tags User
+// @Produce json
+// @Success 202 {object} model.DataExportResponse
+// @Failure 401,409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/account/export [POST]
+func (ep *Endpoints) RequestDataExport(g *gin.Context) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	export, err := ep.services.Account.RequestExport(username)
+	if err != nil {
+		if errors.Is(err, service.ErrExportInProgress) {
+			newErrorResponse(g, http.StatusConflict, err.Error())
+			return
+		}
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusAccepted, export.ToResponse())
+}
+
+// @Summary Get data export
+// @Schemes
+// @Description Get status of a data export
+// @Security ApiKeyAuth
+// @Tags User
+// @Produce json
+// @Param id path int true "export id"
+// @Success 200 {object} model.DataExportResponse
+// @Failure 400,401,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/account/export/{id} [GET]
+func (ep *Endpoints) GetDataExport(g *gin.Context) {
+	export, ok := ep.getDataExport(g)
+	if !ok {
+		return
+	}
+
+	g.JSON(http.StatusOK, export.ToResponse())
+}
+
+// @Summary Download data export
+// @Schemes
+// @Description Download a ready data export as a zip archive of json files
+// @Security ApiKeyAuth
+// @Tags User
+// @Produce application/zip
+// @Param id path int true "export id"
+// @Success 200 {file} file "zip archive"
+// @Failure 400,401,404,409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/account/export/{id}/download [GET]
+func (ep *Endpoints) DownloadDataExport(g *gin.Context) {
+	export, ok := ep.getDataExport(g)
+	if !ok {
+		return
+	}
+
+	if export.Status != model.DataExportReady {
+		newErrorResponse(g, http.StatusConflict, service.ErrExportNotReady.Error())
+		return
+	}
+
+	g.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"export-%d.zip\"", export.ID))
+	g.Data(http.StatusOK, "application/zip", export.Archive)
+}
+
+func (ep *Endpoints) getDataExport(g *gin.Context) (model.DataExport, bool) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return model.DataExport{}, false
+	}
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return model.DataExport{}, false
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return model.DataExport{}, false
+	}
+
+	export, err := ep.services.Account.GetExport(username, uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			newErrorResponse(g, http.StatusNotFound, "export not found")
+			return model.DataExport{}, false
+		}
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return model.DataExport{}, false
+	}
+	return export, true
+}
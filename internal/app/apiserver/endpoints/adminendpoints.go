@@ -0,0 +1,285 @@
+package endpoints
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// @Summary Admin: list users
+// @Schemes
+// @Description Search users by a part of the username or email, with private fields
+// @Security ApiKeyAuth
+// @Tags Admin
+// @Produce json
+// @Param query query string false "username or email query"
+// @Param offset query int false "offset of users"
+// @Param limit query int false "limit of users"
+// @Success 200 {object} []model.AdminUserResponse "users"
+// @Failure 401,403 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/admin/users [GET]
+func (ep *Endpoints) AdminListUsers(g *gin.Context) {
+	limit, err := strconv.Atoi(g.Query("limit"))
+	if err != nil || limit < 0 {
+		limit = 15
+	}
+	offset, err := strconv.Atoi(g.Query("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	usersResp, err := ep.services.Admin.ListUsers(g.GetString(usernameKey), g.Query("query"), offset, limit)
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, usersResp)
+}
+
+// @Summary Admin: suspend user
+// @Schemes
+// @Description Suspend a user, their tokens stop working and websocket connections are closed
+// @Security ApiKeyAuth
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param username path string true "username"
+// @Param suspendUserDto body model.SuspendUserDto true "reason"
+// @Success 200 {object} statusResponse
+// @Failure 400,401,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/admin/users/{username}/suspend [POST]
+func (ep *Endpoints) AdminSuspendUser(g *gin.Context) {
+	var suspendUserDto model.SuspendUserDto
+
+	if err := g.BindJSON(&suspendUserDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := suspendUserDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username := g.Param("username")
+	err := ep.services.Admin.SuspendUser(g.GetString(usernameKey), username, suspendUserDto.Reason)
+	if err != nil {
+		newModerationErrorResponse(g, err)
+		return
+	}
+
+	ep.hub.DisconnectUser(username)
+	g.JSON(http.StatusOK, statusResponse{"user suspended"})
+}
+
+// @Summary Admin: unsuspend user
+// @Schemes
+// @Description Lift the suspension of a user
+// @Security ApiKeyAuth
+// @Tags Admin
+// @Produce json
+// @Param username path string true "username"
+// @Success 200 {object} statusResponse
+// @Failure 400,401,403,404,409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/admin/users/{username}/unsuspend [POST]
+func (ep *Endpoints) AdminUnsuspendUser(g *gin.Context) {
+	if err := ep.services.Admin.UnsuspendUser(g.GetString(usernameKey), g.Param("username")); err != nil {
+		newModerationErrorResponse(g, err)
+		return
+	}
+
+	g.JSON(http.StatusOK, statusResponse{"user unsuspended"})
+}
+
+// @Summary Admin: force logout
+// @Schemes
+// @Description Revoke every token of a user and close their websocket connections
+// @Security ApiKeyAuth
+// @Tags Admin
+// @Produce json
+// @Param username path string true "username"
+// @Success 200 {object} statusResponse
+// @Failure 400,401,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/admin/users/{username}/logout [POST]
+func (ep *Endpoints) AdminForceLogout(g *gin.Context) {
+	username := g.Param("username")
+	if err := ep.services.Admin.ForceLogout(g.GetString(usernameKey), username); err != nil {
+		newModerationErrorResponse(g, err)
+		return
+	}
+
+	ep.hub.DisconnectUser(username)
+	g.JSON(http.StatusOK, statusResponse{"user logged out"})
+}
+
+// @Summary Admin: set role
+// @Schemes
+// @Description Appoint or dismiss an admin
+// @Security ApiKeyAuth
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param username path string true "username"
+// @Param setRoleDto body model.SetRoleDto true "role"
+// @Success 200 {object} statusResponse
+// @Failure 400,401,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/admin/users/{username}/role [PUT]
+func (ep *Endpoints) AdminSetRole(g *gin.Context) {
+	var setRoleDto model.SetRoleDto
+
+	if err := g.BindJSON(&setRoleDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := setRoleDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := ep.services.Admin.SetRole(g.GetString(usernameKey), g.Param("username"), setRoleDto.Role); err != nil {
+		newModerationErrorResponse(g, err)
+		return
+	}
+
+	g.JSON(http.StatusOK, statusResponse{"role updated"})
+}
+
+// @Summary Admin: delete message
+// @Schemes
+// @Description Delete a message of any chat, chat members get a "message_deleted" event
+// @Security ApiKeyAuth
+// @Tags Admin
+// @Produce json
+// @Param id path int true "message id"
+// @Param reason query string false "reason for the audit log"
+// @Success 200 {object} statusResponse
+// @Failure 400,401,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/admin/messages/{id} [DELETE]
+func (ep *Endpoints) AdminDeleteMessage(g *gin.Context) {
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+
+	message, err := ep.services.Admin.DeleteMessage(g.GetString(usernameKey), uint(id), g.Query("reason"))
+	if err != nil {
+		if errors.Is(err, service.ErrMessageNotFound) {
+			newErrorResponse(g, http.StatusNotFound, err.Error())
+			return
+		}
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ep.sendMessageDeleted(message)
+	g.JSON(http.StatusOK, statusResponse{"message deleted"})
+}
+
+// @Summary Admin: chat metadata
+// @Schemes
+// @Description Get members, message count and last activity of any chat
+// @Security ApiKeyAuth
+// @Tags Admin
+// @Produce json
+// @Param id path int true "chat id"
+// @Success 200 {object} model.AdminChatResponse "chat"
+// @Failure 400,401,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/admin/chats/{id} [GET]
+func (ep *Endpoints) AdminGetChat(g *gin.Context) {
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+
+	chatResp, err := ep.services.Admin.GetChat(g.GetString(usernameKey), uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			newErrorResponse(g, http.StatusNotFound, "chat not found")
+			return
+		}
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, chatResp)
+}
+
+// @Summary Admin: audit log
+// @Schemes
+// @Description Get actions made by admins, newest first
+// @Security ApiKeyAuth
+// @Tags Admin
+// @Produce json
+// @Param offset query int false "offset of records"
+// @Param limit query int false "limit of records"
+// @Success 200 {object} []model.AdminAuditLogResponse "audit log"
+// @Failure 401,403 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/admin/audit [GET]
+func (ep *Endpoints) AdminGetAuditLog(g *gin.Context) {
+	limit, err := strconv.Atoi(g.Query("limit"))
+	if err != nil || limit < 0 {
+		limit = 50
+	}
+	offset, err := strconv.Atoi(g.Query("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	logsResp, err := ep.services.Admin.GetAuditLog(offset, limit)
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, logsResp)
+}
+
+// sendMessageDeleted tells chat members that the message was deleted
+func (ep *Endpoints) sendMessageDeleted(message model.Message) {
+	recipients := make([]string, 0, len(message.Chat.Users))
+	for _, user := range message.Chat.Users {
+		recipients = append(recipients, user.Username)
+	}
+	ep.hub.SendEvent(model.MessageWS{
+		Type:       "message_deleted",
+		Recipients: recipients,
+		ChatID:     message.ChatID,
+		Data:       gin.H{"id": message.ID},
+	})
+}
+
+func newModerationErrorResponse(g *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrModerateSelf):
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrNotSuspended):
+		newErrorResponse(g, http.StatusConflict, err.Error())
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		newErrorResponse(g, http.StatusNotFound, "user not found")
+	default:
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+	}
+}
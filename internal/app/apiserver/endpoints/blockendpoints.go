@@ -0,0 +1,145 @@
+package endpoints
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// @Summary Get blocked users
+// @Schemes
+// @Description Get users blocked by the current user
+// @Security ApiKeyAuth
+// @Tags User
+// @Produce json
+// @Success 200 {object} []model.UserResponse "blocked users"
+// @Failure 401 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/account/blocked [GET]
+func (ep *Endpoints) GetBlockedUsers(g *gin.Context) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	usersResp, err := ep.services.Block.GetBlockedUsers_ToResponse(username)
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, usersResp)
+}
+
+// @Summary Block user
+// @Schemes
+// @Description Block a user. They can't start a private chat or write to you and don't see your presence
+// @Security ApiKeyAuth
+// @Tags User
+// @Accept json
+// @Produce json
+// @Param blockUserDto body model.BlockUserDto true "user to block"
+// @Success 201 {object} statusResponse
+// @Failure 400,401,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/account/blocked [POST]
+func (ep *Endpoints) BlockUser(g *gin.Context) {
+	var blockUserDto model.BlockUserDto
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	if err := g.BindJSON(&blockUserDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := blockUserDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if err := ep.services.Block.BlockUser(username, blockUserDto.Username); err != nil {
+		switch {
+		case errors.Is(err, service.ErrBlockSelf):
+			newErrorResponse(g, http.StatusBadRequest, err.Error())
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			newErrorResponse(g, http.StatusNotFound, "user not found")
+		default:
+			newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	g.JSON(http.StatusCreated, statusResponse{"user blocked"})
+}
+
+// @Summary Unblock user
+// @Schemes
+// @Description Remove a user from the blocked list
+// @Security ApiKeyAuth
+// @Tags User
+// @Accept json
+// @Produce json
+// @Param blockUserDto body model.BlockUserDto true "user to unblock"
+// @Success 200 {object} statusResponse
+// @Failure 400,401,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/account/blocked [DELETE]
+func (ep *Endpoints) UnblockUser(g *gin.Context) {
+	var blockUserDto model.BlockUserDto
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	if err := g.BindJSON(&blockUserDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := blockUserDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if err := ep.services.Block.UnblockUser(username, blockUserDto.Username); err != nil {
+		if errors.Is(err, service.ErrNotBlocked) {
+			newErrorResponse(g, http.StatusNotFound, err.Error())
+			return
+		}
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, statusResponse{"user unblocked"})
+}
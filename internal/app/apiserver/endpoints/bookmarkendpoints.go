@@ -0,0 +1,264 @@
+package endpoints
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/service"
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Get saved messages chat
+// @Schemes
+// @Description Get the Saved messages chat of the current user, created on first use. Its only member is the user
+// @Security ApiKeyAuth
+// @Tags Chats
+// @Produce json
+// @Success 200 {object} model.ChatResponse "chat"
+// @Failure 401 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/chats/saved [GET]
+func (ep *Endpoints) GetSavedChat(g *gin.Context) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	chat, err := ep.services.Chat.GetSavedChat(username)
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+	chatResp, err := ep.services.Chat.GetChat_ToResponse(username, chat.ID)
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, chatResp)
+}
+
+// @Summary Bookmark message
+// @Schemes
+// @Description Save a copy of a message of any chat of the current user to their Saved messages chat, with a link back to the original. Disappearing messages can't be bookmarked
+// @Security ApiKeyAuth
+// @Tags Bookmarks
+// @Accept json
+// @Produce json
+// @Param createBookmarkDto body model.CreateBookmarkDto true "message and tags"
+// @Success 201 {object} model.BookmarkResponse "bookmark"
+// @Failure 400,401,403,404,409,422 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/bookmarks [POST]
+func (ep *Endpoints) CreateBookmark(g *gin.Context) {
+	var createBookmarkDto model.CreateBookmarkDto
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	if err := g.BindJSON(&createBookmarkDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := createBookmarkDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	bookmark, err := ep.services.Bookmark.CreateBookmark(username, createBookmarkDto)
+	if err != nil {
+		newBookmarkErrorResponse(g, err)
+		return
+	}
+
+	viewer, err := ep.services.Privacy.GetViewer(username)
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if bookmark.CopyID != bookmark.MessageID {
+		ep.hub.DeliverMessage(bookmark.Copy)
+	}
+	g.JSON(http.StatusCreated, bookmark.ToResponseFor(viewer))
+}
+
+// @Summary Get bookmarks
+// @Schemes
+// @Description Get bookmarks of the current user, the latest first. q searches the content, tag keeps bookmarks with the tag
+// @Security ApiKeyAuth
+// @Tags Bookmarks
+// @Produce json
+// @Param q query string false "text to search"
+// @Param tag query string false "tag"
+// @Param limit query int false "limit"
+// @Param offset query int false "offset"
+// @Success 200 {object} []model.BookmarkResponse "bookmarks"
+// @Failure 401 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/bookmarks [GET]
+func (ep *Endpoints) GetBookmarks(g *gin.Context) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	limit, err := strconv.Atoi(g.Query("limit"))
+	if err != nil || limit < 0 {
+		limit = 15
+	}
+	offset, err := strconv.Atoi(g.Query("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	bookmarksResp, err := ep.services.Bookmark.GetBookmarks_ToResponse(username, g.Query("q"), g.Query("tag"), offset, limit)
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, bookmarksResp)
+}
+
+// @Summary Set bookmark tags
+// @Schemes
+// @Description Replace tags of a bookmark. Tags are lowercased, without a leading #
+// @Security ApiKeyAuth
+// @Tags Bookmarks
+// @Accept json
+// @Produce json
+// @Param id path int true "bookmark id"
+// @Param bookmarkTagsDto body model.BookmarkTagsDto true "tags"
+// @Success 200 {object} model.BookmarkResponse "bookmark"
+// @Failure 400,401,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/bookmarks/{id}/tags [PUT]
+func (ep *Endpoints) SetBookmarkTags(g *gin.Context) {
+	var bookmarkTagsDto model.BookmarkTagsDto
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+
+	if err := g.BindJSON(&bookmarkTagsDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := bookmarkTagsDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	bookmark, err := ep.services.Bookmark.SetBookmarkTags(username, uint(id), bookmarkTagsDto.Tags)
+	if err != nil {
+		newBookmarkErrorResponse(g, err)
+		return
+	}
+
+	viewer, err := ep.services.Privacy.GetViewer(username)
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, bookmark.ToResponseFor(viewer))
+}
+
+// @Summary Delete bookmark
+// @Schemes
+// @Description Delete a bookmark with its copy in the Saved messages chat, the original stays
+// @Security ApiKeyAuth
+// @Tags Bookmarks
+// @Produce json
+// @Param id path int true "bookmark id"
+// @Success 200 {object} statusResponse
+// @Failure 400,401,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/bookmarks/{id} [DELETE]
+func (ep *Endpoints) DeleteBookmark(g *gin.Context) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if err := ep.services.Bookmark.DeleteBookmark(username, uint(id)); err != nil {
+		newBookmarkErrorResponse(g, err)
+		return
+	}
+
+	g.JSON(http.StatusOK, statusResponse{"deleted"})
+}
+
+// newBookmarkErrorResponse maps errors of bookmarks to status codes
+func newBookmarkErrorResponse(g *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrBookmarkNotFound), errors.Is(err, service.ErrMessageNotFound):
+		newErrorResponse(g, http.StatusNotFound, err.Error())
+	case errors.Is(err, service.ErrNotChatMember):
+		newErrorResponse(g, http.StatusForbidden, err.Error())
+	case errors.Is(err, service.ErrAlreadyBookmarked):
+		newErrorResponse(g, http.StatusConflict, err.Error())
+	case errors.Is(err, service.ErrBookmarkExpiring):
+		newErrorResponse(g, http.StatusUnprocessableEntity, err.Error())
+	default:
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+	}
+}
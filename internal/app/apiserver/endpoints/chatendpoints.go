@@ -1,11 +1,13 @@
 package endpoints
 
 import (
+	"errors"
 	"net/http"
 	"slices"
 	"strconv"
 
 	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/service"
 	"github.com/gin-gonic/gin"
 )
 
@@ -44,11 +46,22 @@ func (ep *Endpoints) CreateChat(g *gin.Context){
 
 	chat := createChatDto.ToModel(username)
 	if err = ep.services.Chat.CreateChat(&chat); err != nil{
+		if errors.Is(err, service.ErrUserBlocked) || errors.Is(err, service.ErrContactsOnly) ||
+			errors.Is(err, service.ErrGroupInvitesRestricted){
+			newErrorResponse(g, http.StatusForbidden, err.Error())
+			return
+		}
 		newErrorResponse(g, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	chatResponse := chat.ToResponse()
+	viewer, err := ep.services.Privacy.GetViewer(username)
+	if err != nil{
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	chatResponse := chat.ToResponseFor(viewer)
 	g.JSON(http.StatusCreated, chatResponse)
 }
 
@@ -59,9 +72,12 @@ func (ep *Endpoints) CreateChat(g *gin.Context){
 // @Tags Chats
 // @Accept json
 // @Produce json
-// @Param offset query int false "offset of chats"
+// @Param cursor query string false "X-Next-Cursor of the previous page"
 // @Param limit query int false "limit of chats"
+// @Param archived query bool false "archived chats instead of the main list"
+// @Param folder query int false "id of a folder to list chats of instead, archived is ignored"
 // @Success 200 {object} []model.ChatResponse "chat response"
+// @Header 200 {string} X-Next-Cursor "cursor of the next page, absent on the last one"
 // @Failure 400,404,401 {object} errorResponse
 // @Failure 500 {object} errorResponse
 // @Failure default {object} errorResponse
@@ -83,16 +99,33 @@ func (ep *Endpoints) GetChats(g *gin.Context){
 	if err != nil || limit < 0{
 		limit = 15
 	}
-	offset, err := strconv.Atoi(g.Query("offset"))
-	if err != nil || offset < 0{
-		offset = 0
+	archived := g.Query("archived") == "true"
+	var folderID uint
+	if folder := g.Query("folder"); folder != ""{
+		id, err := strconv.Atoi(folder)
+		if err != nil || id <= 0{
+			newErrorResponse(g, http.StatusBadRequest, "Invalid type of folder")
+			return
+		}
+		folderID = uint(id)
 	}
 
-	chatsResponces, err := ep.services.Chat.GetChats_ToResponse(username, offset, limit)
+	chatsResponces, nextCursor, err := ep.services.Chat.GetChats_ToResponse(username, archived, folderID, g.Query("cursor"), limit)
 	if err != nil{
+		if errors.Is(err, service.ErrInvalidCursor){
+			newErrorResponse(g, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, service.ErrFolderNotFound){
+			newErrorResponse(g, http.StatusNotFound, err.Error())
+			return
+		}
 		newErrorResponse(g, http.StatusInternalServerError, err.Error())
 		return
 	}
+	if nextCursor != ""{
+		g.Header("X-Next-Cursor", nextCursor)
+	}
 	g.JSON(http.StatusOK, chatsResponces)
 }
 
@@ -128,7 +161,7 @@ func (ep *Endpoints) GetChat(g *gin.Context){
 		return
 	}
 
-	chatResp, err := ep.services.Chat.GetChat_ToResponse(uint(id))
+	chatResp, err := ep.services.Chat.GetChat_ToResponse(username, uint(id))
 	if err != nil{
 		newErrorResponse(g, http.StatusInternalServerError, err.Error())
 		return
@@ -197,14 +230,14 @@ func (ep *Endpoints) GetChat(g *gin.Context){
 
 // @Summary Modify chat data
 // @Schemes
-// @Description Modify chat data
+// @Description Modify chat data, only admins can rename a group chat or change its members
 // @Security ApiKeyAuth
 // @Tags Chats
 // @Accept json
 // @Produce json
 // @Param modifyChatDto body model.ModifyChatDto true "modify chat dto"
 // @Success 200 {object} model.ChatResponse "chat response"
-// @Failure 400,404,401 {object} errorResponse
+// @Failure 400,404,401,403 {object} errorResponse
 // @Failure 500 {object} errorResponse
 // @Failure default {object} errorResponse
 // @Router /v1/chats/{id} [PATCH]
@@ -235,16 +268,20 @@ func (ep *Endpoints) ModifyChat(g *gin.Context){
 
 	chat := modifyChatDto.ToModel()
 	if modifyChatDto.Name != nil{
-		err := ep.services.Chat.ModifyChatName(chat.ID, chat.Name)
+		err := ep.services.Chat.ModifyChatName(username, chat.ID, chat.Name)
 		if err != nil{
-			newErrorResponse(g, http.StatusInternalServerError, err.Error())
+			newChatAdminErrorResponse(g, err)
 			return
 		}
 	}
 	if modifyChatDto.UserUsernames != nil{
-		err := ep.services.Chat.ModifyChatUsers(chat.ID, chat.Users)
+		err := ep.services.Chat.ModifyChatUsers(username, chat.ID, chat.Users)
 		if err != nil{
-			newErrorResponse(g, http.StatusInternalServerError, err.Error())
+			if errors.Is(err, service.ErrGroupInvitesRestricted){
+				newErrorResponse(g, http.StatusForbidden, err.Error())
+				return
+			}
+			newChatAdminErrorResponse(g, err)
 			return
 		}
 	}
@@ -254,8 +291,17 @@ func (ep *Endpoints) ModifyChat(g *gin.Context){
 		newErrorResponse(g, http.StatusInternalServerError, err.Error())
 		return
 	}
+	if modifyChatDto.Name != nil{
+		ep.hub.NotifyChatUpdated(chat)
+	}
+
+	viewer, err := ep.services.Privacy.GetViewer(username)
+	if err != nil{
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
 	
-	g.JSON(http.StatusOK, chat.ToResponse())
+	g.JSON(http.StatusOK, chat.ToResponseFor(viewer))
 
 	// chatModel := modifyChatDto.ToModel()
 	// err = ep.services.Chat.ModifyChat(&chatModel)
@@ -0,0 +1,115 @@
+package endpoints
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/service"
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Update chat info
+// @Schemes
+// @Description Change the description, avatar and public handle of a group chat, omitted fields stay as they are, an empty avatar or handle removes it. Handles are 5-32 letters, digits or underscores starting with a letter. Only chat admins can do it
+// @Security ApiKeyAuth
+// @Tags Chats
+// @Accept json
+// @Produce json
+// @Param id path int true "chat id"
+// @Param chatInfoDto body model.ChatInfoDto true "chat info"
+// @Success 200 {object} model.ChatResponse "chat response"
+// @Failure 400,401,403,404,409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/chats/{id}/info [PATCH]
+func (ep *Endpoints) UpdateChatInfo(g *gin.Context) {
+	var chatInfoDto model.ChatInfoDto
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+
+	if err := g.BindJSON(&chatInfoDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := chatInfoDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	chat, err := ep.services.Chat.UpdateChatInfo(username, uint(id), chatInfoDto)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidAvatar):
+			newErrorResponse(g, http.StatusBadRequest, err.Error())
+		case errors.Is(err, service.ErrHandleTaken):
+			newErrorResponse(g, http.StatusConflict, err.Error())
+		default:
+			newChatAdminErrorResponse(g, err)
+		}
+		return
+	}
+	ep.hub.NotifyChatUpdated(chat)
+
+	chatResp, err := ep.services.Chat.GetChat_ToResponse(username, uint(id))
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, chatResp)
+}
+
+// @Summary Get chat by handle
+// @Schemes
+// @Description Find a group chat by its public handle, with or without the leading @. Anyone signed in can look it up
+// @Security ApiKeyAuth
+// @Tags Chats
+// @Produce json
+// @Param handle path string true "chat handle"
+// @Success 200 {object} model.PublicChatResponse "chat"
+// @Failure 401,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/chats/by-handle/{handle} [GET]
+func (ep *Endpoints) GetChatByHandle(g *gin.Context) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	if _, err := ep.services.User.GetUsernameFromToken(tokenString); err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	chat, err := ep.services.Chat.GetChatByHandle(g.Param("handle"))
+	if err != nil {
+		if errors.Is(err, service.ErrChatNotFound) {
+			newErrorResponse(g, http.StatusNotFound, err.Error())
+			return
+		}
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, chat.ToPublicResponse())
+}
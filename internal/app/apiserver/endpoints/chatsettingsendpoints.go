@@ -0,0 +1,166 @@
+package endpoints
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/service"
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Change chat settings
+// @Schemes
+// @Description Mute, archive or pin a chat for the current user. Muting without mutedUntil mutes the chat forever, archiving unpins it and pinning unarchives it
+// @Security ApiKeyAuth
+// @Tags Chats
+// @Accept json
+// @Produce json
+// @Param id path int true "chat id"
+// @Param chatSettingsDto body model.ChatSettingsDto true "settings"
+// @Success 200 {object} model.ChatResponse "chat"
+// @Failure 400,401,403,409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/chats/{id}/settings [PATCH]
+func (ep *Endpoints) UpdateChatSettings(g *gin.Context) {
+	var chatSettingsDto model.ChatSettingsDto
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+
+	if err := g.BindJSON(&chatSettingsDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if err := ep.services.Chat.UpdateChatSettings(username, uint(id), chatSettingsDto); err != nil {
+		switch {
+		case errors.Is(err, service.ErrNotChatMember):
+			newErrorResponse(g, http.StatusForbidden, err.Error())
+		case errors.Is(err, service.ErrInvalidMutedUntil):
+			newErrorResponse(g, http.StatusBadRequest, err.Error())
+		case errors.Is(err, service.ErrTooManyPinned):
+			newErrorResponse(g, http.StatusConflict, err.Error())
+		default:
+			newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	chatResp, err := ep.services.Chat.GetChat_ToResponse(username, uint(id))
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, chatResp)
+}
+
+// @Summary Reorder pinned chats
+// @Schemes
+// @Description Set the order of pinned chats of the current user, chatIds have to list every pinned chat
+// @Security ApiKeyAuth
+// @Tags Chats
+// @Accept json
+// @Produce json
+// @Param pinnedChatsDto body model.PinnedChatsDto true "pinned chats in order"
+// @Success 200 {object} statusResponse
+// @Failure 400,401 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/chats/pinned [PUT]
+func (ep *Endpoints) ReorderPinnedChats(g *gin.Context) {
+	var pinnedChatsDto model.PinnedChatsDto
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	if err := g.BindJSON(&pinnedChatsDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := pinnedChatsDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if err := ep.services.Chat.ReorderPinnedChats(username, pinnedChatsDto.ChatIDs); err != nil {
+		if errors.Is(err, service.ErrPinnedChats) {
+			newErrorResponse(g, http.StatusBadRequest, err.Error())
+			return
+		}
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, statusResponse{"reordered"})
+}
+
+// @Summary Mark chat read
+// @Schemes
+// @Description Mark every message of the chat read by the current user, resetting unread and unreadMentions of the chat
+// @Security ApiKeyAuth
+// @Tags Chats
+// @Produce json
+// @Param id path int true "chat id"
+// @Success 200 {object} statusResponse
+// @Failure 400,401,403 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/chats/{id}/read [POST]
+func (ep *Endpoints) MarkChatRead(g *gin.Context) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if err := ep.services.Chat.MarkChatRead(username, uint(id)); err != nil {
+		if errors.Is(err, service.ErrNotChatMember) {
+			newErrorResponse(g, http.StatusForbidden, err.Error())
+			return
+		}
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, statusResponse{"read"})
+}
@@ -0,0 +1,250 @@
+package endpoints
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// @Summary Get contacts
+// @Schemes
+// @Description Get contacts of the current user with their nicknames and presence
+// @Security ApiKeyAuth
+// @Tags Contacts
+// @Produce json
+// @Success 200 {object} []model.ContactResponse "contacts"
+// @Failure 401 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/contacts [GET]
+func (ep *Endpoints) GetContacts(g *gin.Context) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	contacts, err := ep.services.Contact.GetContacts(username)
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	viewer, err := ep.services.Privacy.GetViewer(username)
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	contactsResp := make([]model.ContactResponse, len(contacts))
+	for i := range contacts {
+		contactsResp[i] = contacts[i].ToResponse(viewer, ep.hub.IsOnline(contacts[i].Contact.Username))
+	}
+	g.JSON(http.StatusOK, contactsResp)
+}
+
+// @Summary Add contact
+// @Schemes
+// @Description Add a user to contacts with an optional private nickname
+// @Security ApiKeyAuth
+// @Tags Contacts
+// @Accept json
+// @Produce json
+// @Param addContactDto body model.AddContactDto true "contact"
+// @Success 201 {object} model.ContactResponse "contact"
+// @Failure 400,401,404,409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/contacts [POST]
+func (ep *Endpoints) AddContact(g *gin.Context) {
+	var addContactDto model.AddContactDto
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	if err := g.BindJSON(&addContactDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := addContactDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	contact, err := ep.services.Contact.AddContact(username, addContactDto.Username, addContactDto.Nickname)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrContactSelf):
+			newErrorResponse(g, http.StatusBadRequest, err.Error())
+		case errors.Is(err, service.ErrContactExists):
+			newErrorResponse(g, http.StatusConflict, err.Error())
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			newErrorResponse(g, http.StatusNotFound, "user not found")
+		default:
+			newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	viewer, err := ep.services.Privacy.GetViewer(username)
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusCreated, contact.ToResponse(viewer, ep.hub.IsOnline(contact.Contact.Username)))
+}
+
+// @Summary Modify contact
+// @Schemes
+// @Description Change the private nickname of a contact
+// @Security ApiKeyAuth
+// @Tags Contacts
+// @Accept json
+// @Produce json
+// @Param username path string true "contact username"
+// @Param modifyContactDto body model.ModifyContactDto true "nickname"
+// @Success 200 {object} model.ContactResponse "contact"
+// @Failure 400,401,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/contacts/{username} [PATCH]
+func (ep *Endpoints) ModifyContact(g *gin.Context) {
+	var modifyContactDto model.ModifyContactDto
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	if err := g.BindJSON(&modifyContactDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := modifyContactDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	contact, err := ep.services.Contact.ModifyContact(username, g.Param("username"), modifyContactDto.Nickname)
+	if err != nil {
+		if errors.Is(err, service.ErrContactNotFound) {
+			newErrorResponse(g, http.StatusNotFound, err.Error())
+			return
+		}
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	viewer, err := ep.services.Privacy.GetViewer(username)
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, contact.ToResponse(viewer, ep.hub.IsOnline(contact.Contact.Username)))
+}
+
+// @Summary Remove contact
+// @Schemes
+// @Description Remove a user from contacts
+// @Security ApiKeyAuth
+// @Tags Contacts
+// @Produce json
+// @Param username path string true "contact username"
+// @Success 200 {object} statusResponse
+// @Failure 401,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/contacts/{username} [DELETE]
+func (ep *Endpoints) RemoveContact(g *gin.Context) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if err := ep.services.Contact.RemoveContact(username, g.Param("username")); err != nil {
+		if errors.Is(err, service.ErrContactNotFound) {
+			newErrorResponse(g, http.StatusNotFound, err.Error())
+			return
+		}
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, statusResponse{"contact removed"})
+}
+
+// @Summary Contact privacy settings
+// @Schemes
+// @Description Allow only contacts to start private chats with the current user
+// @Security ApiKeyAuth
+// @Tags Contacts
+// @Accept json
+// @Produce json
+// @Param contactSettingsDto body model.ContactSettingsDto true "settings"
+// @Success 200 {object} model.ContactSettingsDto
+// @Failure 400,401 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/contacts/settings [PUT]
+func (ep *Endpoints) SetContactSettings(g *gin.Context) {
+	var contactSettingsDto model.ContactSettingsDto
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	if err := g.BindJSON(&contactSettingsDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if err := ep.services.Contact.SetContactsOnlyChats(username, contactSettingsDto.ContactsOnlyChats); err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, contactSettingsDto)
+}
@@ -3,6 +3,7 @@ package endpoints
 import (
 	"github.com/VitalyCone/websocket-messenger/docs"
 	"github.com/VitalyCone/websocket-messenger/internal/app/apiserver/chat"
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
 	"github.com/VitalyCone/websocket-messenger/internal/app/service"
 	"github.com/gin-gonic/gin"
 	swaggerfiles "github.com/swaggo/files"
@@ -18,6 +19,7 @@ type Endpoints struct {
 	services *service.Service
 	router *gin.Engine
 	hub *chat.Hub
+	limits RateLimits
 }
 
 func NewEndpoints(service *service.Service, router *gin.Engine, hub *chat.Hub) *Endpoints {
@@ -32,19 +34,67 @@ func (e *Endpoints) InitRoutes() {
 	docs.SwaggerInfo.BasePath = mainPath
 	path := e.router.Group(mainPath)
 	{
-		v1 := path.Group("/v1")
+		v1 := path.Group("/v1", e.rateLimit("api", e.limits.API))
 		{
 			
 			v1.GET("/chatws", e.ConnectUserToChats)
 			
 			v1.GET("/accounts", e.GetUsersByUsername)
 			v1.GET("/account", e.GetUserData)
+			v1.PATCH("/account", e.ModifyUser)
+			v1.DELETE("/account", e.DeleteAccount)
 			acc:= v1.Group("/account")
 			{
-				acc.POST("/register" , e.RegisterUser)
-				acc.POST("/login" , e.LoginUser)
+				acc.POST("/register" , e.rateLimit("auth", e.limits.Auth), e.RegisterUser)
+				acc.POST("/login" , e.rateLimit("auth", e.limits.Auth), e.LoginUser)
+				acc.PUT("/password", e.ChangePassword)
+				acc.POST("/password/reset", e.rateLimit("auth", e.limits.Auth), e.RequestPasswordReset)
+				acc.POST("/password/reset/confirm", e.rateLimit("auth", e.limits.Auth), e.ResetPassword)
+				acc.POST("/deletion/cancel", e.CancelAccountDeletion)
+				acc.POST("/export", e.RequestDataExport)
+				acc.GET("/export/:id", e.GetDataExport)
+				acc.GET("/export/:id/download", e.DownloadDataExport)
+				acc.GET("/blocked", e.GetBlockedUsers)
+				acc.POST("/blocked", e.BlockUser)
+				acc.DELETE("/blocked", e.UnblockUser)
+				acc.GET("/privacy", e.GetPrivacy)
+				acc.PUT("/privacy", e.UpdatePrivacy)
 			}
 			
+			contact := v1.Group("/contacts")
+			{
+				contact.GET("/", e.GetContacts)
+				contact.POST("/", e.AddContact)
+				contact.PUT("/settings", e.SetContactSettings)
+				contact.PATCH("/:username", e.ModifyContact)
+				contact.DELETE("/:username", e.RemoveContact)
+			}
+
+			admin := v1.Group("/admin", e.requireRole(model.RoleAdmin))
+			{
+				admin.GET("/users", e.AdminListUsers)
+				admin.POST("/users/:username/suspend", e.AdminSuspendUser)
+				admin.POST("/users/:username/unsuspend", e.AdminUnsuspendUser)
+				admin.POST("/users/:username/logout", e.AdminForceLogout)
+				admin.PUT("/users/:username/role", e.AdminSetRole)
+				admin.DELETE("/messages/:id", e.AdminDeleteMessage)
+				admin.GET("/chats/:id", e.AdminGetChat)
+				admin.GET("/audit", e.AdminGetAuditLog)
+				admin.GET("/reports", e.AdminGetReports)
+				admin.GET("/reports/:id", e.AdminGetReport)
+				admin.POST("/reports/:id/resolve", e.AdminResolveReport)
+			}
+
+			v1.POST("/reports", e.CreateReport)
+
+			wallet := v1.Group("/wallet")
+			{
+				wallet.GET("/", e.GetWallet)
+				wallet.POST("/transfers", e.CreateTransfer)
+				wallet.GET("/transactions", e.GetWalletTransactions)
+				wallet.GET("/transactions/:id", e.GetWalletTransaction)
+			}
+
 			chat:= v1.Group("/chats")
 			{
 				chat.POST("/", e.CreateChat)
@@ -52,10 +102,54 @@ func (e *Endpoints) InitRoutes() {
 				chat.GET("/:id", e.GetChat)
 				chat.PATCH("/:id", e.ModifyChat)
 				chat.GET("/:id/messages", e.GetMessages)
+				chat.GET("/:id/banned-words", e.GetBannedWords)
+				chat.PUT("/:id/banned-words", e.SetBannedWords)
+				chat.PUT("/:id/slow-mode", e.SetSlowMode)
+				chat.PUT("/:id/auto-delete", e.SetAutoDelete)
+				chat.POST("/:id/mentions/read", e.ReadMentions)
+				chat.PUT("/:id/members/:username/role", e.SetChatMemberRole)
+				chat.PATCH("/:id/settings", e.UpdateChatSettings)
+				chat.POST("/:id/read", e.MarkChatRead)
+				chat.PUT("/pinned", e.ReorderPinnedChats)
+				chat.GET("/folders", e.GetChatFolders)
+				chat.POST("/folders", e.CreateChatFolder)
+				chat.PUT("/folders/:id", e.UpdateChatFolder)
+				chat.DELETE("/folders/:id", e.DeleteChatFolder)
+				chat.GET("/saved", e.GetSavedChat)
+				chat.PATCH("/:id/info", e.UpdateChatInfo)
+				chat.GET("/by-handle/:handle", e.GetChatByHandle)
 			}
-			message := v1.Group("/messages")
+			message := v1.Group("/messages", e.rateLimit(messagesScope, e.limits.Messages))
 			{
 				message.POST("/", e.CreateMessage)
+				message.POST("/transfers", e.CreateChatTransfer)
+				message.POST("/transfers/:id/accept", e.AcceptChatTransfer)
+				message.POST("/transfers/:id/decline", e.DeclineChatTransfer)
+				message.POST("/polls", e.CreatePoll)
+				message.POST("/polls/:id/vote", e.Vote)
+				message.DELETE("/polls/:id/vote", e.RetractVote)
+				message.POST("/polls/:id/close", e.ClosePoll)
+			}
+			scheduled := v1.Group("/messages/scheduled")
+			{
+				scheduled.GET("/", e.GetScheduledMessages)
+				scheduled.PATCH("/:id", e.ModifyScheduledMessage)
+				scheduled.DELETE("/:id", e.CancelScheduledMessage)
+			}
+			thread := v1.Group("/messages/:id/thread")
+			{
+				thread.GET("/", e.GetThread)
+				thread.POST("/subscription", e.SubscribeThread)
+				thread.DELETE("/subscription", e.UnsubscribeThread)
+				thread.POST("/read", e.MarkThreadRead)
+			}
+			v1.GET("/threads", e.GetThreadSubscriptions)
+			bookmark := v1.Group("/bookmarks")
+			{
+				bookmark.GET("/", e.GetBookmarks)
+				bookmark.POST("/", e.CreateBookmark)
+				bookmark.PUT("/:id/tags", e.SetBookmarkTags)
+				bookmark.DELETE("/:id", e.DeleteBookmark)
 			}
 			
 		}
@@ -0,0 +1,112 @@
+package endpoints
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Get banned words of chat
+// @Schemes
+// @Description Get words the content filter catches in the chat, in addition to the ones banned everywhere
+// @Security ApiKeyAuth
+// @Tags Chats
+// @Produce json
+// @Param id path int true "chat id"
+// @Success 200 {object} model.BannedWordsResponse "banned words"
+// @Failure 400,401,403 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/chats/{id}/banned-words [GET]
+func (ep *Endpoints) GetBannedWords(g *gin.Context) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if !ep.services.Chat.IsUserInChat(username, uint(id)) {
+		newErrorResponse(g, http.StatusForbidden, "user can't read this chat")
+		return
+	}
+
+	words, err := ep.services.Chat.GetBannedWords(uint(id))
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, model.BannedWordsResponse{ChatID: uint(id), Words: words})
+}
+
+// @Summary Set banned words of chat
+// @Schemes
+// @Description Replace words the content filter catches in the chat. How messages with them are handled is configured by the deployment
+// @Security ApiKeyAuth
+// @Tags Chats
+// @Accept json
+// @Produce json
+// @Param id path int true "chat id"
+// @Param bannedWordsDto body model.BannedWordsDto true "banned words"
+// @Success 200 {object} model.BannedWordsResponse "banned words"
+// @Failure 400,401,403 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/chats/{id}/banned-words [PUT]
+func (ep *Endpoints) SetBannedWords(g *gin.Context) {
+	var bannedWordsDto model.BannedWordsDto
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+
+	if err := g.BindJSON(&bannedWordsDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := bannedWordsDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if !ep.services.Chat.IsUserInChat(username, uint(id)) {
+		newErrorResponse(g, http.StatusForbidden, "user can't read this chat")
+		return
+	}
+
+	words, err := ep.services.Chat.SetBannedWords(uint(id), bannedWordsDto.Words)
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, model.BannedWordsResponse{ChatID: uint(id), Words: words})
+}
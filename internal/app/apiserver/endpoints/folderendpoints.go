@@ -0,0 +1,196 @@
+package endpoints
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/service"
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Get chat folders
+// @Schemes
+// @Description Get folders of the current user with numbers of their unread chats for tab badges. Chats of a folder are listed by GET /v1/chats?folder=
+// @Security ApiKeyAuth
+// @Tags Chats
+// @Produce json
+// @Success 200 {object} []model.ChatFolderResponse "folders"
+// @Failure 401 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/chats/folders [GET]
+func (ep *Endpoints) GetChatFolders(g *gin.Context) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	folders, err := ep.services.Folder.GetFolders_ToResponse(username)
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, folders)
+}
+
+// @Summary Create chat folder
+// @Schemes
+// @Description Create a folder of chats listed explicitly and matching its rules. Listed chats are always shown, excluded ones never are
+// @Security ApiKeyAuth
+// @Tags Chats
+// @Accept json
+// @Produce json
+// @Param chatFolderDto body model.ChatFolderDto true "folder"
+// @Success 201 {object} model.ChatFolderResponse "folder"
+// @Failure 400,401,409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/chats/folders [POST]
+func (ep *Endpoints) CreateChatFolder(g *gin.Context) {
+	var chatFolderDto model.ChatFolderDto
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	if err := g.BindJSON(&chatFolderDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := chatFolderDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	folder, err := ep.services.Folder.CreateFolder(username, chatFolderDto)
+	if err != nil {
+		newFolderErrorResponse(g, err)
+		return
+	}
+
+	g.JSON(http.StatusCreated, folder)
+}
+
+// @Summary Update chat folder
+// @Schemes
+// @Description Replace the name, chats and rules of a folder
+// @Security ApiKeyAuth
+// @Tags Chats
+// @Accept json
+// @Produce json
+// @Param id path int true "folder id"
+// @Param chatFolderDto body model.ChatFolderDto true "folder"
+// @Success 200 {object} model.ChatFolderResponse "folder"
+// @Failure 400,401,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/chats/folders/{id} [PUT]
+func (ep *Endpoints) UpdateChatFolder(g *gin.Context) {
+	var chatFolderDto model.ChatFolderDto
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+
+	if err := g.BindJSON(&chatFolderDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := chatFolderDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	folder, err := ep.services.Folder.UpdateFolder(username, uint(id), chatFolderDto)
+	if err != nil {
+		newFolderErrorResponse(g, err)
+		return
+	}
+
+	g.JSON(http.StatusOK, folder)
+}
+
+// @Summary Delete chat folder
+// @Schemes
+// @Description Delete a folder, its chats stay in the chat list
+// @Security ApiKeyAuth
+// @Tags Chats
+// @Produce json
+// @Param id path int true "folder id"
+// @Success 200 {object} statusResponse
+// @Failure 400,401,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/chats/folders/{id} [DELETE]
+func (ep *Endpoints) DeleteChatFolder(g *gin.Context) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if err := ep.services.Folder.DeleteFolder(username, uint(id)); err != nil {
+		newFolderErrorResponse(g, err)
+		return
+	}
+
+	g.JSON(http.StatusOK, statusResponse{"deleted"})
+}
+
+// newFolderErrorResponse maps errors of folders to status codes
+func newFolderErrorResponse(g *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrFolderNotFound):
+		newErrorResponse(g, http.StatusNotFound, err.Error())
+	case errors.Is(err, service.ErrTooManyFolders):
+		newErrorResponse(g, http.StatusConflict, err.Error())
+	case errors.Is(err, service.ErrEmptyFolder), errors.Is(err, service.ErrFolderChats):
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+	default:
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,201 @@
+package endpoints
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// @Summary Set slow mode
+// @Schemes
+// @Description Let members of a group chat post once per the number of seconds, 0 turns slow mode off. Chat admins are exempt. Only chat admins can set it
+// @Security ApiKeyAuth
+// @Tags Chats
+// @Accept json
+// @Produce json
+// @Param id path int true "chat id"
+// @Param slowModeDto body model.SlowModeDto true "slow mode"
+// @Success 200 {object} model.ChatResponse "chat response"
+// @Failure 400,401,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/chats/{id}/slow-mode [PUT]
+func (ep *Endpoints) SetSlowMode(g *gin.Context) {
+	var slowModeDto model.SlowModeDto
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+
+	if err := g.BindJSON(&slowModeDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := slowModeDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if err := ep.services.Chat.SetSlowMode(username, uint(id), slowModeDto.Seconds); err != nil {
+		newChatAdminErrorResponse(g, err)
+		return
+	}
+
+	chatResp, err := ep.services.Chat.GetChat_ToResponse(username, uint(id))
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, chatResp)
+}
+
+// @Summary Set disappearing messages timer
+// @Schemes
+// @Description Delete new messages of the chat after the number of seconds, 0 turns it off. Any member of a private chat can set it, only admins of a group chat
+// @Security ApiKeyAuth
+// @Tags Chats
+// @Accept json
+// @Produce json
+// @Param id path int true "chat id"
+// @Param autoDeleteDto body model.AutoDeleteDto true "timer"
+// @Success 200 {object} model.ChatResponse "chat response"
+// @Failure 400,401,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/chats/{id}/auto-delete [PUT]
+func (ep *Endpoints) SetAutoDelete(g *gin.Context) {
+	var autoDeleteDto model.AutoDeleteDto
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+
+	if err := g.BindJSON(&autoDeleteDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := autoDeleteDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if err := ep.services.Chat.SetAutoDelete(username, uint(id), autoDeleteDto.Seconds); err != nil {
+		newChatAdminErrorResponse(g, err)
+		return
+	}
+
+	chatResp, err := ep.services.Chat.GetChat_ToResponse(username, uint(id))
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, chatResp)
+}
+
+// @Summary Set role of chat member
+// @Schemes
+// @Description Make a member of a group chat an admin or a regular member. Only chat admins can do it
+// @Security ApiKeyAuth
+// @Tags Chats
+// @Accept json
+// @Produce json
+// @Param id path int true "chat id"
+// @Param username path string true "username of the member"
+// @Param chatRoleDto body model.ChatRoleDto true "role"
+// @Success 200 {object} model.ChatResponse "chat response"
+// @Failure 400,401,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/chats/{id}/members/{username}/role [PUT]
+func (ep *Endpoints) SetChatMemberRole(g *gin.Context) {
+	var chatRoleDto model.ChatRoleDto
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+
+	if err := g.BindJSON(&chatRoleDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := chatRoleDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if err := ep.services.Chat.SetMemberRole(username, uint(id), g.Param("username"), chatRoleDto.Role); err != nil {
+		newChatAdminErrorResponse(g, err)
+		return
+	}
+
+	chatResp, err := ep.services.Chat.GetChat_ToResponse(username, uint(id))
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, chatResp)
+}
+
+// newChatAdminErrorResponse maps errors of actions of chat admins to status codes
+func newChatAdminErrorResponse(g *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrNotGroupChat), errors.Is(err, service.ErrChangeOwnRole):
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrNotChatAdmin):
+		newErrorResponse(g, http.StatusForbidden, err.Error())
+	case errors.Is(err, service.ErrNotChatMember), errors.Is(err, gorm.ErrRecordNotFound):
+		newErrorResponse(g, http.StatusNotFound, err.Error())
+	default:
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,53 @@
+package endpoints
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/service"
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Read mentions
+// @Schemes
+// @Description Mark every message of the chat mentioning the user as read, resetting unreadMentions of the chat
+// @Security ApiKeyAuth
+// @Tags Chats
+// @Produce json
+// @Param id path int true "chat id"
+// @Success 200 {object} statusResponse
+// @Failure 400,401,403 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/chats/{id}/mentions/read [POST]
+func (ep *Endpoints) ReadMentions(g *gin.Context) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if err := ep.services.Chat.ReadMentions(username, uint(id)); err != nil {
+		if errors.Is(err, service.ErrNotChatMember) {
+			newErrorResponse(g, http.StatusForbidden, err.Error())
+			return
+		}
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, statusResponse{"read"})
+}
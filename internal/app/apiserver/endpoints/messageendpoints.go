@@ -1,10 +1,13 @@
 package endpoints
 
 import (
+	"errors"
+	"math"
 	"net/http"
 	"strconv"
 
 	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/service"
 	"github.com/gin-gonic/gin"
 )
 
@@ -17,7 +20,8 @@ import (
 // @Produce json
 // @Param createMessageDto body model.CreateMessageDto true "Create message dto"
 // @Success 201 {object} model.MessageResponse "message response"
-// @Failure 400,404,401 {object} errorResponse
+// @Success 202 {object} model.ScheduledMessageResponse "scheduled message, if sendAt is set"
+// @Failure 400,404,401,403,422,429 {object} errorResponse
 // @Failure 500 {object} errorResponse
 // @Failure default {object} errorResponse
 // @Router /v1/messages [POST]
@@ -41,12 +45,50 @@ func (ep *Endpoints) CreateMessage(g *gin.Context){
 		return
 	}
 
+	if createMessageDto.SendAt != nil{
+		ep.scheduleMessage(g, username, createMessageDto)
+		return
+	}
+
 	message := createMessageDto.ToModel(username)
 	if err = ep.services.Message.CreateMessage(&message); err != nil{
+		if errors.Is(err, service.ErrUserBlocked){
+			newErrorResponse(g, http.StatusForbidden, err.Error())
+			return
+		}
+		if errors.Is(err, service.ErrMessageRejected){
+			newErrorResponse(g, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		var slowModeErr *service.SlowModeError
+		if errors.As(err, &slowModeErr){
+			g.Header("Retry-After", strconv.Itoa(int(math.Ceil(slowModeErr.Cooldown.Seconds()))))
+			newErrorResponse(g, http.StatusTooManyRequests, err.Error())
+			return
+		}
+		if errors.Is(err, service.ErrNotChatMember){
+			newErrorResponse(g, http.StatusForbidden, err.Error())
+			return
+		}
+		if errors.Is(err, service.ErrThreadNotFound){
+			newErrorResponse(g, http.StatusNotFound, err.Error())
+			return
+		}
+		if errors.Is(err, service.ErrNotGroupChat){
+			newErrorResponse(g, http.StatusBadRequest, err.Error())
+			return
+		}
 		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	viewer, err := ep.services.Privacy.GetViewer(username)
+	if err != nil{
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
 	}
 
-	messageResp := message.ToResponse()
+	messageResp := message.ToResponseFor(viewer)
 	g.JSON(http.StatusCreated, messageResp)
 }
 
@@ -96,7 +138,7 @@ func (ep *Endpoints) GetMessages(g *gin.Context){
 		return
 	}
 
-	modelResps, err := ep.services.Message.GetMessages_ToResponse(uint(id),limit,offest)
+	modelResps, err := ep.services.Message.GetMessages_ToResponse(username, uint(id),limit,offest)
 	if err != nil{
 		newErrorResponse(g, http.StatusInternalServerError, err.Error())	
 	}
@@ -0,0 +1,97 @@
+package endpoints
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/apiserver/chat"
+	"github.com/VitalyCone/websocket-messenger/internal/app/ratelimit"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Context key of the username of an authorized user
+const usernameKey = "username"
+
+// Rate limit scope of sending messages, shared with the websocket
+const messagesScope = chat.MessagesScope
+
+// RateLimits are limits of route groups, zero limits turn limiting off
+type RateLimits struct {
+	// Every request of a user or an IP address
+	API ratelimit.Limit
+	// Logins, registrations and password resets of an IP address
+	Auth ratelimit.Limit
+	// Messages sent over REST, shared with the websocket limit of the user
+	Messages ratelimit.Limit
+}
+
+// UseRateLimits sets limits of route groups, it has to be called before InitRoutes
+func (ep *Endpoints) UseRateLimits(limits RateLimits) {
+	ep.limits = limits
+}
+
+// rateLimit takes a token from the bucket of the user, or of the IP address
+// for requests without a valid token, and answers 429 with Retry-After when
+// the bucket is empty. Requests are let through if the limiter fails.
+func (ep *Endpoints) rateLimit(scope string, limit ratelimit.Limit) gin.HandlerFunc {
+	return func(g *gin.Context) {
+		if limit.IsZero() || ep.services.RateLimiter == nil {
+			g.Next()
+			return
+		}
+
+		key := ratelimit.IPKey(scope, g.ClientIP())
+		if tokenString := g.GetHeader("token"); tokenString != "" {
+			if username, err := ep.services.User.GetUsernameFromToken(tokenString); err == nil {
+				key = ratelimit.UserKey(scope, username)
+			}
+		}
+
+		result, err := ep.services.RateLimiter.Allow(g.Request.Context(), key, limit)
+		if err != nil {
+			logrus.Errorf("failed to check rate limit of %s : %v", key, err)
+			g.Next()
+			return
+		}
+		if !result.Allowed {
+			g.Header("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+			newErrorResponse(g, http.StatusTooManyRequests, "too many requests")
+			return
+		}
+
+		g.Next()
+	}
+}
+
+// requireRole lets through only users with the role, their username is put
+// into the context under usernameKey
+func (ep *Endpoints) requireRole(role string) gin.HandlerFunc {
+	return func(g *gin.Context) {
+		tokenString := g.GetHeader("token")
+		if tokenString == "" {
+			newErrorResponse(g, http.StatusUnauthorized, "token nil")
+			return
+		}
+
+		username, err := ep.services.User.GetUsernameFromToken(tokenString)
+		if err != nil {
+			newErrorResponse(g, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		user, err := ep.services.User.GetUserData(tokenString)
+		if err != nil {
+			newErrorResponse(g, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if user.Role != role {
+			newErrorResponse(g, http.StatusForbidden, "not enough rights")
+			return
+		}
+
+		g.Set(usernameKey, username)
+		g.Next()
+	}
+}
@@ -0,0 +1,46 @@
+package endpoints
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/ratelimit"
+	"github.com/VitalyCone/websocket-messenger/internal/app/service"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRateLimitedRouter(t *testing.T, trustedProxies []string) *gin.Engine {
+	router := gin.New()
+	assert.NoError(t, router.SetTrustedProxies(trustedProxies))
+	ep := NewEndpoints(&service.Service{RateLimiter: ratelimit.NewLocalLimiter()}, router, nil)
+	ep.UseRateLimits(RateLimits{Auth: ratelimit.PerMinute(1)})
+	ep.InitRoutes()
+	return router
+}
+
+func login(router *gin.Engine, remoteAddr, forwardedFor string) int {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/account/login", nil)
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("X-Forwarded-For", forwardedFor)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestRateLimit_SpoofedForwardedFor(t *testing.T) {
+	router := newRateLimitedRouter(t, nil)
+
+	assert.Equal(t, http.StatusBadRequest, login(router, "203.0.113.7:4000", "198.51.100.1"))
+	assert.Equal(t, http.StatusTooManyRequests, login(router, "203.0.113.7:4001", "198.51.100.2"))
+}
+
+func TestRateLimit_TrustedProxy(t *testing.T) {
+	router := newRateLimitedRouter(t, []string{"192.0.2.1"})
+
+	// clients behind the proxy get a bucket each
+	assert.Equal(t, http.StatusBadRequest, login(router, "192.0.2.1:4000", "198.51.100.1"))
+	assert.Equal(t, http.StatusBadRequest, login(router, "192.0.2.1:4001", "198.51.100.2"))
+	assert.Equal(t, http.StatusTooManyRequests, login(router, "192.0.2.1:4002", "198.51.100.1"))
+}
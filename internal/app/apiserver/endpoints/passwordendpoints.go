@@ -0,0 +1,129 @@
+package endpoints
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/service"
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Change password
+// @Schemes
+// @Description Change password of the current user. Every other session is revoked and open websockets are closed, a new token is returned
+// @Security ApiKeyAuth
+// @Tags User
+// @Accept json
+// @Produce json
+// @Param changePasswordDto body model.ChangePasswordDto true "old and new password"
+// @Success 200 {string} string "token"
+// @Failure 400,401,403 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/account/password [PUT]
+func (ep *Endpoints) ChangePassword(g *gin.Context) {
+	var changePasswordDto model.ChangePasswordDto
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	if err := g.BindJSON(&changePasswordDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := changePasswordDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	token, err := ep.services.Password.ChangePassword(username, changePasswordDto.OldPassword, changePasswordDto.NewPassword)
+	if err != nil {
+		if errors.Is(err, service.ErrWrongPassword) {
+			newErrorResponse(g, http.StatusForbidden, err.Error())
+			return
+		}
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ep.hub.DisconnectUser(username)
+	g.JSON(http.StatusOK, gin.H{"token": token})
+}
+
+// @Summary Request password reset
+// @Schemes
+// @Description Send a single-use password reset token to the email of the account
+// @Tags User
+// @Accept json
+// @Produce json
+// @Param passwordResetRequestDto body model.PasswordResetRequestDto true "account email"
+// @Success 202 {object} statusResponse
+// @Failure 400 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/account/password/reset [POST]
+func (ep *Endpoints) RequestPasswordReset(g *gin.Context) {
+	var resetRequestDto model.PasswordResetRequestDto
+
+	if err := g.BindJSON(&resetRequestDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := resetRequestDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := ep.services.Password.RequestPasswordReset(resetRequestDto.Email); err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusAccepted, statusResponse{"if the account exists, a reset token was sent"})
+}
+
+// @Summary Reset password
+// @Schemes
+// @Description Set a new password with a reset token. Every session of the account is revoked and open websockets are closed
+// @Tags User
+// @Accept json
+// @Produce json
+// @Param passwordResetDto body model.PasswordResetDto true "reset token and new password"
+// @Success 200 {object} statusResponse
+// @Failure 400 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/account/password/reset/confirm [POST]
+func (ep *Endpoints) ResetPassword(g *gin.Context) {
+	var resetDto model.PasswordResetDto
+
+	if err := g.BindJSON(&resetDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := resetDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.Password.ResetPassword(resetDto.Token, resetDto.NewPassword)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidResetToken) {
+			newErrorResponse(g, http.StatusBadRequest, err.Error())
+			return
+		}
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ep.hub.DisconnectUser(username)
+	g.JSON(http.StatusOK, statusResponse{"password changed"})
+}
@@ -0,0 +1,212 @@
+package endpoints
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/service"
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Create poll
+// @Schemes
+// @Description Post a poll message to a group chat. The question is markdown-lite like the content of messages
+// @Security ApiKeyAuth
+// @Tags Messages
+// @Accept json
+// @Produce json
+// @Param createPollDto body model.CreatePollDto true "poll"
+// @Success 201 {object} model.MessageResponse "poll message"
+// @Failure 400,401,403,404,422,429 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/messages/polls [POST]
+func (ep *Endpoints) CreatePoll(g *gin.Context) {
+	var createPollDto model.CreatePollDto
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	if err := g.BindJSON(&createPollDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := createPollDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	message, err := ep.services.Poll.CreatePoll(username, createPollDto)
+	if err != nil {
+		newPollErrorResponse(g, err)
+		return
+	}
+
+	viewer, err := ep.services.Privacy.GetViewer(username)
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ep.hub.DeliverMessage(message)
+	g.JSON(http.StatusCreated, message.ToResponseFor(viewer))
+}
+
+// @Summary Vote in poll
+// @Schemes
+// @Description Choose options of a poll, replacing the previous choice. Single choice polls take one option
+// @Security ApiKeyAuth
+// @Tags Messages
+// @Accept json
+// @Produce json
+// @Param id path int true "poll id"
+// @Param voteDto body model.VoteDto true "options"
+// @Success 200 {object} model.PollResponse "poll"
+// @Failure 400,401,403,404,409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/messages/polls/{id}/vote [POST]
+func (ep *Endpoints) Vote(g *gin.Context) {
+	var voteDto model.VoteDto
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+
+	if err := g.BindJSON(&voteDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := voteDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	ep.pollAction(g, username, func() (model.Poll, error) {
+		return ep.services.Poll.Vote(username, uint(id), voteDto.OptionIDs)
+	})
+}
+
+// @Summary Retract vote
+// @Schemes
+// @Description Remove the choice of the current user in a poll
+// @Security ApiKeyAuth
+// @Tags Messages
+// @Produce json
+// @Param id path int true "poll id"
+// @Success 200 {object} model.PollResponse "poll"
+// @Failure 400,401,403,404,409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/messages/polls/{id}/vote [DELETE]
+func (ep *Endpoints) RetractVote(g *gin.Context) {
+	ep.pollIDAction(g, ep.services.Poll.RetractVote)
+}
+
+// @Summary Close poll
+// @Schemes
+// @Description Stop a poll taking votes, only its author and chat admins can close it
+// @Security ApiKeyAuth
+// @Tags Messages
+// @Produce json
+// @Param id path int true "poll id"
+// @Success 200 {object} model.PollResponse "poll"
+// @Failure 400,401,403,404,409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/messages/polls/{id}/close [POST]
+func (ep *Endpoints) ClosePoll(g *gin.Context) {
+	ep.pollIDAction(g, ep.services.Poll.ClosePoll)
+}
+
+// pollIDAction runs an action of the user on the poll in the path
+func (ep *Endpoints) pollIDAction(g *gin.Context, action func(username string, id uint) (model.Poll, error)) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	ep.pollAction(g, username, func() (model.Poll, error) {
+		return action(username, uint(id))
+	})
+}
+
+// pollAction responds with the poll changed by the action and pushes its new tallies
+func (ep *Endpoints) pollAction(g *gin.Context, username string, action func() (model.Poll, error)) {
+	poll, err := action()
+	if err != nil {
+		newPollErrorResponse(g, err)
+		return
+	}
+
+	viewer, err := ep.services.Privacy.GetViewer(username)
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ep.hub.NotifyPollUpdated(poll.ID)
+	g.JSON(http.StatusOK, poll.ToResponse(viewer.ID))
+}
+
+// newPollErrorResponse maps errors of polls and their messages to status codes
+func newPollErrorResponse(g *gin.Context, err error) {
+	var slowModeErr *service.SlowModeError
+	switch {
+	case errors.Is(err, service.ErrPollNotFound), errors.Is(err, service.ErrThreadNotFound):
+		newErrorResponse(g, http.StatusNotFound, err.Error())
+	case errors.Is(err, service.ErrNotChatMember), errors.Is(err, service.ErrPollForbidden):
+		newErrorResponse(g, http.StatusForbidden, err.Error())
+	case errors.Is(err, service.ErrPollClosed):
+		newErrorResponse(g, http.StatusConflict, err.Error())
+	case errors.Is(err, service.ErrInvalidPollOption), errors.Is(err, service.ErrInvalidClosesAt),
+		errors.Is(err, service.ErrNotGroupChat):
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrMessageRejected):
+		newErrorResponse(g, http.StatusUnprocessableEntity, err.Error())
+	case errors.As(err, &slowModeErr):
+		g.Header("Retry-After", strconv.Itoa(int(math.Ceil(slowModeErr.Cooldown.Seconds()))))
+		newErrorResponse(g, http.StatusTooManyRequests, err.Error())
+	default:
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+	}
+}
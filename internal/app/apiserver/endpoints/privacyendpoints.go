@@ -0,0 +1,86 @@
+package endpoints
+
+import (
+	"net/http"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Get privacy settings
+// @Schemes
+// @Description Get who can see last seen time and avatar of the current user and who can add them to groups
+// @Security ApiKeyAuth
+// @Tags User
+// @Produce json
+// @Success 200 {object} model.PrivacySettings "privacy settings"
+// @Failure 401 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/account/privacy [GET]
+func (ep *Endpoints) GetPrivacy(g *gin.Context) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	settings, err := ep.services.Privacy.GetPrivacy(username)
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, settings)
+}
+
+// @Summary Update privacy settings
+// @Schemes
+// @Description Set who can see last seen time and avatar of the current user and who can add them to groups: everybody, contacts or nobody
+// @Security ApiKeyAuth
+// @Tags User
+// @Accept json
+// @Produce json
+// @Param privacySettings body model.PrivacySettings true "privacy settings"
+// @Success 200 {object} model.PrivacySettings "privacy settings"
+// @Failure 400,401 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/account/privacy [PUT]
+func (ep *Endpoints) UpdatePrivacy(g *gin.Context) {
+	var settings model.PrivacySettings
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	if err := g.BindJSON(&settings); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := settings.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if err := ep.services.Privacy.UpdatePrivacy(username, settings); err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, settings)
+}
@@ -0,0 +1,192 @@
+package endpoints
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/service"
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Report
+// @Schemes
+// @Description Report a message, chat or user to admins. Reported messages and chats have to be readable by the reporter
+// @Security ApiKeyAuth
+// @Tags Reports
+// @Accept json
+// @Produce json
+// @Param createReportDto body model.CreateReportDto true "report"
+// @Success 201 {object} model.ReportResponse "report"
+// @Failure 400,401,404,409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/reports [POST]
+func (ep *Endpoints) CreateReport(g *gin.Context) {
+	var createReportDto model.CreateReportDto
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	if err := g.BindJSON(&createReportDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := createReportDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	report, err := ep.services.Report.CreateReport(username, createReportDto)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrReportSelf):
+			newErrorResponse(g, http.StatusBadRequest, err.Error())
+		case errors.Is(err, service.ErrReportTargetNotFound):
+			newErrorResponse(g, http.StatusNotFound, err.Error())
+		case errors.Is(err, service.ErrAlreadyReported):
+			newErrorResponse(g, http.StatusConflict, err.Error())
+		default:
+			newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	g.JSON(http.StatusCreated, report.ToResponse())
+}
+
+// @Summary Admin: moderation queue
+// @Schemes
+// @Description Get reports, oldest first
+// @Security ApiKeyAuth
+// @Tags Admin,Reports
+// @Produce json
+// @Param status query string false "open, actioned or dismissed, open by default, all for every status"
+// @Param offset query int false "offset of reports"
+// @Param limit query int false "limit of reports"
+// @Success 200 {object} []model.ReportResponse "reports"
+// @Failure 401,403 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/admin/reports [GET]
+func (ep *Endpoints) AdminGetReports(g *gin.Context) {
+	limit, err := strconv.Atoi(g.Query("limit"))
+	if err != nil || limit < 0 {
+		limit = 50
+	}
+	offset, err := strconv.Atoi(g.Query("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	status := g.DefaultQuery("status", model.ReportOpen)
+	if status == "all" {
+		status = ""
+	}
+
+	reportsResp, err := ep.services.Report.GetReports(status, offset, limit)
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, reportsResp)
+}
+
+// @Summary Admin: get report
+// @Schemes
+// @Description Get a report with the snapshot of the reported object
+// @Security ApiKeyAuth
+// @Tags Admin,Reports
+// @Produce json
+// @Param id path int true "report id"
+// @Success 200 {object} model.ReportResponse "report"
+// @Failure 400,401,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/admin/reports/{id} [GET]
+func (ep *Endpoints) AdminGetReport(g *gin.Context) {
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+
+	report, err := ep.services.Report.GetReport(uint(id))
+	if err != nil {
+		if errors.Is(err, service.ErrReportNotFound) {
+			newErrorResponse(g, http.StatusNotFound, err.Error())
+			return
+		}
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, report.ToResponse())
+}
+
+// @Summary Admin: resolve report
+// @Schemes
+// @Description Mark a report actioned or dismissed, optionally deleting the reported message and suspending its author or the reported user. Other open reports of the same object are closed too
+// @Security ApiKeyAuth
+// @Tags Admin,Reports
+// @Accept json
+// @Produce json
+// @Param id path int true "report id"
+// @Param resolveReportDto body model.ResolveReportDto true "resolution"
+// @Success 200 {object} model.ReportResponse "report"
+// @Failure 400,401,403,404,409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/admin/reports/{id}/resolve [POST]
+func (ep *Endpoints) AdminResolveReport(g *gin.Context) {
+	var resolveReportDto model.ResolveReportDto
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+
+	if err := g.BindJSON(&resolveReportDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := resolveReportDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resolution, err := ep.services.Report.ResolveReport(g.GetString(usernameKey), uint(id), resolveReportDto)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidResolution), errors.Is(err, service.ErrModerateSelf):
+			newErrorResponse(g, http.StatusBadRequest, err.Error())
+		case errors.Is(err, service.ErrReportNotFound):
+			newErrorResponse(g, http.StatusNotFound, err.Error())
+		case errors.Is(err, service.ErrReportResolved):
+			newErrorResponse(g, http.StatusConflict, err.Error())
+		default:
+			newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	if resolution.SuspendedUser != "" {
+		ep.hub.DisconnectUser(resolution.SuspendedUser)
+	}
+	if resolution.DeletedMessage != nil {
+		ep.sendMessageDeleted(*resolution.DeletedMessage)
+	}
+
+	g.JSON(http.StatusOK, resolution.Report.ToResponse())
+}
@@ -0,0 +1,176 @@
+package endpoints
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/service"
+	"github.com/gin-gonic/gin"
+)
+
+// scheduleMessage answers POST /v1/messages with sendAt set
+func (ep *Endpoints) scheduleMessage(g *gin.Context, username string, createMessageDto model.CreateMessageDto) {
+	if createMessageDto.Content == "" {
+		newErrorResponse(g, http.StatusBadRequest, "invalid message")
+		return
+	}
+
+	scheduled, err := ep.services.Schedule.ScheduleMessage(username, createMessageDto)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrNotChatMember):
+			newErrorResponse(g, http.StatusForbidden, err.Error())
+		case errors.Is(err, service.ErrTooManyScheduled):
+			newErrorResponse(g, http.StatusConflict, err.Error())
+		case errors.Is(err, service.ErrInvalidSendAt):
+			newErrorResponse(g, http.StatusBadRequest, err.Error())
+		default:
+			newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	g.JSON(http.StatusAccepted, scheduled.ToResponse())
+}
+
+// @Summary Get scheduled messages
+// @Schemes
+// @Description Get messages of the user which are not sent yet, failed ones included
+// @Security ApiKeyAuth
+// @Tags Messages
+// @Produce json
+// @Param chatId query int false "chat id, every chat if not set"
+// @Success 200 {object} []model.ScheduledMessageResponse "scheduled messages"
+// @Failure 401 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/messages/scheduled [GET]
+func (ep *Endpoints) GetScheduledMessages(g *gin.Context) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	chatID, err := strconv.Atoi(g.Query("chatId"))
+	if err != nil || chatID < 0 {
+		chatID = 0
+	}
+
+	scheduledResp, err := ep.services.Schedule.GetScheduledMessages(username, uint(chatID))
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, scheduledResp)
+}
+
+// @Summary Modify scheduled message
+// @Schemes
+// @Description Change the content or the time of a message which is not sent yet. Failed messages are scheduled again
+// @Security ApiKeyAuth
+// @Tags Messages
+// @Accept json
+// @Produce json
+// @Param id path int true "scheduled message id"
+// @Param modifyScheduledMessageDto body model.ModifyScheduledMessageDto true "changes"
+// @Success 200 {object} model.ScheduledMessageResponse "scheduled message"
+// @Failure 400,401,404,409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/messages/scheduled/{id} [PATCH]
+func (ep *Endpoints) ModifyScheduledMessage(g *gin.Context) {
+	var modifyDto model.ModifyScheduledMessageDto
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+
+	if err := g.BindJSON(&modifyDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	scheduled, err := ep.services.Schedule.ModifyScheduledMessage(username, uint(id), modifyDto)
+	if err != nil {
+		newScheduledErrorResponse(g, err)
+		return
+	}
+
+	g.JSON(http.StatusOK, scheduled.ToResponse())
+}
+
+// @Summary Cancel scheduled message
+// @Schemes
+// @Description Delete a message which is not sent yet
+// @Security ApiKeyAuth
+// @Tags Messages
+// @Produce json
+// @Param id path int true "scheduled message id"
+// @Success 200 {object} statusResponse "canceled"
+// @Failure 400,401,404,409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/messages/scheduled/{id} [DELETE]
+func (ep *Endpoints) CancelScheduledMessage(g *gin.Context) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if err := ep.services.Schedule.CancelScheduledMessage(username, uint(id)); err != nil {
+		newScheduledErrorResponse(g, err)
+		return
+	}
+
+	g.JSON(http.StatusOK, statusResponse{"canceled"})
+}
+
+func newScheduledErrorResponse(g *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrScheduledNotFound):
+		newErrorResponse(g, http.StatusNotFound, err.Error())
+	case errors.Is(err, service.ErrScheduledNotPending):
+		newErrorResponse(g, http.StatusConflict, err.Error())
+	case errors.Is(err, service.ErrInvalidSendAt):
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+	default:
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,194 @@
+package endpoints
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/service"
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Get thread
+// @Schemes
+// @Description Get a root message of a group chat with a page of its replies, oldest first
+// @Security ApiKeyAuth
+// @Tags Messages
+// @Produce json
+// @Param id path int true "root message id"
+// @Param limit query int false "limit"
+// @Param offset query int false "offset"
+// @Success 200 {object} model.ThreadResponse "thread"
+// @Failure 400,401,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/messages/{id}/thread [GET]
+func (ep *Endpoints) GetThread(g *gin.Context) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+	limit, err := strconv.Atoi(g.Query("limit"))
+	if err != nil || limit < 0 {
+		limit = 15
+	}
+	offset, err := strconv.Atoi(g.Query("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	threadResp, err := ep.services.Thread.GetThread_ToResponse(username, uint(id), limit, offset)
+	if err != nil {
+		newThreadErrorResponse(g, err)
+		return
+	}
+
+	g.JSON(http.StatusOK, threadResp)
+}
+
+// @Summary Get subscribed threads
+// @Schemes
+// @Description Get threads the user is subscribed to with numbers of unread replies, the latest first
+// @Security ApiKeyAuth
+// @Tags Messages
+// @Produce json
+// @Param limit query int false "limit"
+// @Param offset query int false "offset"
+// @Success 200 {object} []model.ThreadSubscriptionResponse "threads"
+// @Failure 401 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/threads [GET]
+func (ep *Endpoints) GetThreadSubscriptions(g *gin.Context) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	limit, err := strconv.Atoi(g.Query("limit"))
+	if err != nil || limit < 0 {
+		limit = 15
+	}
+	offset, err := strconv.Atoi(g.Query("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	subscriptionsResp, err := ep.services.Thread.GetSubscriptions_ToResponse(username, offset, limit)
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, subscriptionsResp)
+}
+
+// @Summary Subscribe to thread
+// @Schemes
+// @Description Get replies of the thread delivered and counted as unread
+// @Security ApiKeyAuth
+// @Tags Messages
+// @Produce json
+// @Param id path int true "root message id"
+// @Success 200 {object} statusResponse
+// @Failure 400,401,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/messages/{id}/thread/subscription [POST]
+func (ep *Endpoints) SubscribeThread(g *gin.Context) {
+	ep.threadAction(g, ep.services.Thread.Subscribe, "subscribed")
+}
+
+// @Summary Unsubscribe from thread
+// @Schemes
+// @Description Stop getting replies of the thread until the user replies to it again
+// @Security ApiKeyAuth
+// @Tags Messages
+// @Produce json
+// @Param id path int true "root message id"
+// @Success 200 {object} statusResponse
+// @Failure 400,401 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/messages/{id}/thread/subscription [DELETE]
+func (ep *Endpoints) UnsubscribeThread(g *gin.Context) {
+	ep.threadAction(g, ep.services.Thread.Unsubscribe, "unsubscribed")
+}
+
+// @Summary Mark thread as read
+// @Schemes
+// @Description Mark every reply of the thread as read
+// @Security ApiKeyAuth
+// @Tags Messages
+// @Produce json
+// @Param id path int true "root message id"
+// @Success 200 {object} statusResponse
+// @Failure 400,401,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/messages/{id}/thread/read [POST]
+func (ep *Endpoints) MarkThreadRead(g *gin.Context) {
+	ep.threadAction(g, ep.services.Thread.MarkThreadRead, "read")
+}
+
+// threadAction runs an action of the user on the thread of the root message in the path
+func (ep *Endpoints) threadAction(g *gin.Context, action func(username string, rootID uint) error, status string) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if err := action(username, uint(id)); err != nil {
+		newThreadErrorResponse(g, err)
+		return
+	}
+
+	g.JSON(http.StatusOK, statusResponse{status})
+}
+
+// newThreadErrorResponse maps errors of threads to status codes
+func newThreadErrorResponse(g *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrThreadNotFound):
+		newErrorResponse(g, http.StatusNotFound, err.Error())
+	case errors.Is(err, service.ErrNotChatMember):
+		newErrorResponse(g, http.StatusForbidden, err.Error())
+	case errors.Is(err, service.ErrNotGroupChat):
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+	default:
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+	}
+}
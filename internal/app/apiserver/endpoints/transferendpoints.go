@@ -0,0 +1,176 @@
+package endpoints
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/service"
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Send money in a chat
+// @Schemes
+// @Description Send money to a chat member as a transfer message. The comment goes through the same filters and slow mode as other messages. The recipient accepts or declines it, declined transfers are refunded
+// @Security ApiKeyAuth
+// @Tags Messages,Wallet
+// @Accept json
+// @Produce json
+// @Param createChatTransferDto body model.CreateChatTransferDto true "transfer"
+// @Success 201 {object} model.MessageResponse "transfer message"
+// @Failure 400,401,403,404,409,422,429 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/messages/transfers [POST]
+func (ep *Endpoints) CreateChatTransfer(g *gin.Context) {
+	var createChatTransferDto model.CreateChatTransferDto
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	if err := g.BindJSON(&createChatTransferDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := createChatTransferDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	message, created, err := ep.services.Wallet.SendToChat(username, createChatTransferDto)
+	if err != nil {
+		var slowModeErr *service.SlowModeError
+		switch {
+		case errors.Is(err, service.ErrChatNotFound):
+			newErrorResponse(g, http.StatusNotFound, err.Error())
+		case errors.Is(err, service.ErrTransferRecipient):
+			newErrorResponse(g, http.StatusBadRequest, err.Error())
+		case errors.Is(err, service.ErrUserBlocked):
+			newErrorResponse(g, http.StatusForbidden, err.Error())
+		case errors.Is(err, service.ErrIdempotencyConflict):
+			newErrorResponse(g, http.StatusConflict, err.Error())
+		case errors.Is(err, service.ErrInsufficientFunds), errors.Is(err, service.ErrMessageRejected):
+			newErrorResponse(g, http.StatusUnprocessableEntity, err.Error())
+		case errors.As(err, &slowModeErr):
+			g.Header("Retry-After", strconv.Itoa(int(math.Ceil(slowModeErr.Cooldown.Seconds()))))
+			newErrorResponse(g, http.StatusTooManyRequests, err.Error())
+		default:
+			newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	viewer, err := ep.services.Privacy.GetViewer(username)
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// a repeated request was announced the first time
+	if created {
+		recipients := make([]string, 0, len(message.Chat.Users))
+		for _, user := range message.Chat.Users {
+			if user.Username != username {
+				recipients = append(recipients, user.Username)
+			}
+		}
+		ep.hub.SendEvent(model.MessageWS{
+			Type:       model.MessageTransfer,
+			Sender:     username,
+			Recipients: recipients,
+			Content:    message.Content,
+			ChatID:     message.ChatID,
+			Data:       message.Transfer.ToResponse(),
+		})
+	}
+
+	g.JSON(http.StatusCreated, message.ToResponseFor(viewer))
+}
+
+// @Summary Accept transfer
+// @Schemes
+// @Description Accept a pending transfer sent to the current user
+// @Security ApiKeyAuth
+// @Tags Messages,Wallet
+// @Produce json
+// @Param id path int true "transfer id"
+// @Success 200 {object} model.ChatTransferResponse "transfer"
+// @Failure 400,401,404,409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/messages/transfers/{id}/accept [POST]
+func (ep *Endpoints) AcceptChatTransfer(g *gin.Context) {
+	ep.settleChatTransfer(g, ep.services.Wallet.AcceptTransfer)
+}
+
+// @Summary Decline transfer
+// @Schemes
+// @Description Decline a pending transfer sent to the current user, the money goes back to the sender
+// @Security ApiKeyAuth
+// @Tags Messages,Wallet
+// @Produce json
+// @Param id path int true "transfer id"
+// @Success 200 {object} model.ChatTransferResponse "transfer"
+// @Failure 400,401,404,409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/messages/transfers/{id}/decline [POST]
+func (ep *Endpoints) DeclineChatTransfer(g *gin.Context) {
+	ep.settleChatTransfer(g, ep.services.Wallet.DeclineTransfer)
+}
+
+func (ep *Endpoints) settleChatTransfer(g *gin.Context, settle func(username string, id uint) (model.ChatTransfer, error)) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	transfer, err := settle(username, uint(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrTransferNotFound):
+			newErrorResponse(g, http.StatusNotFound, err.Error())
+		case errors.Is(err, service.ErrTransferNotPending):
+			newErrorResponse(g, http.StatusConflict, err.Error())
+		default:
+			newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	transferResp := transfer.ToResponse()
+	ep.hub.SendEvent(model.MessageWS{
+		Type:       "transfer_updated",
+		Sender:     username,
+		Recipients: []string{transfer.Sender.Username, transfer.Recipient.Username},
+		ChatID:     transfer.ChatID,
+		Content:    transfer.Status,
+		Data:       transferResp,
+	})
+
+	g.JSON(http.StatusOK, transferResp)
+}
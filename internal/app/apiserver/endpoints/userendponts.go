@@ -1,12 +1,15 @@
 package endpoints
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/service"
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 )
 
 // @Summary Register for user
@@ -17,7 +20,7 @@ import (
 // @Produce json
 // @Param createUserDto body model.CreateUserDto true "Create user dto for register in"
 // @Success 201 {string} string "token"
-// @Failure 400,404 {object} errorResponse
+// @Failure 400,404,409 {object} errorResponse
 // @Failure 500 {object} errorResponse
 // @Failure default {object} errorResponse
 // @Router /v1/account/register [POST]
@@ -37,6 +40,10 @@ func (ep *Endpoints) RegisterUser(g *gin.Context){
 
     token, err := ep.services.User.RegisterUser(user)
     if err != nil {
+        if errors.Is(err, service.ErrEmailTaken) {
+            newErrorResponse(g, http.StatusConflict, err.Error())
+            return
+        }
         newErrorResponse(g, http.StatusInternalServerError, err.Error())
         return
     }
@@ -52,7 +59,7 @@ func (ep *Endpoints) RegisterUser(g *gin.Context){
 // @Produce json
 // @Param userDto body model.UserDto true "Login user dt"
 // @Success 200 {string} string "token"
-// @Failure 400,404 {object} errorResponse
+// @Failure 400,403,404 {object} errorResponse
 // @Failure 500 {object} errorResponse
 // @Failure default {object} errorResponse
 // @Router /v1/account/login [POST]
@@ -68,6 +75,10 @@ func (ep *Endpoints) LoginUser(g *gin.Context){
 
     token, err := ep.services.User.LoginUser(user)
     if err != nil {
+        if errors.Is(err, service.ErrUserSuspended) {
+            newErrorResponse(g, http.StatusForbidden, err.Error())
+            return
+        }
         newErrorResponse(g, http.StatusInternalServerError, err.Error())
         return
     }
@@ -93,30 +104,31 @@ func (ep *Endpoints) GetUserData(g *gin.Context){
         newErrorResponse(g, http.StatusUnauthorized, "token nil")
         return
     }
-    _, err := ep.services.User.GetUsernameFromToken(tokenString)
-    if err != nil{
+    user, err := ep.services.User.GetUserData(tokenString)
+    if err != nil {
         newErrorResponse(g, http.StatusUnauthorized, err.Error())
+        return
     }
-
-    user, err := ep.services.User.GetUserData(tokenString)
+    userResponse := user.ToResponse()
+    balance, err := ep.services.Wallet.GetBalance(user.Username)
     if err != nil {
         newErrorResponse(g, http.StatusInternalServerError, err.Error())
         return
     }
-    userResponse := user.ToResponse()
+    userResponse.Balance = &balance
     g.JSON(http.StatusOK, userResponse)
 }
 
-// @Summary Get user data
+// @Summary Search users
 // @Schemes
-// @Description Get user data by token
+// @Description Search users by a part of the username. Contacts go first, deleted accounts are excluded
 // @Security ApiKeyAuth
 // @Tags User
 // @Accept json
 // @Produce json
 // @Param username query string true "username query"
 // @Param offset query int false "offset from first responses"
-// @Param limit query int false "restriction on return of publications"
+// @Param limit query int false "page size, 20 by default and 50 at most"
 // @Success 200 {object} []model.UserResponse "users response"
 // @Failure 400,404,401 {object} errorResponse
 // @Failure 500 {object} errorResponse
@@ -126,6 +138,17 @@ func (ep *Endpoints) GetUsersByUsername(g *gin.Context){
     var offset , limit int
     var username string
 
+    tokenString := g.GetHeader("token")
+    if tokenString == ""{
+        newErrorResponse(g, http.StatusUnauthorized, "token nil")
+        return
+    }
+    ownUsername, err := ep.services.User.GetUsernameFromToken(tokenString)
+    if err != nil{
+        newErrorResponse(g, http.StatusUnauthorized, err.Error())
+        return
+    }
+
     queryOffset,exists := g.GetQuery("offset")
     if exists{
         queryOffset, err := strconv.Atoi(queryOffset)
@@ -144,27 +167,131 @@ func (ep *Endpoints) GetUsersByUsername(g *gin.Context){
     if exists{
         queryLimit, err := strconv.Atoi(queryLimit)
         if err != nil{
-            newErrorResponse(g, http.StatusBadRequest, "offset is not int")
+            newErrorResponse(g, http.StatusBadRequest, "limit is not int")
             return
         }
         limit = queryLimit
         if limit < 0{
-            newErrorResponse(g, http.StatusBadRequest, "offset is not positive")
+            newErrorResponse(g, http.StatusBadRequest, "limit is not positive")
             return
         }
-    }else{limit = 10000}
+        if limit > service.MaxSearchLimit{
+            limit = service.MaxSearchLimit
+        }
+    }else{limit = service.DefaultSearchLimit}
 
     usernameQuery ,exists := g.GetQuery("username")
     if exists{
         username = strings.TrimSpace(usernameQuery)
     }else{
         newErrorResponse(g, http.StatusBadRequest, "username is not provided")
+        return
     }
 
 
-    usersResp, err := ep.services.User.GetUsersWithQuery_ToResponse(username, offset, limit)
+    usersResp, err := ep.services.Contact.SearchUsers_ToResponse(ownUsername, username, offset, limit)
     if err != nil{
         newErrorResponse(g,http.StatusInternalServerError, err.Error())
+        return
     }
     g.JSON(http.StatusOK, usersResp)
-}
\ No newline at end of file
+}
+// @Summary Modify user profile
+// @Schemes
+// @Description Update first/second name, avatar or username of the current user. Changing the username returns a new token
+// @Security ApiKeyAuth
+// @Tags User
+// @Accept json
+// @Produce json
+// @Param modifyUserDto body model.ModifyUserDto true "fields to update"
+// @Success 200 {object} model.ModifyUserResponse "user response"
+// @Failure 400,401,409,429 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/account [PATCH]
+func (ep *Endpoints) ModifyUser(g *gin.Context){
+    var modifyUserDto model.ModifyUserDto
+
+    tokenString := g.GetHeader("token")
+    if tokenString == ""{
+        newErrorResponse(g, http.StatusUnauthorized, "token nil")
+        return
+    }
+
+    if err := g.BindJSON(&modifyUserDto); err != nil {
+        newErrorResponse(g, http.StatusBadRequest, err.Error())
+        return
+    }
+    if err := modifyUserDto.Validate(); err != nil {
+        newErrorResponse(g, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    username, err := ep.services.User.GetUsernameFromToken(tokenString)
+    if err != nil{
+        newErrorResponse(g, http.StatusUnauthorized, err.Error())
+        return
+    }
+
+    user, token, err := ep.services.User.ModifyUser(username, modifyUserDto)
+    if err != nil{
+        switch {
+        case errors.Is(err, service.ErrUsernameTaken):
+            newErrorResponse(g, http.StatusConflict, err.Error())
+        case errors.Is(err, service.ErrUsernameCooldown):
+            newErrorResponse(g, http.StatusTooManyRequests, err.Error())
+        case errors.Is(err, service.ErrInvalidAvatar):
+            newErrorResponse(g, http.StatusBadRequest, err.Error())
+        default:
+            newErrorResponse(g, http.StatusInternalServerError, err.Error())
+        }
+        return
+    }
+
+    response := model.ModifyUserResponse{User: user.ToResponse()}
+    if token != nil{
+        response.Token = token.(string)
+        ep.hub.DisconnectUser(username)
+    }
+
+    companions, err := ep.services.Chat.GetCompanions(user.Username)
+    if err != nil{
+        logrus.Errorf("failed to get companions of %s : %v", user.Username, err)
+    }else if len(companions) > 0{
+        ep.sendProfileUpdated(user, companions)
+    }
+
+    g.JSON(http.StatusOK, response)
+}
+
+// sendProfileUpdated tells companions about the new profile, the avatar is
+// sent only to the ones allowed to see it
+func (ep *Endpoints) sendProfileUpdated(user model.User, companions []string){
+    allowed, denied, err := ep.services.Privacy.SplitAudience(user.Username, model.PrivacyProfilePhoto, companions)
+    if err != nil{
+        logrus.Errorf("failed to apply privacy settings of %s : %v", user.Username, err)
+        return
+    }
+
+    userResponse := user.ToResponse()
+    userResponse.LastSeenAt = nil
+    if len(allowed) > 0{
+        ep.hub.SendEvent(model.MessageWS{
+            Type:       "profile_updated",
+            Sender:     user.Username,
+            Recipients: allowed,
+            Data:       userResponse,
+        })
+    }
+
+    userResponse.Avatar = nil
+    userResponse.AvatarThumb = nil
+    if len(denied) > 0{
+        ep.hub.SendEvent(model.MessageWS{
+            Type:       "profile_updated",
+            Sender:     user.Username,
+            Recipients: denied,
+            Data:       userResponse,
+        })
+    }
+}
@@ -0,0 +1,195 @@
+package endpoints
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// @Summary Get wallet balance
+// @Schemes
+// @Description Get wallet balance of the current user in minor units
+// @Security ApiKeyAuth
+// @Tags Wallet
+// @Produce json
+// @Success 200 {object} model.WalletBalanceResponse "balance"
+// @Failure 401 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/wallet [GET]
+func (ep *Endpoints) GetWallet(g *gin.Context) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	balance, err := ep.services.Wallet.GetBalance(username)
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, model.WalletBalanceResponse{Balance: balance})
+}
+
+// @Summary Transfer money
+// @Schemes
+// @Description Transfer money to another user. Repeating a request with the same idempotency key doesn't transfer twice
+// @Security ApiKeyAuth
+// @Tags Wallet
+// @Accept json
+// @Produce json
+// @Param transferDto body model.TransferDto true "transfer"
+// @Success 201 {object} model.WalletTransactionResponse "transaction"
+// @Failure 400,401,403,404,409,422 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/wallet/transfers [POST]
+func (ep *Endpoints) CreateTransfer(g *gin.Context) {
+	var transferDto model.TransferDto
+
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	if err := g.BindJSON(&transferDto); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := transferDto.Validate(); err != nil {
+		newErrorResponse(g, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	transaction, err := ep.services.Wallet.Transfer(username, transferDto)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrTransferSelf):
+			newErrorResponse(g, http.StatusBadRequest, err.Error())
+		case errors.Is(err, service.ErrUserBlocked):
+			newErrorResponse(g, http.StatusForbidden, err.Error())
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			newErrorResponse(g, http.StatusNotFound, "user not found")
+		case errors.Is(err, service.ErrIdempotencyConflict):
+			newErrorResponse(g, http.StatusConflict, err.Error())
+		case errors.Is(err, service.ErrInsufficientFunds):
+			newErrorResponse(g, http.StatusUnprocessableEntity, err.Error())
+		default:
+			newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	g.JSON(http.StatusCreated, transaction.ToResponse(username))
+}
+
+// @Summary Get wallet history
+// @Schemes
+// @Description Get transactions of the current user, newest first
+// @Security ApiKeyAuth
+// @Tags Wallet
+// @Produce json
+// @Param offset query int false "offset of transactions"
+// @Param limit query int false "limit of transactions, 15 by default and 50 at most"
+// @Success 200 {object} []model.WalletTransactionResponse "transactions"
+// @Failure 401 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/wallet/transactions [GET]
+func (ep *Endpoints) GetWalletTransactions(g *gin.Context) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	limit, err := strconv.Atoi(g.Query("limit"))
+	if err != nil || limit < 0 {
+		limit = service.DefaultTransactionsLimit
+	}
+	if limit > service.MaxTransactionsLimit {
+		limit = service.MaxTransactionsLimit
+	}
+	offset, err := strconv.Atoi(g.Query("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	transactions, err := ep.services.Wallet.GetTransactions(username, offset, limit)
+	if err != nil {
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, transactions)
+}
+
+// @Summary Get wallet transaction
+// @Schemes
+// @Description Get a transaction of the current user
+// @Security ApiKeyAuth
+// @Tags Wallet
+// @Produce json
+// @Param id path int true "transaction id"
+// @Success 200 {object} model.WalletTransactionResponse "transaction"
+// @Failure 400,401,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /v1/wallet/transactions/{id} [GET]
+func (ep *Endpoints) GetWalletTransaction(g *gin.Context) {
+	tokenString := g.GetHeader("token")
+	if tokenString == "" {
+		newErrorResponse(g, http.StatusUnauthorized, "token nil")
+		return
+	}
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil {
+		newErrorResponse(g, http.StatusBadRequest, "Invalid type of id")
+		return
+	}
+
+	username, err := ep.services.User.GetUsernameFromToken(tokenString)
+	if err != nil {
+		newErrorResponse(g, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	transaction, err := ep.services.Wallet.GetTransaction(username, uint(id))
+	if err != nil {
+		if errors.Is(err, service.ErrTransactionNotFound) {
+			newErrorResponse(g, http.StatusNotFound, err.Error())
+			return
+		}
+		newErrorResponse(g, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	g.JSON(http.StatusOK, transaction)
+}
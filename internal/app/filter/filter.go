@@ -0,0 +1,82 @@
+package filter
+
+import (
+	"fmt"
+	"time"
+)
+
+// Action is what a filter does with a message it doesn't like
+type Action string
+
+const (
+	Allow  Action = ""
+	Reject Action = "reject"
+	Mask   Action = "mask"
+	Flag   Action = "flag"
+)
+
+// ParseAction returns the action by its name, Reject for an empty name
+func ParseAction(name string) (Action, error) {
+	switch Action(name) {
+	case "", Reject:
+		return Reject, nil
+	case Mask, Flag:
+		return Action(name), nil
+	}
+	return Allow, fmt.Errorf("unknown filter action %q", name)
+}
+
+// Message is an outgoing message checked by filters
+type Message struct {
+	ChatID   uint
+	SenderID uint
+	Content  string
+	SentAt   time.Time
+}
+
+// Verdict is a decision of a single filter. Content is the masked content for the Mask action.
+type Verdict struct {
+	Action  Action
+	Reason  string
+	Content string
+}
+
+// Filter checks an outgoing message
+type Filter interface {
+	Check(message Message) (Verdict, error)
+}
+
+// Result is a decision of the whole chain
+type Result struct {
+	// Content of the message after masking
+	Content string
+	// Reason of the rejection, empty if the message is accepted
+	Rejected string
+	// Reasons to flag the message for moderation
+	Flags []string
+}
+
+// Chain runs filters in order. Masked content is passed to the next filters,
+// the first rejection stops the chain.
+type Chain []Filter
+
+func (c Chain) Run(message Message) (Result, error) {
+	var result Result
+	for _, filter := range c {
+		verdict, err := filter.Check(message)
+		if err != nil {
+			return Result{}, err
+		}
+
+		switch verdict.Action {
+		case Reject:
+			return Result{Content: message.Content, Rejected: verdict.Reason}, nil
+		case Mask:
+			message.Content = verdict.Content
+		case Flag:
+			result.Flags = append(result.Flags, verdict.Reason)
+		}
+	}
+	result.Content = message.Content
+	return result, nil
+}
@@ -0,0 +1,80 @@
+package filter_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/filter"
+	"github.com/stretchr/testify/assert"
+)
+
+type chatWords map[uint][]string
+
+func (w chatWords) BannedWords(chatID uint) ([]string, error) {
+	return w[chatID], nil
+}
+
+type history []string
+
+func (h history) SentSince(senderID uint, since time.Time) ([]string, error) {
+	return h, nil
+}
+
+func TestWords(t *testing.T) {
+	words := filter.NewWords([]string{"Darn"}, chatWords{2: {"heck"}}, filter.Mask)
+
+	testCases := []struct {
+		name     string
+		chatID   uint
+		content  string
+		expected filter.Verdict
+	}{
+		{"clean", 1, "hello there", filter.Verdict{}},
+		{"global word", 1, "darn it, DARN!", filter.Verdict{Action: filter.Mask, Reason: "message contains banned words", Content: "**** it, ****!"}},
+		{"part of a word", 1, "darning socks", filter.Verdict{}},
+		{"chat word", 2, "what the heck", filter.Verdict{Action: filter.Mask, Reason: "message contains banned words", Content: "what the ****"}},
+		{"word of another chat", 1, "what the heck", filter.Verdict{}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			verdict, err := words.Check(filter.Message{ChatID: tc.chatID, Content: tc.content})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, verdict)
+		})
+	}
+}
+
+func TestLinks(t *testing.T) {
+	links := filter.NewLinks([]string{"spam.com"}, filter.Mask)
+
+	verdict, err := links.Check(filter.Message{Content: "see https://promo.spam.com/win now"})
+	assert.NoError(t, err)
+	assert.Equal(t, filter.Mask, verdict.Action)
+	assert.Equal(t, "see "+strings.Repeat("*", len("https://promo.spam.com/win"))+" now", verdict.Content)
+
+	verdict, err = links.Check(filter.Message{Content: "see https://notspam.com and example.org"})
+	assert.NoError(t, err)
+	assert.Equal(t, filter.Allow, verdict.Action)
+}
+
+func TestChain(t *testing.T) {
+	chain := filter.Chain{
+		filter.MaxLength(20),
+		filter.NewWords([]string{"darn"}, nil, filter.Mask),
+		filter.NewLinks([]string{"spam.com"}, filter.Flag),
+		filter.NewRepeat(history{"again", "AGAIN "}, 2, time.Minute, filter.Reject),
+	}
+
+	result, err := chain.Run(filter.Message{Content: "darn, spam.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, filter.Result{Content: "****, spam.com", Flags: []string{"message contains a blocked link"}}, result)
+
+	result, err = chain.Run(filter.Message{Content: "this message is way too long"})
+	assert.NoError(t, err)
+	assert.Equal(t, "message is longer than 20 characters", result.Rejected)
+
+	result, err = chain.Run(filter.Message{Content: "again"})
+	assert.NoError(t, err)
+	assert.Equal(t, "you are sending the same message too often", result.Rejected)
+}
@@ -0,0 +1,188 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxLength rejects messages longer than the limit in characters
+type MaxLength int
+
+func (l MaxLength) Check(message Message) (Verdict, error) {
+	if l > 0 && utf8.RuneCountInString(message.Content) > int(l) {
+		return Verdict{Action: Reject, Reason: fmt.Sprintf("message is longer than %d characters", l)}, nil
+	}
+	return Verdict{}, nil
+}
+
+// WordSource returns words banned in a chat
+type WordSource interface {
+	BannedWords(chatID uint) ([]string, error)
+}
+
+// Words catches banned words, case insensitive and as whole words only.
+// Masking replaces every letter of a banned word with an asterisk.
+type Words struct {
+	words  []string
+	source WordSource
+	action Action
+}
+
+// NewWords creates a filter of words banned everywhere plus the ones the source
+// returns for the chat of the message. The source may be nil.
+func NewWords(words []string, source WordSource, action Action) *Words {
+	return &Words{
+		words:  words,
+		source: source,
+		action: action,
+	}
+}
+
+func (f *Words) Check(message Message) (Verdict, error) {
+	words := f.words
+	if f.source != nil {
+		chatWords, err := f.source.BannedWords(message.ChatID)
+		if err != nil {
+			return Verdict{}, err
+		}
+		words = append(words[:len(words):len(words)], chatWords...)
+	}
+	if len(words) == 0 {
+		return Verdict{}, nil
+	}
+
+	banned := make(map[string]bool, len(words))
+	for _, word := range words {
+		banned[strings.ToLower(word)] = true
+	}
+
+	content := []rune(message.Content)
+	found := false
+	for start := 0; start < len(content); {
+		if !isWordRune(content[start]) {
+			start++
+			continue
+		}
+		end := start
+		for end < len(content) && isWordRune(content[end]) {
+			end++
+		}
+		if banned[strings.ToLower(string(content[start:end]))] {
+			found = true
+			for i := start; i < end; i++ {
+				content[i] = '*'
+			}
+		}
+		start = end
+	}
+	if !found {
+		return Verdict{}, nil
+	}
+	return Verdict{Action: f.action, Reason: "message contains banned words", Content: string(content)}, nil
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+var linkPattern = regexp.MustCompile(`(?i)(?:https?://)?((?:[\p{L}\d-]+\.)+\p{L}{2,})(?::\d+)?(?:/\S*)?`)
+
+// Links catches links to the blocked domains and their subdomains.
+// Masking replaces the whole link with asterisks.
+type Links struct {
+	domains []string
+	action  Action
+}
+
+func NewLinks(domains []string, action Action) *Links {
+	normalized := make([]string, len(domains))
+	for i, domain := range domains {
+		normalized[i] = strings.TrimPrefix(strings.ToLower(domain), ".")
+	}
+	return &Links{
+		domains: normalized,
+		action:  action,
+	}
+}
+
+func (f *Links) Check(message Message) (Verdict, error) {
+	if len(f.domains) == 0 {
+		return Verdict{}, nil
+	}
+
+	found := false
+	content := linkPattern.ReplaceAllStringFunc(message.Content, func(link string) string {
+		host := strings.ToLower(linkPattern.FindStringSubmatch(link)[1])
+		if !f.isBlocked(host) {
+			return link
+		}
+		found = true
+		return strings.Repeat("*", utf8.RuneCountInString(link))
+	})
+	if !found {
+		return Verdict{}, nil
+	}
+	return Verdict{Action: f.action, Reason: "message contains a blocked link", Content: content}, nil
+}
+
+func (f *Links) isBlocked(host string) bool {
+	for _, domain := range f.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// History returns contents of messages the user sent since the time
+type History interface {
+	SentSince(senderID uint, since time.Time) ([]string, error)
+}
+
+// Repeat catches users sending the same message more than limit times within
+// the window, in any chats. Repeated messages can't be masked, so Mask rejects them.
+type Repeat struct {
+	history History
+	limit   int
+	window  time.Duration
+	action  Action
+}
+
+func NewRepeat(history History, limit int, window time.Duration, action Action) *Repeat {
+	if action == Mask {
+		action = Reject
+	}
+	return &Repeat{
+		history: history,
+		limit:   limit,
+		window:  window,
+		action:  action,
+	}
+}
+
+func (f *Repeat) Check(message Message) (Verdict, error) {
+	if f.limit <= 0 {
+		return Verdict{}, nil
+	}
+
+	sent, err := f.history.SentSince(message.SenderID, message.SentAt.Add(-f.window))
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	content := strings.TrimSpace(message.Content)
+	repeats := 0
+	for _, previous := range sent {
+		if strings.EqualFold(strings.TrimSpace(previous), content) {
+			repeats++
+		}
+	}
+	if repeats < f.limit {
+		return Verdict{}, nil
+	}
+	return Verdict{Action: f.action, Reason: "you are sending the same message too often", Content: message.Content}, nil
+}
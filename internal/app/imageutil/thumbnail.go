@@ -0,0 +1,77 @@
+package imageutil
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+)
+
+const (
+	// Size of the longest side of generated thumbnails in pixels.
+	ThumbnailSize = 128
+
+	// Maximum size of an uploaded image in bytes.
+	MaxImageSize = 2 << 20
+
+	// Maximum number of pixels of an uploaded image, a small file may declare
+	// dimensions that don't fit in memory once decoded.
+	MaxImagePixels = 4096 * 4096
+
+	thumbnailQuality = 85
+)
+
+var ErrImageTooLarge = errors.New("image is too large")
+
+// Thumbnail decodes a jpeg, png or gif image and returns a jpeg copy whose
+// longest side is at most size pixels
+func Thumbnail(data []byte, size int) ([]byte, error) {
+	if len(data) > MaxImageSize {
+		return nil, ErrImageTooLarge
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > MaxImagePixels {
+		return nil, ErrImageTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil, errors.New("image is empty")
+	}
+
+	dstWidth, dstHeight := width, height
+	if width > size || height > size {
+		if width >= height {
+			dstWidth, dstHeight = size, max(1, height*size/width)
+		} else {
+			dstWidth, dstHeight = max(1, width*size/height), size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		srcY := bounds.Min.Y + y*height/dstHeight
+		for x := 0; x < dstWidth; x++ {
+			srcX := bounds.Min.X + x*width/dstWidth
+			dst.Set(x, y, src.At(srcX, srcY))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
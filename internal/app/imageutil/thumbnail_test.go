@@ -0,0 +1,67 @@
+package imageutil_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/imageutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, 0, color.RGBA{R: 255, A: 255})
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func TestThumbnail(t *testing.T) {
+	testCases := []struct {
+		name           string
+		width, height  int
+		expectedWidth  int
+		expectedHeight int
+	}{
+		{name: "landscape", width: 512, height: 256, expectedWidth: 128, expectedHeight: 64},
+		{name: "portrait", width: 100, height: 400, expectedWidth: 32, expectedHeight: 128},
+		{name: "small image keeps size", width: 40, height: 30, expectedWidth: 40, expectedHeight: 30},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			thumb, err := imageutil.Thumbnail(testPNG(t, tc.width, tc.height), imageutil.ThumbnailSize)
+			assert.NoError(t, err)
+
+			cfg, format, err := image.DecodeConfig(bytes.NewReader(thumb))
+			assert.NoError(t, err)
+			assert.Equal(t, "jpeg", format)
+			assert.Equal(t, tc.expectedWidth, cfg.Width)
+			assert.Equal(t, tc.expectedHeight, cfg.Height)
+		})
+	}
+}
+
+func TestThumbnail_Invalid(t *testing.T) {
+	_, err := imageutil.Thumbnail([]byte("not an image"), imageutil.ThumbnailSize)
+	assert.Error(t, err)
+
+	_, err = imageutil.Thumbnail(make([]byte, imageutil.MaxImageSize+1), imageutil.ThumbnailSize)
+	assert.ErrorIs(t, err, imageutil.ErrImageTooLarge)
+
+	// a few bytes declaring 50000x50000 pixels
+	data := testPNG(t, 1, 1)
+	binary.BigEndian.PutUint32(data[16:], 50000)
+	binary.BigEndian.PutUint32(data[20:], 50000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	_, err = imageutil.Thumbnail(data, imageutil.ThumbnailSize)
+	assert.ErrorIs(t, err, imageutil.ErrImageTooLarge)
+}
@@ -0,0 +1,6 @@
+package mailer
+
+// Mailer delivers plain text emails to users
+type Mailer interface {
+	Send(to, subject, body string) error
+}
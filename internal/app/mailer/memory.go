@@ -0,0 +1,47 @@
+package mailer
+
+import "sync"
+
+// Mail is a single email captured by MemoryMailer
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// MemoryMailer keeps sent emails in memory instead of delivering them.
+// Used in tests and when no SMTP relay is configured.
+type MemoryMailer struct {
+	mu    sync.Mutex
+	mails []Mail
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) Send(to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mails = append(m.mails, Mail{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+// Mails returns a copy of all captured emails
+func (m *MemoryMailer) Mails() []Mail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mails := make([]Mail, len(m.mails))
+	copy(mails, m.mails)
+	return mails
+}
+
+// Last returns the most recently captured email
+func (m *MemoryMailer) Last() (Mail, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.mails) == 0 {
+		return Mail{}, false
+	}
+	return m.mails[len(m.mails)-1], true
+}
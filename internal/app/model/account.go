@@ -0,0 +1,61 @@
+package model
+
+import (
+	"time"
+
+	"github.com/go-playground/validator"
+	"gorm.io/gorm"
+)
+
+const (
+	DataExportPending  = "pending"
+	DataExportBuilding = "building"
+	DataExportReady    = "ready"
+	DataExportFailed   = "failed"
+)
+
+// DataExport is an archive of everything stored about a user, built in the background.
+// A replica building the export holds it until LockedUntil under its own LeaseToken.
+// A user has at most one export pending or building.
+type DataExport struct {
+	gorm.Model
+	UserID      uint   `gorm:"not null;index;uniqueIndex:idx_data_exports_in_progress,where:status <> 'ready' AND status <> 'failed'"`
+	Status      string `gorm:"not null;default:pending"`
+	Archive     []byte
+	Error       string
+	CompletedAt *time.Time
+	LockedUntil *time.Time
+	LeaseToken  string `gorm:"size:32;not null;default:''"`
+}
+
+func (e *DataExport) ToResponse() DataExportResponse {
+	return DataExportResponse{
+		ID:          e.ID,
+		Status:      e.Status,
+		Error:       e.Error,
+		Size:        len(e.Archive),
+		CreatedAt:   e.CreatedAt,
+		CompletedAt: e.CompletedAt,
+	}
+}
+
+type DataExportResponse struct {
+	ID          uint       `json:"id"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	Size        int        `json:"size"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	CompletedAt *time.Time `json:"completedAt"`
+}
+
+type DeleteAccountDto struct {
+	Password string `json:"password" form:"password" validate:"required"`
+}
+
+func (d *DeleteAccountDto) Validate() error {
+	return validator.New().Struct(d)
+}
+
+type AccountDeletionResponse struct {
+	DeletionScheduledAt time.Time `json:"deletionScheduledAt"`
+}
@@ -0,0 +1,105 @@
+package model
+
+import (
+	"time"
+
+	"github.com/go-playground/validator"
+)
+
+// Roles of users
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Actions written to the admin audit log
+const (
+	AuditListUsers     = "list_users"
+	AuditSuspendUser   = "suspend_user"
+	AuditUnsuspendUser = "unsuspend_user"
+	AuditForceLogout   = "force_logout"
+	AuditSetRole       = "set_role"
+	AuditDeleteMessage = "delete_message"
+	AuditViewChat      = "view_chat"
+	AuditResolveReport = "resolve_report"
+)
+
+// AdminAuditLog is a record of an action made by an admin
+type AdminAuditLog struct {
+	ID         uint   `gorm:"primarykey"`
+	AdminID    uint   `gorm:"not null;index"`
+	Admin      User   `gorm:"foreignKey:AdminID"`
+	Action     string `gorm:"size:32;not null;index"`
+	TargetType string `gorm:"size:16"` // user, message or chat
+	TargetID   uint
+	Details    string
+	CreatedAt  time.Time `gorm:"index"`
+}
+
+func (l *AdminAuditLog) ToResponse() AdminAuditLogResponse {
+	return AdminAuditLogResponse{
+		ID:         l.ID,
+		Admin:      l.Admin.Username,
+		Action:     l.Action,
+		TargetType: l.TargetType,
+		TargetID:   l.TargetID,
+		Details:    l.Details,
+		CreatedAt:  l.CreatedAt,
+	}
+}
+
+type AdminAuditLogResponse struct {
+	ID         uint      `json:"id"`
+	Admin      string    `json:"admin"`
+	Action     string    `json:"action"`
+	TargetType string    `json:"targetType"`
+	TargetID   uint      `json:"targetId"`
+	Details    string    `json:"details"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// AdminUserResponse is a user as seen by admins, with the private fields
+type AdminUserResponse struct {
+	UserResponse
+	Email               string     `json:"email"`
+	SuspendedAt         *time.Time `json:"suspendedAt"`
+	SuspensionReason    string     `json:"suspensionReason"`
+	DeletionScheduledAt *time.Time `json:"deletionScheduledAt"`
+}
+
+func (m *User) ToAdminResponse() AdminUserResponse {
+	return AdminUserResponse{
+		UserResponse:        m.ToResponse(),
+		Email:               m.Email,
+		SuspendedAt:         m.SuspendedAt,
+		SuspensionReason:    m.SuspensionReason,
+		DeletionScheduledAt: m.DeletionScheduledAt,
+	}
+}
+
+// AdminChatResponse is chat metadata for moderation, without message contents
+type AdminChatResponse struct {
+	ID             uint                `json:"id"`
+	Name           string              `json:"name"`
+	IsGroup        bool                `json:"isGroup"`
+	Users          []AdminUserResponse `json:"users"`
+	MessageCount   int64               `json:"messageCount"`
+	LastActivityAt *time.Time          `json:"lastActivityAt"`
+	CreatedAt      time.Time           `json:"createdAt"`
+}
+
+type SuspendUserDto struct {
+	Reason string `json:"reason" form:"reason" validate:"required,max=255"`
+}
+
+func (s *SuspendUserDto) Validate() error {
+	return validator.New().Struct(s)
+}
+
+type SetRoleDto struct {
+	Role string `json:"role" form:"role" validate:"required,oneof=user admin"`
+}
+
+func (s *SetRoleDto) Validate() error {
+	return validator.New().Struct(s)
+}
@@ -0,0 +1,24 @@
+package model
+
+import (
+	"time"
+
+	"github.com/go-playground/validator"
+)
+
+// UserBlock means Blocker doesn't want to hear from Blocked
+type UserBlock struct {
+	ID        uint `gorm:"primarykey"`
+	BlockerID uint `gorm:"not null;uniqueIndex:idx_user_blocks_pair"`
+	BlockedID uint `gorm:"not null;uniqueIndex:idx_user_blocks_pair;index"`
+	Blocked   User `gorm:"foreignKey:BlockedID"`
+	CreatedAt time.Time
+}
+
+type BlockUserDto struct {
+	Username string `json:"username" form:"username" validate:"required"`
+}
+
+func (b *BlockUserDto) Validate() error {
+	return validator.New().Struct(b)
+}
@@ -0,0 +1,81 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator"
+)
+
+// Bookmark is a message saved by a user: a copy in their Saved messages chat, which
+// stays when the original is deleted or expires, and a link back to the original
+type Bookmark struct {
+	ID     uint `gorm:"primarykey"`
+	UserID uint `gorm:"not null;uniqueIndex:idx_bookmarks_user_message"`
+	// The original, the copy itself for messages of the Saved messages chat
+	MessageID uint          `gorm:"not null;uniqueIndex:idx_bookmarks_user_message"`
+	ChatID    uint          `gorm:"not null"`
+	AuthorID  uint          `gorm:"not null"`
+	Author    User          `gorm:"foreignKey:AuthorID"`
+	CopyID    uint          `gorm:"not null"`
+	Copy      Message       `gorm:"foreignKey:CopyID"`
+	Tags      []BookmarkTag `gorm:"foreignKey:BookmarkID"`
+	CreatedAt time.Time
+}
+
+type BookmarkTag struct {
+	BookmarkID uint   `gorm:"primaryKey"`
+	Tag        string `gorm:"primaryKey;size:32;index"`
+}
+
+// ToResponseFor builds the response with privacy settings of the users applied for the viewer
+func (b *Bookmark) ToResponseFor(viewer Viewer) BookmarkResponse {
+	tags := make([]string, len(b.Tags))
+	for i := range b.Tags {
+		tags[i] = b.Tags[i].Tag
+	}
+
+	return BookmarkResponse{
+		ID:        b.ID,
+		Message:   b.Copy.ToResponseFor(viewer),
+		MessageID: b.MessageID,
+		ChatID:    b.ChatID,
+		Author:    b.Author.ToResponseFor(viewer),
+		Tags:      tags,
+		CreatedAt: b.CreatedAt,
+	}
+}
+
+type BookmarkResponse struct {
+	ID uint `json:"id"`
+	// The copy in the Saved messages chat
+	Message MessageResponse `json:"message"`
+	// Link to the original, which may be gone
+	MessageID uint         `json:"messageId"`
+	ChatID    uint         `json:"chatId"`
+	Author    UserResponse `json:"author"`
+	Tags      []string     `json:"tags"`
+	CreatedAt time.Time    `json:"createdAt"`
+}
+
+type CreateBookmarkDto struct {
+	MessageID uint     `json:"messageId" validate:"required"`
+	Tags      []string `json:"tags" validate:"max=10,dive,required,max=32"`
+}
+
+func (b *CreateBookmarkDto) Validate() error {
+	return validator.New().Struct(b)
+}
+
+type BookmarkTagsDto struct {
+	Tags []string `json:"tags" validate:"max=10,dive,required,max=32"`
+}
+
+func (b *BookmarkTagsDto) Validate() error {
+	return validator.New().Struct(b)
+}
+
+// NormalizeTag lowercases the tag and strips spaces and the leading #
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+}
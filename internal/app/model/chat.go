@@ -1,31 +1,110 @@
 package model
 
 import (
+	"errors"
+	"math"
+	"regexp"
+	"strings"
 	"time"
 
+	"github.com/go-playground/validator"
 	"gorm.io/gorm"
 )
 
+// Roles of chat members
+const (
+	ChatMember = "member"
+	ChatAdmin  = "admin"
+)
+
 type Chat struct {
 	gorm.Model
-	Name     string
+	Name        string
+	Description string `gorm:"size:255;not null;default:''"`
+	Avatar      []byte
+	AvatarThumb []byte
+	// Public name of a group chat to find it by, lowercase
+	Handle *string `gorm:"size:32;uniqueIndex"`
 	// ChatKey  string    `gorm:"not null;unique"`
-	IsGroup  bool      `gorm:"not null;default:false"`
-	Users    []User    `gorm:"many2many:user_chats;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
-	Messages []Message `gorm:"foreignKey:ChatID"`
+	IsGroup  bool       `gorm:"not null;default:false"`
+	Users    []User     `gorm:"many2many:user_chats;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	Members  []UserChat `gorm:"foreignKey:ChatID"`
+	Messages []Message  `gorm:"foreignKey:ChatID"`
+	// Members except admins may post once per this number of seconds, 0 turns slow mode off
+	SlowModeSeconds int `gorm:"not null;default:0"`
+	// New messages are deleted after this number of seconds, 0 keeps them forever
+	AutoDeleteSeconds int `gorm:"not null;default:0"`
+	// Latest message of the timeline, thread replies aside
+	LastMessageID *uint
+	// Time of the latest message or of the creation, chats are listed by it
+	LastActivityAt time.Time `gorm:"index"`
+	// set for the Saved messages chat of the user, its only member
+	SavedByID *uint `gorm:"uniqueIndex"`
+}
+
+// UserChat is a membership of a user in a chat, the join table of Chat.Users
+type UserChat struct {
+	UserID uint   `gorm:"primaryKey"`
+	ChatID uint   `gorm:"primaryKey"`
+	Role   string `gorm:"size:16;not null;default:member"`
+	// Time of the last message in slow mode
+	LastPostedAt *time.Time
+	// Settings of the member: muted chats deliver messages silently, until
+	// MutedUntil if set. Pinned chats go first, by PinPosition.
+	Muted       bool `gorm:"not null;default:false"`
+	MutedUntil  *time.Time
+	Archived    bool `gorm:"not null;default:false"`
+	PinPosition *int
+	// Last message of the timeline the member has read
+	LastReadID uint `gorm:"not null;default:0"`
+}
+
+// IsMuted reports whether the member muted the chat
+func (m *UserChat) IsMuted(now time.Time) bool {
+	return m.Muted && (m.MutedUntil == nil || m.MutedUntil.After(now))
+}
+
+// SlowModeCooldown returns how long the user has to wait before posting to the chat.
+// Members have to be preloaded.
+func (c *Chat) SlowModeCooldown(userID uint, now time.Time) time.Duration {
+	if !c.IsGroup || c.SlowModeSeconds <= 0 {
+		return 0
+	}
+	for _, member := range c.Members {
+		if member.UserID != userID {
+			continue
+		}
+		if member.Role == ChatAdmin || member.LastPostedAt == nil {
+			return 0
+		}
+		cooldown := member.LastPostedAt.Add(time.Duration(c.SlowModeSeconds) * time.Second).Sub(now)
+		return max(cooldown, 0)
+	}
+	return 0
 }
 
 func (c *Chat) ToResponse() ChatResponse {
+	return c.toResponse(0, (*User).ToResponse)
+}
+
+// ToResponseFor builds the response with privacy settings of the users applied for the viewer
+func (c *Chat) ToResponseFor(viewer Viewer) ChatResponse {
+	return c.toResponse(viewer.ID, func(u *User) UserResponse {
+		return u.ToResponseFor(viewer)
+	})
+}
+
+func (c *Chat) toResponse(viewerID uint, userToResponse func(*User) UserResponse) ChatResponse {
 	userResponse := make([]UserResponse, 0)
-	for _, user := range c.Users {
-		userResponse = append(userResponse, user.ToResponse())
+	for i := range c.Users {
+		userResponse = append(userResponse, userToResponse(&c.Users[i]))
 	}
 
 	var lastMessage MessageResponse
 	if len(c.Messages) > 0 {
 		// lastMes := &c.Messages
 		// lastMessage = lastMes.ToResponse()
-		lastMessage = c.Messages[0].ToResponse()
+		lastMessage = c.Messages[0].toResponse(viewerID, userToResponse)
 	}
 	// if c.LastSender != nil {
 	// 	lastSenderResponse := c.LastSender.ToResponse()
@@ -37,17 +116,71 @@ func (c *Chat) ToResponse() ChatResponse {
 	// 	lastMessage = &lastMessageResponse
 	// }
 
+	admins := make([]string, 0)
+	for _, member := range c.Members {
+		if member.Role != ChatAdmin {
+			continue
+		}
+		for _, user := range c.Users {
+			if user.ID == member.UserID {
+				admins = append(admins, user.Username)
+			}
+		}
+	}
+	cooldown := c.SlowModeCooldown(viewerID, time.Now())
+
+	var settings ChatSettingsResponse
+	for _, member := range c.Members {
+		if member.UserID == viewerID {
+			settings = member.settingsToResponse(time.Now())
+		}
+	}
+
 	return ChatResponse{
-		ID:          c.ID,
-		Name:        c.Name,
-		IsGroup:     c.IsGroup,
-		Users:       userResponse,
-		LastMessage: &lastMessage,
-		CreatedAt:   c.CreatedAt,
-		UpdatedAt:   c.UpdatedAt,
+		ID:                c.ID,
+		Name:              c.Name,
+		Description:       c.Description,
+		AvatarThumb:       c.AvatarThumb,
+		Handle:            c.Handle,
+		IsGroup:           c.IsGroup,
+		Saved:             c.SavedByID != nil,
+		Users:             userResponse,
+		Admins:            admins,
+		SlowModeSeconds:   c.SlowModeSeconds,
+		SlowModeCooldown:  int(math.Ceil(cooldown.Seconds())),
+		AutoDeleteSeconds: c.AutoDeleteSeconds,
+		Settings:          settings,
+		LastMessage:       &lastMessage,
+		LastActivityAt:    c.LastActivityAt,
+		CreatedAt:         c.CreatedAt,
+		UpdatedAt:         c.UpdatedAt,
+	}
+}
+
+// ToPublicResponse returns what anyone may see of a group chat found by its handle.
+// Users have to be preloaded.
+func (c *Chat) ToPublicResponse() PublicChatResponse {
+	return PublicChatResponse{
+		ID:           c.ID,
+		Name:         c.Name,
+		Description:  c.Description,
+		Avatar:       c.Avatar,
+		AvatarThumb:  c.AvatarThumb,
+		Handle:       c.Handle,
+		MembersCount: len(c.Users),
 	}
 }
 
+type PublicChatResponse struct {
+	ID           uint    `json:"id"`
+	Name         string  `json:"name"`
+	Description  string  `json:"description"`
+	Avatar       []byte  `json:"avatar"`
+	AvatarThumb  []byte  `json:"avatarThumb"`
+	Handle       *string `json:"handle"`
+	MembersCount int     `json:"membersCount"`
+}
+
 type CreateChatDto struct {
 	Name                string   `json:"name"`
 	CompanionsUsernames []string `json:"companions_usernames"`
@@ -69,13 +202,122 @@ func (c *CreateChatDto) ToModel(ownUsername string) Chat {
 }
 
 type ChatResponse struct {
-	ID          uint             `json:"id"`
-	Name        string           `json:"name"`
-	IsGroup     bool             `json:"isGroup"`
-	Users       []UserResponse   `json:"users"`
-	LastMessage *MessageResponse `json:"lastMessage"`
-	CreatedAt   time.Time        `json:"createdAt"`
-	UpdatedAt   time.Time        `json:"updatedAt"`
+	ID          uint           `json:"id"`
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	AvatarThumb []byte         `json:"avatarThumb"`
+	Handle      *string        `json:"handle,omitempty"`
+	IsGroup     bool           `json:"isGroup"`
+	Users       []UserResponse `json:"users"`
+	// set for a single chat only, lists carry the thumbnail
+	Avatar []byte `json:"avatar,omitempty"`
+	// set for the Saved messages chat of the caller
+	Saved bool `json:"saved"`
+	// Usernames of chat admins
+	Admins          []string `json:"admins"`
+	SlowModeSeconds int      `json:"slowModeSeconds"`
+	// Seconds left before the caller can post again
+	SlowModeCooldown  int `json:"slowModeCooldown"`
+	AutoDeleteSeconds int `json:"autoDeleteSeconds"`
+	// Settings of the chat for the caller
+	Settings ChatSettingsResponse `json:"settings"`
+	// Messages mentioning the caller they haven't read, set by the chat service
+	UnreadMentions int `json:"unreadMentions"`
+	// Messages of others the caller hasn't read, set by the chat service
+	Unread         int              `json:"unread"`
+	LastMessage    *MessageResponse `json:"lastMessage"`
+	LastActivityAt time.Time        `json:"lastActivityAt"`
+	CreatedAt      time.Time        `json:"createdAt"`
+	UpdatedAt      time.Time        `json:"updatedAt"`
+}
+
+func (m *UserChat) settingsToResponse(now time.Time) ChatSettingsResponse {
+	settings := ChatSettingsResponse{
+		Muted:    m.IsMuted(now),
+		Archived: m.Archived,
+		Pinned:   m.PinPosition != nil,
+	}
+	if settings.Muted {
+		settings.MutedUntil = m.MutedUntil
+	}
+	return settings
+}
+
+type ChatSettingsResponse struct {
+	Muted bool `json:"muted"`
+	// not set for chats muted forever
+	MutedUntil *time.Time `json:"mutedUntil,omitempty"`
+	Archived   bool       `json:"archived"`
+	Pinned     bool       `json:"pinned"`
+}
+
+// ChatSettingsDto changes settings of a chat for the caller, nil fields stay as they are.
+// Muting without MutedUntil mutes the chat forever. Archiving unpins the chat,
+// pinning unarchives it.
+type ChatSettingsDto struct {
+	Muted      *bool      `json:"muted"`
+	MutedUntil *time.Time `json:"mutedUntil"`
+	Archived   *bool      `json:"archived"`
+	Pinned     *bool      `json:"pinned"`
+}
+
+// PinnedChatsDto orders every pinned chat of the caller
+type PinnedChatsDto struct {
+	ChatIDs []uint `json:"chatIds" validate:"required,dive,required"`
+}
+
+func (p *PinnedChatsDto) Validate() error {
+	return validator.New().Struct(p)
+}
+
+// Handles start with a letter and take letters, digits and underscores
+var chatHandleRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]{4,31}$`)
+
+// ChatInfoDto changes the description, avatar and handle of a group chat, nil fields
+// stay as they are. An empty avatar or handle removes it.
+type ChatInfoDto struct {
+	Description *string `json:"description" validate:"omitempty,max=255"`
+	Avatar      []byte  `json:"avatar"`
+	Handle      *string `json:"handle"`
+}
+
+func (c *ChatInfoDto) Validate() error {
+	if err := validator.New().Struct(c); err != nil {
+		return err
+	}
+	if c.Handle != nil {
+		handle := strings.ToLower(strings.TrimPrefix(*c.Handle, "@"))
+		if handle != "" && !chatHandleRegexp.MatchString(handle) {
+			return errors.New("handle has to be 5-32 letters, digits or underscores, starting with a letter")
+		}
+		c.Handle = &handle
+	}
+	return nil
+}
+
+type SlowModeDto struct {
+	Seconds int `json:"seconds" form:"seconds" validate:"min=0,max=86400"`
+}
+
+func (s *SlowModeDto) Validate() error {
+	return validator.New().Struct(s)
+}
+
+// AutoDeleteDto sets the disappearing messages timer, up to a year
+type AutoDeleteDto struct {
+	Seconds int `json:"seconds" form:"seconds" validate:"min=0,max=31536000"`
+}
+
+func (a *AutoDeleteDto) Validate() error {
+	return validator.New().Struct(a)
+}
+
+type ChatRoleDto struct {
+	Role string `json:"role" form:"role" validate:"required,oneof=member admin"`
+}
+
+func (c *ChatRoleDto) Validate() error {
+	return validator.New().Struct(c)
 }
 
 type ModifyChatDto struct {
@@ -0,0 +1,58 @@
+package model
+
+import (
+	"time"
+
+	"github.com/go-playground/validator"
+)
+
+// Contact is an entry of Owner's address book with a private nickname
+type Contact struct {
+	ID        uint   `gorm:"primarykey"`
+	OwnerID   uint   `gorm:"not null;uniqueIndex:idx_contacts_pair"`
+	ContactID uint   `gorm:"not null;uniqueIndex:idx_contacts_pair;index"`
+	Contact   User   `gorm:"foreignKey:ContactID"`
+	Nickname  string `gorm:"size:64"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// ToResponse builds the response for the owner, online is hidden together with
+// the last seen time if the contact doesn't share it with the owner
+func (c *Contact) ToResponse(owner Viewer, online bool) ContactResponse {
+	return ContactResponse{
+		User:      c.Contact.ToResponseFor(owner),
+		Nickname:  c.Nickname,
+		Online:    online && c.Contact.VisibleTo(owner, PrivacyLastSeen),
+		CreatedAt: c.CreatedAt,
+	}
+}
+
+type ContactResponse struct {
+	User      UserResponse `json:"user"`
+	Nickname  string       `json:"nickname"`
+	Online    bool         `json:"online"`
+	CreatedAt time.Time    `json:"createdAt"`
+}
+
+type AddContactDto struct {
+	Username string `json:"username" form:"username" validate:"required"`
+	Nickname string `json:"nickname" form:"nickname" validate:"max=64"`
+}
+
+func (c *AddContactDto) Validate() error {
+	return validator.New().Struct(c)
+}
+
+type ModifyContactDto struct {
+	Nickname string `json:"nickname" form:"nickname" validate:"max=64"`
+}
+
+func (c *ModifyContactDto) Validate() error {
+	return validator.New().Struct(c)
+}
+
+type ContactSettingsDto struct {
+	// Only contacts can start a private chat with the user
+	ContactsOnlyChats bool `json:"contactsOnlyChats"`
+}
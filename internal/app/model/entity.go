@@ -0,0 +1,29 @@
+package model
+
+// MessageEntity formats a part of the content of a message, see package richtext
+type MessageEntity struct {
+	ID        uint   `gorm:"primarykey"`
+	MessageID uint   `gorm:"not null;index"`
+	Type      string `gorm:"size:16;not null"`
+	// Position in the content, in characters
+	Offset int `gorm:"not null"`
+	Length int `gorm:"not null"`
+	// set for text links
+	URL string
+}
+
+func (e *MessageEntity) ToResponse() EntityResponse {
+	return EntityResponse{
+		Type:   e.Type,
+		Offset: e.Offset,
+		Length: e.Length,
+		URL:    e.URL,
+	}
+}
+
+type EntityResponse struct {
+	Type   string `json:"type"`
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+	URL    string `json:"url,omitempty"`
+}
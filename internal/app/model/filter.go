@@ -0,0 +1,26 @@
+package model
+
+import (
+	"github.com/go-playground/validator"
+)
+
+// ChatBannedWord is a word the content filter catches in a single chat,
+// in addition to the words banned by the deployment
+type ChatBannedWord struct {
+	ID     uint   `gorm:"primarykey"`
+	ChatID uint   `gorm:"not null;uniqueIndex:idx_chat_banned_word"`
+	Word   string `gorm:"size:64;not null;uniqueIndex:idx_chat_banned_word"`
+}
+
+type BannedWordsDto struct {
+	Words []string `json:"words" form:"words" validate:"max=200,dive,required,max=64"`
+}
+
+func (b *BannedWordsDto) Validate() error {
+	return validator.New().Struct(b)
+}
+
+type BannedWordsResponse struct {
+	ChatID uint     `json:"chatId"`
+	Words  []string `json:"words"`
+}
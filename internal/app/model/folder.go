@@ -0,0 +1,108 @@
+package model
+
+import (
+	"time"
+
+	"github.com/go-playground/validator"
+)
+
+// ChatFolder is a custom tab of the chat list of a user. Chats listed in it are
+// always shown and excluded ones never are, others are shown if they match the rules.
+type ChatFolder struct {
+	ID     uint   `gorm:"primarykey"`
+	UserID uint   `gorm:"not null;index"`
+	Name   string `gorm:"size:32;not null"`
+	// Rules: chats of the included types are shown unless an exclusion applies to them
+	IncludeGroups   bool             `gorm:"not null;default:false"`
+	IncludePrivate  bool             `gorm:"not null;default:false"`
+	ExcludeMuted    bool             `gorm:"not null;default:false"`
+	ExcludeRead     bool             `gorm:"not null;default:false"`
+	ExcludeArchived bool             `gorm:"not null;default:false"`
+	Chats           []ChatFolderChat `gorm:"foreignKey:FolderID"`
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// ChatFolderChat is a chat listed in a folder explicitly
+type ChatFolderChat struct {
+	FolderID uint `gorm:"primaryKey"`
+	ChatID   uint `gorm:"primaryKey;index"`
+	Excluded bool `gorm:"not null;default:false"`
+}
+
+// ToResponse returns the folder, UnreadChats is set by the folder service
+func (f *ChatFolder) ToResponse() ChatFolderResponse {
+	included := make([]uint, 0)
+	excluded := make([]uint, 0)
+	for _, chat := range f.Chats {
+		if chat.Excluded {
+			excluded = append(excluded, chat.ChatID)
+		} else {
+			included = append(included, chat.ChatID)
+		}
+	}
+
+	return ChatFolderResponse{
+		ID:              f.ID,
+		Name:            f.Name,
+		IncludedChatIDs: included,
+		ExcludedChatIDs: excluded,
+		IncludeGroups:   f.IncludeGroups,
+		IncludePrivate:  f.IncludePrivate,
+		ExcludeMuted:    f.ExcludeMuted,
+		ExcludeRead:     f.ExcludeRead,
+		ExcludeArchived: f.ExcludeArchived,
+	}
+}
+
+type ChatFolderResponse struct {
+	ID              uint   `json:"id"`
+	Name            string `json:"name"`
+	IncludedChatIDs []uint `json:"includedChatIds"`
+	ExcludedChatIDs []uint `json:"excludedChatIds"`
+	IncludeGroups   bool   `json:"includeGroups"`
+	IncludePrivate  bool   `json:"includePrivate"`
+	ExcludeMuted    bool   `json:"excludeMuted"`
+	ExcludeRead     bool   `json:"excludeRead"`
+	ExcludeArchived bool   `json:"excludeArchived"`
+	// Chats of the folder with messages the caller hasn't read, for the badge of the tab
+	UnreadChats int `json:"unreadChats"`
+}
+
+// ChatFolderDto creates or replaces a folder, it has to include some chats
+// explicitly or by type
+type ChatFolderDto struct {
+	Name            string `json:"name" validate:"required,max=32"`
+	IncludedChatIDs []uint `json:"includedChatIds" validate:"max=100,dive,required"`
+	ExcludedChatIDs []uint `json:"excludedChatIds" validate:"max=100,dive,required"`
+	IncludeGroups   bool   `json:"includeGroups"`
+	IncludePrivate  bool   `json:"includePrivate"`
+	ExcludeMuted    bool   `json:"excludeMuted"`
+	ExcludeRead     bool   `json:"excludeRead"`
+	ExcludeArchived bool   `json:"excludeArchived"`
+}
+
+func (f *ChatFolderDto) Validate() error {
+	return validator.New().Struct(f)
+}
+
+func (f *ChatFolderDto) ToModel(userID uint) ChatFolder {
+	chats := make([]ChatFolderChat, 0, len(f.IncludedChatIDs)+len(f.ExcludedChatIDs))
+	for _, id := range f.IncludedChatIDs {
+		chats = append(chats, ChatFolderChat{ChatID: id})
+	}
+	for _, id := range f.ExcludedChatIDs {
+		chats = append(chats, ChatFolderChat{ChatID: id, Excluded: true})
+	}
+
+	return ChatFolder{
+		UserID:          userID,
+		Name:            f.Name,
+		IncludeGroups:   f.IncludeGroups,
+		IncludePrivate:  f.IncludePrivate,
+		ExcludeMuted:    f.ExcludeMuted,
+		ExcludeRead:     f.ExcludeRead,
+		ExcludeArchived: f.ExcludeArchived,
+		Chats:           chats,
+	}
+}
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var mentionPattern = regexp.MustCompile(`@([A-Za-z0-9]{3,32})`)
+
+// MessageMention is an @username of a chat member in a message
+type MessageMention struct {
+	ID        uint `gorm:"primarykey"`
+	MessageID uint `gorm:"not null;index"`
+	UserID    uint `gorm:"not null;index"`
+	User      User `gorm:"foreignKey:UserID"`
+	// Position of the mention in the content, in characters
+	Offset int `gorm:"not null"`
+	Length int `gorm:"not null"`
+	// Set when the mentioned user reads mentions of the chat
+	Read bool `gorm:"not null;default:false"`
+}
+
+func (m *MessageMention) ToResponse() MentionResponse {
+	return MentionResponse{
+		UserID:   m.UserID,
+		Username: m.User.Username,
+		Offset:   m.Offset,
+		Length:   m.Length,
+	}
+}
+
+type MentionResponse struct {
+	UserID   uint   `json:"userId"`
+	Username string `json:"username"`
+	Offset   int    `json:"offset"`
+	Length   int    `json:"length"`
+}
+
+// Mention is an @username found in the content, Offset and Length are in characters
+type Mention struct {
+	Username string
+	Offset   int
+	Length   int
+}
+
+// ParseMentions finds @usernames in the content. Usernames are lowercased, the ones
+// glued to other words like in emails are skipped.
+func ParseMentions(content string) []Mention {
+	mentions := make([]Mention, 0)
+	for _, loc := range mentionPattern.FindAllStringSubmatchIndex(content, -1) {
+		start, end := loc[0], loc[1]
+		if before, _ := utf8.DecodeLastRuneInString(content[:start]); start > 0 && isWordRune(before) {
+			continue
+		}
+		if after, _ := utf8.DecodeRuneInString(content[end:]); end < len(content) && isWordRune(after) {
+			continue
+		}
+		mentions = append(mentions, Mention{
+			Username: strings.ToLower(content[loc[2]:loc[3]]),
+			Offset:   utf8.RuneCountInString(content[:start]),
+			Length:   end - start,
+		})
+	}
+	return mentions
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,29 @@
+package model_test
+
+import (
+	"testing"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMentions(t *testing.T) {
+	testCases := []struct {
+		name     string
+		content  string
+		expected []model.Mention
+	}{
+		{"none", "hello there", []model.Mention{}},
+		{"single", "hi @Bob!", []model.Mention{{Username: "bob", Offset: 3, Length: 4}}},
+		{"several", "@alice and @carol", []model.Mention{{Username: "alice", Offset: 0, Length: 6}, {Username: "carol", Offset: 11, Length: 6}}},
+		{"offset in characters", "привет @bob", []model.Mention{{Username: "bob", Offset: 7, Length: 4}}},
+		{"email", "write to bob@mail.com", []model.Mention{}},
+		{"too short", "@bo", []model.Mention{}},
+		{"too long", "@" + "a123456789a123456789a123456789abc", []model.Mention{}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, model.ParseMentions(tc.content))
+		})
+	}
+}
@@ -6,46 +6,150 @@ import (
 	"gorm.io/gorm"
 )
 
+// Kinds of messages
+const (
+	MessageText     = "text"
+	MessageTransfer = "transfer"
+	MessagePoll     = "poll"
+)
+
 type Message struct {
 	gorm.Model
-	Content  string
-	Sender   User `gorm:"foreignKey:SenderID"`
-	SenderID uint // Важно: это внешний ключ
-	Chat     Chat `gorm:"foreignKey:ChatID"`
-	ChatID   uint // Внешний ключ для чата
+	Content    string
+	Kind       string        `gorm:"size:16;not null;default:text"`
+	Sender     User          `gorm:"foreignKey:SenderID"`
+	SenderID   uint          // Важно: это внешний ключ
+	Chat       Chat          `gorm:"foreignKey:ChatID"`
+	ChatID     uint          // Внешний ключ для чата
+	TransferID *uint         // set for transfer messages
+	Transfer   *ChatTransfer `gorm:"foreignKey:TransferID"`
+	PollID     *uint         // set for poll messages
+	Poll       *Poll         `gorm:"foreignKey:PollID"`
+	// set in chats with disappearing messages
+	ExpiresAt *time.Time `gorm:"index"`
+	// set for replies in a thread
+	ThreadRootID *uint `gorm:"index"`
+	// set for root messages with replies when loaded
+	Thread   *ThreadSummary   `gorm:"-"`
+	Mentions []MessageMention `gorm:"foreignKey:MessageID"`
+	// Formatting of the content
+	Entities []MessageEntity `gorm:"foreignKey:MessageID"`
+	// set once the preview of the first link is fetched
+	Preview *LinkPreview `gorm:"foreignKey:MessageID"`
 }
 
 func (m *Message) ToResponse() MessageResponse {
+	return m.toResponse(0, (*User).ToResponse)
+}
+
+// ToResponseFor builds the response with privacy settings of the users applied for the viewer
+func (m *Message) ToResponseFor(viewer Viewer) MessageResponse {
+	return m.toResponse(viewer.ID, func(u *User) UserResponse {
+		return u.ToResponseFor(viewer)
+	})
+}
+
+func (m *Message) toResponse(viewerID uint, userToResponse func(*User) UserResponse) MessageResponse {
+	var transfer *ChatTransferResponse
+	if m.Transfer != nil {
+		transferResp := m.Transfer.ToResponse()
+		transfer = &transferResp
+	}
+
+	var poll *PollResponse
+	if m.Poll != nil {
+		pollResp := m.Poll.ToResponse(viewerID)
+		poll = &pollResp
+	}
+
+	var thread *ThreadSummaryResponse
+	if m.Thread != nil {
+		participants := make([]UserResponse, len(m.Thread.Participants))
+		for i := range m.Thread.Participants {
+			participants[i] = userToResponse(&m.Thread.Participants[i])
+		}
+		thread = &ThreadSummaryResponse{
+			ReplyCount:   m.Thread.ReplyCount,
+			LastReplyAt:  m.Thread.LastReplyAt,
+			Participants: participants,
+		}
+	}
+
+	mentions := make([]MentionResponse, len(m.Mentions))
+	for i := range m.Mentions {
+		mentions[i] = m.Mentions[i].ToResponse()
+	}
+
+	entities := make([]EntityResponse, len(m.Entities))
+	for i := range m.Entities {
+		entities[i] = m.Entities[i].ToResponse()
+	}
+
+	var preview *LinkPreviewResponse
+	if m.Preview != nil {
+		previewResp := m.Preview.ToResponse()
+		preview = &previewResp
+	}
+
+	kind := m.Kind
+	if kind == "" {
+		kind = MessageText
+	}
 	return MessageResponse{
-		ID:        m.ID,
-		Content:   m.Content,
-		Sender:    m.Sender.ToResponse(),
-		Chat:      m.Chat.ToResponse(),
-		CreatedAt: m.CreatedAt,
-		UpdatedAt: m.UpdatedAt,
+		ID:           m.ID,
+		Kind:         kind,
+		Content:      m.Content,
+		Sender:       userToResponse(&m.Sender),
+		Chat:         m.Chat.toResponse(viewerID, userToResponse),
+		Transfer:     transfer,
+		Poll:         poll,
+		ExpiresAt:    m.ExpiresAt,
+		ThreadRootID: m.ThreadRootID,
+		Thread:       thread,
+		Mentions:     mentions,
+		Entities:     entities,
+		Preview:      preview,
+		CreatedAt:    m.CreatedAt,
+		UpdatedAt:    m.UpdatedAt,
 	}
 }
 
 type CreateMessageDto struct {
+	// Markdown-lite, see package richtext
 	Content string `json:"content"`
 	ChatID  uint   `json:"chatId"`
+	// Schedules the message to be sent later if set
+	SendAt *time.Time `json:"sendAt"`
+	// Posts the message as a reply in the thread of the message
+	ThreadRootID *uint `json:"threadRootId"`
 }
 
 func (m *CreateMessageDto) ToModel(senderUsername string) Message {
 	return Message{
-		Content: m.Content,
-		ChatID:  m.ChatID,
-		Sender:  User{Username: senderUsername},
+		Content:      m.Content,
+		ChatID:       m.ChatID,
+		ThreadRootID: m.ThreadRootID,
+		Sender:       User{Username: senderUsername},
 	}
 }
 
 type MessageResponse struct {
-	ID        uint         `json:"id"`
-	Content   string       `json:"content"`
-	Sender    UserResponse `json:"sender"`
-	Chat      ChatResponse `json:"chat"`
-	CreatedAt time.Time    `json:"createdAt"`
-	UpdatedAt time.Time    `json:"updatedAt"`
+	ID        uint                  `json:"id"`
+	Kind      string                `json:"kind"`
+	Content   string                `json:"content"`
+	Sender    UserResponse          `json:"sender"`
+	Chat      ChatResponse          `json:"chat"`
+	Transfer  *ChatTransferResponse `json:"transfer,omitempty"`
+	Poll      *PollResponse         `json:"poll,omitempty"`
+	ExpiresAt *time.Time            `json:"expiresAt,omitempty"`
+	// set for replies in a thread
+	ThreadRootID *uint                  `json:"threadRootId,omitempty"`
+	Thread       *ThreadSummaryResponse `json:"thread,omitempty"`
+	Mentions     []MentionResponse      `json:"mentions"`
+	Entities     []EntityResponse       `json:"entities"`
+	Preview      *LinkPreviewResponse   `json:"preview,omitempty"`
+	CreatedAt    time.Time              `json:"createdAt"`
+	UpdatedAt    time.Time              `json:"updatedAt"`
 }
 
 type MessageWS struct {
@@ -54,6 +158,14 @@ type MessageWS struct {
 	Recipients []string
 	Content    string `json:"content"`
 	ChatID     uint   `json:"chat_id"`
+	// set for replies in a thread
+	ThreadRootID *uint `json:"thread_root_id,omitempty"`
+	// Formatting of the content in outbound frames
+	Entities []EntityResponse `json:"entities,omitempty"`
+	// set for recipients who muted the chat, clients don't notify about the message
+	Silent bool `json:"silent,omitempty"`
+	// Payload of server events such as "profile_updated"
+	Data interface{} `json:"data,omitempty"`
 	// {
 	// 	"type":"message",
 	// 	"content": "проверка",
@@ -63,8 +175,9 @@ type MessageWS struct {
 
 func (m *MessageWS) ToModel() Message {
 	return Message{
-		Sender:  User{Username: m.Sender},
-		Content: m.Content,
-		ChatID:    m.ChatID,
+		Sender:       User{Username: m.Sender},
+		Content:      m.Content,
+		ChatID:       m.ChatID,
+		ThreadRootID: m.ThreadRootID,
 	}
 }
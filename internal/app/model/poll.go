@@ -0,0 +1,120 @@
+package model
+
+import (
+	"time"
+
+	"github.com/go-playground/validator"
+)
+
+// Poll holds options of a poll message, the content of the message is the question
+type Poll struct {
+	ID        uint `gorm:"primarykey"`
+	ChatID    uint `gorm:"not null;index"`
+	CreatorID uint `gorm:"not null"`
+	// Members may choose several options
+	Multiple bool
+	// Voters are hidden from everyone, only tallies are shown
+	Anonymous bool
+	ClosesAt  *time.Time
+	// set when the poll is closed before ClosesAt
+	ClosedAt  *time.Time
+	Options   []PollOption `gorm:"foreignKey:PollID"`
+	Votes     []PollVote   `gorm:"foreignKey:PollID"`
+	CreatedAt time.Time
+}
+
+type PollOption struct {
+	ID       uint   `gorm:"primarykey"`
+	PollID   uint   `gorm:"not null;index"`
+	Position int    `gorm:"not null"`
+	Text     string `gorm:"not null"`
+}
+
+// PollVote is a choice of a user, users choosing several options have a vote for each
+type PollVote struct {
+	PollID    uint `gorm:"primaryKey"`
+	OptionID  uint `gorm:"primaryKey"`
+	UserID    uint `gorm:"primaryKey"`
+	User      User `gorm:"foreignKey:UserID"`
+	CreatedAt time.Time
+}
+
+// IsClosed reports whether the poll takes no more votes
+func (p *Poll) IsClosed(now time.Time) bool {
+	return p.ClosedAt != nil || (p.ClosesAt != nil && !p.ClosesAt.After(now))
+}
+
+// ToResponse returns tallies of the poll with options chosen by the viewer, 0 for nobody
+func (p *Poll) ToResponse(viewerID uint) PollResponse {
+	options := make([]PollOptionResponse, len(p.Options))
+	voters := make(map[uint]bool)
+	for i, option := range p.Options {
+		options[i] = PollOptionResponse{ID: option.ID, Text: option.Text}
+		for _, vote := range p.Votes {
+			if vote.OptionID != option.ID {
+				continue
+			}
+			voters[vote.UserID] = true
+			options[i].Votes++
+			if vote.UserID == viewerID {
+				options[i].Chosen = true
+			}
+			if !p.Anonymous {
+				options[i].Voters = append(options[i].Voters, vote.User.Username)
+			}
+		}
+	}
+
+	return PollResponse{
+		ID:          p.ID,
+		Multiple:    p.Multiple,
+		Anonymous:   p.Anonymous,
+		ClosesAt:    p.ClosesAt,
+		Closed:      p.IsClosed(time.Now()),
+		TotalVoters: len(voters),
+		Options:     options,
+	}
+}
+
+type PollResponse struct {
+	ID          uint                 `json:"id"`
+	Multiple    bool                 `json:"multiple"`
+	Anonymous   bool                 `json:"anonymous"`
+	ClosesAt    *time.Time           `json:"closesAt,omitempty"`
+	Closed      bool                 `json:"closed"`
+	TotalVoters int                  `json:"totalVoters"`
+	Options     []PollOptionResponse `json:"options"`
+}
+
+type PollOptionResponse struct {
+	ID    uint   `json:"id"`
+	Text  string `json:"text"`
+	Votes int    `json:"votes"`
+	// usernames of voters, public polls only
+	Voters []string `json:"voters,omitempty"`
+	// set if the viewer voted for the option
+	Chosen bool `json:"chosen"`
+}
+
+type CreatePollDto struct {
+	ChatID    uint       `json:"chatId" validate:"required"`
+	Question  string     `json:"question" validate:"required,max=300"`
+	Options   []string   `json:"options" validate:"required,min=2,max=10,dive,required,max=100"`
+	Multiple  bool       `json:"multiple"`
+	Anonymous bool       `json:"anonymous"`
+	ClosesAt  *time.Time `json:"closesAt"`
+	// Posts the poll as a reply in the thread of the message
+	ThreadRootID *uint `json:"threadRootId"`
+}
+
+func (p *CreatePollDto) Validate() error {
+	return validator.New().Struct(p)
+}
+
+type VoteDto struct {
+	OptionIDs []uint `json:"optionIds" validate:"required,min=1,max=10,dive,required"`
+}
+
+func (v *VoteDto) Validate() error {
+	return validator.New().Struct(v)
+}
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"time"
+)
+
+// LinkPreview is the preview of the first link of a message, fetched after the
+// message is sent
+type LinkPreview struct {
+	ID          uint   `gorm:"primarykey"`
+	MessageID   uint   `gorm:"not null;uniqueIndex"`
+	URL         string `gorm:"not null"`
+	Title       string
+	Description string
+	Image       string
+	SiteName    string
+	CreatedAt   time.Time
+}
+
+func (p *LinkPreview) ToResponse() LinkPreviewResponse {
+	return LinkPreviewResponse{
+		URL:         p.URL,
+		Title:       p.Title,
+		Description: p.Description,
+		Image:       p.Image,
+		SiteName:    p.SiteName,
+	}
+}
+
+type LinkPreviewResponse struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	SiteName    string `json:"siteName,omitempty"`
+}
@@ -0,0 +1,61 @@
+package model
+
+import "github.com/go-playground/validator"
+
+// Privacy rules, who a setting applies to
+const (
+	PrivacyEverybody = "everybody"
+	PrivacyContacts  = "contacts"
+	PrivacyNobody    = "nobody"
+)
+
+// Privacy options of PrivacySettings
+const (
+	PrivacyLastSeen     = "lastSeen"
+	PrivacyProfilePhoto = "profilePhoto"
+	PrivacyGroupInvites = "groupInvites"
+)
+
+// PrivacySettings decide who sees the last seen time and the avatar of a user
+// and who can add them to groups. "contacts" means contacts of the user.
+type PrivacySettings struct {
+	LastSeen     string `json:"lastSeen" form:"lastSeen" gorm:"not null;default:everybody" validate:"required,oneof=everybody contacts nobody"`
+	ProfilePhoto string `json:"profilePhoto" form:"profilePhoto" gorm:"not null;default:everybody" validate:"required,oneof=everybody contacts nobody"`
+	GroupInvites string `json:"groupInvites" form:"groupInvites" gorm:"not null;default:everybody" validate:"required,oneof=everybody contacts nobody"`
+}
+
+func (p *PrivacySettings) Validate() error {
+	return validator.New().Struct(p)
+}
+
+// Rule returns the rule of a privacy option
+func (p *PrivacySettings) Rule(option string) string {
+	switch option {
+	case PrivacyLastSeen:
+		return p.LastSeen
+	case PrivacyProfilePhoto:
+		return p.ProfilePhoto
+	case PrivacyGroupInvites:
+		return p.GroupInvites
+	}
+	return PrivacyEverybody
+}
+
+// Allows reports whether a user passes the rule of a privacy option,
+// isContact tells whether the owner of the settings has that user in contacts
+func (p *PrivacySettings) Allows(option string, isContact bool) bool {
+	switch p.Rule(option) {
+	case PrivacyNobody:
+		return false
+	case PrivacyContacts:
+		return isContact
+	}
+	return true
+}
+
+// Viewer is the user a response is built for
+type Viewer struct {
+	ID uint
+	// IDs of users who have the viewer in their contacts
+	ContactOf map[uint]bool
+}
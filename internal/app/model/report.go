@@ -0,0 +1,117 @@
+package model
+
+import (
+	"time"
+
+	"github.com/go-playground/validator"
+)
+
+// Statuses of reports
+const (
+	ReportOpen      = "open"
+	ReportActioned  = "actioned"
+	ReportDismissed = "dismissed"
+)
+
+// Types of reported objects
+const (
+	ReportMessage = "message"
+	ReportChat    = "chat"
+	ReportUser    = "user"
+)
+
+// ReporterFilter is the reporter of reports flagged by the content filter
+const ReporterFilter = "filter"
+
+// Report is a complaint of a user about a message, chat or user. Snapshot keeps
+// the reported content as it was at report time. Reports flagged by the content
+// filter have no reporter. A user has at most one open report of an object.
+type Report struct {
+	ID           uint   `gorm:"primarykey"`
+	ReporterID   *uint  `gorm:"index;uniqueIndex:idx_reports_open,where:status = 'open'"`
+	Reporter     *User  `gorm:"foreignKey:ReporterID"`
+	TargetType   string `gorm:"size:16;not null;index:idx_reports_target;uniqueIndex:idx_reports_open"`
+	TargetID     uint   `gorm:"not null;index:idx_reports_target;uniqueIndex:idx_reports_open"`
+	Reason       string `gorm:"size:32;not null"`
+	Comment      string `gorm:"size:500"`
+	Snapshot     string
+	Status       string `gorm:"size:16;not null;default:open;index"`
+	ResolvedByID *uint
+	ResolvedBy   *User `gorm:"foreignKey:ResolvedByID"`
+	Resolution   string
+	ResolvedAt   *time.Time
+	CreatedAt    time.Time
+}
+
+func (r *Report) ToResponse() ReportResponse {
+	reporter := ReporterFilter
+	if r.Reporter != nil {
+		reporter = r.Reporter.Username
+	}
+	var resolvedBy string
+	if r.ResolvedBy != nil {
+		resolvedBy = r.ResolvedBy.Username
+	}
+	return ReportResponse{
+		ID:         r.ID,
+		Reporter:   reporter,
+		TargetType: r.TargetType,
+		TargetID:   r.TargetID,
+		Reason:     r.Reason,
+		Comment:    r.Comment,
+		Snapshot:   r.Snapshot,
+		Status:     r.Status,
+		ResolvedBy: resolvedBy,
+		Resolution: r.Resolution,
+		ResolvedAt: r.ResolvedAt,
+		CreatedAt:  r.CreatedAt,
+	}
+}
+
+type ReportResponse struct {
+	ID         uint       `json:"id"`
+	Reporter   string     `json:"reporter"`
+	TargetType string     `json:"targetType"`
+	TargetID   uint       `json:"targetId"`
+	Reason     string     `json:"reason"`
+	Comment    string     `json:"comment"`
+	Snapshot   string     `json:"snapshot"` // JSON of the reported object at report time
+	Status     string     `json:"status"`
+	ResolvedBy string     `json:"resolvedBy"`
+	Resolution string     `json:"resolution"`
+	ResolvedAt *time.Time `json:"resolvedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+type CreateReportDto struct {
+	TargetType string `json:"targetType" form:"targetType" validate:"required,oneof=message chat user"`
+	TargetID   uint   `json:"targetId" form:"targetId" validate:"required"`
+	Reason     string `json:"reason" form:"reason" validate:"required,oneof=spam abuse violence illegal other"`
+	Comment    string `json:"comment" form:"comment" validate:"max=500"`
+}
+
+func (r *CreateReportDto) Validate() error {
+	return validator.New().Struct(r)
+}
+
+// ResolveReportDto closes a report. Actions are allowed only with the actioned status:
+// DeleteMessage for message reports, SuspendUser for message and user reports.
+type ResolveReportDto struct {
+	Status        string `json:"status" form:"status" validate:"required,oneof=actioned dismissed"`
+	DeleteMessage bool   `json:"deleteMessage" form:"deleteMessage"`
+	SuspendUser   bool   `json:"suspendUser" form:"suspendUser"`
+	Note          string `json:"note" form:"note" validate:"max=255"`
+}
+
+func (r *ResolveReportDto) Validate() error {
+	return validator.New().Struct(r)
+}
+
+// ReportResolution is a resolved report with the results of the actions taken
+type ReportResolution struct {
+	Report Report
+	// Deleted message with the chat members, nil if no message was deleted
+	DeletedMessage *Message
+	// Username of the suspended user, empty if nobody was suspended
+	SuspendedUser string
+}
@@ -0,0 +1,69 @@
+package model
+
+import (
+	"time"
+)
+
+// Statuses of scheduled messages
+const (
+	ScheduledPending = "pending"
+	ScheduledSending = "sending"
+	ScheduledSent    = "sent"
+	ScheduledFailed  = "failed"
+)
+
+// ScheduledMessage is a message to be sent to the chat at SendAt.
+// A scheduler claims due messages by moving them to the sending status until
+// LockedUntil under its own LeaseToken, so replicas don't send the same message twice.
+type ScheduledMessage struct {
+	ID       uint   `gorm:"primarykey"`
+	SenderID uint   `gorm:"not null;index"`
+	Sender   User   `gorm:"foreignKey:SenderID"`
+	ChatID   uint   `gorm:"not null;index"`
+	Content  string `gorm:"not null"`
+	// Posts the message as a reply in the thread
+	ThreadRootID *uint
+	SendAt       time.Time `gorm:"not null;index"`
+	Status       string    `gorm:"size:16;not null;default:pending;index"`
+	LockedUntil  *time.Time
+	LeaseToken   string `gorm:"size:32;not null;default:''"`
+	// Message created at SendAt
+	MessageID *uint
+	// Why the message could not be sent
+	Error     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (m *ScheduledMessage) ToResponse() ScheduledMessageResponse {
+	return ScheduledMessageResponse{
+		ID:           m.ID,
+		ChatID:       m.ChatID,
+		Content:      m.Content,
+		ThreadRootID: m.ThreadRootID,
+		SendAt:       m.SendAt,
+		Status:       m.Status,
+		Error:        m.Error,
+		MessageID:    m.MessageID,
+		CreatedAt:    m.CreatedAt,
+		UpdatedAt:    m.UpdatedAt,
+	}
+}
+
+type ScheduledMessageResponse struct {
+	ID           uint      `json:"id"`
+	ChatID       uint      `json:"chatId"`
+	Content      string    `json:"content"`
+	ThreadRootID *uint     `json:"threadRootId,omitempty"`
+	SendAt       time.Time `json:"sendAt"`
+	Status       string    `json:"status"`
+	Error        string    `json:"error,omitempty"`
+	MessageID    *uint     `json:"messageId,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+type ModifyScheduledMessageDto struct {
+	Content *string    `json:"content"`
+	SendAt  *time.Time `json:"sendAt"`
+}
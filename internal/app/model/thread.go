@@ -0,0 +1,41 @@
+package model
+
+import (
+	"time"
+)
+
+// ThreadSubscription delivers replies of the thread to the user and counts the
+// ones they haven't read. Authors of the root message and of replies are subscribed.
+type ThreadSubscription struct {
+	UserID uint `gorm:"primaryKey"`
+	RootID uint `gorm:"primaryKey;index"`
+	// Last reply the user has read
+	LastReadID uint `gorm:"not null;default:0"`
+	CreatedAt  time.Time
+}
+
+// ThreadSummary is the state of the thread of a root message
+type ThreadSummary struct {
+	ReplyCount  int
+	LastReplyAt time.Time
+	// Authors of the last replies, the most recent first
+	Participants []User
+}
+
+type ThreadSummaryResponse struct {
+	ReplyCount   int            `json:"replyCount"`
+	LastReplyAt  time.Time      `json:"lastReplyAt"`
+	Participants []UserResponse `json:"participants"`
+}
+
+type ThreadResponse struct {
+	Root       MessageResponse   `json:"root"`
+	Replies    []MessageResponse `json:"replies"`
+	Subscribed bool              `json:"subscribed"`
+	Unread     int               `json:"unread"`
+}
+
+type ThreadSubscriptionResponse struct {
+	Root   MessageResponse `json:"root"`
+	Unread int             `json:"unread"`
+}
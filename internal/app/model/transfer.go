@@ -0,0 +1,67 @@
+package model
+
+import (
+	"time"
+
+	"github.com/go-playground/validator"
+)
+
+// Statuses of chat transfers
+const (
+	TransferPending  = "pending"
+	TransferAccepted = "accepted"
+	TransferDeclined = "declined"
+)
+
+// ChatTransfer is money sent inside a chat. It is held on the escrow account
+// until the recipient accepts it or declines and it goes back to the sender.
+type ChatTransfer struct {
+	ID            uint   `gorm:"primarykey"`
+	ChatID        uint   `gorm:"not null;index"`
+	SenderID      uint   `gorm:"not null"`
+	Sender        User   `gorm:"foreignKey:SenderID"`
+	RecipientID   uint   `gorm:"not null;index"`
+	Recipient     User   `gorm:"foreignKey:RecipientID"`
+	Amount        int64  `gorm:"not null"`
+	Status        string `gorm:"size:16;not null;default:pending"`
+	TransactionID uint   `gorm:"not null;uniqueIndex"`
+	// Transaction that moved the money out of escrow
+	SettlementID *uint
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (t *ChatTransfer) ToResponse() ChatTransferResponse {
+	return ChatTransferResponse{
+		ID:        t.ID,
+		ChatID:    t.ChatID,
+		Sender:    t.Sender.Username,
+		Recipient: t.Recipient.Username,
+		Amount:    t.Amount,
+		Status:    t.Status,
+		UpdatedAt: t.UpdatedAt,
+	}
+}
+
+type ChatTransferResponse struct {
+	ID        uint      `json:"id"`
+	ChatID    uint      `json:"chatId"`
+	Sender    string    `json:"sender"`
+	Recipient string    `json:"recipient"`
+	Amount    int64     `json:"amount"` // minor units
+	Status    string    `json:"status"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type CreateChatTransferDto struct {
+	ChatID uint `json:"chatId" form:"chatId" validate:"required"`
+	// Recipient, required in group chats
+	Username       string `json:"username" form:"username"`
+	Amount         int64  `json:"amount" form:"amount" validate:"required,min=1"`
+	Comment        string `json:"comment" form:"comment" validate:"max=255"`
+	IdempotencyKey string `json:"idempotencyKey" form:"idempotencyKey" validate:"required,max=64"`
+}
+
+func (t *CreateChatTransferDto) Validate() error {
+	return validator.New().Struct(t)
+}
@@ -10,38 +10,71 @@ import (
 
 type User struct {
 	gorm.Model
-	Avatar       []byte  `json:"avatar"`
-	Username     string  `json:"username" gorm:"index;unique"`
-	PasswordHash string  `json:"password_hash"`
-	Password     string  `json:"password" gorm:"-"`
-	FirstName    string  `json:"firstname"`
-	SecondName   string  `json:"secondname"`
-	Role         string  `json:"role" gorm:"not null;default:user"`
-	Balance      float32 `json:"balance"`
-	Chats        []*Chat    `json:"chats" gorm:"many2many:user_chats;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
-    Messages     []Message  `json:"messages" gorm:"foreignKey:SenderID"`
+	Avatar              []byte          `json:"avatar"`
+	AvatarThumb         []byte          `json:"avatar_thumb"`
+	Username            string          `json:"username" gorm:"index;unique"`
+	UsernameChangedAt   *time.Time      `json:"username_changed_at"`
+	Email               string          `json:"email" gorm:"index;uniqueIndex:idx_users_unique_email,where:email <> ''"`
+	PasswordHash        string          `json:"password_hash"`
+	TokenVersion        uint            `json:"token_version" gorm:"not null;default:0"`
+	DeletionScheduledAt *time.Time      `json:"deletion_scheduled_at" gorm:"index"`
+	LastSeenAt          *time.Time      `json:"last_seen_at"`
+	SuspendedAt         *time.Time      `json:"suspended_at"`
+	SuspensionReason    string          `json:"suspension_reason"`
+	ContactsOnlyChats   bool            `json:"contacts_only_chats" gorm:"not null;default:false"`
+	Privacy             PrivacySettings `json:"privacy" gorm:"embedded;embeddedPrefix:privacy_"`
+	Password            string          `json:"password" gorm:"-"`
+	FirstName           string          `json:"firstname"`
+	SecondName          string          `json:"secondname"`
+	Role                string          `json:"role" gorm:"not null;default:user"`
+	Chats               []*Chat         `json:"chats" gorm:"many2many:user_chats;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	Messages            []Message       `json:"messages" gorm:"foreignKey:SenderID"`
 }
 
-
 func (m *User) ToResponse() UserResponse {
 	return UserResponse{
-		ID:         m.ID,
-		Avatar:     m.Avatar,
-		Username:   m.Username,
-		FirstName:  m.FirstName,
-		SecondName: m.SecondName,
-		Balance:    m.Balance,
-		Role:       m.Role,
-		CreatedAt:  m.CreatedAt,
-		UpdatedAt:  m.UpdatedAt,
+		ID:          m.ID,
+		Avatar:      m.Avatar,
+		AvatarThumb: m.AvatarThumb,
+		Username:    m.Username,
+		FirstName:   m.FirstName,
+		SecondName:  m.SecondName,
+		Role:        m.Role,
+		LastSeenAt:  m.LastSeenAt,
+		CreatedAt:   m.CreatedAt,
+		UpdatedAt:   m.UpdatedAt,
 	}
 }
+
+// ToResponseFor hides the avatar and the last seen time if the privacy
+// settings of the user don't allow the viewer to see them
+func (m *User) ToResponseFor(viewer Viewer) UserResponse {
+	resp := m.ToResponse()
+	if !m.VisibleTo(viewer, PrivacyProfilePhoto) {
+		resp.Avatar = nil
+		resp.AvatarThumb = nil
+	}
+	if !m.VisibleTo(viewer, PrivacyLastSeen) {
+		resp.LastSeenAt = nil
+	}
+	return resp
+}
+
+// VisibleTo reports whether the privacy option of the user allows the viewer
+func (m *User) VisibleTo(viewer Viewer, option string) bool {
+	if viewer.ID == m.ID {
+		return true
+	}
+	return m.Privacy.Allows(option, viewer.ContactOf[m.ID])
+}
+
 type CreateUserDto struct {
 	Username   string `json:"username" form:"username" validate:"required,alphanum,min=3,max=32"`
 	Password   string `json:"password" form:"password" validate:"required,min=3,max=32"`
+	Email      string `json:"email" form:"email" validate:"omitempty,email,max=254"`
 	FirstName  string `json:"firstname" form:"firstname" validate:"max=50"`
 	SecondName string `json:"secondname" form:"secondname" validate:"max=50"`
-	Role       string `json:"role" form:"role" validate:"required,oneof=user admin"` //"user"/"admin"
+	Role       string `json:"role" form:"role" validate:"required,oneof=user"` // admins are appointed by other admins
 }
 
 func (c *CreateUserDto) ToModel() (User, error) {
@@ -52,24 +85,61 @@ func (c *CreateUserDto) ToModel() (User, error) {
 	return User{
 		Username:   strings.ToLower(c.Username),
 		Password:   c.Password,
+		Email:      strings.ToLower(c.Email),
 		FirstName:  c.FirstName,
 		SecondName: c.SecondName,
 		Role:       c.Role,
 	}, nil
 }
 
+// ModifyUserDto is a partial profile update, nil fields are left unchanged
 type ModifyUserDto struct {
-	Username    string  `json:"username" form:"username"`
-	OldPassword string  `json:"oldPassword" form:"oldPassword"`
-	NewPassword string  `json:"newPassword" form:"newPassword"`
-	Avatar      []byte  `json:"avatar" form:"avatar"`
-	FirstName   string  `json:"firstname" form:"firstname"`
-	SecondName  string  `json:"secondname" form:"secondname"`
-	Balance     float32 `json:"balance"`
+	Username   *string `json:"username" form:"username" validate:"omitempty,alphanum,min=3,max=32"`
+	Avatar     []byte  `json:"avatar" form:"avatar"`
+	FirstName  *string `json:"firstname" form:"firstname" validate:"omitempty,max=50"`
+	SecondName *string `json:"secondname" form:"secondname" validate:"omitempty,max=50"`
+}
+
+func (u *ModifyUserDto) Validate() error {
+	if err := validator.New().Struct(u); err != nil {
+		return err
+	}
+	if u.Username != nil {
+		username := strings.ToLower(*u.Username)
+		u.Username = &username
+	}
+	return nil
+}
+
+type ModifyUserResponse struct {
+	User  UserResponse `json:"user"`
+	Token string       `json:"token,omitempty"` // new token, set when the username was changed
+}
+
+type ChangePasswordDto struct {
+	OldPassword string `json:"oldPassword" form:"oldPassword" validate:"required"`
+	NewPassword string `json:"newPassword" form:"newPassword" validate:"required,min=3,max=32"`
+}
+
+func (c *ChangePasswordDto) Validate() error {
+	return validator.New().Struct(c)
+}
+
+type PasswordResetRequestDto struct {
+	Email string `json:"email" form:"email" validate:"required,email"`
+}
+
+func (c *PasswordResetRequestDto) Validate() error {
+	return validator.New().Struct(c)
+}
+
+type PasswordResetDto struct {
+	Token       string `json:"token" form:"token" validate:"required"`
+	NewPassword string `json:"newPassword" form:"newPassword" validate:"required,min=3,max=32"`
 }
 
-func (u *ModifyUserDto) ToModel(passHash string) User {
-	return User{}
+func (c *PasswordResetDto) Validate() error {
+	return validator.New().Struct(c)
 }
 
 type UserDto struct {
@@ -77,7 +147,7 @@ type UserDto struct {
 	Password string `json:"password" form:"password"`
 }
 
-func (u *UserDto) ToModel() User{
+func (u *UserDto) ToModel() User {
 	return User{
 		Username: u.Username,
 		Password: u.Password,
@@ -85,14 +155,16 @@ func (u *UserDto) ToModel() User{
 }
 
 type UserResponse struct {
-	ID         uint      `json:"id"`
-	Avatar     []byte    `json:"avatar"`
-	Username   string    `json:"username"`
-	FirstName  string    `json:"firstname"`
-	SecondName string    `json:"secondname"`
-	Balance    float32   `json:"balance"`
-	Role       string    `json:"role"`
-	CreatedAt  time.Time `json:"createdAt"`
-	UpdatedAt  time.Time `json:"updatedAt"`
-	DeletedAt *time.Time `json:"deletedAt"`
+	ID          uint       `json:"id"`
+	Avatar      []byte     `json:"avatar"`
+	AvatarThumb []byte     `json:"avatarThumb"`
+	Username    string     `json:"username"`
+	FirstName   string     `json:"firstname"`
+	SecondName  string     `json:"secondname"`
+	Balance     *int64     `json:"balance,omitempty"` // wallet balance, only in the response for the user themselves
+	Role        string     `json:"role"`
+	LastSeenAt  *time.Time `json:"lastSeenAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	DeletedAt   *time.Time `json:"deletedAt"`
 }
@@ -137,7 +137,7 @@ func TestCreateUserDroValidate(t *testing.T) {
 				m.Role = "admin"
 				return m
 			},
-			isValid: true,
+			isValid: false,
 		},
 		{
 			name:"role empty",
@@ -171,3 +171,59 @@ func TestCreateUserDroValidate(t *testing.T) {
 	}
 }
 
+
+func TestModifyUserDtoValidate(t *testing.T) {
+	strPtr := func(s string) *string { return &s }
+
+	testCases := []struct {
+		name    string
+		u       model.ModifyUserDto
+		isValid bool
+	}{
+		{
+			name:    "empty update",
+			u:       model.ModifyUserDto{},
+			isValid: true,
+		},
+		{
+			name:    "valid username",
+			u:       model.ModifyUserDto{Username: strPtr("NewName")},
+			isValid: true,
+		},
+		{
+			name:    "username dont alphanum",
+			u:       model.ModifyUserDto{Username: strPtr("new name!")},
+			isValid: false,
+		},
+		{
+			name:    "username min 3",
+			u:       model.ModifyUserDto{Username: strPtr("ab")},
+			isValid: false,
+		},
+		{
+			name:    "firstname can be cleared",
+			u:       model.ModifyUserDto{FirstName: strPtr("")},
+			isValid: true,
+		},
+		{
+			name:    "secondname max 50",
+			u:       model.ModifyUserDto{SecondName: strPtr("hhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhh")},
+			isValid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.u.Validate()
+			if tc.isValid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+
+	u := model.ModifyUserDto{Username: strPtr("NewName")}
+	assert.NoError(t, u.Validate())
+	assert.Equal(t, "newname", *u.Username)
+}
@@ -0,0 +1,96 @@
+package model
+
+import (
+	"time"
+
+	"github.com/go-playground/validator"
+)
+
+// Kinds of wallet transactions
+const (
+	WalletTransfer           = "transfer"
+	WalletDeposit            = "deposit"
+	WalletChatTransfer       = "chat_transfer"
+	WalletChatTransferAccept = "chat_transfer_accept"
+	WalletChatTransferRefund = "chat_transfer_refund"
+)
+
+// WalletAccount is an account of the double-entry ledger. Balance is kept in
+// minor units and always equals the sum of the account entries.
+type WalletAccount struct {
+	ID        uint   `gorm:"primarykey"`
+	Name      string `gorm:"size:64;not null;uniqueIndex"` // "user:<id>" or a system account name
+	UserID    *uint  `gorm:"index"`
+	User      *User  `gorm:"foreignKey:UserID"`
+	Balance   int64  `gorm:"not null;default:0"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// WalletTransaction moves Amount between accounts, its entries always sum up to zero
+type WalletTransaction struct {
+	ID             uint          `gorm:"primarykey"`
+	InitiatorID    uint          `gorm:"not null;uniqueIndex:idx_wallet_transactions_key"`
+	IdempotencyKey string        `gorm:"size:64;not null;uniqueIndex:idx_wallet_transactions_key"`
+	Kind           string        `gorm:"size:32;not null"`
+	Amount         int64         `gorm:"not null"`
+	Comment        string        `gorm:"size:255"`
+	Entries        []WalletEntry `gorm:"foreignKey:TransactionID"`
+	CreatedAt      time.Time
+}
+
+// WalletEntry is one side of a transaction, negative Amount is a debit
+type WalletEntry struct {
+	ID            uint          `gorm:"primarykey"`
+	TransactionID uint          `gorm:"not null;index"`
+	AccountID     uint          `gorm:"not null;index"`
+	Account       WalletAccount `gorm:"foreignKey:AccountID"`
+	Amount        int64         `gorm:"not null"`
+	CreatedAt     time.Time
+}
+
+// ToResponse builds the transaction as seen by the user, entries have to be
+// loaded with their accounts and users
+func (t *WalletTransaction) ToResponse(username string) WalletTransactionResponse {
+	resp := WalletTransactionResponse{
+		ID:        t.ID,
+		Kind:      t.Kind,
+		Comment:   t.Comment,
+		CreatedAt: t.CreatedAt,
+	}
+	for _, entry := range t.Entries {
+		if entry.Account.User == nil {
+			continue
+		}
+		if entry.Account.User.Username == username {
+			resp.Amount += entry.Amount
+		} else {
+			resp.Counterparty = entry.Account.User.Username
+		}
+	}
+	return resp
+}
+
+type WalletTransactionResponse struct {
+	ID           uint      `json:"id"`
+	Kind         string    `json:"kind"`
+	Amount       int64     `json:"amount"`       // minor units, negative when money left the account
+	Counterparty string    `json:"counterparty"` // empty for deposits
+	Comment      string    `json:"comment"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+type WalletBalanceResponse struct {
+	Balance int64 `json:"balance"` // minor units
+}
+
+type TransferDto struct {
+	Username       string `json:"username" form:"username" validate:"required"`
+	Amount         int64  `json:"amount" form:"amount" validate:"required,min=1"`
+	Comment        string `json:"comment" form:"comment" validate:"max=255"`
+	IdempotencyKey string `json:"idempotencyKey" form:"idempotencyKey" validate:"required,max=64"`
+}
+
+func (t *TransferDto) Validate() error {
+	return validator.New().Struct(t)
+}
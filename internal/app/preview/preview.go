@@ -0,0 +1,264 @@
+// Package preview fetches OpenGraph previews of links sent in messages.
+//
+// The fetcher only talks to public addresses: the address is checked when the
+// connection is made, after DNS resolution and on every redirect, so neither
+// DNS rebinding nor redirects can reach the internal network.
+package preview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	maxRedirects      = 3
+	maxTitleLength    = 300
+	maxDescriptLength = 1000
+	userAgent         = "websocket-messenger-preview/1.0"
+)
+
+var (
+	ErrForbiddenAddress = errors.New("address is not public")
+	ErrNotHTML          = errors.New("content is not html")
+	ErrNoPreview        = errors.New("page has no preview")
+)
+
+// Preview is the OpenGraph data of a page
+type Preview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+	SiteName    string `json:"siteName"`
+}
+
+type Config struct {
+	// Timeout of the whole request, redirects included
+	Timeout time.Duration
+	// Bytes of the body read at most
+	MaxBytes int64
+	// Lets the fetcher reach private and loopback addresses, for tests only
+	AllowPrivate bool
+}
+
+// DefaultConfig is used for zero fields of the config
+var DefaultConfig = Config{
+	Timeout:  5 * time.Second,
+	MaxBytes: 512 << 10,
+}
+
+type Fetcher struct {
+	client   *http.Client
+	maxBytes int64
+}
+
+func NewFetcher(config Config) *Fetcher {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultConfig.Timeout
+	}
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = DefaultConfig.MaxBytes
+	}
+
+	dialer := &net.Dialer{
+		Timeout: config.Timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if config.AllowPrivate {
+				return nil
+			}
+			return checkAddress(address)
+		},
+	}
+	transport := &http.Transport{
+		// a proxy would make the connection instead of the checked dialer
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   config.Timeout,
+		ResponseHeaderTimeout: config.Timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+	return &Fetcher{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   config.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxRedirects {
+					return errors.New("too many redirects")
+				}
+				return checkURL(req.URL)
+			},
+		},
+		maxBytes: config.MaxBytes,
+	}
+}
+
+// Fetch downloads the page and returns its preview. Pages without a title and
+// a description return ErrNoPreview.
+func (f *Fetcher) Fetch(ctx context.Context, link string) (Preview, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return Preview{}, err
+	}
+	if err := checkURL(u); err != nil {
+		return Preview{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return Preview{}, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return Preview{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Preview{}, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "text/html" {
+		return Preview{}, ErrNotHTML
+	}
+
+	body, err := charset.NewReader(io.LimitReader(resp.Body, f.maxBytes), contentType)
+	if err != nil {
+		return Preview{}, err
+	}
+	preview := parse(body, resp.Request.URL)
+	if preview.Title == "" && preview.Description == "" {
+		return Preview{}, ErrNoPreview
+	}
+	return preview, nil
+}
+
+// parse reads OpenGraph tags of the head of the page, falling back to the
+// title and the description meta tag
+func parse(body io.Reader, pageURL *url.URL) Preview {
+	preview := Preview{URL: pageURL.String()}
+	var title, description string
+
+	tokenizer := html.NewTokenizer(body)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return finish(preview, title, description)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "body":
+				return finish(preview, title, description)
+			case "title":
+				if tokenizer.Next() == html.TextToken {
+					title = string(tokenizer.Text())
+				}
+			case "meta":
+				key, content := metaAttributes(token)
+				switch key {
+				case "og:title":
+					preview.Title = content
+				case "og:description":
+					preview.Description = content
+				case "og:site_name":
+					preview.SiteName = content
+				case "og:image":
+					if image, err := pageURL.Parse(content); err == nil && checkURL(image) == nil {
+						preview.Image = image.String()
+					}
+				case "description":
+					description = content
+				}
+			}
+		case html.EndTagToken:
+			if tokenizer.Token().Data == "head" {
+				return finish(preview, title, description)
+			}
+		}
+	}
+}
+
+func finish(preview Preview, title, description string) Preview {
+	if preview.Title == "" {
+		preview.Title = title
+	}
+	if preview.Description == "" {
+		preview.Description = description
+	}
+	preview.Title = truncate(preview.Title, maxTitleLength)
+	preview.Description = truncate(preview.Description, maxDescriptLength)
+	preview.SiteName = truncate(preview.SiteName, maxTitleLength)
+	return preview
+}
+
+func metaAttributes(token html.Token) (string, string) {
+	var key, content string
+	for _, attr := range token.Attr {
+		switch attr.Key {
+		case "property", "name":
+			key = strings.ToLower(attr.Val)
+		case "content":
+			content = attr.Val
+		}
+	}
+	return key, content
+}
+
+// truncate collapses white space and cuts the text to the length in characters
+func truncate(text string, length int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= length {
+		return text
+	}
+	return string([]rune(text)[:length-1]) + "…"
+}
+
+func checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" || u.User != nil {
+		return errors.New("invalid url")
+	}
+	return nil
+}
+
+// checkAddress lets connections to public unicast addresses only
+func checkAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ErrForbiddenAddress
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || isSharedAddress(ip) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// isSharedAddress reports carrier-grade NAT addresses, which are internal too
+func isSharedAddress(ip net.IP) bool {
+	return sharedAddressSpace.Contains(ip)
+}
@@ -0,0 +1,83 @@
+package preview_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/preview"
+	"github.com/stretchr/testify/assert"
+)
+
+func testServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><head>
+			<title>Fallback</title>
+			<meta property="og:title" content="  Go   release ">
+			<meta property="og:description" content="What is new">
+			<meta property="og:image" content="/cover.png">
+			<meta property="og:site_name" content="Blog">
+			</head><body><meta property="og:title" content="ignored"></body></html>`))
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><title>Only title</title><meta name="description" content="Described"></head></html>`))
+	})
+	mux.HandleFunc("/huge", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><head>" + strings.Repeat("<!-- padding -->", 1000) + "<title>Too far</title></head></html>"))
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/plain", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFetch(t *testing.T) {
+	server := testServer(t)
+	fetcher := preview.NewFetcher(preview.Config{MaxBytes: 4 << 10, AllowPrivate: true})
+	ctx := context.Background()
+
+	p, err := fetcher.Fetch(ctx, server.URL+"/article")
+	assert.NoError(t, err)
+	assert.Equal(t, preview.Preview{
+		URL:         server.URL + "/article",
+		Title:       "Go release",
+		Description: "What is new",
+		Image:       server.URL + "/cover.png",
+		SiteName:    "Blog",
+	}, p)
+
+	p, err = fetcher.Fetch(ctx, server.URL+"/redirect")
+	assert.NoError(t, err)
+	assert.Equal(t, server.URL+"/plain", p.URL)
+	assert.Equal(t, "Only title", p.Title)
+	assert.Equal(t, "Described", p.Description)
+
+	_, err = fetcher.Fetch(ctx, server.URL+"/huge")
+	assert.ErrorIs(t, err, preview.ErrNoPreview)
+	_, err = fetcher.Fetch(ctx, server.URL+"/image")
+	assert.ErrorIs(t, err, preview.ErrNotHTML)
+	_, err = fetcher.Fetch(ctx, "file:///etc/passwd")
+	assert.Error(t, err)
+}
+
+func TestFetch_PrivateAddresses(t *testing.T) {
+	server := testServer(t)
+	fetcher := preview.NewFetcher(preview.Config{})
+
+	_, err := fetcher.Fetch(context.Background(), server.URL+"/article")
+	assert.ErrorIs(t, err, preview.ErrForbiddenAddress)
+	_, err = fetcher.Fetch(context.Background(), "http://169.254.169.254/latest/meta-data/")
+	assert.ErrorIs(t, err, preview.ErrForbiddenAddress)
+}
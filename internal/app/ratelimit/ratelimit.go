@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limit is a token bucket: it holds up to Burst tokens and gets Rate tokens
+// every Per. Every request takes a token. Zero Rate means no limit.
+type Limit struct {
+	Rate  int
+	Per   time.Duration
+	Burst int
+}
+
+// PerMinute allows rate requests a minute with bursts of the same size
+func PerMinute(rate int) Limit {
+	return Limit{Rate: rate, Per: time.Minute, Burst: rate}
+}
+
+// IsZero reports whether the limit lets everything through
+func (l Limit) IsZero() bool {
+	return l.Rate <= 0 || l.Per <= 0
+}
+
+func (l Limit) burst() int {
+	if l.Burst <= 0 {
+		return l.Rate
+	}
+	return l.Burst
+}
+
+// Result is a decision of a limiter
+type Result struct {
+	Allowed bool
+	// Tokens left in the bucket
+	Remaining int
+	// Time until the next token, set if the request is not allowed
+	RetryAfter time.Duration
+}
+
+// Limiter takes a token from the bucket of the key
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// UserKey is the key of the bucket of a user in the scope
+func UserKey(scope, username string) string {
+	return scope + ":user:" + username
+}
+
+// IPKey is the key of the bucket of an IP address in the scope
+func IPKey(scope, ip string) string {
+	return scope + ":ip:" + ip
+}
@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := NewLocalLimiter()
+	limiter.now = func() time.Time { return now }
+	limit := Limit{Rate: 1, Per: time.Second, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		result, err := limiter.Allow(context.Background(), "alice", limit)
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+	}
+
+	result, err := limiter.Allow(context.Background(), "alice", limit)
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, time.Second, result.RetryAfter)
+
+	result, err = limiter.Allow(context.Background(), "bob", limit)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	now = now.Add(500 * time.Millisecond)
+	result, err = limiter.Allow(context.Background(), "alice", limit)
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 500*time.Millisecond, result.RetryAfter)
+
+	now = now.Add(500 * time.Millisecond)
+	result, err = limiter.Allow(context.Background(), "alice", limit)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	result, err = limiter.Allow(context.Background(), "alice", Limit{})
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
+
+func TestRedisLimiter(t *testing.T) {
+	rdb, mock := redismock.NewClientMock()
+	limiter := NewRedisLimiter(rdb)
+	limit := PerMinute(30)
+
+	mock.ExpectEvalSha(tokenBucket.Hash(), []string{"ratelimit_messages:alice"}, 30, int64(60000), 30).
+		SetVal([]interface{}{int64(0), int64(0), int64(1500)})
+
+	result, err := limiter.Allow(context.Background(), "messages:alice", limit)
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 1500*time.Millisecond, result.RetryAfter)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// tokenBucket refills the bucket by the time passed since the last request and
+// takes a token if there is one. Time is taken from Redis, so every node sees
+// the same clock.
+var tokenBucket = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local per = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(bucket[1])
+local updated = tonumber(bucket[2])
+if tokens == nil or updated == nil then
+	tokens = burst
+	updated = now
+end
+
+tokens = math.min(burst, tokens + math.max(0, now - updated) * rate / per)
+
+local allowed = 0
+local retryAfter = 0
+if tokens >= 1 then
+	allowed = 1
+	tokens = tokens - 1
+else
+	retryAfter = math.ceil((1 - tokens) * per / rate)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst * per / rate))
+return {allowed, math.floor(tokens), retryAfter}
+`)
+
+// RedisLimiter keeps buckets in Redis, so limits are shared by every node
+type RedisLimiter struct {
+	rdb *redis.Client
+}
+
+func NewRedisLimiter(rdb *redis.Client) *RedisLimiter {
+	return &RedisLimiter{
+		rdb: rdb,
+	}
+}
+
+func (l *RedisLimiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	if limit.IsZero() {
+		return Result{Allowed: true}, nil
+	}
+
+	values, err := tokenBucket.Run(ctx, l.rdb, []string{"ratelimit_" + key},
+		limit.Rate, limit.Per.Milliseconds(), limit.burst()).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Allowed:    values[0] == 1,
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+	}, nil
+}
@@ -0,0 +1,293 @@
+// Package richtext parses markdown-lite input of messages into plain text and
+// formatting entities, so every client renders messages the same way.
+//
+// Supported markup: **bold**, *italic* or _italic_, ~~strikethrough~~, `code`,
+// ```pre``` and [text](https://link). A backslash escapes the next markup character.
+// Unclosed markup stays as it is. URLs in the text are detected as url entities.
+package richtext
+
+import (
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Types of entities
+const (
+	Bold          = "bold"
+	Italic        = "italic"
+	Strikethrough = "strikethrough"
+	Code          = "code"
+	Pre           = "pre"
+	TextLink      = "text_link"
+	URL           = "url"
+)
+
+// Entity formats Length characters of the text starting at Offset.
+// URL is set for text links only.
+type Entity struct {
+	Type   string
+	Offset int
+	Length int
+	URL    string
+}
+
+// marker is an emphasis delimiter of the input
+type marker struct {
+	text       string
+	entityType string
+	// Single * and _ can't open or close inside a word
+	intraword bool
+}
+
+// Longer markers first so ** isn't read as two *
+var markers = []marker{
+	{"**", Bold, true},
+	{"~~", Strikethrough, true},
+	{"*", Italic, false},
+	{"_", Italic, false},
+}
+
+const escapable = "\\*_~`[]()"
+
+var urlPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"']+`)
+
+// token is markup of the input found by the first pass
+type token struct {
+	// index of the rune after the token
+	end int
+	// for emphasis
+	marker  *marker
+	closing bool
+	// for code, pre and links, the inner text
+	text       []rune
+	entityType string
+	url        string
+}
+
+// Parse returns the text without markup and the entities sorted by offset,
+// the longer first at the same offset. Offsets are in characters.
+func Parse(input string) (string, []Entity) {
+	in := []rune(Sanitize(input))
+	tokens := scan(in)
+
+	out := make([]rune, 0, len(in))
+	entities := make([]Entity, 0)
+	opened := make(map[*marker]int)
+	for i := 0; i < len(in); {
+		tok, ok := tokens[i]
+		if !ok {
+			if in[i] == '\\' && i+1 < len(in) && strings.ContainsRune(escapable, in[i+1]) {
+				i++
+			}
+			out = append(out, in[i])
+			i++
+			continue
+		}
+
+		switch {
+		case tok.marker != nil && !tok.closing:
+			opened[tok.marker] = len(out)
+		case tok.marker != nil:
+			if start := opened[tok.marker]; len(out) > start {
+				entities = append(entities, Entity{Type: tok.marker.entityType, Offset: start, Length: len(out) - start})
+			}
+		default:
+			if tok.entityType != "" && len(tok.text) > 0 {
+				entities = append(entities, Entity{Type: tok.entityType, Offset: len(out), Length: len(tok.text), URL: tok.url})
+			}
+			out = append(out, tok.text...)
+		}
+		i = tok.end
+	}
+
+	text := string(out)
+	entities = append(entities, detectURLs(text, entities)...)
+	slices.SortStableFunc(entities, func(a, b Entity) int {
+		if a.Offset != b.Offset {
+			return a.Offset - b.Offset
+		}
+		return b.Length - a.Length
+	})
+	return text, entities
+}
+
+// Sanitize drops control characters except new lines and tabs, and characters
+// overriding the direction of the text which can disguise links and file names
+func Sanitize(input string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\t':
+			return r
+		case r == '\r' || unicode.IsControl(r):
+			return -1
+		case r >= '\u202a' && r <= '\u202e', r >= '\u2066' && r <= '\u2069':
+			return -1
+		}
+		return r
+	}, input)
+}
+
+// scan finds markup of the input, keyed by the index of its first rune.
+// Emphasis markers are paired, the ones without a pair are left out.
+func scan(in []rune) map[int]token {
+	tokens := make(map[int]token)
+	open := make(map[*marker]int)
+	for i := 0; i < len(in); {
+		switch {
+		case in[i] == '\\' && i+1 < len(in) && strings.ContainsRune(escapable, in[i+1]):
+			i += 2
+			continue
+		case hasPrefix(in, i, "```"):
+			if end := index(in, i+3, "```"); end >= 0 {
+				text := in[i+3 : end]
+				if len(text) > 0 && text[0] == '\n' {
+					text = text[1:]
+				}
+				tokens[i] = token{end: end + 3, text: text, entityType: Pre}
+				i = end + 3
+				continue
+			}
+		case in[i] == '`':
+			if end := index(in, i+1, "`"); end >= 0 {
+				tokens[i] = token{end: end + 1, text: in[i+1 : end], entityType: Code}
+				i = end + 1
+				continue
+			}
+		case in[i] == '[':
+			if tok, ok := scanLink(in, i); ok {
+				tokens[i] = tok
+				i = tok.end
+				continue
+			}
+		}
+
+		if m := markerAt(in, i); m != nil {
+			end := i + utf8.RuneCountInString(m.text)
+			if start, ok := open[m]; ok && canClose(in, i, end, m) {
+				tokens[start] = token{end: start + utf8.RuneCountInString(m.text), marker: m}
+				tokens[i] = token{end: end, marker: m, closing: true}
+				delete(open, m)
+			} else if !ok && canOpen(in, i, end, m) {
+				open[m] = i
+			}
+			i = end
+			continue
+		}
+		i++
+	}
+	return tokens
+}
+
+// scanLink reads [text](url) at i, the text is plain. Links to anything but http
+// and https are dropped leaving the text only, so they can't run scripts in clients.
+func scanLink(in []rune, i int) (token, bool) {
+	textEnd := index(in, i+1, "](")
+	if textEnd < 0 || slices.Contains(in[i+1:textEnd], '\n') {
+		return token{}, false
+	}
+	// parentheses of the url have to be balanced
+	urlEnd, depth := -1, 0
+	for j := textEnd + 2; j < len(in) && urlEnd < 0; j++ {
+		switch in[j] {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				urlEnd = j
+			}
+			depth--
+		case '\n':
+			return token{}, false
+		}
+	}
+	if urlEnd < 0 {
+		return token{}, false
+	}
+
+	link := strings.TrimSpace(string(in[textEnd+2 : urlEnd]))
+	tok := token{end: urlEnd + 1, text: in[i+1 : textEnd]}
+	if u, err := url.Parse(link); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		tok.entityType = TextLink
+		tok.url = u.String()
+	}
+	return tok, true
+}
+
+func markerAt(in []rune, i int) *marker {
+	for j := range markers {
+		if hasPrefix(in, i, markers[j].text) {
+			return &markers[j]
+		}
+	}
+	return nil
+}
+
+// canOpen reports whether the marker from start to end opens an entity:
+// it has to be followed by a non-space, and not be glued to a word before if it is single
+func canOpen(in []rune, start, end int, m *marker) bool {
+	if end >= len(in) || unicode.IsSpace(in[end]) {
+		return false
+	}
+	return m.intraword || start == 0 || !isWordRune(in[start-1])
+}
+
+// canClose reports whether the marker from start to end closes an entity:
+// it has to follow a non-space, and not be glued to a word after if it is single
+func canClose(in []rune, start, end int, m *marker) bool {
+	if start == 0 || unicode.IsSpace(in[start-1]) {
+		return false
+	}
+	return m.intraword || end >= len(in) || !isWordRune(in[end])
+}
+
+// detectURLs finds URLs of the text outside of code, pre and text links
+func detectURLs(text string, entities []Entity) []Entity {
+	urls := make([]Entity, 0)
+	for _, loc := range urlPattern.FindAllStringIndex(text, -1) {
+		link := strings.TrimRight(text[loc[0]:loc[1]], ".,!?;:")
+		if strings.HasSuffix(link, ")") && !strings.Contains(link, "(") {
+			link = link[:len(link)-1]
+		}
+		entity := Entity{
+			Type:   URL,
+			Offset: utf8.RuneCountInString(text[:loc[0]]),
+			Length: utf8.RuneCountInString(link),
+		}
+		overlaps := slices.ContainsFunc(entities, func(e Entity) bool {
+			return (e.Type == Code || e.Type == Pre || e.Type == TextLink) &&
+				e.Offset < entity.Offset+entity.Length && entity.Offset < e.Offset+e.Length
+		})
+		if !overlaps {
+			urls = append(urls, entity)
+		}
+	}
+	return urls
+}
+
+func hasPrefix(in []rune, i int, prefix string) bool {
+	for _, r := range prefix {
+		if i >= len(in) || in[i] != r {
+			return false
+		}
+		i++
+	}
+	return true
+}
+
+// index returns the index of the first occurrence of s in the runes from i, or -1
+func index(in []rune, i int, s string) int {
+	for ; i < len(in); i++ {
+		if hasPrefix(in, i, s) {
+			return i
+		}
+	}
+	return -1
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,41 @@
+package richtext_test
+
+import (
+	"testing"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/richtext"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		text     string
+		entities []richtext.Entity
+	}{
+		{"plain", "hello", "hello", []richtext.Entity{}},
+		{"bold", "say **hi**", "say hi", []richtext.Entity{{Type: richtext.Bold, Offset: 4, Length: 2}}},
+		{"italic", "*a* and _b_", "a and b", []richtext.Entity{{Type: richtext.Italic, Offset: 0, Length: 1}, {Type: richtext.Italic, Offset: 6, Length: 1}}},
+		{"nested", "**bold ~~both~~**", "bold both", []richtext.Entity{{Type: richtext.Bold, Offset: 0, Length: 9}, {Type: richtext.Strikethrough, Offset: 5, Length: 4}}},
+		{"offsets in characters", "привет **мир**", "привет мир", []richtext.Entity{{Type: richtext.Bold, Offset: 7, Length: 3}}},
+		{"code keeps markup", "run `a *b*`", "run a *b*", []richtext.Entity{{Type: richtext.Code, Offset: 4, Length: 5}}},
+		{"pre", "```\nx := **1**\n```", "x := **1**\n", []richtext.Entity{{Type: richtext.Pre, Offset: 0, Length: 11}}},
+		{"unclosed", "2 * 3 and **open", "2 * 3 and **open", []richtext.Entity{}},
+		{"inside words", "snake_case_name", "snake_case_name", []richtext.Entity{}},
+		{"escaped", `\*not italic\*`, "*not italic*", []richtext.Entity{}},
+		{"text link", "see [docs](https://example.com/a)", "see docs", []richtext.Entity{{Type: richtext.TextLink, Offset: 4, Length: 4, URL: "https://example.com/a"}}},
+		{"script link", "[click](javascript:alert(1))", "click", []richtext.Entity{}},
+		{"link with parentheses", "[wiki](https://en.wikipedia.org/wiki/Go_(language))", "wiki", []richtext.Entity{{Type: richtext.TextLink, Offset: 0, Length: 4, URL: "https://en.wikipedia.org/wiki/Go_(language)"}}},
+		{"url", "go to https://example.com/x.", "go to https://example.com/x.", []richtext.Entity{{Type: richtext.URL, Offset: 6, Length: 21}}},
+		{"url in code", "`https://example.com`", "https://example.com", []richtext.Entity{{Type: richtext.Code, Offset: 0, Length: 19}}},
+		{"direction override", "file‮gnp.exe", "filegnp.exe", []richtext.Entity{}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			text, entities := richtext.Parse(tc.input)
+			assert.Equal(t, tc.text, text)
+			assert.Equal(t, tc.entities, entities)
+		})
+	}
+}
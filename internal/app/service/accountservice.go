@@ -0,0 +1,456 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	accountDeletionGracePeriod = 14 * 24 * time.Hour
+	deletedUsernamePrefix      = "deleted_"
+	// Exports built on one tick of the export builder
+	dataExportsBatch = 10
+	// How long a replica holds the export it builds, then another one may take it over
+	dataExportLease = 10 * time.Minute
+)
+
+var (
+	ErrDeletionNotScheduled = errors.New("account deletion is not scheduled")
+	ErrExportNotReady       = errors.New("data export is not ready")
+	ErrExportInProgress     = errors.New("previous data export is not ready yet")
+	ErrExportNotPending     = errors.New("data export is already being built")
+)
+
+type AccountService struct {
+	db  *gorm.DB
+	rdb *redis.Client
+	// usernames of deleted accounts whose cached users failed to be cleared,
+	// retried on the next purge
+	staleCacheMu sync.Mutex
+	staleCaches  []string
+}
+
+func NewAccountService(db *gorm.DB, rdb *redis.Client) *AccountService {
+	return &AccountService{
+		db:  db,
+		rdb: rdb,
+	}
+}
+
+// ScheduleDeletion marks the account for deletion after the grace period.
+// Until then the user can log in and cancel it.
+func (s *AccountService) ScheduleDeletion(username, password string) (time.Time, error) {
+	var user model.User
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return time.Time{}, err
+	}
+
+	if err := verifyPassword(user.PasswordHash, password); err != nil {
+		return time.Time{}, ErrWrongPassword
+	}
+
+	deleteAt := time.Now().Add(accountDeletionGracePeriod)
+	if err := s.db.Model(&user).Update("deletion_scheduled_at", deleteAt).Error; err != nil {
+		return time.Time{}, err
+	}
+
+	return deleteAt, invalidateUserCache(s.rdb, username)
+}
+
+func (s *AccountService) CancelDeletion(username string) error {
+	resoult := s.db.Model(&model.User{}).
+		Where("username = ? AND deletion_scheduled_at IS NOT NULL", username).
+		Update("deletion_scheduled_at", nil)
+	if resoult.Error != nil {
+		return resoult.Error
+	}
+	if resoult.RowsAffected == 0 {
+		return ErrDeletionNotScheduled
+	}
+	return invalidateUserCache(s.rdb, username)
+}
+
+// PurgeDueAccounts deletes every account whose grace period is over and
+// returns the usernames they had. An account that fails to be deleted doesn't
+// stop the rest, errors of all of them are returned joined.
+func (s *AccountService) PurgeDueAccounts() ([]string, error) {
+	s.clearStaleCaches()
+
+	var users []model.User
+	resoult := s.db.
+		Where("deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= ?", time.Now()).
+		Find(&users)
+	if resoult.Error != nil {
+		return nil, resoult.Error
+	}
+
+	deleted := make([]string, 0, len(users))
+	errs := make([]error, 0)
+	for i := range users {
+		if err := s.deleteAccount(&users[i]); err != nil {
+			logrus.Errorf("failed to delete account %d: %v", users[i].ID, err)
+			errs = append(errs, fmt.Errorf("failed to delete account %d: %w", users[i].ID, err))
+			continue
+		}
+		deleted = append(deleted, users[i].Username)
+	}
+	return deleted, errors.Join(errs...)
+}
+
+// RunDeletionPurger purges due accounts every interval until ctx is done.
+// onDeleted is called with the former username of every deleted account.
+func (s *AccountService) RunDeletionPurger(ctx context.Context, interval time.Duration, onDeleted func(username string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := s.PurgeDueAccounts()
+			if err != nil {
+				logrus.Errorf("failed to purge deleted accounts: %v", err)
+			}
+			for _, username := range deleted {
+				logrus.Printf("account %s deleted", username)
+				if onDeleted != nil {
+					onDeleted(username)
+				}
+			}
+		}
+	}
+}
+
+// deleteAccount anonymizes the user so their messages stay in chats without
+// any personal data, removes them from every chat and revokes their tokens
+func (s *AccountService) deleteAccount(user *model.User) error {
+	username := user.Username
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM user_chats WHERE user_id = ?", user.ID).Error; err != nil {
+			return err
+		}
+
+		err := tx.Model(user).Updates(map[string]interface{}{
+			"username":              fmt.Sprintf("%s%d", deletedUsernamePrefix, user.ID),
+			"email":                 "",
+			"first_name":            "Deleted account",
+			"second_name":           "",
+			"avatar":                nil,
+			"avatar_thumb":          nil,
+			"password_hash":         "",
+			"token_version":         gorm.Expr("token_version + 1"),
+			"deletion_scheduled_at": nil,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		// both are soft-deletable, a soft-deleted export would keep its archive
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&model.PasswordResetToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&model.DataExport{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("sender_id = ? AND status <> ?", user.ID, model.ScheduledSent).Delete(&model.ScheduledMessage{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("blocker_id = ? OR blocked_id = ?", user.ID, user.ID).Delete(&model.UserBlock{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("owner_id = ? OR contact_id = ?", user.ID, user.ID).Delete(&model.Contact{}).Error; err != nil {
+			return err
+		}
+		bookmarks := tx.Model(&model.Bookmark{}).Select("id").Where("user_id = ?", user.ID)
+		if err := tx.Where("bookmark_id IN (?)", bookmarks).Delete(&model.BookmarkTag{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&model.Bookmark{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("saved_by_id = ?", user.ID).Delete(&model.Chat{}).Error; err != nil {
+			return err
+		}
+		folders := tx.Model(&model.ChatFolder{}).Select("id").Where("user_id = ?", user.ID)
+		if err := tx.Where("folder_id IN (?)", folders).Delete(&model.ChatFolderChat{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&model.ChatFolder{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(user).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	user.Username = username
+	// the account is deleted once the transaction commits, a stale cached user
+	// would still accept its old tokens, so the cache is cleared until it works
+	if err := invalidateUserCache(s.rdb, username); err != nil {
+		logrus.Errorf("failed to clear cache of deleted account %d: %v", user.ID, err)
+		s.staleCacheMu.Lock()
+		s.staleCaches = append(s.staleCaches, username)
+		s.staleCacheMu.Unlock()
+	}
+	return nil
+}
+
+// clearStaleCaches retries clearing cached users of deleted accounts
+func (s *AccountService) clearStaleCaches() {
+	s.staleCacheMu.Lock()
+	defer s.staleCacheMu.Unlock()
+
+	stale := s.staleCaches[:0]
+	for _, username := range s.staleCaches {
+		if err := invalidateUserCache(s.rdb, username); err != nil {
+			logrus.Errorf("failed to clear cache of deleted account %s: %v", username, err)
+			stale = append(stale, username)
+		}
+	}
+	s.staleCaches = stale
+}
+
+// RequestExport creates a data export, RunExportBuilder builds it in the background.
+// A user can't request another export until the previous one is built.
+func (s *AccountService) RequestExport(username string) (model.DataExport, error) {
+	var user model.User
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return model.DataExport{}, err
+	}
+
+	inProgress, err := hasExportInProgress(s.db, user.ID)
+	if err != nil {
+		return model.DataExport{}, err
+	}
+	if inProgress {
+		return model.DataExport{}, ErrExportInProgress
+	}
+
+	export := model.DataExport{
+		UserID: user.ID,
+		Status: model.DataExportPending,
+	}
+	if err := s.db.Create(&export).Error; err != nil {
+		// a concurrent request is refused by the unique index
+		if inProgress, _ := hasExportInProgress(s.db, user.ID); inProgress {
+			return model.DataExport{}, ErrExportInProgress
+		}
+		return model.DataExport{}, err
+	}
+	return export, nil
+}
+
+func (s *AccountService) GetExport(username string, id uint) (model.DataExport, error) {
+	var export model.DataExport
+	resoult := s.db.
+		Joins("JOIN users ON users.id = data_exports.user_id").
+		Where("data_exports.id = ? AND users.username = ?", id, username).
+		First(&export)
+	if resoult.Error != nil {
+		return model.DataExport{}, resoult.Error
+	}
+	return export, nil
+}
+
+// BuildPendingExports builds a batch of pending exports one at a time, oldest
+// first, and returns how many were built. Failed exports are marked failed and
+// don't stop the rest.
+func (s *AccountService) BuildPendingExports() (int, error) {
+	ids := make([]uint, 0)
+	resoult := s.db.Model(&model.DataExport{}).
+		Scopes(buildableExports(time.Now())).
+		Order("id").
+		Limit(dataExportsBatch).
+		Pluck("id", &ids)
+	if resoult.Error != nil {
+		return 0, resoult.Error
+	}
+
+	built := 0
+	for _, id := range ids {
+		if err := s.BuildExport(id); err != nil {
+			if !errors.Is(err, ErrExportNotPending) {
+				logrus.Errorf("failed to build data export %d: %v", id, err)
+			}
+			continue
+		}
+		built++
+	}
+	return built, nil
+}
+
+// RunExportBuilder builds pending exports every interval until ctx is done.
+// Exports are built one at a time, however many are requested.
+func (s *AccountService) RunExportBuilder(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.BuildPendingExports(); err != nil {
+				logrus.Errorf("failed to build data exports: %v", err)
+			}
+		}
+	}
+}
+
+// BuildExport claims the export and packages the profile, chats and messages of
+// its owner into a zip archive of json files. ErrExportNotPending is returned if
+// another replica builds it.
+func (s *AccountService) BuildExport(id uint) error {
+	leaseToken, err := generateLeaseToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	resoult := s.db.Model(&model.DataExport{}).
+		Where("id = ?", id).
+		Scopes(buildableExports(now)).
+		Updates(map[string]interface{}{
+			"status":       model.DataExportBuilding,
+			"locked_until": now.Add(dataExportLease),
+			"lease_token":  leaseToken,
+		})
+	if resoult.Error != nil {
+		return resoult.Error
+	}
+	if resoult.RowsAffected == 0 {
+		return ErrExportNotPending
+	}
+
+	var export model.DataExport
+	if err := s.db.First(&export, id).Error; err != nil {
+		return err
+	}
+
+	archive, buildErr := s.buildArchive(export.UserID)
+
+	now = time.Now()
+	updates := map[string]interface{}{
+		"status":       model.DataExportReady,
+		"archive":      archive,
+		"completed_at": now,
+	}
+	if buildErr != nil {
+		updates["status"] = model.DataExportFailed
+		updates["archive"] = nil
+		updates["error"] = buildErr.Error()
+	}
+
+	// the lease may have run out and another replica taken the export over
+	err = s.db.Model(&model.DataExport{}).
+		Where("id = ? AND status = ? AND lease_token = ?", export.ID, model.DataExportBuilding, leaseToken).
+		Updates(updates).Error
+	if err != nil {
+		return err
+	}
+	return buildErr
+}
+
+// buildableExports selects pending exports and exports whose builder lost its lease
+func buildableExports(now time.Time) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("status = ? OR (status = ? AND locked_until < ?)",
+			model.DataExportPending, model.DataExportBuilding, now)
+	}
+}
+
+// hasExportInProgress reports whether the user has an export pending or building
+func hasExportInProgress(db *gorm.DB, userID uint) (bool, error) {
+	var count int64
+	err := db.Model(&model.DataExport{}).
+		Where("user_id = ? AND status IN ?", userID, []string{model.DataExportPending, model.DataExportBuilding}).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (s *AccountService) buildArchive(userID uint) ([]byte, error) {
+	var user model.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+
+	var chats []model.Chat
+	resoult := s.db.Model(&model.Chat{}).
+		Preload("Users").
+		Joins("JOIN user_chats ON user_chats.chat_id = chats.id").
+		Where("user_chats.user_id = ?", userID).
+		Find(&chats)
+	if resoult.Error != nil {
+		return nil, resoult.Error
+	}
+	// co-members are exported as the user sees them
+	viewer, err := getViewer(s.db, user.Username)
+	if err != nil {
+		return nil, err
+	}
+	chatResponses := make([]model.ChatResponse, len(chats))
+	for i := range chats {
+		chatResponses[i] = chats[i].ToResponseFor(viewer)
+		chatResponses[i].LastMessage = nil
+	}
+
+	var messages []model.Message
+	resoult = s.db.Where("sender_id = ?", userID).Order("created_at").Find(&messages)
+	if resoult.Error != nil {
+		return nil, resoult.Error
+	}
+	messageResponses := make([]model.MessageResponse, len(messages))
+	for i := range messages {
+		messageResponses[i] = model.MessageResponse{
+			ID:        messages[i].ID,
+			Content:   messages[i].Content,
+			Chat:      model.ChatResponse{ID: messages[i].ChatID},
+			CreatedAt: messages[i].CreatedAt,
+			UpdatedAt: messages[i].UpdatedAt,
+		}
+	}
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", struct {
+			model.UserResponse
+			Email string `json:"email"`
+		}{user.ToResponse(), user.Email}},
+		{"chats.json", chatResponses},
+		{"messages.json", messageResponses},
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, file := range files {
+		w, err := zw.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
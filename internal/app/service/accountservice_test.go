@@ -0,0 +1,236 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleDeletion_AndCancel(t *testing.T) {
+	db := setupTestDB()
+	rdb, mock := redismock.NewClientMock()
+	service := NewAccountService(db, rdb)
+
+	createTestUserWithPassword(t, db, "alice", "", "secret")
+
+	_, err := service.ScheduleDeletion("alice", "wrong")
+	assert.ErrorIs(t, err, ErrWrongPassword)
+
+	mock.ExpectDel("user_alice").SetVal(1)
+	deleteAt, err := service.ScheduleDeletion("alice", "secret")
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(accountDeletionGracePeriod), deleteAt, time.Minute)
+
+	mock.ExpectDel("user_alice").SetVal(1)
+	assert.NoError(t, service.CancelDeletion("alice"))
+	assert.ErrorIs(t, service.CancelDeletion("alice"), ErrDeletionNotScheduled)
+
+	var user model.User
+	db.First(&user, "username = ?", "alice")
+	assert.Nil(t, user.DeletionScheduledAt)
+}
+
+func TestPurgeDueAccounts(t *testing.T) {
+	db := setupTestDB()
+	rdb, mock := redismock.NewClientMock()
+	service := NewAccountService(db, rdb)
+
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+	alice := model.User{Username: "alice", Email: "alice@example.com", FirstName: "Alice", DeletionScheduledAt: &past}
+	bob := model.User{Username: "bob", DeletionScheduledAt: &future}
+	db.Create(&alice)
+	db.Create(&bob)
+	chat := model.Chat{Name: "private", Users: []model.User{alice, bob}}
+	db.Create(&chat)
+	db.Create(&model.Message{Content: "hello", SenderID: alice.ID, ChatID: chat.ID})
+	db.Create(&model.ScheduledMessage{SenderID: alice.ID, ChatID: chat.ID, Content: "later", SendAt: future, Status: model.ScheduledPending})
+	db.Create(&model.DataExport{UserID: alice.ID, Status: model.DataExportReady, Archive: []byte("archive")})
+	db.Create(&model.PasswordResetToken{UserID: alice.ID, TokenHash: "hash", ExpiresAt: future})
+
+	mock.ExpectDel("user_alice").SetVal(1)
+	deleted, err := service.PurgeDueAccounts()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice"}, deleted)
+
+	var anonymized model.User
+	db.Unscoped().First(&anonymized, alice.ID)
+	assert.Equal(t, fmt.Sprintf("deleted_%d", alice.ID), anonymized.Username)
+	assert.Empty(t, anonymized.Email)
+	assert.Empty(t, anonymized.PasswordHash)
+	assert.Equal(t, uint(1), anonymized.TokenVersion)
+	assert.True(t, anonymized.DeletedAt.Valid)
+
+	var pending int64
+	db.Model(&model.ScheduledMessage{}).Where("sender_id = ?", alice.ID).Count(&pending)
+	assert.Zero(t, pending)
+	// personal data doesn't stay behind in soft-deleted rows
+	var exports, tokens int64
+	db.Unscoped().Model(&model.DataExport{}).Where("user_id = ?", alice.ID).Count(&exports)
+	assert.Zero(t, exports)
+	db.Unscoped().Model(&model.PasswordResetToken{}).Where("user_id = ?", alice.ID).Count(&tokens)
+	assert.Zero(t, tokens)
+
+	var members model.Chat
+	db.Preload("Users").First(&members, chat.ID)
+	assert.Len(t, members.Users, 1)
+	assert.Equal(t, "bob", members.Users[0].Username)
+
+	var message model.Message
+	db.Preload("Sender", withDeletedUsers).First(&message, "chat_id = ?", chat.ID)
+	assert.Equal(t, anonymized.Username, message.Sender.Username)
+}
+
+func TestPurgeDueAccounts_CacheFailure(t *testing.T) {
+	db := setupTestDB()
+	rdb, mock := redismock.NewClientMock()
+	service := NewAccountService(db, rdb)
+
+	past := time.Now().Add(-time.Minute)
+	alice := model.User{Username: "alice", DeletionScheduledAt: &past}
+	bob := model.User{Username: "bob", DeletionScheduledAt: &past}
+	db.Create(&alice)
+	db.Create(&bob)
+
+	// the accounts are deleted even if their cache can't be cleared
+	mock.ExpectDel("user_alice").SetErr(errors.New("redis is down"))
+	mock.ExpectDel("user_bob").SetVal(1)
+	deleted, err := service.PurgeDueAccounts()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, deleted)
+
+	// and the cache is cleared on the next purge
+	mock.ExpectDel("user_alice").SetErr(errors.New("redis is down"))
+	deleted, err = service.PurgeDueAccounts()
+	assert.NoError(t, err)
+	assert.Empty(t, deleted)
+	mock.ExpectDel("user_alice").SetVal(1)
+	_, err = service.PurgeDueAccounts()
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Empty(t, service.staleCaches)
+}
+
+func TestBuildPendingExports(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewAccountService(db, rdb)
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	carol := model.User{Username: "carol"}
+	db.Create(&alice)
+	db.Create(&bob)
+	db.Create(&carol)
+
+	first, err := service.RequestExport("alice")
+	assert.NoError(t, err)
+	assert.Equal(t, model.DataExportPending, first.Status)
+	_, err = service.RequestExport("alice")
+	assert.ErrorIs(t, err, ErrExportInProgress)
+	second, err := service.RequestExport("bob")
+	assert.NoError(t, err)
+	// the export of a missing user fails without stopping the rest
+	orphan := model.DataExport{UserID: carol.ID + 1, Status: model.DataExportPending}
+	db.Create(&orphan)
+	// another replica builds this one
+	lockedUntil := time.Now().Add(time.Minute)
+	claimed := model.DataExport{UserID: carol.ID, Status: model.DataExportBuilding, LockedUntil: &lockedUntil, LeaseToken: "other"}
+	db.Create(&claimed)
+
+	built, err := service.BuildPendingExports()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, built)
+
+	for username, id := range map[string]uint{"alice": first.ID, "bob": second.ID} {
+		export, err := service.GetExport(username, id)
+		assert.NoError(t, err)
+		assert.Equal(t, model.DataExportReady, export.Status)
+	}
+	db.First(&orphan, orphan.ID)
+	assert.Equal(t, model.DataExportFailed, orphan.Status)
+	db.First(&claimed, claimed.ID)
+	assert.Equal(t, model.DataExportBuilding, claimed.Status)
+	assert.ErrorIs(t, service.BuildExport(claimed.ID), ErrExportNotPending)
+
+	// a finished export lets the user request a new one
+	_, err = service.RequestExport("alice")
+	assert.NoError(t, err)
+
+	// the export of a replica that lost its lease is taken over
+	db.Model(&claimed).Update("locked_until", time.Now().Add(-time.Minute))
+	built, err = service.BuildPendingExports()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, built)
+	db.First(&claimed, claimed.ID)
+	assert.Equal(t, model.DataExportReady, claimed.Status)
+}
+
+func TestBuildExport(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewAccountService(db, rdb)
+
+	seen := time.Now()
+	alice := model.User{Username: "alice", Email: "alice@example.com"}
+	bob := model.User{Username: "bob", LastSeenAt: &seen, Avatar: []byte("avatar"), AvatarThumb: []byte("thumb"),
+		Privacy: model.PrivacySettings{LastSeen: model.PrivacyNobody, ProfilePhoto: model.PrivacyContacts, GroupInvites: model.PrivacyEverybody}}
+	db.Create(&alice)
+	db.Create(&bob)
+	chat := model.Chat{Name: "private", Users: []model.User{alice, bob}}
+	db.Create(&chat)
+	db.Create(&model.Message{Content: "from alice", SenderID: alice.ID, ChatID: chat.ID})
+	db.Create(&model.Message{Content: "from bob", SenderID: bob.ID, ChatID: chat.ID})
+
+	export := model.DataExport{UserID: alice.ID, Status: model.DataExportPending}
+	db.Create(&export)
+
+	assert.NoError(t, service.BuildExport(export.ID))
+
+	got, err := service.GetExport("alice", export.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.DataExportReady, got.Status)
+	assert.NotNil(t, got.CompletedAt)
+
+	_, err = service.GetExport("bob", export.ID)
+	assert.Error(t, err)
+
+	zr, err := zip.NewReader(bytes.NewReader(got.Archive), int64(len(got.Archive)))
+	assert.NoError(t, err)
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		assert.NoError(t, err)
+		files[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+	assert.Contains(t, files, "profile.json")
+	assert.Contains(t, files, "chats.json")
+	assert.Contains(t, files, "messages.json")
+
+	var messages []model.MessageResponse
+	assert.NoError(t, json.Unmarshal(files["messages.json"], &messages))
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "from alice", messages[0].Content)
+	assert.Contains(t, string(files["profile.json"]), "alice@example.com")
+
+	// privacy settings of bob apply to the export of alice
+	var chats []model.ChatResponse
+	assert.NoError(t, json.Unmarshal(files["chats.json"], &chats))
+	assert.Len(t, chats, 1)
+	for _, member := range chats[0].Users {
+		if member.Username == "bob" {
+			assert.Nil(t, member.LastSeenAt)
+			assert.Empty(t, member.AvatarThumb)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUserSuspended   = errors.New("account is suspended")
+	ErrModerateSelf    = errors.New("admins can't moderate themselves")
+	ErrNotSuspended    = errors.New("user is not suspended")
+	ErrMessageNotFound = errors.New("message not found")
+)
+
+type AdminService struct {
+	db  *gorm.DB
+	rdb *redis.Client
+	// the service runs in a transaction of the caller, which clears the cache
+	// of moderated users once it commits
+	inTransaction bool
+}
+
+func NewAdminService(db *gorm.DB, rdb *redis.Client) *AdminService {
+	return &AdminService{
+		db:  db,
+		rdb: rdb,
+	}
+}
+
+// PromoteAdmins gives the admin role to existing users, used to appoint the
+// first admins from the config
+func (s *AdminService) PromoteAdmins(usernames []string) error {
+	if len(usernames) == 0 {
+		return nil
+	}
+	err := s.db.Model(&model.User{}).
+		Where("username IN ?", usernames).
+		Update("role", model.RoleAdmin).
+		Error
+	if err != nil {
+		return err
+	}
+	return invalidateUserCache(s.rdb, usernames...)
+}
+
+func (s *AdminService) ListUsers(adminUsername, query string, offset, limit int) ([]model.AdminUserResponse, error) {
+	admin, err := getAdmin(s.db, adminUsername)
+	if err != nil {
+		return nil, err
+	}
+
+	pattern := likeContains(strings.ToLower(query))
+	users := make([]model.User, 0)
+	resoult := s.db.Model(&model.User{}).
+		Where(`username LIKE ? ESCAPE '\' OR email LIKE ? ESCAPE '\'`, pattern, pattern).
+		Order("username").
+		Offset(offset).
+		Limit(limit).
+		Find(&users)
+	if resoult.Error != nil {
+		return nil, resoult.Error
+	}
+
+	if err := writeAuditLog(s.db, admin, model.AuditListUsers, "", 0, fmt.Sprintf("query=%q", query)); err != nil {
+		return nil, err
+	}
+
+	usersResp := make([]model.AdminUserResponse, len(users))
+	for i := range users {
+		usersResp[i] = users[i].ToAdminResponse()
+	}
+	return usersResp, nil
+}
+
+// SuspendUser blocks every request of the user until they are unsuspended
+func (s *AdminService) SuspendUser(adminUsername, username, reason string) error {
+	return s.moderateUser(adminUsername, username, model.AuditSuspendUser, reason, func(tx *gorm.DB, user model.User) error {
+		now := time.Now()
+		return tx.Model(&user).Updates(map[string]interface{}{
+			"suspended_at":      &now,
+			"suspension_reason": reason,
+		}).Error
+	})
+}
+
+func (s *AdminService) UnsuspendUser(adminUsername, username string) error {
+	return s.moderateUser(adminUsername, username, model.AuditUnsuspendUser, "", func(tx *gorm.DB, user model.User) error {
+		if user.SuspendedAt == nil {
+			return ErrNotSuspended
+		}
+		return tx.Model(&user).Updates(map[string]interface{}{
+			"suspended_at":      nil,
+			"suspension_reason": "",
+		}).Error
+	})
+}
+
+// ForceLogout revokes every token of the user
+func (s *AdminService) ForceLogout(adminUsername, username string) error {
+	return s.moderateUser(adminUsername, username, model.AuditForceLogout, "", func(tx *gorm.DB, user model.User) error {
+		return tx.Model(&user).Update("token_version", gorm.Expr("token_version + 1")).Error
+	})
+}
+
+func (s *AdminService) SetRole(adminUsername, username, role string) error {
+	return s.moderateUser(adminUsername, username, model.AuditSetRole, role, func(tx *gorm.DB, user model.User) error {
+		return tx.Model(&user).Update("role", role).Error
+	})
+}
+
+// DeleteMessage deletes a message of any chat and returns it with the chat members
+func (s *AdminService) DeleteMessage(adminUsername string, id uint, reason string) (model.Message, error) {
+	admin, err := getAdmin(s.db, adminUsername)
+	if err != nil {
+		return model.Message{}, err
+	}
+
+	var message model.Message
+	if err := s.db.Preload("Chat.Users").First(&message, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return model.Message{}, ErrMessageNotFound
+		}
+		return model.Message{}, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&model.Message{}, message.ID).Error; err != nil {
+			return err
+		}
+		if err := refreshLastMessages(tx, []uint{message.ChatID}); err != nil {
+			return err
+		}
+		return writeAuditLog(tx, admin, model.AuditDeleteMessage, "message", message.ID, reason)
+	})
+	if err != nil {
+		return model.Message{}, err
+	}
+	return message, nil
+}
+
+// GetChat returns metadata of any chat without reading its messages
+func (s *AdminService) GetChat(adminUsername string, id uint) (model.AdminChatResponse, error) {
+	admin, err := getAdmin(s.db, adminUsername)
+	if err != nil {
+		return model.AdminChatResponse{}, err
+	}
+
+	var chat model.Chat
+	if err := s.db.Preload("Users").First(&chat, id).Error; err != nil {
+		return model.AdminChatResponse{}, err
+	}
+
+	chatResp := model.AdminChatResponse{
+		ID:        chat.ID,
+		Name:      chat.Name,
+		IsGroup:   chat.IsGroup,
+		Users:     make([]model.AdminUserResponse, len(chat.Users)),
+		CreatedAt: chat.CreatedAt,
+	}
+	for i := range chat.Users {
+		chatResp.Users[i] = chat.Users[i].ToAdminResponse()
+	}
+
+	if err := s.db.Model(&model.Message{}).Where("chat_id = ?", chat.ID).Count(&chatResp.MessageCount).Error; err != nil {
+		return model.AdminChatResponse{}, err
+	}
+	if chatResp.MessageCount > 0 {
+		var last model.Message
+		if err := s.db.Where("chat_id = ?", chat.ID).Order("created_at DESC").First(&last).Error; err != nil {
+			return model.AdminChatResponse{}, err
+		}
+		chatResp.LastActivityAt = &last.CreatedAt
+	}
+
+	if err := writeAuditLog(s.db, admin, model.AuditViewChat, "chat", chat.ID, ""); err != nil {
+		return model.AdminChatResponse{}, err
+	}
+	return chatResp, nil
+}
+
+func (s *AdminService) GetAuditLog(offset, limit int) ([]model.AdminAuditLogResponse, error) {
+	logs := make([]model.AdminAuditLog, 0)
+	resoult := s.db.
+		Preload("Admin", withDeletedUsers).
+		Order("created_at DESC").
+		Order("id DESC").
+		Offset(offset).
+		Limit(limit).
+		Find(&logs)
+	if resoult.Error != nil {
+		return nil, resoult.Error
+	}
+
+	logsResp := make([]model.AdminAuditLogResponse, len(logs))
+	for i := range logs {
+		logsResp[i] = logs[i].ToResponse()
+	}
+	return logsResp, nil
+}
+
+// moderateUser applies the action to the user and writes it to the audit log
+// in one transaction. Admins can't apply actions to themselves.
+func (s *AdminService) moderateUser(adminUsername, username, action, details string, apply func(tx *gorm.DB, user model.User) error) error {
+	if adminUsername == username {
+		return ErrModerateSelf
+	}
+
+	admin, err := getAdmin(s.db, adminUsername)
+	if err != nil {
+		return err
+	}
+
+	var user model.User
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := apply(tx, user); err != nil {
+			return err
+		}
+		return writeAuditLog(tx, admin, action, "user", user.ID, details)
+	})
+	if err != nil {
+		return err
+	}
+	// a request before the commit would cache the user as they were
+	if s.inTransaction {
+		return nil
+	}
+	return invalidateUserCache(s.rdb, username)
+}
+
+// getAdmin loads the user only if they are an admin
+func getAdmin(db *gorm.DB, username string) (model.User, error) {
+	var admin model.User
+	err := db.Where(model.User{Username: username, Role: model.RoleAdmin}).First(&admin).Error
+	return admin, err
+}
+
+func writeAuditLog(tx *gorm.DB, admin model.User, action, targetType string, targetID uint, details string) error {
+	return tx.Create(&model.AdminAuditLog{
+		AdminID:    admin.ID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Details:    details,
+	}).Error
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuspendUser(t *testing.T) {
+	db := setupTestDB()
+	rdb, mock := redismock.NewClientMock()
+	service := NewAdminService(db, rdb)
+
+	db.Create(&model.User{Username: "root", Role: model.RoleAdmin})
+	db.Create(&model.User{Username: "alice", Role: model.RoleUser})
+	db.Create(&model.User{Username: "bob", Role: model.RoleUser})
+
+	assert.ErrorIs(t, service.SuspendUser("root", "root", "spam"), ErrModerateSelf)
+	assert.Error(t, service.SuspendUser("alice", "bob", "spam"))
+
+	mock.ExpectDel("user_bob").SetVal(1)
+	assert.NoError(t, service.SuspendUser("root", "bob", "spam"))
+
+	var bob model.User
+	db.First(&bob, "username = ?", "bob")
+	assert.NotNil(t, bob.SuspendedAt)
+	assert.Equal(t, "spam", bob.SuspensionReason)
+
+	mock.ExpectDel("user_bob").SetVal(1)
+	assert.NoError(t, service.UnsuspendUser("root", "bob"))
+	assert.ErrorIs(t, service.UnsuspendUser("root", "bob"), ErrNotSuspended)
+
+	logs, err := service.GetAuditLog(0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, logs, 2)
+	assert.Equal(t, model.AuditUnsuspendUser, logs[0].Action)
+	assert.Equal(t, model.AuditSuspendUser, logs[1].Action)
+	assert.Equal(t, "root", logs[1].Admin)
+	assert.Equal(t, bob.ID, logs[1].TargetID)
+}
+
+func TestListUsers(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewAdminService(db, rdb)
+
+	db.Create(&model.User{Username: "root", Role: model.RoleAdmin})
+	db.Create(&model.User{Username: "alice", Email: "alice_1@example.com"})
+	db.Create(&model.User{Username: "bob", Email: "bob1@example.com"})
+
+	users, err := service.ListUsers("root", "EXAMPLE", 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, users, 2)
+
+	// wildcards are searched for as they are
+	users, err = service.ListUsers("root", "_1", 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+	assert.Equal(t, "alice", users[0].Username)
+}
+
+func TestForceLogoutAndSetRole(t *testing.T) {
+	db := setupTestDB()
+	rdb, mock := redismock.NewClientMock()
+	service := NewAdminService(db, rdb)
+
+	db.Create(&model.User{Username: "root", Role: model.RoleAdmin})
+	db.Create(&model.User{Username: "alice", Role: model.RoleUser})
+
+	mock.ExpectDel("user_alice").SetVal(1)
+	assert.NoError(t, service.ForceLogout("root", "alice"))
+	mock.ExpectDel("user_alice").SetVal(1)
+	assert.NoError(t, service.SetRole("root", "alice", model.RoleAdmin))
+
+	var alice model.User
+	db.First(&alice, "username = ?", "alice")
+	assert.Equal(t, uint(1), alice.TokenVersion)
+	assert.Equal(t, model.RoleAdmin, alice.Role)
+}
+
+func TestAdminDeleteMessageAndGetChat(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewAdminService(db, rdb)
+
+	root := model.User{Username: "root", Role: model.RoleAdmin}
+	alice := model.User{Username: "alice", Email: "alice@example.com"}
+	bob := model.User{Username: "bob"}
+	db.Create(&root)
+	db.Create(&alice)
+	db.Create(&bob)
+	chat := model.Chat{Name: "private", Users: []model.User{alice, bob}}
+	db.Create(&chat)
+	message := model.Message{Content: "spam", SenderID: alice.ID, ChatID: chat.ID}
+	db.Create(&message)
+	db.Create(&model.Message{Content: "hello", SenderID: bob.ID, ChatID: chat.ID})
+
+	deleted, err := service.DeleteMessage("root", message.ID, "spam")
+	assert.NoError(t, err)
+	assert.Len(t, deleted.Chat.Users, 2)
+	_, err = service.DeleteMessage("root", message.ID, "spam")
+	assert.ErrorIs(t, err, ErrMessageNotFound)
+
+	chatResp, err := service.GetChat("root", chat.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), chatResp.MessageCount)
+	assert.NotNil(t, chatResp.LastActivityAt)
+	assert.Equal(t, "alice@example.com", chatResp.Users[0].Email)
+
+	logs, err := service.GetAuditLog(0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, logs, 2)
+	assert.Equal(t, model.AuditViewChat, logs[0].Action)
+	assert.Equal(t, model.AuditDeleteMessage, logs[1].Action)
+}
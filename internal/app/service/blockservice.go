@@ -0,0 +1,121 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrUserBlocked = errors.New("user has blocked you")
+	ErrBlockSelf   = errors.New("you can't block yourself")
+	ErrNotBlocked  = errors.New("user is not blocked")
+)
+
+type BlockService struct {
+	db  *gorm.DB
+	rdb *redis.Client
+}
+
+func NewBlockService(db *gorm.DB, rdb *redis.Client) *BlockService {
+	return &BlockService{
+		db:  db,
+		rdb: rdb,
+	}
+}
+
+func (s *BlockService) BlockUser(username, blockedUsername string) error {
+	if username == blockedUsername {
+		return ErrBlockSelf
+	}
+
+	var blocker, blocked model.User
+	if err := s.db.Where(model.User{Username: username}).First(&blocker).Error; err != nil {
+		return err
+	}
+	if err := s.db.Where(model.User{Username: blockedUsername}).First(&blocked).Error; err != nil {
+		return err
+	}
+
+	block := model.UserBlock{BlockerID: blocker.ID, BlockedID: blocked.ID}
+	return s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&block).Error
+}
+
+func (s *BlockService) UnblockUser(username, blockedUsername string) error {
+	resoult := s.db.
+		Where("blocker_id = (?) AND blocked_id = (?)",
+			s.db.Model(&model.User{}).Select("id").Where("username = ?", username),
+			s.db.Model(&model.User{}).Select("id").Where("username = ?", blockedUsername)).
+		Delete(&model.UserBlock{})
+	if resoult.Error != nil {
+		return resoult.Error
+	}
+	if resoult.RowsAffected == 0 {
+		return ErrNotBlocked
+	}
+	return nil
+}
+
+func (s *BlockService) GetBlockedUsers_ToResponse(username string) ([]model.UserResponse, error) {
+	viewer, err := getViewer(s.db, username)
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []model.UserBlock
+	resoult := s.db.
+		Preload("Blocked").
+		Joins("JOIN users ON users.id = user_blocks.blocker_id").
+		Where("users.username = ?", username).
+		Order("user_blocks.created_at DESC").
+		Find(&blocks)
+	if resoult.Error != nil {
+		return nil, resoult.Error
+	}
+
+	usersResp := make([]model.UserResponse, 0, len(blocks))
+	for _, block := range blocks {
+		usersResp = append(usersResp, block.Blocked.ToResponseFor(viewer))
+	}
+	return usersResp, nil
+}
+
+// GetBlockedUsernames returns usernames of everyone the user has blocked
+func (s *BlockService) GetBlockedUsernames(username string) ([]string, error) {
+	blocked := make([]string, 0)
+	resoult := s.db.Model(&model.UserBlock{}).
+		Joins("JOIN users blockers ON blockers.id = user_blocks.blocker_id").
+		Joins("JOIN users blocked ON blocked.id = user_blocks.blocked_id").
+		Where("blockers.username = ?", username).
+		Pluck("blocked.username", &blocked)
+	if resoult.Error != nil {
+		return nil, resoult.Error
+	}
+	return blocked, nil
+}
+
+// IsBlocked reports whether blocker has blocked blocked
+func (s *BlockService) IsBlocked(blocker, blocked string) bool {
+	var count int64
+	s.db.Model(&model.UserBlock{}).
+		Joins("JOIN users blockers ON blockers.id = user_blocks.blocker_id").
+		Joins("JOIN users blocked ON blocked.id = user_blocks.blocked_id").
+		Where("blockers.username = ? AND blocked.username = ?", blocker, blocked).
+		Count(&count)
+	return count > 0
+}
+
+// isBlockedByAny reports whether any of blockerIDs has blocked blockedID
+func isBlockedByAny(db *gorm.DB, blockerIDs []uint, blockedID uint) (bool, error) {
+	if len(blockerIDs) == 0 {
+		return false, nil
+	}
+	var count int64
+	err := db.Model(&model.UserBlock{}).
+		Where("blocker_id IN ? AND blocked_id = ?", blockerIDs, blockedID).
+		Count(&count).Error
+	return count > 0, err
+}
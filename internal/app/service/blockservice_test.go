@@ -0,0 +1,82 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockUser(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewBlockService(db, rdb)
+
+	db.Create(&model.User{Username: "alice"})
+	db.Create(&model.User{Username: "bob"})
+
+	assert.ErrorIs(t, service.BlockUser("alice", "alice"), ErrBlockSelf)
+	assert.Error(t, service.BlockUser("alice", "ghost"))
+
+	assert.NoError(t, service.BlockUser("alice", "bob"))
+	assert.NoError(t, service.BlockUser("alice", "bob"))
+	assert.True(t, service.IsBlocked("alice", "bob"))
+	assert.False(t, service.IsBlocked("bob", "alice"))
+
+	blocked, err := service.GetBlockedUsers_ToResponse("alice")
+	assert.NoError(t, err)
+	assert.Len(t, blocked, 1)
+	assert.Equal(t, "bob", blocked[0].Username)
+
+	usernames, err := service.GetBlockedUsernames("alice")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bob"}, usernames)
+
+	assert.NoError(t, service.UnblockUser("alice", "bob"))
+	assert.ErrorIs(t, service.UnblockUser("alice", "bob"), ErrNotBlocked)
+	assert.False(t, service.IsBlocked("alice", "bob"))
+}
+
+func TestCreateChat_Blocked(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	chatService := NewChatService(db, rdb)
+	blockService := NewBlockService(db, rdb)
+
+	db.Create(&model.User{Username: "alice"})
+	db.Create(&model.User{Username: "bob"})
+	assert.NoError(t, blockService.BlockUser("alice", "bob"))
+
+	chat := &model.Chat{Users: []model.User{{Username: "bob"}, {Username: "alice"}}}
+	assert.ErrorIs(t, chatService.CreateChat(chat), ErrUserBlocked)
+
+	chat = &model.Chat{Users: []model.User{{Username: "alice"}, {Username: "bob"}}}
+	assert.NoError(t, chatService.CreateChat(chat))
+}
+
+func TestCreateMessage_Blocked(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	messageService := NewMessageService(db, rdb)
+	blockService := NewBlockService(db, rdb)
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	db.Create(&alice)
+	db.Create(&bob)
+	private := model.Chat{Name: "private", Users: []model.User{alice, bob}}
+	group := model.Chat{Name: "group", IsGroup: true, Users: []model.User{alice, bob}}
+	db.Create(&private)
+	db.Create(&group)
+	assert.NoError(t, blockService.BlockUser("alice", "bob"))
+
+	msg := &model.Message{Content: "hi", Sender: model.User{Username: "bob"}, ChatID: private.ID}
+	assert.ErrorIs(t, messageService.CreateMessage(msg), ErrUserBlocked)
+
+	msg = &model.Message{Content: "hi", Sender: model.User{Username: "alice"}, ChatID: private.ID}
+	assert.NoError(t, messageService.CreateMessage(msg))
+
+	msg = &model.Message{Content: "hi all", Sender: model.User{Username: "bob"}, ChatID: group.ID}
+	assert.NoError(t, messageService.CreateMessage(msg))
+}
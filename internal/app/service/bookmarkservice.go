@@ -0,0 +1,252 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrBookmarkNotFound  = errors.New("bookmark not found")
+	ErrAlreadyBookmarked = errors.New("message is already bookmarked")
+	ErrBookmarkExpiring  = errors.New("disappearing messages can't be bookmarked")
+)
+
+type BookmarkService struct {
+	db  *gorm.DB
+	rdb *redis.Client
+}
+
+func NewBookmarkService(db *gorm.DB, rdb *redis.Client) *BookmarkService {
+	return &BookmarkService{
+		db:  db,
+		rdb: rdb,
+	}
+}
+
+// CreateBookmark copies the message to the Saved messages chat of the user and
+// links the copy to the original. The user has to be a member of the chat of the
+// message. Messages of the Saved messages chat are bookmarked without a copy,
+// disappearing messages can't be bookmarked.
+func (s *BookmarkService) CreateBookmark(username string, createBookmarkDto model.CreateBookmarkDto) (model.Bookmark, error) {
+	var user model.User
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return model.Bookmark{}, err
+	}
+
+	var original model.Message
+	resoult := s.db.Scopes(preloadEntities, preloadPreview, notExpired).First(&original, createBookmarkDto.MessageID)
+	if resoult.Error != nil {
+		if errors.Is(resoult.Error, gorm.ErrRecordNotFound) {
+			return model.Bookmark{}, ErrMessageNotFound
+		}
+		return model.Bookmark{}, resoult.Error
+	}
+	// a permanent copy would outlive the message
+	if original.ExpiresAt != nil {
+		return model.Bookmark{}, ErrBookmarkExpiring
+	}
+	var count int64
+	s.db.Model(&model.UserChat{}).
+		Where("chat_id = ? AND user_id = ?", original.ChatID, user.ID).
+		Count(&count)
+	if count == 0 {
+		return model.Bookmark{}, ErrNotChatMember
+	}
+	s.db.Model(&model.Bookmark{}).
+		Where("user_id = ? AND message_id = ?", user.ID, original.ID).
+		Count(&count)
+	if count > 0 {
+		return model.Bookmark{}, ErrAlreadyBookmarked
+	}
+
+	saved, err := getSavedChat(s.db, user)
+	if err != nil {
+		return model.Bookmark{}, err
+	}
+
+	bookmark := model.Bookmark{
+		UserID:    user.ID,
+		MessageID: original.ID,
+		ChatID:    original.ChatID,
+		AuthorID:  original.SenderID,
+		CopyID:    original.ID,
+		Tags:      bookmarkTags(createBookmarkDto.Tags),
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if original.ChatID != saved.ID {
+			savedCopy := model.Message{
+				Content:  original.Content,
+				Kind:     model.MessageText,
+				SenderID: user.ID,
+				ChatID:   saved.ID,
+				Entities: make([]model.MessageEntity, len(original.Entities)),
+			}
+			for i, entity := range original.Entities {
+				savedCopy.Entities[i] = model.MessageEntity{
+					Type:   entity.Type,
+					Offset: entity.Offset,
+					Length: entity.Length,
+					URL:    entity.URL,
+				}
+			}
+			if original.Preview != nil {
+				preview := *original.Preview
+				preview.ID = 0
+				preview.MessageID = 0
+				savedCopy.Preview = &preview
+			}
+			if err := tx.Omit("Sender", "Chat").Create(&savedCopy).Error; err != nil {
+				return err
+			}
+			if err := touchChat(tx, savedCopy); err != nil {
+				return err
+			}
+			bookmark.CopyID = savedCopy.ID
+		}
+		return tx.Omit("Author", "Copy").Create(&bookmark).Error
+	})
+	if err != nil {
+		return model.Bookmark{}, err
+	}
+	return s.getBookmark(user.ID, bookmark.ID)
+}
+
+// GetBookmarks_ToResponse returns a page of bookmarks of the user, the latest first.
+// A non-empty query searches the content, a non-empty tag keeps bookmarks with the tag.
+func (s *BookmarkService) GetBookmarks_ToResponse(username, query, tag string, offset, limit int) ([]model.BookmarkResponse, error) {
+	viewer, err := getViewer(s.db, username)
+	if err != nil {
+		return nil, err
+	}
+
+	db := s.db.
+		Scopes(preloadBookmark).
+		Joins("JOIN messages ON messages.id = bookmarks.copy_id AND messages.deleted_at IS NULL").
+		Where("bookmarks.user_id = ?", viewer.ID)
+	if query != "" {
+		db = db.Where(`LOWER(messages.content) LIKE ? ESCAPE '\'`, likeContains(strings.ToLower(query)))
+	}
+	if tag != "" {
+		db = db.Where("EXISTS (SELECT 1 FROM bookmark_tags WHERE bookmark_tags.bookmark_id = bookmarks.id AND bookmark_tags.tag = ?)",
+			model.NormalizeTag(tag))
+	}
+
+	bookmarks := make([]model.Bookmark, 0)
+	resoult := db.Order("bookmarks.id DESC").Offset(offset).Limit(limit).Find(&bookmarks)
+	if resoult.Error != nil {
+		return nil, resoult.Error
+	}
+
+	bookmarkResponses := make([]model.BookmarkResponse, len(bookmarks))
+	for i := range bookmarks {
+		bookmarkResponses[i] = bookmarks[i].ToResponseFor(viewer)
+	}
+	return bookmarkResponses, nil
+}
+
+// SetBookmarkTags replaces tags of the bookmark
+func (s *BookmarkService) SetBookmarkTags(username string, id uint, tags []string) (model.Bookmark, error) {
+	var user model.User
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return model.Bookmark{}, err
+	}
+	bookmark, err := s.getBookmark(user.ID, id)
+	if err != nil {
+		return model.Bookmark{}, err
+	}
+
+	newTags := bookmarkTags(tags)
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("bookmark_id = ?", bookmark.ID).Delete(&model.BookmarkTag{}).Error; err != nil {
+			return err
+		}
+		if len(newTags) == 0 {
+			return nil
+		}
+		for i := range newTags {
+			newTags[i].BookmarkID = bookmark.ID
+		}
+		return tx.Create(&newTags).Error
+	})
+	if err != nil {
+		return model.Bookmark{}, err
+	}
+	return s.getBookmark(user.ID, id)
+}
+
+// DeleteBookmark removes the bookmark with its copy, the original stays
+func (s *BookmarkService) DeleteBookmark(username string, id uint) error {
+	var user model.User
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return err
+	}
+	bookmark, err := s.getBookmark(user.ID, id)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("bookmark_id = ?", bookmark.ID).Delete(&model.BookmarkTag{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&bookmark).Error; err != nil {
+			return err
+		}
+		if bookmark.CopyID == bookmark.MessageID {
+			return nil
+		}
+		if err := tx.Delete(&model.Message{}, bookmark.CopyID).Error; err != nil {
+			return err
+		}
+		return refreshLastMessages(tx, []uint{bookmark.Copy.ChatID})
+	})
+}
+
+func (s *BookmarkService) getBookmark(userID, id uint) (model.Bookmark, error) {
+	var bookmark model.Bookmark
+	resoult := s.db.Scopes(preloadBookmark).Where("user_id = ?", userID).First(&bookmark, id)
+	if resoult.Error != nil {
+		if errors.Is(resoult.Error, gorm.ErrRecordNotFound) {
+			return model.Bookmark{}, ErrBookmarkNotFound
+		}
+		return model.Bookmark{}, resoult.Error
+	}
+	return bookmark, nil
+}
+
+// bookmarkTags normalizes the tags, dropping empty and repeated ones
+func bookmarkTags(tags []string) []model.BookmarkTag {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag = model.NormalizeTag(tag); tag != "" {
+			normalized = append(normalized, tag)
+		}
+	}
+	slices.Sort(normalized)
+	normalized = slices.Compact(normalized)
+
+	rows := make([]model.BookmarkTag, len(normalized))
+	for i, tag := range normalized {
+		rows[i] = model.BookmarkTag{Tag: tag}
+	}
+	return rows
+}
+
+// preloadBookmark loads the copy of bookmarked messages with their authors and tags
+func preloadBookmark(db *gorm.DB) *gorm.DB {
+	return db.
+		Preload("Author", withDeletedUsers).
+		Preload("Tags", func(db *gorm.DB) *gorm.DB {
+			return db.Order("bookmark_tags.tag")
+		}).
+		Preload("Copy.Sender", withDeletedUsers).
+		Preload("Copy.Entities", func(db *gorm.DB) *gorm.DB {
+			return db.Order("message_entities.id")
+		}).
+		Preload("Copy.Preview")
+}
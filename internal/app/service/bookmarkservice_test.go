@@ -0,0 +1,133 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBookmarks(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewBookmarkService(db, rdb)
+	chatService := NewChatService(db, rdb)
+	messageService := NewMessageService(db, rdb)
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	carol := model.User{Username: "carol"}
+	db.Create(&alice)
+	db.Create(&bob)
+	db.Create(&carol)
+	chat := model.Chat{Name: "private", Users: []model.User{alice, bob}}
+	db.Create(&chat)
+
+	original := model.Message{Content: "**Deploy** at noon", SenderID: bob.ID, ChatID: chat.ID}
+	assert.NoError(t, messageService.CreateMessage(&original))
+
+	_, err := service.CreateBookmark("carol", model.CreateBookmarkDto{MessageID: original.ID})
+	assert.ErrorIs(t, err, ErrNotChatMember)
+	_, err = service.CreateBookmark("alice", model.CreateBookmarkDto{MessageID: 999})
+	assert.ErrorIs(t, err, ErrMessageNotFound)
+
+	bookmark, err := service.CreateBookmark("alice", model.CreateBookmarkDto{MessageID: original.ID, Tags: []string{"#Work", "work", " ops "}})
+	assert.NoError(t, err)
+	_, err = service.CreateBookmark("alice", model.CreateBookmarkDto{MessageID: original.ID})
+	assert.ErrorIs(t, err, ErrAlreadyBookmarked)
+
+	// the copy goes to the Saved messages chat, created once
+	saved, err := chatService.GetSavedChat("alice")
+	assert.NoError(t, err)
+	assert.Equal(t, alice.ID, *saved.SavedByID)
+	again, err := chatService.GetSavedChat("alice")
+	assert.NoError(t, err)
+	assert.Equal(t, saved.ID, again.ID)
+	assert.Equal(t, saved.ID, bookmark.Copy.ChatID)
+	assert.NotEqual(t, original.ID, bookmark.CopyID)
+	assert.Equal(t, "Deploy at noon", bookmark.Copy.Content)
+	assert.Len(t, bookmark.Copy.Entities, 1)
+
+	bookmarkResp := bookmark.ToResponseFor(model.Viewer{ID: alice.ID})
+	assert.Equal(t, []string{"ops", "work"}, bookmarkResp.Tags)
+	assert.Equal(t, "bob", bookmarkResp.Author.Username)
+	assert.Equal(t, chat.ID, bookmarkResp.ChatID)
+	assert.Equal(t, original.ID, bookmarkResp.MessageID)
+
+	savedResp, err := chatService.GetChat_ToResponse("alice", saved.ID)
+	assert.NoError(t, err)
+	assert.True(t, savedResp.Saved)
+	assert.Len(t, savedResp.Users, 1)
+	assert.Equal(t, bookmark.CopyID, savedResp.LastMessage.ID)
+
+	// notes of the Saved messages chat are bookmarked without a copy
+	note := model.Message{Content: "milk and eggs", SenderID: alice.ID, ChatID: saved.ID}
+	assert.NoError(t, messageService.CreateMessage(&note))
+	noteBookmark, err := service.CreateBookmark("alice", model.CreateBookmarkDto{MessageID: note.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, note.ID, noteBookmark.CopyID)
+
+	// copies outlive the original
+	db.Delete(&original)
+	bookmarks, err := service.GetBookmarks_ToResponse("alice", "", "", 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, bookmarks, 2)
+	assert.Equal(t, noteBookmark.ID, bookmarks[0].ID)
+
+	bookmarks, err = service.GetBookmarks_ToResponse("alice", "DEPLOY", "", 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, bookmarks, 1)
+	// wildcards are searched for as they are
+	bookmarks, err = service.GetBookmarks_ToResponse("alice", "_", "", 0, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, bookmarks)
+	bookmarks, err = service.GetBookmarks_ToResponse("alice", "", "#Work", 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, bookmarks, 1)
+	bookmarks, err = service.GetBookmarks_ToResponse("bob", "", "", 0, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, bookmarks)
+
+	_, err = service.SetBookmarkTags("bob", noteBookmark.ID, []string{"home"})
+	assert.ErrorIs(t, err, ErrBookmarkNotFound)
+	noteBookmark, err = service.SetBookmarkTags("alice", noteBookmark.ID, []string{"Home", "work"})
+	assert.NoError(t, err)
+	bookmarks, err = service.GetBookmarks_ToResponse("alice", "", "work", 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, bookmarks, 2)
+
+	// deleting the bookmark deletes the copy, notes stay
+	assert.ErrorIs(t, service.DeleteBookmark("bob", bookmark.ID), ErrBookmarkNotFound)
+	assert.NoError(t, service.DeleteBookmark("alice", bookmark.ID))
+	assert.NoError(t, service.DeleteBookmark("alice", noteBookmark.ID))
+	var count int64
+	db.Model(&model.Message{}).Where("id = ?", bookmark.CopyID).Count(&count)
+	assert.Zero(t, count)
+	savedResp, err = chatService.GetChat_ToResponse("alice", saved.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, note.ID, savedResp.LastMessage.ID)
+}
+
+func TestCreateBookmark_Disappearing(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewBookmarkService(db, rdb)
+	messageService := NewMessageService(db, rdb)
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	db.Create(&alice)
+	db.Create(&bob)
+	chat := model.Chat{Name: "private", Users: []model.User{alice, bob}, AutoDeleteSeconds: 60}
+	db.Create(&chat)
+
+	message := model.Message{Content: "burn after reading", SenderID: bob.ID, ChatID: chat.ID}
+	assert.NoError(t, messageService.CreateMessage(&message))
+
+	_, err := service.CreateBookmark("alice", model.CreateBookmarkDto{MessageID: message.ID})
+	assert.ErrorIs(t, err, ErrBookmarkExpiring)
+	var count int64
+	db.Model(&model.Message{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
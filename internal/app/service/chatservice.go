@@ -1,15 +1,37 @@
 package service
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/VitalyCone/websocket-messenger/internal/app/imageutil"
 	"github.com/VitalyCone/websocket-messenger/internal/app/model"
 	"github.com/redis/go-redis/v9"
-	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
 
+var (
+	ErrNotGroupChat      = errors.New("that's chat for 2 users only")
+	ErrNotChatAdmin      = errors.New("only chat admins can do it")
+	ErrNotChatMember     = errors.New("user is not a member of the chat")
+	ErrChangeOwnRole     = errors.New("you can't change your own role")
+	ErrTooManyPinned     = errors.New("too many pinned chats")
+	ErrInvalidMutedUntil = errors.New("mutedUntil has to be in the future")
+	ErrPinnedChats       = errors.New("chatIds have to list every pinned chat once")
+	ErrInvalidCursor     = errors.New("invalid cursor")
+	ErrChatNotFound      = errors.New("chat not found")
+	ErrHandleTaken       = errors.New("handle is already taken")
+)
+
+// Chats a user may pin
+const maxPinnedChats = 5
+
 type ChatService struct {
 	db *gorm.DB
 	rdb *redis.Client
@@ -40,8 +62,26 @@ func (s *ChatService) CreateChat(chat *model.Chat) error {
 		// userIDs= append(userIDs, existingUser.ID)
     }
 	if !chat.IsGroup{
+		blocked, err := isBlockedByAny(s.db, []uint{chat.Users[1].ID}, chat.Users[0].ID)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			return ErrUserBlocked
+		}
+
+		if chat.Users[1].ContactsOnlyChats {
+			isContact, err := isContactOf(s.db, chat.Users[1].ID, chat.Users[0].ID)
+			if err != nil {
+				return err
+			}
+			if !isContact {
+				return ErrContactsOnly
+			}
+		}
+
 		var existingChat model.Chat
-		err := s.db.
+		err = s.db.
 			Model(&model.Chat{}).
 			Joins("JOIN user_chats ON user_chats.chat_id = chats.id").
 			Where("user_chats.user_id IN (?, ?)", chat.Users[0].ID, chat.Users[1].ID).
@@ -52,40 +92,68 @@ func (s *ChatService) CreateChat(chat *model.Chat) error {
 		if err == nil {
 			return fmt.Errorf("private chat between these users already exists")
 		}
+	}else{
+		if err := checkGroupInvites(s.db, chat.Users[0], chat.Users[1:]); err != nil {
+			return err
+		}
 	}
 	// chat.ChatKey = generateChatKey(userIDs)
+	chat.LastActivityAt = time.Now()
 
-	resoult := s.db.Create(&chat)
-	if resoult.Error != nil {
-		return resoult.Error
-	}
-	return nil
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&chat).Error; err != nil {
+			return err
+		}
+		if !chat.IsGroup {
+			return nil
+		}
+		// the creator administers the group
+		return tx.Model(&model.UserChat{}).
+			Where("chat_id = ? AND user_id = ?", chat.ID, chat.Users[0].ID).
+			Update("role", model.ChatAdmin).Error
+	})
 }
 
 func (s *ChatService) GetChat(id uint) (model.Chat, error) {
 	var chat model.Chat
-	resoult := s.db.Preload("Users").First(&chat, id)
+	resoult := s.db.Preload("Users").Preload("Members").First(&chat, id)
 	if resoult.Error != nil{
 		return model.Chat{}, resoult.Error
 	}
 	return chat, nil
 }
 
-func (s *ChatService) GetChat_ToResponse(id uint) (model.ChatResponse, error) {
+func (s *ChatService) GetChat_ToResponse(username string, id uint) (model.ChatResponse, error) {
+	viewer, err := getViewer(s.db, username)
+	if err != nil{
+		return model.ChatResponse{}, err
+	}
+
 	var chat model.Chat
 	resoult := s.db.
 		Preload("Users").
-		Preload("Messages", func(db *gorm.DB) *gorm.DB {
-			return db.Preload("Sender").
-				Order("messages.created_at DESC").
-				Limit(1)
-		  }).
+		Preload("Members").
 		First(&chat, id)
 		
 	if resoult.Error != nil{
 		return model.ChatResponse{}, resoult.Error
 	}
-	chatResp := chat.ToResponse()
+	chats := []model.Chat{chat}
+	if err := loadLastMessages(s.db, chats); err != nil {
+		return model.ChatResponse{}, err
+	}
+	unread, err := countUnreadMentions(s.db, viewer.ID, []uint{chat.ID})
+	if err != nil {
+		return model.ChatResponse{}, err
+	}
+	unreadMessages, err := countUnreadMessages(s.db, viewer.ID, []uint{chat.ID})
+	if err != nil {
+		return model.ChatResponse{}, err
+	}
+	chatResp := chats[0].ToResponseFor(viewer)
+	chatResp.Avatar = chat.Avatar
+	chatResp.UnreadMentions = unread[chat.ID]
+	chatResp.Unread = unreadMessages[chat.ID]
 	return chatResp, nil
 }
 
@@ -105,43 +173,85 @@ func (s *ChatService) GetChats(username string) ([]model.Chat, error) {
 	return chats, nil
 }
 
-func (s *ChatService) GetChats_ToResponse(username string, offset, limit int) ([]model.ChatResponse, error) {
-	var chats []model.Chat
-	
-    resoult := s.db.Model(&model.Chat{}).
+// GetChats_ToResponse returns a page of archived or not archived chats of the user,
+// pinned first, then by the latest activity. It returns the cursor of the next page,
+// empty after the last one.
+func (s *ChatService) GetChats_ToResponse(username string, archived bool, folderID uint, cursor string, limit int) ([]model.ChatResponse, string, error) {
+	viewer, err := getViewer(s.db, username)
+	if err != nil{
+		return nil, "", err
+	}
+
+	// full avatars are left to GET /chats/:id
+	query := s.db.Model(&model.Chat{}).
+		Omit("avatar").
 		Preload("Users").
+		Preload("Members").
 		Joins("JOIN user_chats ON user_chats.chat_id = chats.id").
-		Joins("JOIN users ON users.id = user_chats.user_id").
-		// Preload("Messages", func(db *gorm.DB) *gorm.DB {
-		// 	return db.Preload("Sender").
-		// 		Order("messages.created_at DESC").
-		// 		Limit(1)
-		// }).
-		Where("users.username = ?", username).
-		Offset(offset).
+		Where("user_chats.user_id = ?", viewer.ID)
+	if folderID != 0 {
+		folder, err := getFolder(s.db, viewer.ID, folderID)
+		if err != nil {
+			return nil, "", err
+		}
+		query = query.Scopes(inFolder(folder, time.Now()))
+	} else {
+		query = query.Where("user_chats.archived = ?", archived)
+	}
+	if cursor != "" {
+		after, err := decodeChatCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		if after.PinPosition != nil {
+			query = query.Where("user_chats.pin_position > ? OR user_chats.pin_position IS NULL", *after.PinPosition)
+		} else {
+			query = query.
+				Where("user_chats.pin_position IS NULL").
+				Where("chats.last_activity_at < ? OR (chats.last_activity_at = ? AND chats.id < ?)", after.ActivityAt, after.ActivityAt, after.ID)
+		}
+	}
+
+	chats := make([]model.Chat, 0)
+	resoult := query.
+		Order("user_chats.pin_position IS NULL").
+		Order("user_chats.pin_position").
+		Order("chats.last_activity_at DESC").
+		Order("chats.id DESC").
 		Limit(limit).
 		Find(&chats)
-	
 	if resoult.Error != nil{
-		return nil, resoult.Error
+		return nil, "", resoult.Error
+	}
+
+	if err := loadLastMessages(s.db, chats); err != nil {
+		return nil, "", err
+	}
+	chatIDs := make([]uint, len(chats))
+	for i := range chats {
+		chatIDs[i] = chats[i].ID
+	}
+	unread, err := countUnreadMentions(s.db, viewer.ID, chatIDs)
+	if err != nil {
+		return nil, "", err
+	}
+	unreadMessages, err := countUnreadMessages(s.db, viewer.ID, chatIDs)
+	if err != nil {
+		return nil, "", err
 	}
 
 	chatResponses := make([]model.ChatResponse, len(chats))
 	for i := range chats {
-		err := s.db.Model(&chats[i]).
-			Preload("Sender").
-			Order("created_at DESC").
-			Limit(1).
-			Association("Messages").
-			Find(&chats[i].Messages)
-		
-		if err != nil {
-			return nil, err
-		}
-		chatResponses[i] = chats[i].ToResponse()
-		logrus.Println(chatResponses[i].LastMessage)
+		chatResponses[i] = chats[i].ToResponseFor(viewer)
+		chatResponses[i].UnreadMentions = unread[chats[i].ID]
+		chatResponses[i].Unread = unreadMessages[chats[i].ID]
 	}
-	return chatResponses, nil
+
+	var next string
+	if limit > 0 && len(chats) == limit {
+		next = encodeChatCursor(chats[len(chats)-1], viewer.ID)
+	}
+	return chatResponses, next, nil
 }
 
 func (s *ChatService) IsUserInChat(username string, chatID uint) bool{
@@ -155,8 +265,42 @@ func (s *ChatService) IsUserInChat(username string, chatID uint) bool{
     return count > 0
 }
 
+// GetCompanions returns usernames of everyone who shares at least one chat with the user
+func (s *ChatService) GetCompanions(username string) ([]string, error) {
+	companions := make([]string, 0)
+
+	resoult := s.db.Model(&model.User{}).
+		Distinct("users.username").
+		Joins("JOIN user_chats ON user_chats.user_id = users.id").
+		Where("user_chats.chat_id IN (?)", s.db.
+			Table("user_chats").
+			Select("user_chats.chat_id").
+			Joins("JOIN users ON users.id = user_chats.user_id").
+			Where("users.username = ?", username)).
+		Where("users.username <> ?", username).
+		Pluck("users.username", &companions)
+	if resoult.Error != nil{
+		return nil, resoult.Error
+	}
+	return companions, nil
+}
+
 //ЛИШНИЕ INSERTЫ USERS
-func (s *ChatService) ModifyChatName(id uint, name string) error {
+// ModifyChatName renames the chat. Any member of a private chat can do it, only
+// admins of a group chat.
+func (s *ChatService) ModifyChatName(username string, id uint, name string) error {
+	var chat model.Chat
+	if err := s.db.First(&chat, id).Error; err != nil {
+		return err
+	}
+	if chat.IsGroup {
+		if _, err := getChatAdmin(s.db, username, id); err != nil {
+			return err
+		}
+	} else if !s.IsUserInChat(username, id) {
+		return ErrNotChatMember
+	}
+
 	err:= s.db.Model(model.Chat{}).
 		Where(id).
 		Update("name",name).
@@ -164,7 +308,15 @@ func (s *ChatService) ModifyChatName(id uint, name string) error {
 	return err
 }
 
-func (s *ChatService) ModifyChatUsers(id uint, users []model.User) error {
+// ModifyChatUsers replaces members of a group chat, only chat admins can do it.
+// The privacy settings of new members are checked against username, the admin
+// who adds them
+func (s *ChatService) ModifyChatUsers(username string, id uint, users []model.User) error {
+	inviter, err := getChatAdmin(s.db, username, id)
+	if err != nil {
+		return err
+	}
+
 	var chat model.Chat
 	if err := s.db.
 		Preload("Users").
@@ -173,10 +325,6 @@ func (s *ChatService) ModifyChatUsers(id uint, users []model.User) error {
 		err != nil{
 		return err
 	}
-
-	if !chat.IsGroup{
-		return fmt.Errorf("error: that's chat for 2 users only")
-	}
 	
 	var fullUsers []model.User
 	for _, user := range users {
@@ -186,8 +334,18 @@ func (s *ChatService) ModifyChatUsers(id uint, users []model.User) error {
 		}
 		fullUsers = append(fullUsers, existingUser)
 	}
+
+	invitees := make([]model.User, 0)
+	for _, user := range fullUsers {
+		if !slices.ContainsFunc(chat.Users, func(member model.User) bool { return member.ID == user.ID }) {
+			invitees = append(invitees, user)
+		}
+	}
+	if err := checkGroupInvites(s.db, inviter, invitees); err != nil{
+		return err
+	}
 	
-	err := s.db.
+	err = s.db.
 		Model(&chat).
 		Association("Users").
 		Replace(fullUsers)
@@ -199,9 +357,556 @@ func (s *ChatService) ModifyChatUsers(id uint, users []model.User) error {
 	return nil
 }
 
+// SetSlowMode sets the slow mode interval of a group chat, only chat admins can do it
+func (s *ChatService) SetSlowMode(username string, id uint, seconds int) error {
+	if _, err := getChatAdmin(s.db, username, id); err != nil {
+		return err
+	}
+	return s.db.Model(&model.Chat{}).
+		Where("id = ?", id).
+		Update("slow_mode_seconds", seconds).Error
+}
+
+// SetAutoDelete sets the disappearing messages timer of the chat, it applies to
+// new messages. Any member of a private chat can set it, only admins of a group chat.
+func (s *ChatService) SetAutoDelete(username string, id uint, seconds int) error {
+	var chat model.Chat
+	if err := s.db.First(&chat, id).Error; err != nil {
+		return err
+	}
+	if chat.IsGroup {
+		if _, err := getChatAdmin(s.db, username, id); err != nil {
+			return err
+		}
+	} else if !s.IsUserInChat(username, id) {
+		return ErrNotChatMember
+	}
+
+	return s.db.Model(&model.Chat{}).
+		Where("id = ?", id).
+		Update("auto_delete_seconds", seconds).Error
+}
+
+// ReadMentions marks every mention of the user in the chat as read
+func (s *ChatService) ReadMentions(username string, id uint) error {
+	var user model.User
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return err
+	}
+	if !s.IsUserInChat(username, id) {
+		return ErrNotChatMember
+	}
+
+	return s.db.Model(&model.MessageMention{}).
+		Where("user_id = ? AND read = ?", user.ID, false).
+		Where("message_id IN (?)", s.db.Model(&model.Message{}).Select("id").Where("chat_id = ?", id)).
+		Update("read", true).Error
+}
+
+// SetMemberRole makes a member of a group chat an admin or a regular member,
+// only chat admins can do it
+func (s *ChatService) SetMemberRole(username string, id uint, memberUsername, role string) error {
+	admin, err := getChatAdmin(s.db, username, id)
+	if err != nil {
+		return err
+	}
+	if admin.Username == memberUsername {
+		return ErrChangeOwnRole
+	}
+
+	var member model.User
+	if err := s.db.Where(model.User{Username: memberUsername}).First(&member).Error; err != nil {
+		return ErrNotChatMember
+	}
+	resoult := s.db.Model(&model.UserChat{}).
+		Where("chat_id = ? AND user_id = ?", id, member.ID).
+		Update("role", role)
+	if resoult.Error != nil {
+		return resoult.Error
+	}
+	if resoult.RowsAffected == 0 {
+		return ErrNotChatMember
+	}
+	return nil
+}
+
+// getChatAdmin returns the user if they are an admin of the group chat
+func getChatAdmin(db *gorm.DB, username string, chatID uint) (model.User, error) {
+	var chat model.Chat
+	if err := db.First(&chat, chatID).Error; err != nil {
+		return model.User{}, err
+	}
+	if !chat.IsGroup {
+		return model.User{}, ErrNotGroupChat
+	}
+
+	var admin model.User
+	resoult := db.
+		Joins("JOIN user_chats ON user_chats.user_id = users.id").
+		Where("user_chats.chat_id = ? AND user_chats.role = ? AND users.username = ?", chatID, model.ChatAdmin, username).
+		First(&admin)
+	if resoult.Error != nil {
+		if errors.Is(resoult.Error, gorm.ErrRecordNotFound) {
+			return model.User{}, ErrNotChatAdmin
+		}
+		return model.User{}, resoult.Error
+	}
+	return admin, nil
+}
+
+func (s *ChatService) GetBannedWords(id uint) ([]string, error) {
+	return chatBannedWords{db: s.db}.BannedWords(id)
+}
+
+// SetBannedWords replaces words the content filter catches in the chat
+func (s *ChatService) SetBannedWords(id uint, words []string) ([]string, error) {
+	bannedWords := make([]model.ChatBannedWord, 0, len(words))
+	for _, word := range words {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word == "" || slices.ContainsFunc(bannedWords, func(w model.ChatBannedWord) bool { return w.Word == word }) {
+			continue
+		}
+		bannedWords = append(bannedWords, model.ChatBannedWord{ChatID: id, Word: word})
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("chat_id = ?", id).Delete(&model.ChatBannedWord{}).Error; err != nil {
+			return err
+		}
+		if len(bannedWords) == 0 {
+			return nil
+		}
+		return tx.Create(&bannedWords).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetBannedWords(id)
+}
+
 // func generateChatKey(userIDs []uint) string {
 //     ids := make([]uint, len(userIDs))
 //     copy(ids, userIDs)
 //     sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
 //     return fmt.Sprintf("%v", ids)
-// }
\ No newline at end of file
+// }
+
+// UpdateChatSettings changes settings of the chat for the user
+func (s *ChatService) UpdateChatSettings(username string, id uint, settingsDto model.ChatSettingsDto) error {
+	member, err := getMember(s.db, username, id)
+	if err != nil {
+		return err
+	}
+
+	updates := make(map[string]interface{})
+	if settingsDto.Muted != nil {
+		updates["muted"] = *settingsDto.Muted
+		updates["muted_until"] = nil
+		if *settingsDto.Muted && settingsDto.MutedUntil != nil {
+			if !settingsDto.MutedUntil.After(time.Now()) {
+				return ErrInvalidMutedUntil
+			}
+			updates["muted_until"] = *settingsDto.MutedUntil
+		}
+	}
+	if settingsDto.Archived != nil {
+		updates["archived"] = *settingsDto.Archived
+		if *settingsDto.Archived {
+			updates["pin_position"] = nil
+		}
+	}
+	if settingsDto.Pinned != nil {
+		if !*settingsDto.Pinned {
+			updates["pin_position"] = nil
+		} else {
+			updates["archived"] = false
+			if member.PinPosition == nil {
+				position, err := s.nextPinPosition(member.UserID)
+				if err != nil {
+					return err
+				}
+				updates["pin_position"] = position
+			} else {
+				delete(updates, "pin_position")
+			}
+		}
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+
+	return s.db.Model(&model.UserChat{}).
+		Where("chat_id = ? AND user_id = ?", member.ChatID, member.UserID).
+		Updates(updates).Error
+}
+
+// nextPinPosition returns the position putting a newly pinned chat of the user first
+func (s *ChatService) nextPinPosition(userID uint) (int, error) {
+	var pinned struct {
+		Count int
+		First int
+	}
+	resoult := s.db.Model(&model.UserChat{}).
+		Select("COUNT(*) AS count, COALESCE(MIN(pin_position), 0) AS first").
+		Where("user_id = ? AND pin_position IS NOT NULL", userID).
+		Scan(&pinned)
+	if resoult.Error != nil {
+		return 0, resoult.Error
+	}
+	if pinned.Count >= maxPinnedChats {
+		return 0, ErrTooManyPinned
+	}
+	return pinned.First - 1, nil
+}
+
+// ReorderPinnedChats sets the order of pinned chats of the user, chatIDs have to
+// list every one of them
+func (s *ChatService) ReorderPinnedChats(username string, chatIDs []uint) error {
+	var user model.User
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return err
+	}
+
+	pinnedIDs := make([]uint, 0)
+	resoult := s.db.Model(&model.UserChat{}).
+		Where("user_id = ? AND pin_position IS NOT NULL", user.ID).
+		Pluck("chat_id", &pinnedIDs)
+	if resoult.Error != nil {
+		return resoult.Error
+	}
+	sorted := slices.Clone(chatIDs)
+	slices.Sort(sorted)
+	slices.Sort(pinnedIDs)
+	if !slices.Equal(sorted, pinnedIDs) {
+		return ErrPinnedChats
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for i, chatID := range chatIDs {
+			err := tx.Model(&model.UserChat{}).
+				Where("user_id = ? AND chat_id = ?", user.ID, chatID).
+				Update("pin_position", i).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetSavedChat returns the Saved messages chat of the user, creating it on first use
+func (s *ChatService) GetSavedChat(username string) (model.Chat, error) {
+	var user model.User
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return model.Chat{}, err
+	}
+	return getSavedChat(s.db, user)
+}
+
+// UpdateChatInfo changes the description, avatar and handle of a group chat, only
+// chat admins can do it
+func (s *ChatService) UpdateChatInfo(username string, id uint, infoDto model.ChatInfoDto) (model.Chat, error) {
+	if _, err := getChatAdmin(s.db, username, id); err != nil {
+		return model.Chat{}, err
+	}
+
+	updates := map[string]interface{}{}
+	if infoDto.Description != nil {
+		updates["description"] = *infoDto.Description
+	}
+	if infoDto.Avatar != nil {
+		if len(infoDto.Avatar) == 0 {
+			updates["avatar"] = nil
+			updates["avatar_thumb"] = nil
+		} else {
+			thumb, err := imageutil.Thumbnail(infoDto.Avatar, imageutil.ThumbnailSize)
+			if err != nil {
+				return model.Chat{}, fmt.Errorf("%w: %v", ErrInvalidAvatar, err)
+			}
+			updates["avatar"] = infoDto.Avatar
+			updates["avatar_thumb"] = thumb
+		}
+	}
+	if infoDto.Handle != nil {
+		if *infoDto.Handle == "" {
+			updates["handle"] = nil
+		} else {
+			taken, err := handleTaken(s.db, id, *infoDto.Handle)
+			if err != nil {
+				return model.Chat{}, err
+			}
+			if taken {
+				return model.Chat{}, ErrHandleTaken
+			}
+			updates["handle"] = *infoDto.Handle
+		}
+	}
+
+	if len(updates) > 0 {
+		err := s.db.Model(&model.Chat{}).Where("id = ?", id).Updates(updates).Error
+		if err != nil {
+			// claimed by a concurrent request
+			if handle, ok := updates["handle"].(string); ok {
+				if taken, _ := handleTaken(s.db, id, handle); taken {
+					return model.Chat{}, ErrHandleTaken
+				}
+			}
+			return model.Chat{}, err
+		}
+	}
+	return s.GetChat(id)
+}
+
+// handleTaken reports whether another chat has the handle. Handles of deleted
+// chats stay taken, like usernames.
+func handleTaken(db *gorm.DB, chatID uint, handle string) (bool, error) {
+	var count int64
+	err := db.Unscoped().Model(&model.Chat{}).
+		Where("handle = ? AND id <> ?", handle, chatID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// GetChatByHandle returns the group chat with the handle, with its members
+func (s *ChatService) GetChatByHandle(handle string) (model.Chat, error) {
+	var chat model.Chat
+	resoult := s.db.
+		Preload("Users").
+		Where("handle = ? AND is_group = ?", strings.ToLower(strings.TrimPrefix(handle, "@")), true).
+		First(&chat)
+	if resoult.Error != nil {
+		if errors.Is(resoult.Error, gorm.ErrRecordNotFound) {
+			return model.Chat{}, ErrChatNotFound
+		}
+		return model.Chat{}, resoult.Error
+	}
+	return chat, nil
+}
+
+// MarkChatRead marks messages of the chat up to the last one read by the user,
+// mentions of the user among them included
+func (s *ChatService) MarkChatRead(username string, id uint) error {
+	member, err := getMember(s.db, username, id)
+	if err != nil {
+		return err
+	}
+	var chat model.Chat
+	if err := s.db.Select("id", "last_message_id").First(&chat, id).Error; err != nil {
+		return err
+	}
+	if chat.LastMessageID == nil {
+		return nil
+	}
+	lastID := *chat.LastMessageID
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&model.UserChat{}).
+			Where("chat_id = ? AND user_id = ? AND last_read_id < ?", member.ChatID, member.UserID, lastID).
+			Update("last_read_id", lastID).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&model.MessageMention{}).
+			Where("user_id = ? AND read = ?", member.UserID, false).
+			Where("message_id IN (?)", tx.Model(&model.Message{}).Select("id").Where("chat_id = ? AND id <= ?", member.ChatID, lastID)).
+			Update("read", true).Error
+	})
+}
+
+// GetMutedUsernames returns members who muted the chat
+func (s *ChatService) GetMutedUsernames(chatID uint) ([]string, error) {
+	usernames := make([]string, 0)
+	resoult := s.db.Model(&model.User{}).
+		Joins("JOIN user_chats ON user_chats.user_id = users.id").
+		Where("user_chats.chat_id = ? AND user_chats.muted = ?", chatID, true).
+		Where("user_chats.muted_until IS NULL OR user_chats.muted_until > ?", time.Now()).
+		Pluck("users.username", &usernames)
+	return usernames, resoult.Error
+}
+
+// chatCursor is the position of the last chat of a page in the list of a user
+type chatCursor struct {
+	PinPosition *int      `json:"p,omitempty"`
+	ActivityAt  time.Time `json:"a"`
+	ID          uint      `json:"i"`
+}
+
+func encodeChatCursor(chat model.Chat, userID uint) string {
+	cursor := chatCursor{ActivityAt: chat.LastActivityAt, ID: chat.ID}
+	for _, member := range chat.Members {
+		if member.UserID == userID {
+			cursor.PinPosition = member.PinPosition
+		}
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeChatCursor(cursor string) (chatCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return chatCursor{}, ErrInvalidCursor
+	}
+	var decoded chatCursor
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.ID == 0 {
+		return chatCursor{}, ErrInvalidCursor
+	}
+	return decoded, nil
+}
+
+// loadLastMessages sets the last message of every chat in one query
+func loadLastMessages(db *gorm.DB, chats []model.Chat) error {
+	ids := make([]uint, 0, len(chats))
+	for i := range chats {
+		if chats[i].LastMessageID != nil {
+			ids = append(ids, *chats[i].LastMessageID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	messages := make([]model.Message, 0, len(ids))
+	resoult := db.
+		Preload("Sender", withDeletedUsers).
+		Scopes(preloadTransfer, preloadPoll, preloadMentions, preloadEntities, preloadPreview, notExpired).
+		Find(&messages, ids)
+	if resoult.Error != nil {
+		return resoult.Error
+	}
+
+	byID := make(map[uint]model.Message, len(messages))
+	for _, message := range messages {
+		byID[message.ID] = message
+	}
+	for i := range chats {
+		if chats[i].LastMessageID == nil {
+			continue
+		}
+		if message, ok := byID[*chats[i].LastMessageID]; ok {
+			chats[i].Messages = []model.Message{message}
+		}
+	}
+	return nil
+}
+
+// touchChat makes the new message the last one of its chat, read by its sender.
+// Thread replies don't count.
+func touchChat(tx *gorm.DB, message model.Message) error {
+	if message.ThreadRootID != nil {
+		return nil
+	}
+	err := tx.Model(&model.Chat{}).
+		Where("id = ?", message.ChatID).
+		UpdateColumns(map[string]interface{}{
+			"last_message_id":  message.ID,
+			"last_activity_at": message.CreatedAt,
+		}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&model.UserChat{}).
+		Where("chat_id = ? AND user_id = ?", message.ChatID, message.SenderID).
+		Update("last_read_id", message.ID).Error
+}
+
+// refreshLastMessages points the chats to their latest messages left after deleting some
+func refreshLastMessages(tx *gorm.DB, chatIDs []uint) error {
+	for _, chatID := range chatIDs {
+		var lastID uint
+		resoult := tx.Model(&model.Message{}).
+			Select("COALESCE(MAX(id), 0)").
+			Where("chat_id = ? AND thread_root_id IS NULL", chatID).
+			Scan(&lastID)
+		if resoult.Error != nil {
+			return resoult.Error
+		}
+
+		var lastMessageID interface{}
+		if lastID != 0 {
+			lastMessageID = lastID
+		}
+		err := tx.Model(&model.Chat{}).
+			Where("id = ?", chatID).
+			UpdateColumn("last_message_id", lastMessageID).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unreadMessages selects messages of others in the timeline of the chat of a joined
+// user_chats row after the last one its member has read, it takes the current time
+const unreadMessages = "FROM messages WHERE messages.chat_id = user_chats.chat_id AND messages.thread_root_id IS NULL " +
+	"AND messages.deleted_at IS NULL AND messages.id > user_chats.last_read_id AND messages.sender_id <> user_chats.user_id " +
+	"AND (messages.expires_at IS NULL OR messages.expires_at > ?)"
+
+// countUnreadMessages returns the number of messages the user hasn't read in every chat
+func countUnreadMessages(db *gorm.DB, userID uint, chatIDs []uint) (map[uint]int, error) {
+	var counts []struct {
+		ChatID uint
+		Unread int
+	}
+	resoult := db.Model(&model.UserChat{}).
+		Select("user_chats.chat_id, (SELECT COUNT(*) "+unreadMessages+") AS unread", time.Now()).
+		Where("user_chats.user_id = ? AND user_chats.chat_id IN ?", userID, chatIDs).
+		Scan(&counts)
+	if resoult.Error != nil {
+		return nil, resoult.Error
+	}
+
+	unread := make(map[uint]int, len(counts))
+	for _, count := range counts {
+		unread[count.ChatID] = count.Unread
+	}
+	return unread, nil
+}
+
+// getMember returns the membership of the user in the chat
+func getMember(db *gorm.DB, username string, chatID uint) (model.UserChat, error) {
+	var member model.UserChat
+	resoult := db.
+		Joins("JOIN users ON users.id = user_chats.user_id").
+		Where("user_chats.chat_id = ? AND users.username = ?", chatID, username).
+		First(&member)
+	if resoult.Error != nil {
+		if errors.Is(resoult.Error, gorm.ErrRecordNotFound) {
+			return model.UserChat{}, ErrNotChatMember
+		}
+		return model.UserChat{}, resoult.Error
+	}
+	return member, nil
+}
+
+// savedChatName is the name of Saved messages chats
+const savedChatName = "Saved messages"
+
+// getSavedChat returns the Saved messages chat of the user, creating it on first use.
+// It's the only chat with a single member.
+func getSavedChat(db *gorm.DB, user model.User) (model.Chat, error) {
+	var chat model.Chat
+	resoult := db.Where("saved_by_id = ?", user.ID).First(&chat)
+	if resoult.Error == nil {
+		return chat, nil
+	}
+	if !errors.Is(resoult.Error, gorm.ErrRecordNotFound) {
+		return model.Chat{}, resoult.Error
+	}
+
+	chat = model.Chat{
+		Name:           savedChatName,
+		Users:          []model.User{user},
+		SavedByID:      &user.ID,
+		LastActivityAt: time.Now(),
+	}
+	if err := db.Create(&chat).Error; err != nil {
+		// created by a concurrent request
+		var existing model.Chat
+		if db.Where("saved_by_id = ?", user.ID).First(&existing).Error == nil {
+			return existing, nil
+		}
+		return model.Chat{}, err
+	}
+	return chat, nil
+}
@@ -1,12 +1,18 @@
 package service
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
 	"testing"
+	"time"
 
 	"github.com/VitalyCone/websocket-messenger/internal/app/model"
 	"github.com/go-redis/redismock/v9"
 	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
 )
 
 // func setupTestDB() *gorm.DB {
@@ -104,7 +110,7 @@ func TestGetChat_ToResponse(t *testing.T) {
 	chat := model.Chat{Name: "test", Users: []model.User{user}, IsGroup: true}
 	db.Create(&chat)
 
-	resp, err := service.GetChat_ToResponse(chat.ID)
+	resp, err := service.GetChat_ToResponse("alice", chat.ID)
 	assert.NoError(t, err)
 	assert.Equal(t, chat.ID, resp.ID)
 }
@@ -158,10 +164,19 @@ func TestModifyChatName(t *testing.T) {
 	rdb, _ := redismock.NewClientMock()
 	service := NewChatService(db, rdb)
 
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	db.Create(&alice)
+	db.Create(&bob)
 	chat := model.Chat{Name: "old", IsGroup: true}
 	db.Create(&chat)
+	db.Create(&model.UserChat{UserID: alice.ID, ChatID: chat.ID, Role: model.ChatAdmin})
+	db.Create(&model.UserChat{UserID: bob.ID, ChatID: chat.ID})
 
-	err := service.ModifyChatName(chat.ID, "new")
+	err := service.ModifyChatName("bob", chat.ID, "bob's")
+	assert.ErrorIs(t, err, ErrNotChatAdmin)
+
+	err = service.ModifyChatName("alice", chat.ID, "new")
 	assert.NoError(t, err)
 	var updated model.Chat
 	db.First(&updated, chat.ID)
@@ -174,21 +189,28 @@ func TestModifyChatUsers_Group(t *testing.T) {
 
 	user1 := model.User{Username: "alice"}
 	user2 := model.User{Username: "bob"}
+	user3 := model.User{Username: "carol"}
 
 	service := NewChatService(db, rdb)
 	
 	db.Create(&user1)
 	db.Create(&user2)
+	db.Create(&user3)
+
+	chat := model.Chat{Name: "group", IsGroup: true}
+	db.Create(&chat)
+	db.Create(&model.UserChat{UserID: user1.ID, ChatID: chat.ID, Role: model.ChatAdmin})
+	db.Create(&model.UserChat{UserID: user3.ID, ChatID: chat.ID})
+
+	// members can't remove the admin
+	err := service.ModifyChatUsers("carol", chat.ID, []model.User{user3})
+	assert.ErrorIs(t, err, ErrNotChatAdmin)
 
 	user2Byte, _ := json.Marshal(user2)
 	mock.ExpectSet("user_bob", user2Byte, 0).SetVal("OK")
 	mock.ExpectGet("user_bob").SetVal(string(user2Byte))
 
-	chat := model.Chat{Name: "group", IsGroup: true}
-	db.Create(&chat)
-	db.Model(&chat).Association("Users").Append(&user1)
-
-	err := service.ModifyChatUsers(chat.ID, []model.User{user2})
+	err = service.ModifyChatUsers("alice", chat.ID, []model.User{user2})
 	assert.NoError(t, err)
 	var updated model.Chat
 	db.Preload("Users").First(&updated, chat.ID)
@@ -207,7 +229,298 @@ func TestModifyChatUsers_Private(t *testing.T) {
 	db.Create(&chat)
 	db.Model(&chat).Association("Users").Append(&user1)
 
-	err := service.ModifyChatUsers(chat.ID, []model.User{{Username: "alice"}})
+	err := service.ModifyChatUsers("alice", chat.ID, []model.User{{Username: "alice"}})
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "for 2 users only")
 }
+
+func TestGetCompanions(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewChatService(db, rdb)
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	carol := model.User{Username: "carol"}
+	dave := model.User{Username: "dave"}
+	db.Create(&alice)
+	db.Create(&bob)
+	db.Create(&carol)
+	db.Create(&dave)
+	db.Create(&model.Chat{Name: "private", Users: []model.User{alice, bob}})
+	db.Create(&model.Chat{Name: "group", IsGroup: true, Users: []model.User{alice, bob, carol}})
+
+	companions, err := service.GetCompanions("alice")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"bob", "carol"}, companions)
+}
+
+func TestSlowMode(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewChatService(db, rdb)
+	messageService := NewMessageService(db, rdb)
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	carol := model.User{Username: "carol"}
+	db.Create(&alice)
+	db.Create(&bob)
+	db.Create(&carol)
+
+	chat := model.Chat{Name: "group", IsGroup: true, Users: []model.User{{Username: "alice"}, {Username: "bob"}, {Username: "carol"}}}
+	assert.NoError(t, service.CreateChat(&chat))
+
+	assert.ErrorIs(t, service.SetSlowMode("bob", chat.ID, 60), ErrNotChatAdmin)
+	assert.NoError(t, service.SetSlowMode("alice", chat.ID, 60))
+	assert.NoError(t, service.SetMemberRole("alice", chat.ID, "carol", model.ChatAdmin))
+	assert.ErrorIs(t, service.SetMemberRole("alice", chat.ID, "alice", model.ChatMember), ErrChangeOwnRole)
+
+	assert.NoError(t, messageService.CreateMessage(&model.Message{Content: "first", SenderID: bob.ID, ChatID: chat.ID}))
+	err := messageService.CreateMessage(&model.Message{Content: "second", SenderID: bob.ID, ChatID: chat.ID})
+	assert.ErrorIs(t, err, ErrSlowMode)
+	var slowModeErr *SlowModeError
+	assert.ErrorAs(t, err, &slowModeErr)
+	assert.InDelta(t, 60, slowModeErr.Cooldown.Seconds(), 1)
+
+	// admins are exempt
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, messageService.CreateMessage(&model.Message{Content: "admin", SenderID: alice.ID, ChatID: chat.ID}))
+		assert.NoError(t, messageService.CreateMessage(&model.Message{Content: "admin", SenderID: carol.ID, ChatID: chat.ID}))
+	}
+
+	chatResp, err := service.GetChat_ToResponse("bob", chat.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 60, chatResp.SlowModeSeconds)
+	assert.InDelta(t, 60, chatResp.SlowModeCooldown, 1)
+	assert.ElementsMatch(t, []string{"alice", "carol"}, chatResp.Admins)
+
+	chatResp, err = service.GetChat_ToResponse("alice", chat.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, chatResp.SlowModeCooldown)
+
+	assert.NoError(t, service.SetSlowMode("alice", chat.ID, 0))
+	assert.NoError(t, messageService.CreateMessage(&model.Message{Content: "third", SenderID: bob.ID, ChatID: chat.ID}))
+}
+
+func TestChatSettings(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewChatService(db, rdb)
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	db.Create(&alice)
+	db.Create(&bob)
+	chats := make([]model.Chat, maxPinnedChats+2)
+	for i := range chats {
+		chats[i] = model.Chat{Name: fmt.Sprintf("chat%d", i), IsGroup: true, Users: []model.User{alice, bob}}
+		db.Create(&chats[i])
+	}
+
+	yes, no := true, false
+	past := time.Now().Add(-time.Hour)
+	assert.ErrorIs(t, service.UpdateChatSettings("alice", 999, model.ChatSettingsDto{Muted: &yes}), ErrNotChatMember)
+	assert.ErrorIs(t, service.UpdateChatSettings("alice", chats[0].ID, model.ChatSettingsDto{Muted: &yes, MutedUntil: &past}), ErrInvalidMutedUntil)
+
+	// muted forever, and until a time which has passed
+	assert.NoError(t, service.UpdateChatSettings("alice", chats[0].ID, model.ChatSettingsDto{Muted: &yes}))
+	assert.NoError(t, service.UpdateChatSettings("bob", chats[0].ID, model.ChatSettingsDto{Muted: &yes}))
+	db.Model(&model.UserChat{}).Where("chat_id = ? AND user_id = ?", chats[0].ID, bob.ID).Update("muted_until", past)
+	muted, err := service.GetMutedUsernames(chats[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice"}, muted)
+
+	// the latest pinned chat goes first
+	assert.NoError(t, service.UpdateChatSettings("alice", chats[3].ID, model.ChatSettingsDto{Pinned: &yes}))
+	assert.NoError(t, service.UpdateChatSettings("alice", chats[2].ID, model.ChatSettingsDto{Pinned: &yes}))
+	assert.NoError(t, service.UpdateChatSettings("alice", chats[1].ID, model.ChatSettingsDto{Archived: &yes}))
+
+	chatsResp, _, err := service.GetChats_ToResponse("alice", false, 0, "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, chatsResp, len(chats)-1)
+	assert.Equal(t, chats[2].ID, chatsResp[0].ID)
+	assert.Equal(t, chats[3].ID, chatsResp[1].ID)
+	assert.Equal(t, chats[len(chats)-1].ID, chatsResp[2].ID)
+	assert.True(t, chatsResp[0].Settings.Pinned)
+	assert.Equal(t, chats[0].ID, chatsResp[len(chatsResp)-1].ID)
+	assert.True(t, chatsResp[len(chatsResp)-1].Settings.Muted)
+	assert.Nil(t, chatsResp[len(chatsResp)-1].Settings.MutedUntil)
+
+	archived, _, err := service.GetChats_ToResponse("alice", true, 0, "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, archived, 1)
+	assert.Equal(t, chats[1].ID, archived[0].ID)
+	assert.True(t, archived[0].Settings.Archived)
+
+	assert.ErrorIs(t, service.ReorderPinnedChats("alice", []uint{chats[3].ID}), ErrPinnedChats)
+	assert.NoError(t, service.ReorderPinnedChats("alice", []uint{chats[3].ID, chats[2].ID}))
+	chatsResp, _, _ = service.GetChats_ToResponse("alice", false, 0, "", 2)
+	assert.Equal(t, chats[3].ID, chatsResp[0].ID)
+
+	// pinning an archived chat unarchives it, the number of pins is limited
+	for _, i := range []int{0, 1, 4} {
+		assert.NoError(t, service.UpdateChatSettings("alice", chats[i].ID, model.ChatSettingsDto{Pinned: &yes}))
+	}
+	assert.ErrorIs(t, service.UpdateChatSettings("alice", chats[len(chats)-1].ID, model.ChatSettingsDto{Pinned: &yes}), ErrTooManyPinned)
+	archived, _, _ = service.GetChats_ToResponse("alice", true, 0, "", 10)
+	assert.Empty(t, archived)
+
+	assert.NoError(t, service.UpdateChatSettings("alice", chats[0].ID, model.ChatSettingsDto{Muted: &no, Pinned: &no}))
+	chatResp, err := service.GetChat_ToResponse("alice", chats[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.ChatSettingsResponse{}, chatResp.Settings)
+}
+
+func TestChatActivity(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewChatService(db, rdb)
+	messageService := NewMessageService(db, rdb)
+
+	alice := model.User{Username: "alice"}
+	db.Create(&alice)
+	chats := make([]model.Chat, 5)
+	for i := range chats {
+		chats[i] = model.Chat{Name: fmt.Sprintf("chat%d", i), IsGroup: true, Users: []model.User{alice}}
+		db.Create(&chats[i])
+	}
+
+	// chats go from the most recently active one
+	order := []int{3, 0, 4, 1, 2}
+	lastMessages := make([]model.Message, len(chats))
+	for _, i := range order {
+		lastMessages[i] = model.Message{Content: fmt.Sprintf("hi %d", i), SenderID: alice.ID, ChatID: chats[i].ID}
+		assert.NoError(t, messageService.CreateMessage(&lastMessages[i]))
+		time.Sleep(time.Millisecond)
+	}
+	yes := true
+	assert.NoError(t, service.UpdateChatSettings("alice", chats[4].ID, model.ChatSettingsDto{Pinned: &yes}))
+
+	thread := model.Message{Content: "reply", SenderID: alice.ID, ChatID: chats[3].ID, ThreadRootID: &lastMessages[3].ID}
+	assert.NoError(t, messageService.CreateMessage(&thread))
+
+	want := []uint{chats[4].ID, chats[2].ID, chats[1].ID, chats[0].ID, chats[3].ID}
+	got := make([]uint, 0, len(want))
+	cursor := ""
+	for page := 0; page < 3; page++ {
+		chatsResp, next, err := service.GetChats_ToResponse("alice", false, 0, cursor, 2)
+		assert.NoError(t, err)
+		for _, chat := range chatsResp {
+			got = append(got, chat.ID)
+		}
+		if page < 2 {
+			assert.NotEmpty(t, next)
+		} else {
+			assert.Empty(t, next)
+		}
+		cursor = next
+	}
+	assert.Equal(t, want, got)
+
+	chatsResp, _, err := service.GetChats_ToResponse("alice", false, 0, "", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "hi 3", chatsResp[4].LastMessage.Content)
+	assert.Equal(t, "hi 2", chatsResp[1].LastMessage.Content)
+
+	_, _, err = service.GetChats_ToResponse("alice", false, 0, "garbage", 10)
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+
+	// expired messages stop being the last ones
+	db.Model(&lastMessages[2]).Update("expires_at", time.Now().Add(-time.Minute))
+	_, err = messageService.PurgeExpiredMessages(nil)
+	assert.NoError(t, err)
+	chatResp, err := service.GetChat_ToResponse("alice", chats[2].ID)
+	assert.NoError(t, err)
+	assert.Zero(t, chatResp.LastMessage.ID)
+}
+
+func TestChatInfo(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewChatService(db, rdb)
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	db.Create(&alice)
+	db.Create(&bob)
+	group := model.Chat{Name: "group", IsGroup: true, Users: []model.User{{Username: "alice"}, {Username: "bob"}}}
+	assert.NoError(t, service.CreateChat(&group))
+	other := model.Chat{Name: "other", IsGroup: true, Users: []model.User{{Username: "bob"}, {Username: "alice"}}}
+	assert.NoError(t, service.CreateChat(&other))
+	private := model.Chat{Name: "private", Users: []model.User{alice, bob}}
+	db.Create(&private)
+
+	var avatar bytes.Buffer
+	assert.NoError(t, png.Encode(&avatar, image.NewRGBA(image.Rect(0, 0, 300, 200))))
+
+	handle := "@Go_Team"
+	infoDto := model.ChatInfoDto{Handle: &handle}
+	assert.NoError(t, infoDto.Validate())
+	assert.Equal(t, "go_team", *infoDto.Handle)
+	short := "go"
+	assert.Error(t, (&model.ChatInfoDto{Handle: &short}).Validate())
+
+	description := "Gophers only"
+	infoDto.Description = &description
+	infoDto.Avatar = avatar.Bytes()
+	_, err := service.UpdateChatInfo("bob", group.ID, infoDto)
+	assert.ErrorIs(t, err, ErrNotChatAdmin)
+	_, err = service.UpdateChatInfo("alice", private.ID, infoDto)
+	assert.ErrorIs(t, err, ErrNotGroupChat)
+	_, err = service.UpdateChatInfo("alice", group.ID, model.ChatInfoDto{Avatar: []byte("not an image")})
+	assert.ErrorIs(t, err, ErrInvalidAvatar)
+
+	chat, err := service.UpdateChatInfo("alice", group.ID, infoDto)
+	assert.NoError(t, err)
+	assert.Equal(t, "Gophers only", chat.Description)
+	assert.Equal(t, "go_team", *chat.Handle)
+	assert.NotEmpty(t, chat.AvatarThumb)
+	assert.Len(t, chat.Users, 2)
+	_, err = service.UpdateChatInfo("bob", other.ID, model.ChatInfoDto{Handle: chat.Handle})
+	assert.ErrorIs(t, err, ErrHandleTaken)
+
+	// lists carry the thumbnail, the full avatar comes with the chat alone
+	chatsResp, _, err := service.GetChats_ToResponse("bob", false, 0, "", 10)
+	assert.NoError(t, err)
+	for _, chatResp := range chatsResp {
+		assert.Nil(t, chatResp.Avatar)
+	}
+	chatResp, err := service.GetChat_ToResponse("bob", group.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, avatar.Bytes(), chatResp.Avatar)
+	assert.NotEmpty(t, chatResp.AvatarThumb)
+
+	// the handle is claimed by a concurrent request between the check and the update
+	racing := "racing"
+	db.Callback().Update().Before("gorm:update").Register("test:claim_handle", func(tx *gorm.DB) {
+		tx.Session(&gorm.Session{NewDB: true}).Exec("UPDATE chats SET handle = ? WHERE id = ?", racing, private.ID)
+	})
+	// the claim commits on its own, outside of the update
+	racingService := NewChatService(db.Session(&gorm.Session{SkipDefaultTransaction: true}), rdb)
+	_, err = racingService.UpdateChatInfo("bob", other.ID, model.ChatInfoDto{Handle: &racing})
+	db.Callback().Update().Remove("test:claim_handle")
+	assert.ErrorIs(t, err, ErrHandleTaken)
+
+	found, err := service.GetChatByHandle("@GO_TEAM")
+	assert.NoError(t, err)
+	publicResp := found.ToPublicResponse()
+	assert.Equal(t, group.ID, publicResp.ID)
+	assert.Equal(t, 2, publicResp.MembersCount)
+	_, err = service.GetChatByHandle("nobody")
+	assert.ErrorIs(t, err, ErrChatNotFound)
+
+	// omitted fields stay, empty ones are removed
+	empty := ""
+	chat, err = service.UpdateChatInfo("alice", group.ID, model.ChatInfoDto{Handle: &empty, Avatar: []byte{}})
+	assert.NoError(t, err)
+	assert.Nil(t, chat.Handle)
+	assert.Nil(t, chat.Avatar)
+	assert.Equal(t, "Gophers only", chat.Description)
+	_, err = service.GetChatByHandle("go_team")
+	assert.ErrorIs(t, err, ErrChatNotFound)
+
+	// the handle is free again
+	_, err = service.UpdateChatInfo("bob", other.ID, model.ChatInfoDto{Handle: infoDto.Handle})
+	assert.NoError(t, err)
+}
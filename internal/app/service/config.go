@@ -1,10 +1,46 @@
 package service
 
+import "time"
 
 type Config struct {
 	DatabaseURL     string
 	RedisURL string
 	TokenKey string
+	SMTP SMTPConfig
+	// Usernames that get the admin role on start
+	Admins []string
+	Filters FilterConfig
+	Previews PreviewConfig
+}
+
+// PreviewConfig configures fetching of link previews, zero fields take defaults
+type PreviewConfig struct {
+	Workers  int
+	Timeout  time.Duration
+	MaxBytes int64
+}
+
+// FilterConfig configures the content filters of outgoing messages.
+// Actions are reject, mask or flag, reject if empty.
+type FilterConfig struct {
+	// Maximum length of messages in characters, 0 for no limit
+	MaxLength            int
+	BannedWords          []string
+	BannedWordsAction    string
+	BlockedDomains       []string
+	BlockedDomainsAction string
+	// Number of identical messages a user may send within RepeatWindow, 0 for no limit
+	RepeatLimit  int
+	RepeatWindow time.Duration
+	RepeatAction string
+}
+
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
 }
 
 func NewConfig(databaseURL, redisURL, tokenKey string) *Config{
@@ -13,4 +49,4 @@ func NewConfig(databaseURL, redisURL, tokenKey string) *Config{
 		RedisURL: redisURL,
 		TokenKey: tokenKey,
 	}
-}
\ No newline at end of file
+}
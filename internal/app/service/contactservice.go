@@ -0,0 +1,185 @@
+package service
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 50
+)
+
+var (
+	ErrContactSelf     = errors.New("you can't add yourself to contacts")
+	ErrContactExists   = errors.New("user is already in contacts")
+	ErrContactNotFound = errors.New("contact not found")
+	ErrContactsOnly    = errors.New("user accepts private chats from contacts only")
+)
+
+type ContactService struct {
+	db  *gorm.DB
+	rdb *redis.Client
+}
+
+func NewContactService(db *gorm.DB, rdb *redis.Client) *ContactService {
+	return &ContactService{
+		db:  db,
+		rdb: rdb,
+	}
+}
+
+func (s *ContactService) AddContact(ownerUsername, username, nickname string) (model.Contact, error) {
+	if ownerUsername == username {
+		return model.Contact{}, ErrContactSelf
+	}
+
+	var owner, user model.User
+	if err := s.db.Where(model.User{Username: ownerUsername}).First(&owner).Error; err != nil {
+		return model.Contact{}, err
+	}
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return model.Contact{}, err
+	}
+
+	var count int64
+	s.db.Model(&model.Contact{}).Where("owner_id = ? AND contact_id = ?", owner.ID, user.ID).Count(&count)
+	if count > 0 {
+		return model.Contact{}, ErrContactExists
+	}
+
+	contact := model.Contact{
+		OwnerID:   owner.ID,
+		ContactID: user.ID,
+		Contact:   user,
+		Nickname:  nickname,
+	}
+	if err := s.db.Omit("Contact").Create(&contact).Error; err != nil {
+		return model.Contact{}, err
+	}
+	return contact, nil
+}
+
+func (s *ContactService) ModifyContact(ownerUsername, username, nickname string) (model.Contact, error) {
+	contact, err := s.getContact(ownerUsername, username)
+	if err != nil {
+		return model.Contact{}, err
+	}
+
+	if err := s.db.Model(&contact).Update("nickname", nickname).Error; err != nil {
+		return model.Contact{}, err
+	}
+	contact.Nickname = nickname
+	return contact, nil
+}
+
+func (s *ContactService) RemoveContact(ownerUsername, username string) error {
+	contact, err := s.getContact(ownerUsername, username)
+	if err != nil {
+		return err
+	}
+	return s.db.Delete(&contact).Error
+}
+
+func (s *ContactService) GetContacts(ownerUsername string) ([]model.Contact, error) {
+	contacts := make([]model.Contact, 0)
+	resoult := s.db.
+		Preload("Contact").
+		Joins("JOIN users owners ON owners.id = contacts.owner_id").
+		Joins("JOIN users ON users.id = contacts.contact_id AND users.deleted_at IS NULL").
+		Where("owners.username = ?", ownerUsername).
+		Order("COALESCE(NULLIF(contacts.nickname, ''), users.username)").
+		Find(&contacts)
+	if resoult.Error != nil {
+		return nil, resoult.Error
+	}
+	return contacts, nil
+}
+
+// IsContact reports whether username is in the contacts of ownerUsername
+func (s *ContactService) IsContact(ownerUsername, username string) bool {
+	var count int64
+	s.db.Model(&model.Contact{}).
+		Joins("JOIN users owners ON owners.id = contacts.owner_id").
+		Joins("JOIN users ON users.id = contacts.contact_id").
+		Where("owners.username = ? AND users.username = ?", ownerUsername, username).
+		Count(&count)
+	return count > 0
+}
+
+func (s *ContactService) SetContactsOnlyChats(username string, contactsOnly bool) error {
+	err := s.db.Model(&model.User{}).
+		Where("username = ?", username).
+		Update("contacts_only_chats", contactsOnly).
+		Error
+	if err != nil {
+		return err
+	}
+	return invalidateUserCache(s.rdb, username)
+}
+
+// SearchUsers_ToResponse looks users up by a part of their username. Contacts of
+// the searching user go first, deleted accounts are never returned.
+func (s *ContactService) SearchUsers_ToResponse(ownerUsername, query string, offset, limit int) ([]model.UserResponse, error) {
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	if limit > MaxSearchLimit {
+		limit = MaxSearchLimit
+	}
+
+	viewer, err := getViewer(s.db, ownerUsername)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]model.User, 0)
+	resoult := s.db.Model(&model.User{}).
+		Joins("LEFT JOIN contacts ON contacts.contact_id = users.id AND contacts.owner_id = (?)",
+			s.db.Model(&model.User{}).Select("id").Where("username = ?", ownerUsername)).
+		Where(`users.username LIKE ? ESCAPE '\'`, likeContains(strings.ToLower(query))).
+		Order("contacts.id IS NULL").
+		Order("users.username").
+		Offset(offset).
+		Limit(limit).
+		Find(&users)
+	if resoult.Error != nil {
+		return nil, resoult.Error
+	}
+
+	usersResp := make([]model.UserResponse, 0, len(users))
+	for _, user := range users {
+		usersResp = append(usersResp, user.ToResponseFor(viewer))
+	}
+	return usersResp, nil
+}
+
+func (s *ContactService) getContact(ownerUsername, username string) (model.Contact, error) {
+	var contact model.Contact
+	resoult := s.db.
+		Preload("Contact").
+		Joins("JOIN users owners ON owners.id = contacts.owner_id").
+		Joins("JOIN users ON users.id = contacts.contact_id").
+		Where("owners.username = ? AND users.username = ?", ownerUsername, username).
+		First(&contact)
+	if resoult.Error != nil {
+		if errors.Is(resoult.Error, gorm.ErrRecordNotFound) {
+			return model.Contact{}, ErrContactNotFound
+		}
+		return model.Contact{}, resoult.Error
+	}
+	return contact, nil
+}
+
+// isContactOf reports whether userID is in the contacts of ownerID
+func isContactOf(db *gorm.DB, ownerID, userID uint) (bool, error) {
+	var count int64
+	err := db.Model(&model.Contact{}).
+		Where("owner_id = ? AND contact_id = ?", ownerID, userID).
+		Count(&count).Error
+	return count > 0, err
+}
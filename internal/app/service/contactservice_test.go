@@ -0,0 +1,113 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddContact(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewContactService(db, rdb)
+
+	db.Create(&model.User{Username: "alice"})
+	db.Create(&model.User{Username: "bob"})
+
+	_, err := service.AddContact("alice", "alice", "")
+	assert.ErrorIs(t, err, ErrContactSelf)
+
+	contact, err := service.AddContact("alice", "bob", "Bobby")
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", contact.Contact.Username)
+	assert.Equal(t, "Bobby", contact.Nickname)
+
+	_, err = service.AddContact("alice", "bob", "")
+	assert.ErrorIs(t, err, ErrContactExists)
+
+	assert.True(t, service.IsContact("alice", "bob"))
+	assert.False(t, service.IsContact("bob", "alice"))
+}
+
+func TestModifyAndRemoveContact(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewContactService(db, rdb)
+
+	db.Create(&model.User{Username: "alice"})
+	db.Create(&model.User{Username: "bob"})
+	db.Create(&model.User{Username: "carol"})
+	service.AddContact("alice", "bob", "")
+	service.AddContact("alice", "carol", "Aunt Carol")
+
+	contact, err := service.ModifyContact("alice", "bob", "Zed")
+	assert.NoError(t, err)
+	assert.Equal(t, "Zed", contact.Nickname)
+
+	contacts, err := service.GetContacts("alice")
+	assert.NoError(t, err)
+	assert.Len(t, contacts, 2)
+	assert.Equal(t, "carol", contacts[0].Contact.Username)
+	assert.Equal(t, "bob", contacts[1].Contact.Username)
+
+	assert.NoError(t, service.RemoveContact("alice", "bob"))
+	assert.ErrorIs(t, service.RemoveContact("alice", "bob"), ErrContactNotFound)
+	_, err = service.ModifyContact("alice", "bob", "")
+	assert.ErrorIs(t, err, ErrContactNotFound)
+}
+
+func TestSearchUsers_ToResponse(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewContactService(db, rdb)
+
+	db.Create(&model.User{Username: "alice"})
+	db.Create(&model.User{Username: "ivan"})
+	db.Create(&model.User{Username: "ivanov"})
+	db.Create(&model.User{Username: "ivanova"})
+	deleted := model.User{Username: "ivan_deleted"}
+	db.Create(&deleted)
+	db.Delete(&deleted)
+	service.AddContact("alice", "ivanova", "")
+
+	resp, err := service.SearchUsers_ToResponse("alice", "IVAN", 0, 0)
+	assert.NoError(t, err)
+	assert.Len(t, resp, 3)
+	assert.Equal(t, "ivanova", resp[0].Username)
+	assert.Equal(t, "ivan", resp[1].Username)
+
+	resp, err = service.SearchUsers_ToResponse("alice", "ivan", 0, 1)
+	assert.NoError(t, err)
+	assert.Len(t, resp, 1)
+
+	// wildcards are searched for as they are
+	db.Create(&model.User{Username: "ivan_petrov"})
+	resp, err = service.SearchUsers_ToResponse("alice", "n_p", 0, 0)
+	assert.NoError(t, err)
+	assert.Len(t, resp, 1)
+	resp, err = service.SearchUsers_ToResponse("alice", "%", 0, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, resp)
+}
+
+func TestCreateChat_ContactsOnly(t *testing.T) {
+	db := setupTestDB()
+	rdb, mock := redismock.NewClientMock()
+	contactService := NewContactService(db, rdb)
+	chatService := NewChatService(db, rdb)
+
+	db.Create(&model.User{Username: "alice"})
+	db.Create(&model.User{Username: "bob"})
+	mock.ExpectDel("user_alice").SetVal(1)
+	assert.NoError(t, contactService.SetContactsOnlyChats("alice", true))
+
+	chat := &model.Chat{Users: []model.User{{Username: "bob"}, {Username: "alice"}}}
+	assert.ErrorIs(t, chatService.CreateChat(chat), ErrContactsOnly)
+
+	_, err := contactService.AddContact("alice", "bob", "")
+	assert.NoError(t, err)
+	chat = &model.Chat{Users: []model.User{{Username: "bob"}, {Username: "alice"}}}
+	assert.NoError(t, chatService.CreateChat(chat))
+}
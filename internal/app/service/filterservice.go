@@ -0,0 +1,84 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/filter"
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"gorm.io/gorm"
+)
+
+// chatBannedWords is the filter.WordSource of words banned by chat members
+type chatBannedWords struct {
+	db *gorm.DB
+}
+
+func (w chatBannedWords) BannedWords(chatID uint) ([]string, error) {
+	words := make([]string, 0)
+	resoult := w.db.Model(&model.ChatBannedWord{}).
+		Where("chat_id = ?", chatID).
+		Order("word").
+		Pluck("word", &words)
+	return words, resoult.Error
+}
+
+// sentMessages is the filter.History of stored messages
+type sentMessages struct {
+	db *gorm.DB
+}
+
+func (m sentMessages) SentSince(senderID uint, since time.Time) ([]string, error) {
+	contents := make([]string, 0)
+	resoult := m.db.Model(&model.Message{}).
+		Where("sender_id = ? AND created_at > ?", senderID, since).
+		Pluck("content", &contents)
+	return contents, resoult.Error
+}
+
+// newFilters builds the content filter chain of the deployment
+func newFilters(db *gorm.DB, config FilterConfig) (filter.Chain, error) {
+	wordsAction, err := filter.ParseAction(config.BannedWordsAction)
+	if err != nil {
+		return nil, err
+	}
+	linksAction, err := filter.ParseAction(config.BlockedDomainsAction)
+	if err != nil {
+		return nil, err
+	}
+	repeatAction, err := filter.ParseAction(config.RepeatAction)
+	if err != nil {
+		return nil, err
+	}
+
+	return filter.Chain{
+		filter.MaxLength(config.MaxLength),
+		filter.NewWords(config.BannedWords, chatBannedWords{db: db}, wordsAction),
+		filter.NewLinks(config.BlockedDomains, linksAction),
+		filter.NewRepeat(sentMessages{db: db}, config.RepeatLimit, config.RepeatWindow, repeatAction),
+	}, nil
+}
+
+// flagMessage puts a report of the content filter with the reasons on the moderation queue
+func flagMessage(db *gorm.DB, message model.Message, reasons []string) error {
+	if len(reasons) == 0 {
+		return nil
+	}
+
+	if err := db.Preload("Sender", withDeletedUsers).First(&message, message.ID).Error; err != nil {
+		return err
+	}
+	snapshot, err := messageSnapshot(message)
+	if err != nil {
+		return err
+	}
+
+	return db.Create(&model.Report{
+		TargetType: model.ReportMessage,
+		TargetID:   message.ID,
+		Reason:     model.ReporterFilter,
+		Comment:    strings.Join(reasons, ", "),
+		Snapshot:   snapshot,
+		Status:     model.ReportOpen,
+	}).Error
+}
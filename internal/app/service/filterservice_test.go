@@ -0,0 +1,71 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateMessage_Filters(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewMessageService(db, rdb)
+	chatService := NewChatService(db, rdb)
+
+	filters, err := newFilters(db, FilterConfig{
+		MaxLength:            50,
+		BannedWords:          []string{"darn"},
+		BannedWordsAction:    "mask",
+		BlockedDomains:       []string{"spam.com"},
+		BlockedDomainsAction: "flag",
+		RepeatLimit:          1,
+		RepeatWindow:         time.Minute,
+	})
+	assert.NoError(t, err)
+	service.UseFilters(filters)
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	db.Create(&alice)
+	db.Create(&bob)
+	chat := model.Chat{Name: "private", Users: []model.User{alice, bob}}
+	db.Create(&chat)
+
+	words, err := chatService.SetBannedWords(chat.ID, []string{" Heck", "heck", ""})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"heck"}, words)
+
+	msg := &model.Message{Content: "darn, what the heck", SenderID: alice.ID, ChatID: chat.ID}
+	assert.NoError(t, service.CreateMessage(msg))
+	assert.Equal(t, "****, what the ****", msg.Content)
+
+	msg = &model.Message{Content: "visit spam.com", SenderID: alice.ID, ChatID: chat.ID}
+	assert.NoError(t, service.CreateMessage(msg))
+	var report model.Report
+	assert.NoError(t, db.First(&report, "target_type = ? AND target_id = ?", model.ReportMessage, msg.ID).Error)
+	assert.Nil(t, report.ReporterID)
+	assert.Equal(t, "message contains a blocked link", report.Comment)
+	assert.Equal(t, model.ReporterFilter, report.ToResponse().Reporter)
+
+	msg = &model.Message{Content: "visit spam.com", SenderID: alice.ID, ChatID: chat.ID}
+	assert.ErrorIs(t, service.CreateMessage(msg), ErrMessageRejected)
+
+	msg = &model.Message{Content: "this message is longer than fifty characters for sure", SenderID: bob.ID, ChatID: chat.ID}
+	err = service.CreateMessage(msg)
+	assert.ErrorIs(t, err, ErrMessageRejected)
+	assert.Contains(t, err.Error(), "longer than 50 characters")
+
+	// urls of text links are filtered too
+	msg = &model.Message{Content: "[prize](https://spam.com/win) or [this](https://darn.org)", SenderID: bob.ID, ChatID: chat.ID}
+	assert.NoError(t, service.CreateMessage(msg))
+	assert.Equal(t, "prize or this", msg.Content)
+	assert.Equal(t, []model.EntityResponse{{Type: "text_link", Offset: 0, Length: 5, URL: "https://spam.com/win"}}, msg.ToResponse().Entities)
+	var linkReport model.Report
+	assert.NoError(t, db.First(&linkReport, "target_type = ? AND target_id = ?", model.ReportMessage, msg.ID).Error)
+
+	_, err = newFilters(db, FilterConfig{RepeatAction: "ban"})
+	assert.Error(t, err)
+}
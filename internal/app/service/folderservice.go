@@ -0,0 +1,261 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// Folders a user may create
+const maxChatFolders = 10
+
+var (
+	ErrFolderNotFound = errors.New("folder not found")
+	ErrTooManyFolders = errors.New("too many folders")
+	ErrEmptyFolder    = errors.New("folder has to include some chats explicitly or by type")
+	ErrFolderChats    = errors.New("chats of the folder have to be chats of the user, listed once")
+)
+
+type FolderService struct {
+	db  *gorm.DB
+	rdb *redis.Client
+}
+
+func NewFolderService(db *gorm.DB, rdb *redis.Client) *FolderService {
+	return &FolderService{
+		db:  db,
+		rdb: rdb,
+	}
+}
+
+// CreateFolder adds a folder to the chat list of the user
+func (s *FolderService) CreateFolder(username string, folderDto model.ChatFolderDto) (model.ChatFolderResponse, error) {
+	var user model.User
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return model.ChatFolderResponse{}, err
+	}
+	if err := s.checkFolderDto(user.ID, folderDto); err != nil {
+		return model.ChatFolderResponse{}, err
+	}
+
+	var count int64
+	s.db.Model(&model.ChatFolder{}).Where("user_id = ?", user.ID).Count(&count)
+	if count >= maxChatFolders {
+		return model.ChatFolderResponse{}, ErrTooManyFolders
+	}
+
+	folder := folderDto.ToModel(user.ID)
+	if err := s.db.Create(&folder).Error; err != nil {
+		return model.ChatFolderResponse{}, err
+	}
+	return s.toResponse(folder)
+}
+
+// GetFolders_ToResponse returns folders of the user with numbers of their unread chats
+func (s *FolderService) GetFolders_ToResponse(username string) ([]model.ChatFolderResponse, error) {
+	var user model.User
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return nil, err
+	}
+
+	folders := make([]model.ChatFolder, 0)
+	resoult := s.db.Preload("Chats").Where("user_id = ?", user.ID).Order("id").Find(&folders)
+	if resoult.Error != nil {
+		return nil, resoult.Error
+	}
+
+	folderResponses := make([]model.ChatFolderResponse, len(folders))
+	for i := range folders {
+		folderResp, err := s.toResponse(folders[i])
+		if err != nil {
+			return nil, err
+		}
+		folderResponses[i] = folderResp
+	}
+	return folderResponses, nil
+}
+
+// UpdateFolder replaces the name, chats and rules of the folder
+func (s *FolderService) UpdateFolder(username string, id uint, folderDto model.ChatFolderDto) (model.ChatFolderResponse, error) {
+	var user model.User
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return model.ChatFolderResponse{}, err
+	}
+	folder, err := getFolder(s.db, user.ID, id)
+	if err != nil {
+		return model.ChatFolderResponse{}, err
+	}
+	if err := s.checkFolderDto(user.ID, folderDto); err != nil {
+		return model.ChatFolderResponse{}, err
+	}
+
+	updated := folderDto.ToModel(user.ID)
+	updated.ID = folder.ID
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&folder).
+			Select("Name", "IncludeGroups", "IncludePrivate", "ExcludeMuted", "ExcludeRead", "ExcludeArchived").
+			Updates(&updated).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("folder_id = ?", folder.ID).Delete(&model.ChatFolderChat{}).Error; err != nil {
+			return err
+		}
+		if len(updated.Chats) == 0 {
+			return nil
+		}
+		for i := range updated.Chats {
+			updated.Chats[i].FolderID = folder.ID
+		}
+		return tx.Create(&updated.Chats).Error
+	})
+	if err != nil {
+		return model.ChatFolderResponse{}, err
+	}
+
+	folder, err = getFolder(s.db, user.ID, id)
+	if err != nil {
+		return model.ChatFolderResponse{}, err
+	}
+	return s.toResponse(folder)
+}
+
+// DeleteFolder removes the folder, its chats stay in the chat list
+func (s *FolderService) DeleteFolder(username string, id uint) error {
+	var user model.User
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return err
+	}
+	folder, err := getFolder(s.db, user.ID, id)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("folder_id = ?", folder.ID).Delete(&model.ChatFolderChat{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&folder).Error
+	})
+}
+
+// checkFolderDto makes sure the folder includes something and lists chats of the user once
+func (s *FolderService) checkFolderDto(userID uint, folderDto model.ChatFolderDto) error {
+	if len(folderDto.IncludedChatIDs) == 0 && !folderDto.IncludeGroups && !folderDto.IncludePrivate {
+		return ErrEmptyFolder
+	}
+
+	chatIDs := make(map[uint]bool)
+	for _, id := range append(folderDto.IncludedChatIDs, folderDto.ExcludedChatIDs...) {
+		if chatIDs[id] {
+			return ErrFolderChats
+		}
+		chatIDs[id] = true
+	}
+	if len(chatIDs) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, 0, len(chatIDs))
+	for id := range chatIDs {
+		ids = append(ids, id)
+	}
+	var count int64
+	s.db.Model(&model.UserChat{}).
+		Where("user_id = ? AND chat_id IN ?", userID, ids).
+		Count(&count)
+	if int(count) != len(ids) {
+		return ErrFolderChats
+	}
+	return nil
+}
+
+func (s *FolderService) toResponse(folder model.ChatFolder) (model.ChatFolderResponse, error) {
+	unread, err := countUnreadChats(s.db, folder)
+	if err != nil {
+		return model.ChatFolderResponse{}, err
+	}
+	folderResp := folder.ToResponse()
+	folderResp.UnreadChats = unread
+	return folderResp, nil
+}
+
+// getFolder returns the folder of the user with its chats
+func getFolder(db *gorm.DB, userID, id uint) (model.ChatFolder, error) {
+	var folder model.ChatFolder
+	resoult := db.Preload("Chats").Where("user_id = ?", userID).First(&folder, id)
+	if resoult.Error != nil {
+		if errors.Is(resoult.Error, gorm.ErrRecordNotFound) {
+			return model.ChatFolder{}, ErrFolderNotFound
+		}
+		return model.ChatFolder{}, resoult.Error
+	}
+	return folder, nil
+}
+
+// countUnreadChats returns the number of chats of the folder with messages its owner hasn't read
+func countUnreadChats(db *gorm.DB, folder model.ChatFolder) (int, error) {
+	now := time.Now()
+	var count int64
+	resoult := db.Model(&model.UserChat{}).
+		Joins("JOIN chats ON chats.id = user_chats.chat_id AND chats.deleted_at IS NULL").
+		Where("user_chats.user_id = ?", folder.UserID).
+		Scopes(inFolder(folder, now)).
+		Where("EXISTS (SELECT 1 "+unreadMessages+")", now).
+		Count(&count)
+	return int(count), resoult.Error
+}
+
+// inFolder keeps chats of the folder in a query of user_chats of its owner joined with chats
+func inFolder(folder model.ChatFolder, now time.Time) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		included := make([]uint, 0)
+		excluded := make([]uint, 0)
+		for _, chat := range folder.Chats {
+			if chat.Excluded {
+				excluded = append(excluded, chat.ChatID)
+			} else {
+				included = append(included, chat.ChatID)
+			}
+		}
+
+		rules := make([]string, 0)
+		args := make([]interface{}, 0)
+		switch {
+		case folder.IncludeGroups && folder.IncludePrivate:
+			rules = append(rules, "1 = 1")
+		case folder.IncludeGroups || folder.IncludePrivate:
+			rules = append(rules, "chats.is_group = ?")
+			args = append(args, folder.IncludeGroups)
+		default:
+			rules = append(rules, "1 = 0")
+		}
+		if folder.ExcludeMuted {
+			rules = append(rules, "NOT (user_chats.muted = ? AND (user_chats.muted_until IS NULL OR user_chats.muted_until > ?))")
+			args = append(args, true, now)
+		}
+		if folder.ExcludeArchived {
+			rules = append(rules, "user_chats.archived = ?")
+			args = append(args, false)
+		}
+		if folder.ExcludeRead {
+			rules = append(rules, "EXISTS (SELECT 1 "+unreadMessages+")")
+			args = append(args, now)
+		}
+
+		condition := "(" + strings.Join(rules, " AND ") + ")"
+		if len(included) > 0 {
+			condition = "(user_chats.chat_id IN ? OR " + condition + ")"
+			args = append([]interface{}{included}, args...)
+		}
+		db = db.Where(condition, args...)
+		if len(excluded) > 0 {
+			db = db.Where("user_chats.chat_id NOT IN ?", excluded)
+		}
+		return db
+	}
+}
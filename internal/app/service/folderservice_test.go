@@ -0,0 +1,128 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChatFolders(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewFolderService(db, rdb)
+	chatService := NewChatService(db, rdb)
+	messageService := NewMessageService(db, rdb)
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	carol := model.User{Username: "carol"}
+	db.Create(&alice)
+	db.Create(&bob)
+	db.Create(&carol)
+	group := model.Chat{Name: "group", IsGroup: true, Users: []model.User{alice, bob}}
+	muted := model.Chat{Name: "muted", IsGroup: true, Users: []model.User{alice, bob}}
+	archived := model.Chat{Name: "archived", IsGroup: true, Users: []model.User{alice, bob}}
+	private := model.Chat{Name: "private", Users: []model.User{alice, bob}}
+	own := model.Chat{Name: "own", Users: []model.User{alice, carol}}
+	others := model.Chat{Name: "others", Users: []model.User{bob, carol}}
+	for _, chat := range []*model.Chat{&group, &muted, &archived, &private, &own, &others} {
+		db.Create(chat)
+	}
+
+	yes := true
+	assert.NoError(t, chatService.UpdateChatSettings("alice", muted.ID, model.ChatSettingsDto{Muted: &yes}))
+	assert.NoError(t, chatService.UpdateChatSettings("alice", archived.ID, model.ChatSettingsDto{Archived: &yes}))
+	for _, message := range []model.Message{
+		{Content: "hi", SenderID: bob.ID, ChatID: archived.ID},
+		{Content: "hi", SenderID: bob.ID, ChatID: muted.ID},
+		{Content: "hi", SenderID: bob.ID, ChatID: private.ID},
+		{Content: "hi", SenderID: bob.ID, ChatID: group.ID},
+		{Content: "again", SenderID: bob.ID, ChatID: group.ID},
+		// messages of the user are read
+		{Content: "hi", SenderID: alice.ID, ChatID: own.ID},
+	} {
+		assert.NoError(t, messageService.CreateMessage(&message))
+		time.Sleep(time.Millisecond)
+	}
+
+	_, err := service.CreateFolder("alice", model.ChatFolderDto{Name: "empty", ExcludeRead: true})
+	assert.ErrorIs(t, err, ErrEmptyFolder)
+	_, err = service.CreateFolder("alice", model.ChatFolderDto{Name: "others", IncludedChatIDs: []uint{others.ID}})
+	assert.ErrorIs(t, err, ErrFolderChats)
+	_, err = service.CreateFolder("alice", model.ChatFolderDto{Name: "twice", IncludedChatIDs: []uint{own.ID}, ExcludedChatIDs: []uint{own.ID}})
+	assert.ErrorIs(t, err, ErrFolderChats)
+
+	unreadGroups, err := service.CreateFolder("alice", model.ChatFolderDto{
+		Name:            "Unread groups",
+		IncludeGroups:   true,
+		ExcludeMuted:    true,
+		ExcludeRead:     true,
+		ExcludeArchived: true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, unreadGroups.UnreadChats)
+	work, err := service.CreateFolder("alice", model.ChatFolderDto{
+		Name:            "Work",
+		IncludedChatIDs: []uint{own.ID},
+		ExcludedChatIDs: []uint{muted.ID},
+		IncludeGroups:   true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{own.ID}, work.IncludedChatIDs)
+	assert.Equal(t, 2, work.UnreadChats)
+
+	chatsResp, _, err := chatService.GetChats_ToResponse("alice", false, unreadGroups.ID, "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, chatsResp, 1)
+	assert.Equal(t, group.ID, chatsResp[0].ID)
+	assert.Equal(t, 2, chatsResp[0].Unread)
+
+	chatsResp, _, err = chatService.GetChats_ToResponse("alice", false, work.ID, "", 10)
+	assert.NoError(t, err)
+	ids := make([]uint, len(chatsResp))
+	for i := range chatsResp {
+		ids[i] = chatsResp[i].ID
+	}
+	assert.Equal(t, []uint{own.ID, group.ID, archived.ID}, ids)
+	assert.Equal(t, 0, chatsResp[0].Unread)
+
+	_, _, err = chatService.GetChats_ToResponse("bob", false, work.ID, "", 10)
+	assert.ErrorIs(t, err, ErrFolderNotFound)
+
+	// reading the chat takes it out of the unread folder
+	assert.ErrorIs(t, chatService.MarkChatRead("carol", group.ID), ErrNotChatMember)
+	assert.NoError(t, chatService.MarkChatRead("alice", group.ID))
+	chatsResp, _, err = chatService.GetChats_ToResponse("alice", false, unreadGroups.ID, "", 10)
+	assert.NoError(t, err)
+	assert.Empty(t, chatsResp)
+	chatResp, err := chatService.GetChat_ToResponse("alice", group.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, chatResp.Unread)
+
+	folders, err := service.GetFolders_ToResponse("alice")
+	assert.NoError(t, err)
+	assert.Len(t, folders, 2)
+	assert.Equal(t, 0, folders[0].UnreadChats)
+	assert.Equal(t, 1, folders[1].UnreadChats)
+
+	_, err = service.UpdateFolder("bob", work.ID, model.ChatFolderDto{Name: "mine", IncludeGroups: true})
+	assert.ErrorIs(t, err, ErrFolderNotFound)
+	work, err = service.UpdateFolder("alice", work.ID, model.ChatFolderDto{Name: "Private", IncludePrivate: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "Private", work.Name)
+	assert.Empty(t, work.IncludedChatIDs)
+	assert.False(t, work.IncludeGroups)
+	assert.Equal(t, 1, work.UnreadChats)
+
+	assert.ErrorIs(t, service.DeleteFolder("bob", work.ID), ErrFolderNotFound)
+	assert.NoError(t, service.DeleteFolder("alice", work.ID))
+	for i := 1; i < maxChatFolders; i++ {
+		_, err = service.CreateFolder("alice", model.ChatFolderDto{Name: "groups", IncludeGroups: true})
+		assert.NoError(t, err)
+	}
+	_, err = service.CreateFolder("alice", model.ChatFolderDto{Name: "groups", IncludeGroups: true})
+	assert.ErrorIs(t, err, ErrTooManyFolders)
+}
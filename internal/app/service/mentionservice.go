@@ -0,0 +1,66 @@
+package service
+
+import (
+	"slices"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/richtext"
+	"gorm.io/gorm"
+)
+
+// chatMentions returns mentions of members of the chat in the content, except the
+// sender and the ones in code or links. Users of the chat have to be preloaded.
+func chatMentions(chat model.Chat, senderID uint, content string, entities []richtext.Entity) []model.MessageMention {
+	mentions := make([]model.MessageMention, 0)
+	for _, mention := range model.ParseMentions(content) {
+		i := slices.IndexFunc(chat.Users, func(user model.User) bool { return user.Username == mention.Username })
+		if i < 0 || chat.Users[i].ID == senderID {
+			continue
+		}
+		inCodeOrLink := slices.ContainsFunc(entities, func(e richtext.Entity) bool {
+			return e.Type != richtext.Bold && e.Type != richtext.Italic && e.Type != richtext.Strikethrough &&
+				e.Offset < mention.Offset+mention.Length && mention.Offset < e.Offset+e.Length
+		})
+		if inCodeOrLink {
+			continue
+		}
+		mentions = append(mentions, model.MessageMention{
+			UserID: chat.Users[i].ID,
+			Offset: mention.Offset,
+			Length: mention.Length,
+		})
+	}
+	return mentions
+}
+
+// preloadMentions loads mentions of messages with the mentioned users
+func preloadMentions(db *gorm.DB) *gorm.DB {
+	return db.Preload("Mentions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("message_mentions.id")
+	}).Preload("Mentions.User", withDeletedUsers)
+}
+
+// countUnreadMentions returns the number of messages mentioning the user they
+// haven't read in every chat
+func countUnreadMentions(db *gorm.DB, userID uint, chatIDs []uint) (map[uint]int, error) {
+	var counts []struct {
+		ChatID uint
+		Unread int
+	}
+	resoult := db.Model(&model.MessageMention{}).
+		Select("messages.chat_id, COUNT(DISTINCT messages.id) AS unread").
+		Joins("JOIN messages ON messages.id = message_mentions.message_id AND messages.deleted_at IS NULL").
+		Scopes(notExpired).
+		Where("message_mentions.user_id = ? AND message_mentions.read = ? AND messages.chat_id IN ?", userID, false, chatIDs).
+		Group("messages.chat_id").
+		Scan(&counts)
+	if resoult.Error != nil {
+		return nil, resoult.Error
+	}
+
+	unread := make(map[uint]int, len(counts))
+	for _, count := range counts {
+		unread[count.ChatID] = count.Unread
+	}
+	return unread, nil
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMentions(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	messageService := NewMessageService(db, rdb)
+	chatService := NewChatService(db, rdb)
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	carol := model.User{Username: "carol"}
+	eve := model.User{Username: "eve"}
+	db.Create(&alice)
+	db.Create(&bob)
+	db.Create(&carol)
+	db.Create(&eve)
+	group := model.Chat{Name: "group", IsGroup: true, Users: []model.User{alice, bob, carol}}
+	db.Create(&group)
+
+	// eve is not a member, alice can't mention herself
+	message := model.Message{Content: "@bob @Carol @eve @alice look, @bob", SenderID: alice.ID, ChatID: group.ID}
+	assert.NoError(t, messageService.CreateMessage(&message))
+	resp := message.ToResponse()
+	assert.Equal(t, []model.MentionResponse{
+		{UserID: bob.ID, Username: "bob", Offset: 0, Length: 4},
+		{UserID: carol.ID, Username: "carol", Offset: 5, Length: 6},
+		{UserID: bob.ID, Username: "bob", Offset: 30, Length: 4},
+	}, resp.Mentions)
+
+	second := model.Message{Content: "@bob again", SenderID: carol.ID, ChatID: group.ID}
+	assert.NoError(t, messageService.CreateMessage(&second))
+
+	chatResp, err := chatService.GetChat_ToResponse("bob", group.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, chatResp.UnreadMentions)
+	assert.Len(t, chatResp.LastMessage.Mentions, 1)
+	chats, _, err := chatService.GetChats_ToResponse("carol", false, 0, "", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, chats[0].UnreadMentions)
+
+	assert.ErrorIs(t, chatService.ReadMentions("eve", group.ID), ErrNotChatMember)
+	assert.NoError(t, chatService.ReadMentions("bob", group.ID))
+	chatResp, err = chatService.GetChat_ToResponse("bob", group.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, chatResp.UnreadMentions)
+	chatResp, err = chatService.GetChat_ToResponse("carol", group.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, chatResp.UnreadMentions)
+}
@@ -1,19 +1,53 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/VitalyCone/websocket-messenger/internal/app/filter"
 	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/richtext"
 	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
+// Expired messages hard-deleted at once
+const expiredMessagesBatch = 500
 
+var (
+	ErrMessageRejected = errors.New("message rejected")
+	ErrSlowMode        = errors.New("slow mode is on")
+)
+
+// SlowModeError is ErrSlowMode with the time the sender has to wait
+type SlowModeError struct {
+	Cooldown time.Duration
+}
+
+func (e *SlowModeError) Error() string {
+	return fmt.Sprintf("%v, you can post again in %d seconds", ErrSlowMode, int(math.Ceil(e.Cooldown.Seconds())))
+}
+
+func (e *SlowModeError) Unwrap() error {
+	return ErrSlowMode
+}
 
 type MessageService struct {
 	db *gorm.DB
 	rdb *redis.Client
+	filters filter.Chain
+	previews previewQueue
+}
+
+// previewQueue takes messages whose links get a preview in the background
+type previewQueue interface {
+	Enqueue(message model.Message)
 }
 
 func NewMessageService(db *gorm.DB, rdb *redis.Client) *MessageService {
@@ -23,8 +57,28 @@ func NewMessageService(db *gorm.DB, rdb *redis.Client) *MessageService {
 	}
 }
 
+// UseFilters sets the content filters every new message goes through
+func (s *MessageService) UseFilters(filters filter.Chain) {
+	s.filters = filters
+}
+
+// UsePreviews sets the queue fetching previews of links of new messages
+func (s *MessageService) UsePreviews(previews previewQueue) {
+	s.previews = previews
+}
+
+// CreateMessage stores the message after the content filters. Rejected messages
+// return ErrMessageRejected with the reason, masked ones are stored masked.
 func (s *MessageService) CreateMessage(message *model.Message) error {
-	if message.Content == ""{
+	return s.CreateMessageWith(message, nil)
+}
+
+// CreateMessageWith creates the message like CreateMessage and runs also in the
+// transaction creating it, an error of also rolls the message back
+func (s *MessageService) CreateMessageWith(message *model.Message, also func(tx *gorm.DB) error) error {
+	// transfers may go without a comment
+	noContent := message.Kind == model.MessageTransfer
+	if message.Content == "" && !noContent {
 		return errors.New("invalid message")
 	}
 	if message.Sender.Username != "" && message.SenderID == 0 {
@@ -35,22 +89,206 @@ func (s *MessageService) CreateMessage(message *model.Message) error {
 		message.SenderID = user.ID
 		message.Sender = model.User{}
     }
-	resoult := s.db.Create(&message)
-	if resoult.Error != nil {
-		return resoult.Error
+
+	var chat model.Chat
+	if err := s.db.Preload("Users").Preload("Members").First(&chat, message.ChatID).Error; err != nil {
+		return fmt.Errorf("%w: %v", ErrChatNotFound, err)
+	}
+	if !chat.IsGroup {
+		companionIDs := make([]uint, 0, len(chat.Users))
+		for _, user := range chat.Users {
+			if user.ID != message.SenderID {
+				companionIDs = append(companionIDs, user.ID)
+			}
+		}
+		blocked, err := isBlockedByAny(s.db, companionIDs, message.SenderID)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			return ErrUserBlocked
+		}
 	}
-	if err := s.db.Preload("Sender").Preload("Chat").First(message, message.ID).Error; err != nil {
+
+	var threadRoot *model.Message
+	if message.ThreadRootID != nil {
+		root, err := getThreadRoot(s.db, chat, *message.ThreadRootID)
+		if err != nil {
+			return err
+		}
+		message.ThreadRootID = &root.ID
+		threadRoot = &root
+	}
+
+	content, entities := richtext.Parse(message.Content)
+	if strings.TrimSpace(content) == "" && !noContent {
+		return errors.New("invalid message")
+	}
+
+	var filtered filter.Result
+	if content != "" {
+		var err error
+		filtered, err = s.filters.Run(filter.Message{
+			ChatID:   message.ChatID,
+			SenderID: message.SenderID,
+			Content:  content,
+			SentAt:   time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		if filtered.Rejected != "" {
+			return fmt.Errorf("%w: %s", ErrMessageRejected, filtered.Rejected)
+		}
+		// masking keeps the length, so offsets of entities stay valid
+		content = filtered.Content
+	}
+	message.Content = content
+
+	entities, linkFlags, err := s.filterLinks(*message, entities)
+	if err != nil {
+		return err
+	}
+	filtered.Flags = append(filtered.Flags, linkFlags...)
+	if message.Poll != nil {
+		optionFlags, err := s.filterPollOptions(*message)
+		if err != nil {
+			return err
+		}
+		filtered.Flags = append(filtered.Flags, optionFlags...)
+	}
+	messageEntities := make([]model.MessageEntity, len(entities))
+	for i, entity := range entities {
+		messageEntities[i] = model.MessageEntity{
+			Type:   entity.Type,
+			Offset: entity.Offset,
+			Length: entity.Length,
+			URL:    entity.URL,
+		}
+	}
+
+	mentions := chatMentions(chat, message.SenderID, content, entities)
+
+	now := time.Now()
+	if chat.AutoDeleteSeconds > 0 {
+		expiresAt := now.Add(time.Duration(chat.AutoDeleteSeconds) * time.Second)
+		message.ExpiresAt = &expiresAt
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := takeSlowModeTurn(tx, chat, message.SenderID, now); err != nil {
+			return err
+		}
+		if err := tx.Create(message).Error; err != nil {
+			return err
+		}
+		if err := touchChat(tx, *message); err != nil {
+			return err
+		}
+		if len(messageEntities) > 0 {
+			for i := range messageEntities {
+				messageEntities[i].MessageID = message.ID
+			}
+			if err := tx.Create(&messageEntities).Error; err != nil {
+				return err
+			}
+		}
+		if len(mentions) > 0 {
+			for i := range mentions {
+				mentions[i].MessageID = message.ID
+			}
+			if err := tx.Create(&mentions).Error; err != nil {
+				return err
+			}
+		}
+		if threadRoot != nil {
+			if err := subscribeToThread(tx, threadRoot.SenderID, threadRoot.ID, 0); err != nil {
+				return err
+			}
+			if err := subscribeToThread(tx, message.SenderID, threadRoot.ID, message.ID); err != nil {
+				return err
+			}
+		}
+		if err := flagMessage(tx, *message, filtered.Flags); err != nil {
+			return err
+		}
+		if also != nil {
+			return also(tx)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.db.Preload("Sender", withDeletedUsers).Preload("Chat").Scopes(preloadTransfer, preloadPoll, preloadMentions, preloadEntities, preloadPreview).First(message, message.ID).Error; err != nil {
         return err
     }
+	if s.previews != nil {
+		s.previews.Enqueue(*message)
+	}
 	return nil
 }
 
+// filterLinks runs URLs of text links through the content filters on their own, as
+// they are not in the content. Links the filters don't let through are dropped
+// keeping their text, flags of the filters are returned.
+func (s *MessageService) filterLinks(message model.Message, entities []richtext.Entity) ([]richtext.Entity, []string, error) {
+	allowed := make([]richtext.Entity, 0, len(entities))
+	flags := make([]string, 0)
+	for _, entity := range entities {
+		if entity.Type != richtext.TextLink {
+			allowed = append(allowed, entity)
+			continue
+		}
+
+		filtered, err := s.filters.Run(filter.Message{
+			ChatID:   message.ChatID,
+			SenderID: message.SenderID,
+			Content:  entity.URL,
+			SentAt:   time.Now(),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		flags = append(flags, filtered.Flags...)
+		if filtered.Rejected == "" && filtered.Content == entity.URL {
+			allowed = append(allowed, entity)
+		}
+	}
+	return allowed, flags, nil
+}
+
+// filterPollOptions runs texts of the poll options through the content filters,
+// masking them in place. A rejected option rejects the whole message, flags of
+// the filters are returned.
+func (s *MessageService) filterPollOptions(message model.Message) ([]string, error) {
+	flags := make([]string, 0)
+	for i, option := range message.Poll.Options {
+		filtered, err := s.filters.Run(filter.Message{
+			ChatID:   message.ChatID,
+			SenderID: message.SenderID,
+			Content:  option.Text,
+			SentAt:   time.Now(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if filtered.Rejected != "" {
+			return nil, fmt.Errorf("%w: %s", ErrMessageRejected, filtered.Rejected)
+		}
+		message.Poll.Options[i].Text = filtered.Content
+		flags = append(flags, filtered.Flags...)
+	}
+	return flags, nil
+}
+
 func (s *MessageService) GetMessages(chatID uint, limit, offset int) ([]model.Message, error) {
 	messages := make([]model.Message, 0)
 	
 	resoult := s.db.Model(model.Message{}).
 		Preload("Chat").
-		Preload("Sender").
+		Preload("Sender", withDeletedUsers).
+		Scopes(preloadTransfer, preloadPoll, preloadMentions, preloadEntities, preloadPreview, notExpired, withoutReplies).
 		Where("chat_id = ?", chatID).
 		Offset(offset).
 		Limit(limit).
@@ -61,12 +299,18 @@ func (s *MessageService) GetMessages(chatID uint, limit, offset int) ([]model.Me
 	return messages, nil
 }
 
-func (s *MessageService) GetMessages_ToResponse(chatID uint, limit, offset int) ([]model.MessageResponse, error) {
+func (s *MessageService) GetMessages_ToResponse(username string, chatID uint, limit, offset int) ([]model.MessageResponse, error) {
+	viewer, err := getViewer(s.db, username)
+	if err != nil {
+		return nil, err
+	}
+
 	messages := make([]model.Message, 0)
 	
 	resoult := s.db.Model(model.Message{}).
 		Preload("Chat").
-		Preload("Sender").
+		Preload("Sender", withDeletedUsers).
+		Scopes(preloadTransfer, preloadPoll, preloadMentions, preloadEntities, preloadPreview, notExpired, withoutReplies).
 		Where("chat_id = ?", chatID).
 		Order("created_at DESC").
 		Offset(offset).
@@ -76,10 +320,158 @@ func (s *MessageService) GetMessages_ToResponse(chatID uint, limit, offset int)
 	if resoult.Error != nil {
 		return nil, resoult.Error
 	}
+	if err := loadThreadSummaries(s.db, messages); err != nil {
+		return nil, err
+	}
 	respMessages := make([]model.MessageResponse, len(messages))
 	for i, message := range messages {
-		respMessages[i] = message.ToResponse()
+		respMessages[i] = message.ToResponseFor(viewer)
 	}
 
 	return respMessages, nil
-}
\ No newline at end of file
+}
+
+// PurgeExpiredMessages hard-deletes disappearing messages whose time is up, in batches.
+// onExpired is called with the ids of deleted messages of every chat.
+func (s *MessageService) PurgeExpiredMessages(onExpired func(chatID uint, ids []uint)) (int, error) {
+	purged := 0
+	for {
+		messages := make([]model.Message, 0)
+		resoult := s.db.Unscoped().
+			Select("id", "chat_id", "poll_id").
+			Where("expires_at <= ?", time.Now()).
+			Order("expires_at").
+			Limit(expiredMessagesBatch).
+			Find(&messages)
+		if resoult.Error != nil {
+			return purged, resoult.Error
+		}
+		if len(messages) == 0 {
+			return purged, nil
+		}
+
+		ids := make([]uint, len(messages))
+		pollIDs := make([]uint, 0)
+		byChat := make(map[uint][]uint)
+		for i, message := range messages {
+			ids[i] = message.ID
+			if message.PollID != nil {
+				pollIDs = append(pollIDs, *message.PollID)
+			}
+			byChat[message.ChatID] = append(byChat[message.ChatID], message.ID)
+		}
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("root_id IN ?", ids).Delete(&model.ThreadSubscription{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("message_id IN ?", ids).Delete(&model.MessageMention{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("message_id IN ?", ids).Delete(&model.MessageEntity{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("message_id IN ?", ids).Delete(&model.LinkPreview{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Where("id IN ?", ids).Delete(&model.Message{}).Error; err != nil {
+				return err
+			}
+			chatIDs := make([]uint, 0, len(byChat))
+			for chatID := range byChat {
+				chatIDs = append(chatIDs, chatID)
+			}
+			if err := refreshLastMessages(tx, chatIDs); err != nil {
+				return err
+			}
+			if len(pollIDs) == 0 {
+				return nil
+			}
+			if err := tx.Where("poll_id IN ?", pollIDs).Delete(&model.PollVote{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("poll_id IN ?", pollIDs).Delete(&model.PollOption{}).Error; err != nil {
+				return err
+			}
+			return tx.Where("id IN ?", pollIDs).Delete(&model.Poll{}).Error
+		})
+		if err != nil {
+			return purged, err
+		}
+		purged += len(ids)
+
+		if onExpired != nil {
+			for chatID, chatIDs := range byChat {
+				onExpired(chatID, chatIDs)
+			}
+		}
+		if len(messages) < expiredMessagesBatch {
+			return purged, nil
+		}
+	}
+}
+
+// RunExpiryPurger purges expired messages every interval until ctx is done
+func (s *MessageService) RunExpiryPurger(ctx context.Context, interval time.Duration, onExpired func(chatID uint, ids []uint)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.PurgeExpiredMessages(onExpired); err != nil {
+				logrus.Errorf("failed to purge expired messages: %v", err)
+			}
+		}
+	}
+}
+
+// notExpired hides disappearing messages whose time is up but which are not purged yet
+func notExpired(db *gorm.DB) *gorm.DB {
+	return db.Where("messages.expires_at IS NULL OR messages.expires_at > ?", time.Now())
+}
+
+// preloadEntities loads formatting of messages
+func preloadEntities(db *gorm.DB) *gorm.DB {
+	return db.Preload("Entities", func(db *gorm.DB) *gorm.DB {
+		return db.Order("message_entities.id")
+	})
+}
+
+// withoutReplies leaves thread replies out of the chat timeline
+func withoutReplies(db *gorm.DB) *gorm.DB {
+	return db.Where("messages.thread_root_id IS NULL")
+}
+
+// takeSlowModeTurn records the post of a member of a chat in slow mode, it returns
+// SlowModeError if the member posted less than the interval ago. Chat admins are exempt.
+func takeSlowModeTurn(tx *gorm.DB, chat model.Chat, userID uint, now time.Time) error {
+	if !chat.IsGroup || chat.SlowModeSeconds <= 0 {
+		return nil
+	}
+	i := slices.IndexFunc(chat.Members, func(member model.UserChat) bool { return member.UserID == userID })
+	if i < 0 {
+		return ErrNotChatMember
+	}
+	if chat.Members[i].Role == model.ChatAdmin {
+		return nil
+	}
+	if cooldown := chat.SlowModeCooldown(userID, now); cooldown > 0 {
+		return &SlowModeError{Cooldown: cooldown}
+	}
+
+	// the condition keeps concurrent messages of the member from both passing
+	interval := time.Duration(chat.SlowModeSeconds) * time.Second
+	resoult := tx.Model(&model.UserChat{}).
+		Where("chat_id = ? AND user_id = ?", chat.ID, userID).
+		Where("last_posted_at IS NULL OR last_posted_at <= ?", now.Add(-interval)).
+		Update("last_posted_at", now)
+	if resoult.Error != nil {
+		return resoult.Error
+	}
+	if resoult.RowsAffected == 0 {
+		return &SlowModeError{Cooldown: interval}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"testing"
+	"time"
 
 	"github.com/VitalyCone/websocket-messenger/internal/app/model"
 	"github.com/go-redis/redismock/v9"
@@ -104,8 +105,93 @@ func TestGetMessages_ToResponse(t *testing.T) {
 	}
 	db.Create(&msg)
 
-	responses, err := service.GetMessages_ToResponse(chat.ID, 10, 0)
+	responses, err := service.GetMessages_ToResponse("carol", chat.ID, 10, 0)
 	assert.NoError(t, err)
 	assert.Len(t, responses, 1)
 	assert.Equal(t, "Hey", responses[0].Content)
-}
\ No newline at end of file
+}
+func TestDisappearingMessages(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewMessageService(db, rdb)
+	chatService := NewChatService(db, rdb)
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	eve := model.User{Username: "eve"}
+	db.Create(&alice)
+	db.Create(&bob)
+	db.Create(&eve)
+	chat := model.Chat{Name: "private", Users: []model.User{alice, bob}}
+	db.Create(&chat)
+
+	kept := model.Message{Content: "kept", SenderID: alice.ID, ChatID: chat.ID}
+	assert.NoError(t, service.CreateMessage(&kept))
+	assert.Nil(t, kept.ExpiresAt)
+
+	assert.ErrorIs(t, chatService.SetAutoDelete("eve", chat.ID, 60), ErrNotChatMember)
+	assert.NoError(t, chatService.SetAutoDelete("bob", chat.ID, 60))
+
+	disappearing := model.Message{Content: "secret", SenderID: alice.ID, ChatID: chat.ID}
+	assert.NoError(t, service.CreateMessage(&disappearing))
+	assert.NotNil(t, disappearing.ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), *disappearing.ExpiresAt, time.Second)
+
+	// expired messages are hidden before the purger deletes them
+	db.Model(&disappearing).Update("expires_at", time.Now().Add(-time.Second))
+	responses, err := service.GetMessages_ToResponse("alice", chat.ID, 10, 0)
+	assert.NoError(t, err)
+	assert.Len(t, responses, 1)
+	assert.Equal(t, "kept", responses[0].Content)
+
+	expired := make(map[uint][]uint)
+	purged, err := service.PurgeExpiredMessages(func(chatID uint, ids []uint) {
+		expired[chatID] = ids
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	assert.Equal(t, map[uint][]uint{chat.ID: {disappearing.ID}}, expired)
+
+	var count int64
+	db.Unscoped().Model(&model.Message{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	purged, err = service.PurgeExpiredMessages(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, purged)
+}
+
+func TestCreateMessage_RichText(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewMessageService(db, rdb)
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	carol := model.User{Username: "carol"}
+	db.Create(&alice)
+	db.Create(&bob)
+	db.Create(&carol)
+	group := model.Chat{Name: "group", IsGroup: true, Users: []model.User{alice, bob, carol}}
+	db.Create(&group)
+
+	message := model.Message{Content: "**hi** @bob, not `@carol`: https://example.com", SenderID: alice.ID, ChatID: group.ID}
+	assert.NoError(t, service.CreateMessage(&message))
+	assert.Equal(t, "hi @bob, not @carol: https://example.com", message.Content)
+
+	resp := message.ToResponse()
+	assert.Equal(t, []model.EntityResponse{
+		{Type: "bold", Offset: 0, Length: 2},
+		{Type: "code", Offset: 13, Length: 6},
+		{Type: "url", Offset: 21, Length: 19},
+	}, resp.Entities)
+	assert.Len(t, resp.Mentions, 1)
+	assert.Equal(t, "bob", resp.Mentions[0].Username)
+
+	messages, err := service.GetMessages_ToResponse("bob", group.ID, 10, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, resp.Entities, messages[0].Entities)
+
+	// nothing is left without markup
+	assert.Error(t, service.CreateMessage(&model.Message{Content: "``", SenderID: alice.ID, ChatID: group.ID}))
+}
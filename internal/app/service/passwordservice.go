@@ -0,0 +1,164 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/mailer"
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	resetTokenTTL   = 30 * time.Minute
+	resetTokenBytes = 32
+)
+
+var (
+	ErrWrongPassword     = errors.New("old password is incorrect")
+	ErrInvalidResetToken = errors.New("reset token is invalid or expired")
+)
+
+type PasswordService struct {
+	db       *gorm.DB
+	rdb      *redis.Client
+	mailer   mailer.Mailer
+	tokenKey string
+}
+
+func NewPasswordService(db *gorm.DB, rdb *redis.Client, mailer mailer.Mailer, tokenKey string) *PasswordService {
+	return &PasswordService{
+		db:       db,
+		rdb:      rdb,
+		mailer:   mailer,
+		tokenKey: tokenKey,
+	}
+}
+
+// ChangePassword verifies the old password, stores the new hash and revokes
+// every other session of the user. A fresh token for the caller is returned.
+func (s *PasswordService) ChangePassword(username, oldPassword, newPassword string) (interface{}, error) {
+	var user model.User
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return nil, err
+	}
+
+	if err := verifyPassword(user.PasswordHash, oldPassword); err != nil {
+		return nil, ErrWrongPassword
+	}
+
+	if err := s.setPassword(s.db, &user, newPassword); err != nil {
+		return nil, err
+	}
+	if err := invalidateUserCache(s.rdb, user.Username); err != nil {
+		return nil, err
+	}
+
+	return createToken(user, s.tokenKey)
+}
+
+// RequestPasswordReset mails a single-use reset token to the owner of email.
+// Unknown emails are ignored so the endpoint can't be used to probe accounts.
+func (s *PasswordService) RequestPasswordReset(email string) error {
+	var user model.User
+	resoult := s.db.Where("email = ?", strings.ToLower(email)).Limit(1).Find(&user)
+	if resoult.Error != nil {
+		return resoult.Error
+	}
+	if resoult.RowsAffected == 0 {
+		return nil
+	}
+
+	token, err := generateResetToken()
+	if err != nil {
+		return err
+	}
+
+	resetToken := model.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: hashResetToken(token),
+		ExpiresAt: time.Now().Add(resetTokenTTL),
+	}
+	if err := s.db.Create(&resetToken).Error; err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf(
+		"Hello, %s!\n\nUse this token to reset your password: %s\nIt expires in %d minutes and can be used once.\n",
+		user.Username, token, int(resetTokenTTL.Minutes()))
+	return s.mailer.Send(user.Email, "Password reset", body)
+}
+
+// ResetPassword consumes a reset token and sets the new password, it returns
+// the username of the account. All existing sessions of the user are revoked.
+func (s *PasswordService) ResetPassword(token, newPassword string) (string, error) {
+	var username string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var resetToken model.PasswordResetToken
+		resoult := tx.Preload("User").
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hashResetToken(token), time.Now()).
+			First(&resetToken)
+		if resoult.Error != nil {
+			if errors.Is(resoult.Error, gorm.ErrRecordNotFound) {
+				return ErrInvalidResetToken
+			}
+			return resoult.Error
+		}
+
+		now := time.Now()
+		used := tx.Model(&model.PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", resetToken.ID).
+			Update("used_at", now)
+		if used.Error != nil {
+			return used.Error
+		}
+		if used.RowsAffected == 0 {
+			return ErrInvalidResetToken
+		}
+
+		username = resetToken.User.Username
+		return s.setPassword(tx, &resetToken.User, newPassword)
+	})
+	if err != nil {
+		return "", err
+	}
+	// cleared after the commit, a request in between would cache the old password again
+	return username, invalidateUserCache(s.rdb, username)
+}
+
+// setPassword stores a new password hash and bumps the token version,
+// which invalidates every token issued before the change. The caller clears
+// the cached user once the change is committed.
+func (s *PasswordService) setPassword(db *gorm.DB, user *model.User, password string) error {
+	passHash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptSalt)
+	if err != nil {
+		return err
+	}
+
+	user.PasswordHash = string(passHash)
+	user.TokenVersion++
+	return db.Model(user).Updates(map[string]interface{}{
+		"password_hash": user.PasswordHash,
+		"token_version": user.TokenVersion,
+	}).Error
+}
+
+func generateResetToken() (string, error) {
+	b := make([]byte, resetTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/mailer"
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+func createTestUserWithPassword(t *testing.T, db *gorm.DB, username, email, password string) model.User {
+	t.Helper()
+	passHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	assert.NoError(t, err)
+	user := model.User{Username: username, Email: email, PasswordHash: string(passHash)}
+	assert.NoError(t, db.Create(&user).Error)
+	return user
+}
+
+func extractResetToken(t *testing.T, mail mailer.Mail) string {
+	t.Helper()
+	const prefix = "reset your password: "
+	i := strings.Index(mail.Body, prefix)
+	assert.NotEqual(t, -1, i)
+	return strings.Fields(mail.Body[i+len(prefix):])[0]
+}
+
+func TestChangePassword_Success(t *testing.T) {
+	db := setupTestDB()
+	rdb, mock := redismock.NewClientMock()
+	service := NewPasswordService(db, rdb, mailer.NewMemoryMailer(), testTokenKey)
+
+	createTestUserWithPassword(t, db, "alice", "", "oldpass")
+	mock.ExpectDel("user_alice").SetVal(1)
+
+	token, err := service.ChangePassword("alice", "oldpass", "newpass")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token)
+
+	var updated model.User
+	db.First(&updated, "username = ?", "alice")
+	assert.Equal(t, uint(1), updated.TokenVersion)
+	assert.NoError(t, verifyPassword(updated.PasswordHash, "newpass"))
+
+	claims, err := verifyToken(token.(string), testTokenKey)
+	assert.NoError(t, err)
+	assert.Equal(t, updated.TokenVersion, claims.TokenVersion)
+}
+
+func TestChangePassword_WrongOldPassword(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewPasswordService(db, rdb, mailer.NewMemoryMailer(), testTokenKey)
+
+	createTestUserWithPassword(t, db, "bob", "", "oldpass")
+
+	_, err := service.ChangePassword("bob", "wrong", "newpass")
+	assert.ErrorIs(t, err, ErrWrongPassword)
+}
+
+func TestRequestPasswordReset_UnknownEmail(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	memoryMailer := mailer.NewMemoryMailer()
+	service := NewPasswordService(db, rdb, memoryMailer, testTokenKey)
+
+	err := service.RequestPasswordReset("nobody@example.com")
+	assert.NoError(t, err)
+	assert.Len(t, memoryMailer.Mails(), 0)
+}
+
+func TestResetPassword_Success(t *testing.T) {
+	db := setupTestDB()
+	rdb, mock := redismock.NewClientMock()
+	memoryMailer := mailer.NewMemoryMailer()
+	service := NewPasswordService(db, rdb, memoryMailer, testTokenKey)
+
+	createTestUserWithPassword(t, db, "carol", "carol@example.com", "oldpass")
+
+	err := service.RequestPasswordReset("Carol@Example.com")
+	assert.NoError(t, err)
+	mail, ok := memoryMailer.Last()
+	assert.True(t, ok)
+	assert.Equal(t, "carol@example.com", mail.To)
+
+	mock.ExpectDel("user_carol").SetVal(1)
+	token := extractResetToken(t, mail)
+	username, err := service.ResetPassword(token, "newpass")
+	assert.NoError(t, err)
+	assert.Equal(t, "carol", username)
+
+	var updated model.User
+	db.First(&updated, "username = ?", "carol")
+	assert.NoError(t, verifyPassword(updated.PasswordHash, "newpass"))
+	assert.Equal(t, uint(1), updated.TokenVersion)
+
+	_, err = service.ResetPassword(token, "another")
+	assert.ErrorIs(t, err, ErrInvalidResetToken)
+}
+
+func TestResetPassword_Expired(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewPasswordService(db, rdb, mailer.NewMemoryMailer(), testTokenKey)
+
+	user := createTestUserWithPassword(t, db, "dave", "dave@example.com", "oldpass")
+	db.Create(&model.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: hashResetToken("expired"),
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+
+	_, err := service.ResetPassword("expired", "newpass")
+	assert.ErrorIs(t, err, ErrInvalidResetToken)
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/richtext"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// How long ahead a poll may be set to close
+const maxPollDuration = 365 * 24 * time.Hour
+
+var (
+	ErrPollNotFound      = errors.New("poll not found")
+	ErrPollClosed        = errors.New("poll is closed")
+	ErrInvalidPollOption = errors.New("invalid poll option")
+	ErrInvalidClosesAt   = errors.New("closesAt has to be in the future, within a year")
+	ErrPollForbidden     = errors.New("only the author of the poll or chat admins can close it")
+)
+
+type PollService struct {
+	db      *gorm.DB
+	rdb     *redis.Client
+	message Message
+}
+
+func NewPollService(db *gorm.DB, rdb *redis.Client, message Message) *PollService {
+	return &PollService{
+		db:      db,
+		rdb:     rdb,
+		message: message,
+	}
+}
+
+// CreatePoll posts a poll message to the group chat through MessageService,
+// the question is the content of the message
+func (s *PollService) CreatePoll(username string, createPollDto model.CreatePollDto) (model.Message, error) {
+	if createPollDto.ClosesAt != nil {
+		now := time.Now()
+		if !createPollDto.ClosesAt.After(now) || createPollDto.ClosesAt.After(now.Add(maxPollDuration)) {
+			return model.Message{}, ErrInvalidClosesAt
+		}
+	}
+
+	options := make([]model.PollOption, 0, len(createPollDto.Options))
+	for i, text := range createPollDto.Options {
+		text = strings.TrimSpace(richtext.Sanitize(text))
+		if text == "" || slices.ContainsFunc(options, func(o model.PollOption) bool { return o.Text == text }) {
+			return model.Message{}, ErrInvalidPollOption
+		}
+		options = append(options, model.PollOption{Position: i, Text: text})
+	}
+
+	var sender model.User
+	if err := s.db.Where(model.User{Username: username}).First(&sender).Error; err != nil {
+		return model.Message{}, err
+	}
+	var chat model.Chat
+	if err := s.db.First(&chat, createPollDto.ChatID).Error; err != nil {
+		return model.Message{}, err
+	}
+	if !chat.IsGroup {
+		return model.Message{}, ErrNotGroupChat
+	}
+	var count int64
+	s.db.Model(&model.UserChat{}).
+		Where("chat_id = ? AND user_id = ?", chat.ID, sender.ID).
+		Count(&count)
+	if count == 0 {
+		return model.Message{}, ErrNotChatMember
+	}
+
+	message := model.Message{
+		Content:      createPollDto.Question,
+		Kind:         model.MessagePoll,
+		ChatID:       chat.ID,
+		SenderID:     sender.ID,
+		ThreadRootID: createPollDto.ThreadRootID,
+		Poll: &model.Poll{
+			ChatID:    chat.ID,
+			CreatorID: sender.ID,
+			Multiple:  createPollDto.Multiple,
+			Anonymous: createPollDto.Anonymous,
+			ClosesAt:  createPollDto.ClosesAt,
+			Options:   options,
+		},
+	}
+	if err := s.message.CreateMessage(&message); err != nil {
+		return model.Message{}, err
+	}
+	return message, nil
+}
+
+// GetPollMessage returns the message of the poll with its tallies
+func (s *PollService) GetPollMessage(id uint) (model.Message, error) {
+	var message model.Message
+	resoult := s.db.
+		Preload("Sender", withDeletedUsers).
+		Scopes(preloadPoll, notExpired).
+		Where("poll_id = ?", id).
+		First(&message)
+	if resoult.Error != nil {
+		if errors.Is(resoult.Error, gorm.ErrRecordNotFound) {
+			return model.Message{}, ErrPollNotFound
+		}
+		return model.Message{}, resoult.Error
+	}
+	return message, nil
+}
+
+// Vote replaces choices of the user in the poll with the options
+func (s *PollService) Vote(username string, id uint, optionIDs []uint) (model.Poll, error) {
+	user, poll, err := s.getOpenPoll(username, id)
+	if err != nil {
+		return model.Poll{}, err
+	}
+
+	slices.Sort(optionIDs)
+	optionIDs = slices.Compact(optionIDs)
+	if len(optionIDs) == 0 || (!poll.Multiple && len(optionIDs) > 1) {
+		return model.Poll{}, ErrInvalidPollOption
+	}
+	votes := make([]model.PollVote, len(optionIDs))
+	for i, optionID := range optionIDs {
+		if !slices.ContainsFunc(poll.Options, func(o model.PollOption) bool { return o.ID == optionID }) {
+			return model.Poll{}, ErrInvalidPollOption
+		}
+		votes[i] = model.PollVote{PollID: poll.ID, OptionID: optionID, UserID: user.ID}
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// votes of the poll go one at a time, so concurrent votes of a user
+		// can't leave two choices in a single choice poll
+		var locked model.Poll
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, poll.ID).Error; err != nil {
+			return err
+		}
+		if locked.IsClosed(time.Now()) {
+			return ErrPollClosed
+		}
+		if err := tx.Where("poll_id = ? AND user_id = ?", poll.ID, user.ID).Delete(&model.PollVote{}).Error; err != nil {
+			return err
+		}
+		return tx.Omit("User").Create(&votes).Error
+	})
+	if err != nil {
+		return model.Poll{}, err
+	}
+	return s.getPoll(poll.ID)
+}
+
+// RetractVote removes every choice of the user in the poll
+func (s *PollService) RetractVote(username string, id uint) (model.Poll, error) {
+	user, poll, err := s.getOpenPoll(username, id)
+	if err != nil {
+		return model.Poll{}, err
+	}
+	resoult := s.db.Where("poll_id = ? AND user_id = ?", poll.ID, user.ID).Delete(&model.PollVote{})
+	if resoult.Error != nil {
+		return model.Poll{}, resoult.Error
+	}
+	return s.getPoll(poll.ID)
+}
+
+// ClosePoll stops the poll taking votes, only its author and chat admins can close it
+func (s *PollService) ClosePoll(username string, id uint) (model.Poll, error) {
+	user, poll, err := s.getOpenPoll(username, id)
+	if err != nil {
+		return model.Poll{}, err
+	}
+	if poll.CreatorID != user.ID {
+		if _, err := getChatAdmin(s.db, username, poll.ChatID); err != nil {
+			if errors.Is(err, ErrNotChatAdmin) {
+				return model.Poll{}, ErrPollForbidden
+			}
+			return model.Poll{}, err
+		}
+	}
+
+	resoult := s.db.Model(&model.Poll{}).
+		Where("id = ? AND closed_at IS NULL", poll.ID).
+		Update("closed_at", time.Now())
+	if resoult.Error != nil {
+		return model.Poll{}, resoult.Error
+	}
+	if resoult.RowsAffected == 0 {
+		return model.Poll{}, ErrPollClosed
+	}
+	return s.getPoll(poll.ID)
+}
+
+// getOpenPoll returns the poll if it takes votes and the user is a member of its chat
+func (s *PollService) getOpenPoll(username string, id uint) (model.User, model.Poll, error) {
+	var user model.User
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return model.User{}, model.Poll{}, err
+	}
+	message, err := s.GetPollMessage(id)
+	if err != nil {
+		return model.User{}, model.Poll{}, err
+	}
+
+	var count int64
+	s.db.Model(&model.UserChat{}).
+		Where("chat_id = ? AND user_id = ?", message.ChatID, user.ID).
+		Count(&count)
+	if count == 0 {
+		return model.User{}, model.Poll{}, ErrNotChatMember
+	}
+	if message.Poll.IsClosed(time.Now()) {
+		return model.User{}, model.Poll{}, ErrPollClosed
+	}
+	return user, *message.Poll, nil
+}
+
+func (s *PollService) getPoll(id uint) (model.Poll, error) {
+	message, err := s.GetPollMessage(id)
+	if err != nil {
+		return model.Poll{}, err
+	}
+	return *message.Poll, nil
+}
+
+// preloadPoll loads options and votes of poll messages
+func preloadPoll(db *gorm.DB) *gorm.DB {
+	return db.
+		Preload("Poll.Options", func(db *gorm.DB) *gorm.DB {
+			return db.Order("poll_options.position")
+		}).
+		Preload("Poll.Votes", func(db *gorm.DB) *gorm.DB {
+			return db.Order("poll_votes.created_at")
+		}).
+		Preload("Poll.Votes.User", withDeletedUsers)
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolls(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewPollService(db, rdb, NewMessageService(db, rdb))
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	carol := model.User{Username: "carol"}
+	dave := model.User{Username: "dave"}
+	db.Create(&alice)
+	db.Create(&bob)
+	db.Create(&carol)
+	db.Create(&dave)
+	group := model.Chat{Name: "group", IsGroup: true, Users: []model.User{alice, bob, carol}}
+	db.Create(&group)
+	private := model.Chat{Name: "private", Users: []model.User{alice, bob}}
+	db.Create(&private)
+
+	createPollDto := model.CreatePollDto{ChatID: group.ID, Question: "**Lunch?**", Options: []string{"pizza", "sushi", "salad"}}
+	_, err := service.CreatePoll("alice", model.CreatePollDto{ChatID: private.ID, Question: "?", Options: []string{"a", "b"}})
+	assert.ErrorIs(t, err, ErrNotGroupChat)
+	_, err = service.CreatePoll("dave", createPollDto)
+	assert.ErrorIs(t, err, ErrNotChatMember)
+	_, err = service.CreatePoll("alice", model.CreatePollDto{ChatID: group.ID, Question: "?", Options: []string{"a", " a "}})
+	assert.ErrorIs(t, err, ErrInvalidPollOption)
+	past := time.Now().Add(-time.Minute)
+	_, err = service.CreatePoll("alice", model.CreatePollDto{ChatID: group.ID, Question: "?", Options: []string{"a", "b"}, ClosesAt: &past})
+	assert.ErrorIs(t, err, ErrInvalidClosesAt)
+
+	message, err := service.CreatePoll("alice", createPollDto)
+	assert.NoError(t, err)
+	assert.Equal(t, model.MessagePoll, message.Kind)
+	assert.Equal(t, "Lunch?", message.Content)
+	assert.NotNil(t, message.Poll)
+	options := message.Poll.Options
+	assert.Len(t, options, 3)
+	assert.Equal(t, "pizza", options[0].Text)
+	pollID := message.Poll.ID
+
+	// single choice
+	_, err = service.Vote("bob", pollID, []uint{options[0].ID, options[1].ID})
+	assert.ErrorIs(t, err, ErrInvalidPollOption)
+	_, err = service.Vote("dave", pollID, []uint{options[0].ID})
+	assert.ErrorIs(t, err, ErrNotChatMember)
+	_, err = service.Vote("bob", pollID, []uint{999})
+	assert.ErrorIs(t, err, ErrInvalidPollOption)
+
+	_, err = service.Vote("bob", pollID, []uint{options[0].ID})
+	assert.NoError(t, err)
+	poll, err := service.Vote("carol", pollID, []uint{options[0].ID})
+	assert.NoError(t, err)
+	// voting again replaces the choice
+	poll, err = service.Vote("bob", pollID, []uint{options[1].ID})
+	assert.NoError(t, err)
+
+	pollResp := poll.ToResponse(bob.ID)
+	assert.Equal(t, 2, pollResp.TotalVoters)
+	assert.Equal(t, 1, pollResp.Options[0].Votes)
+	assert.Equal(t, []string{"carol"}, pollResp.Options[0].Voters)
+	assert.False(t, pollResp.Options[0].Chosen)
+	assert.True(t, pollResp.Options[1].Chosen)
+
+	poll, err = service.RetractVote("carol", pollID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, poll.ToResponse(0).Options[0].Votes)
+
+	_, err = service.ClosePoll("bob", pollID)
+	assert.ErrorIs(t, err, ErrPollForbidden)
+	poll, err = service.ClosePoll("alice", pollID)
+	assert.NoError(t, err)
+	assert.True(t, poll.ToResponse(0).Closed)
+	_, err = service.Vote("carol", pollID, []uint{options[2].ID})
+	assert.ErrorIs(t, err, ErrPollClosed)
+
+	// tallies of anonymous polls come without voters
+	createPollDto.Anonymous = true
+	createPollDto.Multiple = true
+	message, err = service.CreatePoll("alice", createPollDto)
+	assert.NoError(t, err)
+	options = message.Poll.Options
+	poll, err = service.Vote("bob", message.Poll.ID, []uint{options[0].ID, options[2].ID, options[0].ID})
+	assert.NoError(t, err)
+	pollResp = poll.ToResponse(bob.ID)
+	assert.Equal(t, 1, pollResp.TotalVoters)
+	assert.Equal(t, 1, pollResp.Options[2].Votes)
+	assert.Nil(t, pollResp.Options[2].Voters)
+	assert.True(t, pollResp.Options[2].Chosen)
+
+	messages, err := NewMessageService(db, rdb).GetMessages_ToResponse("carol", group.ID, 10, 0)
+	assert.NoError(t, err)
+	assert.NotNil(t, messages[0].Poll)
+	assert.Equal(t, 1, messages[0].Poll.TotalVoters)
+}
+
+func TestCreatePoll_Filters(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	messageService := NewMessageService(db, rdb)
+	service := NewPollService(db, rdb, messageService)
+
+	filters, err := newFilters(db, FilterConfig{
+		BannedWords:          []string{"darn"},
+		BannedWordsAction:    "mask",
+		BlockedDomains:       []string{"spam.com"},
+		BlockedDomainsAction: "reject",
+	})
+	assert.NoError(t, err)
+	messageService.UseFilters(filters)
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	db.Create(&alice)
+	db.Create(&bob)
+	group := model.Chat{Name: "group", IsGroup: true, Users: []model.User{alice, bob}}
+	db.Create(&group)
+
+	_, err = service.CreatePoll("alice", model.CreatePollDto{ChatID: group.ID, Question: "Where?", Options: []string{"here", "spam.com"}})
+	assert.ErrorIs(t, err, ErrMessageRejected)
+
+	message, err := service.CreatePoll("alice", model.CreatePollDto{ChatID: group.ID, Question: "Lunch?", Options: []string{"darn pizza", "sushi"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "**** pizza", message.Poll.Options[0].Text)
+
+	var count int64
+	db.Model(&model.Message{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/preview"
+	"github.com/VitalyCone/websocket-messenger/internal/app/richtext"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Links waiting for a worker, links of messages sent when it is full get no preview
+	previewQueueSize      = 1000
+	defaultPreviewWorkers = 4
+	previewCacheTTL       = 24 * time.Hour
+	// Pages without a preview are not fetched again for a while
+	previewFailureTTL = time.Hour
+)
+
+// previewJob is the link of a message waiting for its preview
+type previewJob struct {
+	messageID uint
+	link      string
+}
+
+type PreviewService struct {
+	db      *gorm.DB
+	rdb     *redis.Client
+	fetcher *preview.Fetcher
+	workers int
+	queue   chan previewJob
+}
+
+func NewPreviewService(db *gorm.DB, rdb *redis.Client, fetcher *preview.Fetcher, workers int) *PreviewService {
+	if workers <= 0 {
+		workers = defaultPreviewWorkers
+	}
+	return &PreviewService{
+		db:      db,
+		rdb:     rdb,
+		fetcher: fetcher,
+		workers: workers,
+		queue:   make(chan previewJob, previewQueueSize),
+	}
+}
+
+// Enqueue queues the preview of the first link of the message, it never blocks
+func (s *PreviewService) Enqueue(message model.Message) {
+	link := firstLink(message)
+	if link == "" {
+		return
+	}
+	select {
+	case s.queue <- previewJob{messageID: message.ID, link: link}:
+	default:
+		logrus.Warnf("preview queue is full, message %d gets no preview", message.ID)
+	}
+}
+
+// RunPreviewWorkers fetches previews of queued links until ctx is done.
+// onUpdated is called with every message which got a preview.
+func (s *PreviewService) RunPreviewWorkers(ctx context.Context, onUpdated func(message model.Message)) {
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-s.queue:
+					message, ok, err := s.attachPreview(ctx, job)
+					if err != nil {
+						logrus.Errorf("failed to attach preview to message %d: %v", job.messageID, err)
+						continue
+					}
+					if ok && onUpdated != nil {
+						onUpdated(message)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// attachPreview stores the preview of the link for the message, it reports
+// whether the message got one
+func (s *PreviewService) attachPreview(ctx context.Context, job previewJob) (model.Message, bool, error) {
+	page, err := s.getPreview(ctx, job.link)
+	if err != nil {
+		logrus.Debugf("no preview of %s: %v", job.link, err)
+		return model.Message{}, false, nil
+	}
+
+	linkPreview := model.LinkPreview{
+		MessageID:   job.messageID,
+		URL:         page.URL,
+		Title:       page.Title,
+		Description: page.Description,
+		Image:       page.Image,
+		SiteName:    page.SiteName,
+	}
+	// the message may have been deleted or expired meanwhile
+	var message model.Message
+	resoult := s.db.Scopes(notExpired).First(&message, job.messageID)
+	if resoult.Error != nil {
+		if errors.Is(resoult.Error, gorm.ErrRecordNotFound) {
+			return model.Message{}, false, nil
+		}
+		return model.Message{}, false, resoult.Error
+	}
+	if err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&linkPreview).Error; err != nil {
+		return model.Message{}, false, err
+	}
+
+	resoult = s.db.
+		Preload("Sender", withDeletedUsers).
+		Scopes(preloadTransfer, preloadPoll, preloadMentions, preloadEntities, preloadPreview).
+		First(&message, job.messageID)
+	if resoult.Error != nil {
+		return model.Message{}, false, resoult.Error
+	}
+	return message, true, nil
+}
+
+// getPreview returns the preview of the link, cached for every message sharing it
+func (s *PreviewService) getPreview(ctx context.Context, link string) (preview.Preview, error) {
+	sum := sha256.Sum256([]byte(link))
+	key := "preview_" + hex.EncodeToString(sum[:])
+
+	cached, err := s.rdb.Get(ctx, key).Result()
+	switch {
+	case err == nil && cached == "":
+		return preview.Preview{}, preview.ErrNoPreview
+	case err == nil:
+		var page preview.Preview
+		if err := json.Unmarshal([]byte(cached), &page); err == nil {
+			return page, nil
+		}
+	case !errors.Is(err, redis.Nil):
+		logrus.Errorf("failed to get cached preview: %v", err)
+	}
+
+	page, err := s.fetcher.Fetch(ctx, link)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.rdb.Set(ctx, key, "", previewFailureTTL)
+		}
+		return preview.Preview{}, err
+	}
+	data, err := json.Marshal(page)
+	if err != nil {
+		return preview.Preview{}, err
+	}
+	s.rdb.Set(ctx, key, data, previewCacheTTL)
+	return page, nil
+}
+
+// firstLink returns the first URL or text link of the message
+func firstLink(message model.Message) string {
+	text := []rune(message.Content)
+	for _, entity := range message.Entities {
+		switch entity.Type {
+		case richtext.TextLink:
+			return entity.URL
+		case richtext.URL:
+			if entity.Offset < 0 || entity.Offset+entity.Length > len(text) {
+				continue
+			}
+			link := string(text[entity.Offset : entity.Offset+entity.Length])
+			if !strings.Contains(link, "://") {
+				link = "http://" + link
+			}
+			return link
+		}
+	}
+	return ""
+}
+
+// preloadPreview loads the link preview of messages
+func preloadPreview(db *gorm.DB) *gorm.DB {
+	return db.Preload("Preview")
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/preview"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLinkPreviews(t *testing.T) {
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><meta property="og:title" content="Article"><meta property="og:description" content="About"></head></html>`))
+	}))
+	defer server.Close()
+
+	db := setupTestDB()
+	rdb, mock := redismock.NewClientMock()
+	previews := NewPreviewService(db, rdb, preview.NewFetcher(preview.Config{AllowPrivate: true}), 1)
+	messageService := NewMessageService(db, rdb)
+	messageService.UsePreviews(previews)
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	db.Create(&alice)
+	db.Create(&bob)
+	chat := model.Chat{Name: "chat", Users: []model.User{alice, bob}}
+	db.Create(&chat)
+
+	link := server.URL + "/article"
+	sum := sha256.Sum256([]byte(link))
+	key := "preview_" + hex.EncodeToString(sum[:])
+	data, _ := json.Marshal(preview.Preview{URL: link, Title: "Article", Description: "About"})
+
+	// messages without links are not queued
+	assert.NoError(t, messageService.CreateMessage(&model.Message{Content: "hello", SenderID: alice.ID, ChatID: chat.ID}))
+	assert.Len(t, previews.queue, 0)
+
+	message := model.Message{Content: "look " + link, SenderID: alice.ID, ChatID: chat.ID}
+	assert.NoError(t, messageService.CreateMessage(&message))
+	assert.Len(t, previews.queue, 1)
+
+	mock.ExpectGet(key).RedisNil()
+	mock.ExpectSet(key, data, previewCacheTTL).SetVal("OK")
+	updated, ok, err := previews.attachPreview(context.Background(), <-previews.queue)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, message.ID, updated.ID)
+	assert.Equal(t, &model.LinkPreviewResponse{URL: link, Title: "Article", Description: "About"}, updated.ToResponse().Preview)
+
+	messages, err := messageService.GetMessages_ToResponse("bob", chat.ID, 10, 0)
+	assert.NoError(t, err)
+	assert.NotNil(t, messages[0].Preview)
+
+	// the same link is taken from the cache
+	second := model.Message{Content: "[again](" + link + ")", SenderID: bob.ID, ChatID: chat.ID}
+	assert.NoError(t, messageService.CreateMessage(&second))
+	mock.ExpectGet(key).SetVal(string(data))
+	_, ok, err = previews.attachPreview(context.Background(), <-previews.queue)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 1, fetches)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLinkPreviews_PrivateAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><title>Internal</title></head></html>`))
+	}))
+	defer server.Close()
+
+	db := setupTestDB()
+	rdb, mock := redismock.NewClientMock()
+	previews := NewPreviewService(db, rdb, preview.NewFetcher(preview.Config{}), 1)
+
+	link := server.URL + "/admin"
+	sum := sha256.Sum256([]byte(link))
+	key := "preview_" + hex.EncodeToString(sum[:])
+
+	mock.ExpectGet(key).RedisNil()
+	mock.ExpectSet(key, "", previewFailureTTL).SetVal("OK")
+	_, ok, err := previews.attachPreview(context.Background(), previewJob{messageID: 1, link: link})
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFirstLink(t *testing.T) {
+	message := model.Message{
+		Content: "see www.example.com and example",
+		Entities: []model.MessageEntity{
+			{Type: "bold", Offset: 0, Length: 3},
+			{Type: "url", Offset: 4, Length: 15},
+		},
+	}
+	assert.Equal(t, "http://www.example.com", firstLink(message))
+
+	message.Entities = []model.MessageEntity{{Type: "text_link", Offset: 24, Length: 7, URL: "https://example.org"}}
+	assert.Equal(t, "https://example.org", firstLink(message))
+
+	assert.Equal(t, "", firstLink(model.Message{Content: "no links"}))
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+var ErrGroupInvitesRestricted = errors.New("user doesn't accept group invites from you")
+
+type PrivacyService struct {
+	db  *gorm.DB
+	rdb *redis.Client
+}
+
+func NewPrivacyService(db *gorm.DB, rdb *redis.Client) *PrivacyService {
+	return &PrivacyService{
+		db:  db,
+		rdb: rdb,
+	}
+}
+
+func (s *PrivacyService) GetPrivacy(username string) (model.PrivacySettings, error) {
+	var user model.User
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return model.PrivacySettings{}, err
+	}
+	return user.Privacy, nil
+}
+
+func (s *PrivacyService) UpdatePrivacy(username string, settings model.PrivacySettings) error {
+	err := s.db.Model(&model.User{}).
+		Where("username = ?", username).
+		Updates(map[string]interface{}{
+			"privacy_last_seen":     settings.LastSeen,
+			"privacy_profile_photo": settings.ProfilePhoto,
+			"privacy_group_invites": settings.GroupInvites,
+		}).
+		Error
+	if err != nil {
+		return err
+	}
+	return invalidateUserCache(s.rdb, username)
+}
+
+func (s *PrivacyService) GetViewer(username string) (model.Viewer, error) {
+	return getViewer(s.db, username)
+}
+
+// SplitAudience splits recipients into the ones the privacy option of the user
+// allows and the rest
+func (s *PrivacyService) SplitAudience(username, option string, recipients []string) ([]string, []string, error) {
+	var user model.User
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return nil, nil, err
+	}
+
+	contacts := make([]string, 0)
+	resoult := s.db.Model(&model.Contact{}).
+		Joins("JOIN users ON users.id = contacts.contact_id").
+		Where("contacts.owner_id = ?", user.ID).
+		Pluck("users.username", &contacts)
+	if resoult.Error != nil {
+		return nil, nil, resoult.Error
+	}
+
+	allowed := make([]string, 0, len(recipients))
+	denied := make([]string, 0)
+	for _, recipient := range recipients {
+		if user.Privacy.Allows(option, slices.Contains(contacts, recipient)) {
+			allowed = append(allowed, recipient)
+		} else {
+			denied = append(denied, recipient)
+		}
+	}
+	return allowed, denied, nil
+}
+
+// getViewer loads the user together with the users who have them in contacts
+func getViewer(db *gorm.DB, username string) (model.Viewer, error) {
+	var user model.User
+	if err := db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return model.Viewer{}, err
+	}
+
+	ownerIDs := make([]uint, 0)
+	resoult := db.Model(&model.Contact{}).
+		Where("contact_id = ?", user.ID).
+		Pluck("owner_id", &ownerIDs)
+	if resoult.Error != nil {
+		return model.Viewer{}, resoult.Error
+	}
+
+	viewer := model.Viewer{ID: user.ID, ContactOf: make(map[uint]bool, len(ownerIDs))}
+	for _, id := range ownerIDs {
+		viewer.ContactOf[id] = true
+	}
+	return viewer, nil
+}
+
+// checkGroupInvites returns ErrGroupInvitesRestricted if any of the invitees
+// doesn't let the inviter add them to groups
+func checkGroupInvites(db *gorm.DB, inviter model.User, invitees []model.User) error {
+	for _, invitee := range invitees {
+		if invitee.ID == inviter.ID {
+			continue
+		}
+		isContact, err := isContactOf(db, invitee.ID, inviter.ID)
+		if err != nil {
+			return err
+		}
+		if !invitee.Privacy.Allows(model.PrivacyGroupInvites, isContact) {
+			return fmt.Errorf("%w: %s", ErrGroupInvitesRestricted, invitee.Username)
+		}
+	}
+	return nil
+}
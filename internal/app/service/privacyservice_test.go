@@ -0,0 +1,106 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdatePrivacy(t *testing.T) {
+	db := setupTestDB()
+	rdb, mock := redismock.NewClientMock()
+	service := NewPrivacyService(db, rdb)
+
+	db.Create(&model.User{Username: "alice"})
+
+	settings, err := service.GetPrivacy("alice")
+	assert.NoError(t, err)
+	assert.Equal(t, model.PrivacyEverybody, settings.LastSeen)
+
+	mock.ExpectDel("user_alice").SetVal(1)
+	err = service.UpdatePrivacy("alice", model.PrivacySettings{
+		LastSeen:     model.PrivacyContacts,
+		ProfilePhoto: model.PrivacyNobody,
+		GroupInvites: model.PrivacyEverybody,
+	})
+	assert.NoError(t, err)
+
+	settings, err = service.GetPrivacy("alice")
+	assert.NoError(t, err)
+	assert.Equal(t, model.PrivacyContacts, settings.LastSeen)
+	assert.Equal(t, model.PrivacyNobody, settings.ProfilePhoto)
+}
+
+func TestSplitAudience(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewPrivacyService(db, rdb)
+
+	alice := model.User{Username: "alice", Privacy: model.PrivacySettings{LastSeen: model.PrivacyContacts}}
+	bob := model.User{Username: "bob"}
+	db.Create(&alice)
+	db.Create(&bob)
+	db.Create(&model.User{Username: "carol"})
+	db.Create(&model.Contact{OwnerID: alice.ID, ContactID: bob.ID})
+
+	allowed, denied, err := service.SplitAudience("alice", model.PrivacyLastSeen, []string{"bob", "carol"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bob"}, allowed)
+	assert.Equal(t, []string{"carol"}, denied)
+
+	allowed, _, err = service.SplitAudience("alice", model.PrivacyProfilePhoto, []string{"bob", "carol"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bob", "carol"}, allowed)
+}
+
+func TestGetChat_ToResponse_Privacy(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewChatService(db, rdb)
+
+	seen := time.Now()
+	alice := model.User{Username: "alice", Avatar: []byte{1}, LastSeenAt: &seen,
+		Privacy: model.PrivacySettings{LastSeen: model.PrivacyNobody, ProfilePhoto: model.PrivacyContacts}}
+	bob := model.User{Username: "bob"}
+	db.Create(&alice)
+	db.Create(&bob)
+	chat := model.Chat{Name: "private", Users: []model.User{alice, bob}}
+	db.Create(&chat)
+
+	resp, err := service.GetChat_ToResponse("bob", chat.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, resp.Users[0].Avatar)
+	assert.Nil(t, resp.Users[0].LastSeenAt)
+
+	db.Create(&model.Contact{OwnerID: alice.ID, ContactID: bob.ID})
+	resp, err = service.GetChat_ToResponse("bob", chat.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1}, resp.Users[0].Avatar)
+	assert.Nil(t, resp.Users[0].LastSeenAt)
+
+	resp, err = service.GetChat_ToResponse("alice", chat.ID)
+	assert.NoError(t, err)
+	assert.NotNil(t, resp.Users[0].LastSeenAt)
+}
+
+func TestCreateChat_GroupInvites(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewChatService(db, rdb)
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob", Privacy: model.PrivacySettings{GroupInvites: model.PrivacyContacts}}
+	db.Create(&alice)
+	db.Create(&bob)
+	db.Create(&model.User{Username: "carol"})
+
+	chat := model.Chat{Name: "group", IsGroup: true, Users: []model.User{{Username: "alice"}, {Username: "bob"}, {Username: "carol"}}}
+	assert.ErrorIs(t, service.CreateChat(&chat), ErrGroupInvitesRestricted)
+
+	db.Create(&model.Contact{OwnerID: bob.ID, ContactID: alice.ID})
+	chat = model.Chat{Name: "group", IsGroup: true, Users: []model.User{{Username: "alice"}, {Username: "bob"}, {Username: "carol"}}}
+	assert.NoError(t, service.CreateChat(&chat))
+}
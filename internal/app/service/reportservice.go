@@ -0,0 +1,331 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrReportTargetNotFound = errors.New("reported object not found")
+	ErrReportSelf           = errors.New("you can't report yourself")
+	ErrAlreadyReported      = errors.New("you have already reported it")
+	ErrReportNotFound       = errors.New("report not found")
+	ErrReportResolved       = errors.New("report is already resolved")
+	ErrInvalidResolution    = errors.New("action is not applicable to the report")
+)
+
+type ReportService struct {
+	db  *gorm.DB
+	rdb *redis.Client
+}
+
+func NewReportService(db *gorm.DB, rdb *redis.Client) *ReportService {
+	return &ReportService{
+		db:  db,
+		rdb: rdb,
+	}
+}
+
+// CreateReport files a report. Users can report only messages and chats they
+// can read themselves.
+func (s *ReportService) CreateReport(username string, createReportDto model.CreateReportDto) (model.Report, error) {
+	var reporter model.User
+	if err := s.db.Where(model.User{Username: username}).First(&reporter).Error; err != nil {
+		return model.Report{}, err
+	}
+
+	snapshot, err := s.snapshot(reporter, createReportDto.TargetType, createReportDto.TargetID)
+	if err != nil {
+		return model.Report{}, err
+	}
+
+	reported, err := hasOpenReport(s.db, reporter.ID, createReportDto.TargetType, createReportDto.TargetID)
+	if err != nil {
+		return model.Report{}, err
+	}
+	if reported {
+		return model.Report{}, ErrAlreadyReported
+	}
+
+	report := model.Report{
+		ReporterID: &reporter.ID,
+		Reporter:   &reporter,
+		TargetType: createReportDto.TargetType,
+		TargetID:   createReportDto.TargetID,
+		Reason:     createReportDto.Reason,
+		Comment:    createReportDto.Comment,
+		Snapshot:   snapshot,
+		Status:     model.ReportOpen,
+	}
+	if err := s.db.Omit("Reporter").Create(&report).Error; err != nil {
+		// a concurrent report of the same object is refused by the unique index
+		if reported, _ := hasOpenReport(s.db, reporter.ID, report.TargetType, report.TargetID); reported {
+			return model.Report{}, ErrAlreadyReported
+		}
+		return model.Report{}, err
+	}
+	return report, nil
+}
+
+// hasOpenReport reports whether the user has an open report of the object
+func hasOpenReport(db *gorm.DB, reporterID uint, targetType string, targetID uint) (bool, error) {
+	var count int64
+	err := db.Model(&model.Report{}).
+		Where("reporter_id = ? AND target_type = ? AND target_id = ? AND status = ?",
+			reporterID, targetType, targetID, model.ReportOpen).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// GetReports returns the moderation queue, oldest first. Empty status returns reports of every status.
+func (s *ReportService) GetReports(status string, offset, limit int) ([]model.ReportResponse, error) {
+	reports := make([]model.Report, 0)
+	query := s.db.
+		Preload("Reporter", withDeletedUsers).
+		Preload("ResolvedBy", withDeletedUsers)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	resoult := query.
+		Order("created_at").
+		Order("id").
+		Offset(offset).
+		Limit(limit).
+		Find(&reports)
+	if resoult.Error != nil {
+		return nil, resoult.Error
+	}
+
+	reportsResp := make([]model.ReportResponse, len(reports))
+	for i := range reports {
+		reportsResp[i] = reports[i].ToResponse()
+	}
+	return reportsResp, nil
+}
+
+func (s *ReportService) GetReport(id uint) (model.Report, error) {
+	var report model.Report
+	resoult := s.db.
+		Preload("Reporter", withDeletedUsers).
+		Preload("ResolvedBy", withDeletedUsers).
+		First(&report, id)
+	if resoult.Error != nil {
+		if errors.Is(resoult.Error, gorm.ErrRecordNotFound) {
+			return model.Report{}, ErrReportNotFound
+		}
+		return model.Report{}, resoult.Error
+	}
+	return report, nil
+}
+
+// ResolveReport closes the report and other open reports of the same object,
+// deleting the message and suspending its author if asked to
+func (s *ReportService) ResolveReport(adminUsername string, id uint, resolveReportDto model.ResolveReportDto) (model.ReportResolution, error) {
+	admin, err := getAdmin(s.db, adminUsername)
+	if err != nil {
+		return model.ReportResolution{}, err
+	}
+
+	report, err := s.GetReport(id)
+	if err != nil {
+		return model.ReportResolution{}, err
+	}
+	if err := checkResolution(report, resolveReportDto); err != nil {
+		return model.ReportResolution{}, err
+	}
+
+	// the claim, the actions and the audit log go in one transaction, so a
+	// failed action leaves the report open and two admins don't apply actions twice
+	var resolution model.ReportResolution
+	now := time.Now()
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		resoult := tx.Model(&model.Report{}).
+			Where("id = ? AND status = ?", report.ID, model.ReportOpen).
+			Updates(map[string]interface{}{
+				"status":         resolveReportDto.Status,
+				"resolved_by_id": admin.ID,
+				"resolution":     resolveReportDto.Note,
+				"resolved_at":    &now,
+			})
+		if resoult.Error != nil {
+			return resoult.Error
+		}
+		if resoult.RowsAffected == 0 {
+			return ErrReportResolved
+		}
+
+		var err error
+		resolution, err = s.applyActions(tx, adminUsername, report, resolveReportDto)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&model.Report{}).
+			Where("target_type = ? AND target_id = ? AND status = ?", report.TargetType, report.TargetID, model.ReportOpen).
+			Updates(map[string]interface{}{
+				"status":         resolveReportDto.Status,
+				"resolved_by_id": admin.ID,
+				"resolution":     resolveReportDto.Note,
+				"resolved_at":    &now,
+			}).Error
+		if err != nil {
+			return err
+		}
+
+		details := []string{resolveReportDto.Status}
+		if resolveReportDto.DeleteMessage {
+			details = append(details, "message deleted")
+		}
+		if resolveReportDto.SuspendUser {
+			details = append(details, "user suspended")
+		}
+		return writeAuditLog(tx, admin, model.AuditResolveReport, "report", report.ID, strings.Join(details, ", "))
+	})
+	if err != nil {
+		return model.ReportResolution{}, err
+	}
+	// the suspension is committed by now, a failure to clear the cache
+	// doesn't undo it
+	if resolution.SuspendedUser != "" {
+		if err := invalidateUserCache(s.rdb, resolution.SuspendedUser); err != nil {
+			logrus.Errorf("failed to clear cache of suspended user %s: %v", resolution.SuspendedUser, err)
+		}
+	}
+
+	resolution.Report, err = s.GetReport(report.ID)
+	return resolution, err
+}
+
+// applyActions applies the actions of the dto in the transaction
+func (s *ReportService) applyActions(tx *gorm.DB, adminUsername string, report model.Report, resolveReportDto model.ResolveReportDto) (model.ReportResolution, error) {
+	var resolution model.ReportResolution
+	adminService := &AdminService{db: tx, rdb: s.rdb, inTransaction: true}
+	reason := fmt.Sprintf("report #%d: %s", report.ID, report.Reason)
+
+	if resolveReportDto.SuspendUser {
+		var user model.User
+		if report.TargetType == model.ReportMessage {
+			var message model.Message
+			if err := tx.Unscoped().Preload("Sender").First(&message, report.TargetID).Error; err != nil {
+				return resolution, err
+			}
+			user = message.Sender
+		} else if err := tx.First(&user, report.TargetID).Error; err != nil {
+			return resolution, err
+		}
+
+		err := adminService.SuspendUser(adminUsername, user.Username, reason)
+		if err != nil {
+			return resolution, err
+		}
+		resolution.SuspendedUser = user.Username
+	}
+
+	if resolveReportDto.DeleteMessage {
+		message, err := adminService.DeleteMessage(adminUsername, report.TargetID, reason)
+		if err != nil && !errors.Is(err, ErrMessageNotFound) {
+			return resolution, err
+		}
+		if err == nil {
+			resolution.DeletedMessage = &message
+		}
+	}
+	return resolution, nil
+}
+
+// checkResolution returns ErrInvalidResolution if actions of the dto can't be applied to the report
+func checkResolution(report model.Report, resolveReportDto model.ResolveReportDto) error {
+	hasActions := resolveReportDto.DeleteMessage || resolveReportDto.SuspendUser
+	switch {
+	case hasActions && resolveReportDto.Status == model.ReportDismissed:
+		return ErrInvalidResolution
+	case resolveReportDto.DeleteMessage && report.TargetType != model.ReportMessage:
+		return ErrInvalidResolution
+	case resolveReportDto.SuspendUser && report.TargetType == model.ReportChat:
+		return ErrInvalidResolution
+	}
+	return nil
+}
+
+// snapshot returns the reported object as JSON. Messages and chats have to be
+// visible to the reporter.
+func (s *ReportService) snapshot(reporter model.User, targetType string, targetID uint) (string, error) {
+	var data interface{}
+	switch targetType {
+	case model.ReportMessage:
+		var message model.Message
+		resoult := s.db.
+			Preload("Sender", withDeletedUsers).
+			Joins("JOIN user_chats ON user_chats.chat_id = messages.chat_id AND user_chats.user_id = ?", reporter.ID).
+			First(&message, targetID)
+		if resoult.Error != nil {
+			return "", ErrReportTargetNotFound
+		}
+		return messageSnapshot(message)
+	case model.ReportChat:
+		var chat model.Chat
+		resoult := s.db.
+			Preload("Users").
+			Joins("JOIN user_chats ON user_chats.chat_id = chats.id AND user_chats.user_id = ?", reporter.ID).
+			First(&chat, targetID)
+		if resoult.Error != nil {
+			return "", ErrReportTargetNotFound
+		}
+		members := make([]string, len(chat.Users))
+		for i, user := range chat.Users {
+			members[i] = user.Username
+		}
+		data = struct {
+			ID      uint     `json:"id"`
+			Name    string   `json:"name"`
+			IsGroup bool     `json:"isGroup"`
+			Members []string `json:"members"`
+		}{chat.ID, chat.Name, chat.IsGroup, members}
+	case model.ReportUser:
+		if targetID == reporter.ID {
+			return "", ErrReportSelf
+		}
+		var user model.User
+		if err := s.db.First(&user, targetID).Error; err != nil {
+			return "", ErrReportTargetNotFound
+		}
+		data = struct {
+			ID         uint   `json:"id"`
+			Username   string `json:"username"`
+			FirstName  string `json:"firstname"`
+			SecondName string `json:"secondname"`
+		}{user.ID, user.Username, user.FirstName, user.SecondName}
+	default:
+		return "", ErrReportTargetNotFound
+	}
+
+	snapshot, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	return string(snapshot), nil
+}
+
+// messageSnapshot returns the message with its sender preloaded as JSON
+func messageSnapshot(message model.Message) (string, error) {
+	snapshot, err := json.Marshal(struct {
+		ID        uint      `json:"id"`
+		ChatID    uint      `json:"chatId"`
+		Sender    string    `json:"sender"`
+		SenderID  uint      `json:"senderId"`
+		Content   string    `json:"content"`
+		CreatedAt time.Time `json:"createdAt"`
+	}{message.ID, message.ChatID, message.Sender.Username, message.SenderID, message.Content, message.CreatedAt})
+	if err != nil {
+		return "", err
+	}
+	return string(snapshot), nil
+}
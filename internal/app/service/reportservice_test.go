@@ -0,0 +1,169 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestCreateReport(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewReportService(db, rdb)
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	eve := model.User{Username: "eve"}
+	db.Create(&alice)
+	db.Create(&bob)
+	db.Create(&eve)
+	chat := model.Chat{Name: "private", Users: []model.User{alice, bob}}
+	db.Create(&chat)
+	message := model.Message{Content: "spam", SenderID: alice.ID, ChatID: chat.ID}
+	db.Create(&message)
+
+	dto := model.CreateReportDto{TargetType: model.ReportMessage, TargetID: message.ID, Reason: "spam"}
+	report, err := service.CreateReport("bob", dto)
+	assert.NoError(t, err)
+	assert.Equal(t, model.ReportOpen, report.Status)
+	assert.Contains(t, report.Snapshot, `"content":"spam"`)
+
+	_, err = service.CreateReport("bob", dto)
+	assert.ErrorIs(t, err, ErrAlreadyReported)
+	// the index refuses duplicates the check above misses in a race
+	duplicate := model.Report{ReporterID: &bob.ID, TargetType: model.ReportMessage, TargetID: message.ID, Reason: "spam", Status: model.ReportOpen}
+	assert.Error(t, db.Omit("Reporter").Create(&duplicate).Error)
+	_, err = service.CreateReport("eve", dto)
+	assert.ErrorIs(t, err, ErrReportTargetNotFound)
+	_, err = service.CreateReport("bob", model.CreateReportDto{TargetType: model.ReportUser, TargetID: bob.ID, Reason: "spam"})
+	assert.ErrorIs(t, err, ErrReportSelf)
+
+	// snapshot survives edits and deletion of the message
+	db.Model(&message).Update("content", "edited")
+	db.Delete(&message)
+	report, err = service.GetReport(report.ID)
+	assert.NoError(t, err)
+	assert.Contains(t, report.Snapshot, `"content":"spam"`)
+	assert.Equal(t, "bob", report.ToResponse().Reporter)
+}
+
+func TestResolveReport(t *testing.T) {
+	db := setupTestDB()
+	rdb, mock := redismock.NewClientMock()
+	service := NewReportService(db, rdb)
+
+	db.Create(&model.User{Username: "root", Role: model.RoleAdmin})
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	carol := model.User{Username: "carol"}
+	db.Create(&alice)
+	db.Create(&bob)
+	db.Create(&carol)
+	chat := model.Chat{Name: "group", IsGroup: true, Users: []model.User{alice, bob, carol}}
+	db.Create(&chat)
+	message := model.Message{Content: "spam", SenderID: alice.ID, ChatID: chat.ID}
+	db.Create(&message)
+
+	dto := model.CreateReportDto{TargetType: model.ReportMessage, TargetID: message.ID, Reason: "spam"}
+	first, err := service.CreateReport("bob", dto)
+	assert.NoError(t, err)
+	second, err := service.CreateReport("carol", dto)
+	assert.NoError(t, err)
+
+	_, err = service.ResolveReport("root", first.ID, model.ResolveReportDto{Status: model.ReportDismissed, DeleteMessage: true})
+	assert.ErrorIs(t, err, ErrInvalidResolution)
+
+	mock.ExpectDel("user_alice").SetVal(1)
+	resolution, err := service.ResolveReport("root", first.ID, model.ResolveReportDto{
+		Status:        model.ReportActioned,
+		DeleteMessage: true,
+		SuspendUser:   true,
+		Note:          "spammer",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, model.ReportActioned, resolution.Report.Status)
+	assert.Equal(t, "root", resolution.Report.ResolvedBy.Username)
+	assert.Equal(t, "alice", resolution.SuspendedUser)
+	assert.NotNil(t, resolution.DeletedMessage)
+
+	var count int64
+	db.Model(&model.Message{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+	db.First(&alice, alice.ID)
+	assert.NotNil(t, alice.SuspendedAt)
+
+	// duplicate reports of the same message are closed too
+	second, err = service.GetReport(second.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.ReportActioned, second.Status)
+
+	_, err = service.ResolveReport("root", first.ID, model.ResolveReportDto{Status: model.ReportDismissed})
+	assert.ErrorIs(t, err, ErrReportResolved)
+
+	reports, err := service.GetReports(model.ReportOpen, 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, reports, 0)
+}
+
+func TestResolveReport_FailedAction(t *testing.T) {
+	db := setupTestDB()
+	rdb, mock := redismock.NewClientMock()
+	service := NewReportService(db, rdb)
+
+	db.Create(&model.User{Username: "root", Role: model.RoleAdmin})
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	db.Create(&alice)
+	db.Create(&bob)
+	chat := model.Chat{Name: "private", Users: []model.User{alice, bob}}
+	db.Create(&chat)
+	message := model.Message{Content: "spam", SenderID: alice.ID, ChatID: chat.ID}
+	db.Create(&message)
+
+	report, err := service.CreateReport("bob", model.CreateReportDto{TargetType: model.ReportMessage, TargetID: message.ID, Reason: "spam"})
+	assert.NoError(t, err)
+
+	// the audit log of the resolution is written last
+	db.Callback().Create().Before("gorm:create").Register("test:fail_resolution_log", func(tx *gorm.DB) {
+		if log, ok := tx.Statement.Dest.(*model.AdminAuditLog); ok && log.Action == model.AuditResolveReport {
+			tx.AddError(errors.New("disk is full"))
+		}
+	})
+	_, err = service.ResolveReport("root", report.ID, model.ResolveReportDto{
+		Status:        model.ReportActioned,
+		DeleteMessage: true,
+		SuspendUser:   true,
+	})
+	assert.Error(t, err)
+	db.Callback().Create().Remove("test:fail_resolution_log")
+
+	// nothing of the resolution is left behind, the cache is kept too
+	assert.NoError(t, mock.ExpectationsWereMet())
+	report, err = service.GetReport(report.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.ReportOpen, report.Status)
+	db.First(&alice, alice.ID)
+	assert.Nil(t, alice.SuspendedAt)
+	var count int64
+	db.Model(&model.Message{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+	db.Model(&model.AdminAuditLog{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	// the cache is cleared once the suspension is committed, failing to do it
+	// doesn't undo the resolution
+	mock.ExpectDel("user_alice").SetErr(errors.New("redis is down"))
+	resolution, err := service.ResolveReport("root", report.ID, model.ResolveReportDto{
+		Status:      model.ReportActioned,
+		SuspendUser: true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", resolution.SuspendedUser)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	db.First(&alice, alice.ID)
+	assert.NotNil(t, alice.SuspendedAt)
+}
@@ -0,0 +1,358 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	// How long a replica may send a claimed message before others take it over
+	scheduledMessageLease = time.Minute
+	// Due messages claimed by a replica at once
+	scheduledMessagesBatch = 100
+	// Pending messages a user may have
+	maxScheduledMessages = 100
+	maxScheduleAhead     = 365 * 24 * time.Hour
+)
+
+var (
+	ErrScheduledNotFound   = errors.New("scheduled message not found")
+	ErrScheduledNotPending = errors.New("scheduled message is already being sent")
+	ErrInvalidSendAt       = errors.New("sendAt has to be in the future, within a year")
+	ErrTooManyScheduled    = errors.New("too many scheduled messages")
+)
+
+type ScheduleService struct {
+	db      *gorm.DB
+	rdb     *redis.Client
+	message Message
+}
+
+func NewScheduleService(db *gorm.DB, rdb *redis.Client, message Message) *ScheduleService {
+	return &ScheduleService{
+		db:      db,
+		rdb:     rdb,
+		message: message,
+	}
+}
+
+// ScheduleMessage stores the message to be sent at createMessageDto.SendAt
+func (s *ScheduleService) ScheduleMessage(username string, createMessageDto model.CreateMessageDto) (model.ScheduledMessage, error) {
+	if createMessageDto.Content == "" {
+		return model.ScheduledMessage{}, errors.New("invalid message")
+	}
+	if createMessageDto.SendAt == nil || !isValidSendAt(*createMessageDto.SendAt) {
+		return model.ScheduledMessage{}, ErrInvalidSendAt
+	}
+
+	var sender model.User
+	if err := s.db.Where(model.User{Username: username}).First(&sender).Error; err != nil {
+		return model.ScheduledMessage{}, err
+	}
+
+	var count int64
+	s.db.Model(&model.UserChat{}).
+		Where("chat_id = ? AND user_id = ?", createMessageDto.ChatID, sender.ID).
+		Count(&count)
+	if count == 0 {
+		return model.ScheduledMessage{}, ErrNotChatMember
+	}
+
+	s.db.Model(&model.ScheduledMessage{}).
+		Where("sender_id = ? AND status = ?", sender.ID, model.ScheduledPending).
+		Count(&count)
+	if count >= maxScheduledMessages {
+		return model.ScheduledMessage{}, ErrTooManyScheduled
+	}
+
+	scheduled := model.ScheduledMessage{
+		SenderID:     sender.ID,
+		ChatID:       createMessageDto.ChatID,
+		Content:      createMessageDto.Content,
+		ThreadRootID: createMessageDto.ThreadRootID,
+		SendAt:       *createMessageDto.SendAt,
+		Status:       model.ScheduledPending,
+	}
+	if err := s.db.Create(&scheduled).Error; err != nil {
+		return model.ScheduledMessage{}, err
+	}
+	return scheduled, nil
+}
+
+// GetScheduledMessages returns messages of the user which are not sent yet,
+// in every chat if chatID is 0
+func (s *ScheduleService) GetScheduledMessages(username string, chatID uint) ([]model.ScheduledMessageResponse, error) {
+	scheduled := make([]model.ScheduledMessage, 0)
+	query := s.db.
+		Joins("JOIN users ON users.id = scheduled_messages.sender_id").
+		Where("users.username = ? AND scheduled_messages.status <> ?", username, model.ScheduledSent)
+	if chatID != 0 {
+		query = query.Where("scheduled_messages.chat_id = ?", chatID)
+	}
+	resoult := query.
+		Order("scheduled_messages.send_at").
+		Find(&scheduled)
+	if resoult.Error != nil {
+		return nil, resoult.Error
+	}
+
+	scheduledResp := make([]model.ScheduledMessageResponse, len(scheduled))
+	for i := range scheduled {
+		scheduledResp[i] = scheduled[i].ToResponse()
+	}
+	return scheduledResp, nil
+}
+
+// ModifyScheduledMessage changes the content or the time of a message which is
+// not sent yet. Failed messages are scheduled again.
+func (s *ScheduleService) ModifyScheduledMessage(username string, id uint, modifyDto model.ModifyScheduledMessageDto) (model.ScheduledMessage, error) {
+	scheduled, err := s.getScheduledMessage(username, id)
+	if err != nil {
+		return model.ScheduledMessage{}, err
+	}
+
+	updates := map[string]interface{}{
+		"status": model.ScheduledPending,
+		"error":  "",
+	}
+	if modifyDto.Content != nil {
+		if *modifyDto.Content == "" {
+			return model.ScheduledMessage{}, errors.New("invalid message")
+		}
+		updates["content"] = *modifyDto.Content
+	}
+	if modifyDto.SendAt != nil {
+		if !isValidSendAt(*modifyDto.SendAt) {
+			return model.ScheduledMessage{}, ErrInvalidSendAt
+		}
+		updates["send_at"] = *modifyDto.SendAt
+	}
+
+	// the scheduler may have claimed the message meanwhile
+	resoult := s.db.Model(&model.ScheduledMessage{}).
+		Where("id = ? AND status IN ?", scheduled.ID, []string{model.ScheduledPending, model.ScheduledFailed}).
+		Updates(updates)
+	if resoult.Error != nil {
+		return model.ScheduledMessage{}, resoult.Error
+	}
+	if resoult.RowsAffected == 0 {
+		return model.ScheduledMessage{}, ErrScheduledNotPending
+	}
+
+	return s.getScheduledMessage(username, id)
+}
+
+// CancelScheduledMessage deletes a message which is not sent yet
+func (s *ScheduleService) CancelScheduledMessage(username string, id uint) error {
+	scheduled, err := s.getScheduledMessage(username, id)
+	if err != nil {
+		return err
+	}
+
+	resoult := s.db.
+		Where("id = ? AND status IN ?", scheduled.ID, []string{model.ScheduledPending, model.ScheduledFailed}).
+		Delete(&model.ScheduledMessage{})
+	if resoult.Error != nil {
+		return resoult.Error
+	}
+	if resoult.RowsAffected == 0 {
+		return ErrScheduledNotPending
+	}
+	return nil
+}
+
+// SendDueMessages claims due messages and creates them through MessageService.
+// Messages of replicas which didn't finish sending in time are taken over.
+// onSent is called with every created message, onFailed with every message
+// that can't be sent. Messages hitting slow mode are postponed until the cooldown ends.
+func (s *ScheduleService) SendDueMessages(onSent func(message model.Message), onFailed func(scheduled model.ScheduledMessage)) (int, error) {
+	ids := make([]uint, 0)
+	resoult := s.db.Model(&model.ScheduledMessage{}).
+		Scopes(dueScheduled(time.Now())).
+		Order("send_at").
+		Limit(scheduledMessagesBatch).
+		Pluck("id", &ids)
+	if resoult.Error != nil {
+		return 0, resoult.Error
+	}
+
+	sent := 0
+	for _, id := range ids {
+		leaseToken, err := generateLeaseToken()
+		if err != nil {
+			return sent, err
+		}
+		// claim the message, another replica may have done it already. The lease
+		// starts with the claim, sending the batch may take a while.
+		now := time.Now()
+		resoult := s.db.Model(&model.ScheduledMessage{}).
+			Where("id = ?", id).
+			Scopes(dueScheduled(now)).
+			Updates(map[string]interface{}{
+				"status":       model.ScheduledSending,
+				"locked_until": now.Add(scheduledMessageLease),
+				"lease_token":  leaseToken,
+			})
+		if resoult.Error != nil {
+			return sent, resoult.Error
+		}
+		if resoult.RowsAffected == 0 {
+			continue
+		}
+
+		var scheduled model.ScheduledMessage
+		if err := s.db.Preload("Sender", withDeletedUsers).First(&scheduled, id).Error; err != nil {
+			return sent, err
+		}
+		scheduled.LeaseToken = leaseToken
+		ok, err := s.send(scheduled, onSent, onFailed)
+		if err != nil {
+			return sent, err
+		}
+		if ok {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// send creates the claimed message, it reports whether the message was created.
+// The message is created only if the claim still holds, marking it sent in the
+// same transaction, so a message taken over by another replica isn't posted twice.
+func (s *ScheduleService) send(scheduled model.ScheduledMessage, onSent func(message model.Message), onFailed func(scheduled model.ScheduledMessage)) (bool, error) {
+	claimed := func(db *gorm.DB) *gorm.DB {
+		return db.Model(&model.ScheduledMessage{}).
+			Where("id = ? AND status = ? AND lease_token = ?", scheduled.ID, model.ScheduledSending, scheduled.LeaseToken)
+	}
+
+	message := model.Message{
+		Content:      scheduled.Content,
+		ChatID:       scheduled.ChatID,
+		SenderID:     scheduled.SenderID,
+		ThreadRootID: scheduled.ThreadRootID,
+	}
+	// the sender may have left the chat since the message was scheduled
+	var count int64
+	err := s.db.Model(&model.UserChat{}).
+		Where("chat_id = ? AND user_id = ?", scheduled.ChatID, scheduled.SenderID).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	if count == 0 {
+		err = ErrNotChatMember
+	} else {
+		err = s.message.CreateMessageWith(&message, func(tx *gorm.DB) error {
+			resoult := tx.Scopes(claimed).Updates(map[string]interface{}{
+				"status":       model.ScheduledSent,
+				"message_id":   message.ID,
+				"locked_until": nil,
+			})
+			if resoult.Error != nil {
+				return resoult.Error
+			}
+			if resoult.RowsAffected == 0 {
+				return ErrScheduledNotPending
+			}
+			return nil
+		})
+	}
+
+	var slowModeErr *SlowModeError
+	switch {
+	case errors.Is(err, ErrScheduledNotPending):
+		// the lease ran out and another replica took the message over
+		return false, nil
+	case errors.As(err, &slowModeErr):
+		return false, s.db.Scopes(claimed).Updates(map[string]interface{}{
+			"status":       model.ScheduledPending,
+			"send_at":      time.Now().Add(slowModeErr.Cooldown),
+			"locked_until": nil,
+		}).Error
+	case err != nil:
+		scheduled.Status = model.ScheduledFailed
+		scheduled.Error = err.Error()
+		resoult := s.db.Scopes(claimed).Updates(map[string]interface{}{
+			"status":       model.ScheduledFailed,
+			"error":        err.Error(),
+			"locked_until": nil,
+		})
+		if resoult.Error != nil || resoult.RowsAffected == 0 {
+			return false, resoult.Error
+		}
+		if onFailed != nil {
+			onFailed(scheduled)
+		}
+		return false, nil
+	}
+
+	if onSent != nil {
+		onSent(message)
+	}
+	return true, nil
+}
+
+// RunScheduler sends due messages every interval until ctx is done.
+// It is safe to run on every replica.
+func (s *ScheduleService) RunScheduler(ctx context.Context, interval time.Duration, onSent func(message model.Message), onFailed func(scheduled model.ScheduledMessage)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.SendDueMessages(onSent, onFailed); err != nil {
+				logrus.Errorf("failed to send scheduled messages: %v", err)
+			}
+		}
+	}
+}
+
+func (s *ScheduleService) getScheduledMessage(username string, id uint) (model.ScheduledMessage, error) {
+	var scheduled model.ScheduledMessage
+	resoult := s.db.
+		Joins("JOIN users ON users.id = scheduled_messages.sender_id").
+		Where("users.username = ?", username).
+		First(&scheduled, "scheduled_messages.id = ?", id)
+	if resoult.Error != nil {
+		if errors.Is(resoult.Error, gorm.ErrRecordNotFound) {
+			return model.ScheduledMessage{}, ErrScheduledNotFound
+		}
+		return model.ScheduledMessage{}, resoult.Error
+	}
+	if scheduled.Status == model.ScheduledSent {
+		return model.ScheduledMessage{}, ErrScheduledNotFound
+	}
+	return scheduled, nil
+}
+
+// dueScheduled keeps messages due at now and the ones whose lease ran out
+func dueScheduled(now time.Time) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("(status = ? AND send_at <= ?) OR (status = ? AND locked_until < ?)",
+			model.ScheduledPending, now, model.ScheduledSending, now)
+	}
+}
+
+func generateLeaseToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func isValidSendAt(sendAt time.Time) bool {
+	now := time.Now()
+	return sendAt.After(now) && sendAt.Before(now.Add(maxScheduleAhead))
+}
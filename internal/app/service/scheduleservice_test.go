@@ -0,0 +1,165 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleMessage(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewScheduleService(db, rdb, NewMessageService(db, rdb))
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	eve := model.User{Username: "eve"}
+	db.Create(&alice)
+	db.Create(&bob)
+	db.Create(&eve)
+	chat := model.Chat{Name: "private", Users: []model.User{alice, bob}}
+	db.Create(&chat)
+
+	past := time.Now().Add(-time.Minute)
+	later := time.Now().Add(time.Hour)
+	_, err := service.ScheduleMessage("alice", model.CreateMessageDto{Content: "hi", ChatID: chat.ID, SendAt: &past})
+	assert.ErrorIs(t, err, ErrInvalidSendAt)
+	_, err = service.ScheduleMessage("eve", model.CreateMessageDto{Content: "hi", ChatID: chat.ID, SendAt: &later})
+	assert.ErrorIs(t, err, ErrNotChatMember)
+
+	scheduled, err := service.ScheduleMessage("alice", model.CreateMessageDto{Content: "hi", ChatID: chat.ID, SendAt: &later})
+	assert.NoError(t, err)
+	canceled, err := service.ScheduleMessage("alice", model.CreateMessageDto{Content: "bye", ChatID: chat.ID, SendAt: &later})
+	assert.NoError(t, err)
+
+	content := "hello"
+	scheduled, err = service.ModifyScheduledMessage("alice", scheduled.ID, model.ModifyScheduledMessageDto{Content: &content})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", scheduled.Content)
+	_, err = service.ModifyScheduledMessage("bob", scheduled.ID, model.ModifyScheduledMessageDto{Content: &content})
+	assert.ErrorIs(t, err, ErrScheduledNotFound)
+
+	assert.NoError(t, service.CancelScheduledMessage("alice", canceled.ID))
+	assert.ErrorIs(t, service.CancelScheduledMessage("alice", canceled.ID), ErrScheduledNotFound)
+
+	scheduledResp, err := service.GetScheduledMessages("alice", chat.ID)
+	assert.NoError(t, err)
+	assert.Len(t, scheduledResp, 1)
+
+	// nothing is due yet
+	sent, err := service.SendDueMessages(nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sent)
+
+	db.Model(&scheduled).Update("send_at", time.Now().Add(-time.Second))
+	delivered := make([]model.Message, 0)
+	sent, err = service.SendDueMessages(func(message model.Message) {
+		delivered = append(delivered, message)
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Len(t, delivered, 1)
+	assert.Equal(t, "hello", delivered[0].Content)
+	assert.Equal(t, "alice", delivered[0].Sender.Username)
+
+	db.First(&scheduled, scheduled.ID)
+	assert.Equal(t, model.ScheduledSent, scheduled.Status)
+	assert.Equal(t, delivered[0].ID, *scheduled.MessageID)
+
+	// sent messages are not sent again
+	sent, err = service.SendDueMessages(nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sent)
+	assert.ErrorIs(t, service.CancelScheduledMessage("alice", scheduled.ID), ErrScheduledNotFound)
+}
+
+func TestSendDueMessages_StaleAndFailed(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewScheduleService(db, rdb, NewMessageService(db, rdb))
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	db.Create(&alice)
+	db.Create(&bob)
+	chat := model.Chat{Name: "private", Users: []model.User{alice, bob}}
+	db.Create(&chat)
+
+	now := time.Now()
+	expired := now.Add(-time.Second)
+	locked := now.Add(time.Minute)
+	// claimed by a replica which died
+	stale := model.ScheduledMessage{SenderID: alice.ID, ChatID: chat.ID, Content: "stale", SendAt: now.Add(-time.Hour), Status: model.ScheduledSending, LockedUntil: &expired}
+	// being sent by another replica
+	claimed := model.ScheduledMessage{SenderID: alice.ID, ChatID: chat.ID, Content: "claimed", SendAt: now.Add(-time.Hour), Status: model.ScheduledSending, LockedUntil: &locked}
+	db.Create(&stale)
+	db.Create(&claimed)
+	db.Create(&model.UserBlock{BlockerID: bob.ID, BlockedID: alice.ID})
+	blocked := model.ScheduledMessage{SenderID: alice.ID, ChatID: chat.ID, Content: "blocked", SendAt: now.Add(-time.Minute), Status: model.ScheduledPending}
+	db.Create(&blocked)
+
+	failed := make([]model.ScheduledMessage, 0)
+	sent, err := service.SendDueMessages(nil, func(scheduled model.ScheduledMessage) {
+		failed = append(failed, scheduled)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sent)
+	assert.Len(t, failed, 2)
+	assert.Equal(t, "alice", failed[0].Sender.Username)
+	assert.Equal(t, ErrUserBlocked.Error(), failed[0].Error)
+
+	db.First(&claimed, claimed.ID)
+	assert.Equal(t, model.ScheduledSending, claimed.Status)
+	db.First(&blocked, blocked.ID)
+	assert.Equal(t, model.ScheduledFailed, blocked.Status)
+}
+
+func TestSendDueMessages_LostLeaseAndLeftChat(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewScheduleService(db, rdb, NewMessageService(db, rdb))
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	carol := model.User{Username: "carol"}
+	db.Create(&alice)
+	db.Create(&bob)
+	db.Create(&carol)
+	chat := model.Chat{Name: "group", IsGroup: true, Users: []model.User{alice, bob, carol}}
+	db.Create(&chat)
+
+	// the lease ran out and another replica claimed the message meanwhile
+	locked := time.Now().Add(time.Minute)
+	takenOver := model.ScheduledMessage{SenderID: alice.ID, ChatID: chat.ID, Content: "once", SendAt: time.Now().Add(-time.Hour),
+		Status: model.ScheduledSending, LockedUntil: &locked, LeaseToken: "other"}
+	db.Create(&takenOver)
+	takenOver.LeaseToken = "expired"
+	ok, err := service.send(takenOver, nil, nil)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	var count int64
+	db.Model(&model.Message{}).Where("chat_id = ?", chat.ID).Count(&count)
+	assert.Zero(t, count)
+	db.First(&takenOver, takenOver.ID)
+	assert.Equal(t, model.ScheduledSending, takenOver.Status)
+	assert.Equal(t, "other", takenOver.LeaseToken)
+
+	// carol left the chat after scheduling
+	left := model.ScheduledMessage{SenderID: carol.ID, ChatID: chat.ID, Content: "bye", SendAt: time.Now().Add(-time.Minute), Status: model.ScheduledPending}
+	db.Create(&left)
+	db.Exec("DELETE FROM user_chats WHERE user_id = ?", carol.ID)
+
+	failed := make([]model.ScheduledMessage, 0)
+	sent, err := service.SendDueMessages(nil, func(scheduled model.ScheduledMessage) {
+		failed = append(failed, scheduled)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sent)
+	assert.Len(t, failed, 1)
+	assert.Equal(t, ErrNotChatMember.Error(), failed[0].Error)
+	db.Model(&model.Message{}).Where("chat_id = ?", chat.ID).Count(&count)
+	assert.Zero(t, count)
+}
@@ -3,8 +3,13 @@ package service
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/VitalyCone/websocket-messenger/internal/app/mailer"
 	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/VitalyCone/websocket-messenger/internal/app/preview"
+	"github.com/VitalyCone/websocket-messenger/internal/app/ratelimit"
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 	"gorm.io/driver/postgres"
@@ -17,6 +22,22 @@ type Service struct {
 	User
 	Chat
 	Message
+	Password
+	Account
+	Block
+	Contact
+	Privacy
+	Wallet
+	Admin
+	Report
+	Schedule
+	Thread
+	Preview
+	Poll
+	Folder
+	Bookmark
+	// Shared by every node, nil until Start
+	RateLimiter ratelimit.Limiter
 	config *Config
 	db *gorm.DB
 	rdb *redis.Client
@@ -34,23 +55,158 @@ type User interface {
 	LoginUser(m model.User) (interface{}, error)
 	GetUserData(tokenString string) (model.User, error)
 	GetUsersWithQuery_ToResponse(username string, offset, limit int) ([]model.UserResponse, error)
+	UpdateLastSeen(username string) error
+	ModifyUser(username string, modifyUserDto model.ModifyUserDto) (model.User, interface{}, error)
+}
+
+type Password interface {
+	ChangePassword(username, oldPassword, newPassword string) (interface{}, error)
+	RequestPasswordReset(email string) error
+	ResetPassword(token, newPassword string) (string, error)
+}
+
+type Account interface {
+	ScheduleDeletion(username, password string) (time.Time, error)
+	CancelDeletion(username string) error
+	PurgeDueAccounts() ([]string, error)
+	RunDeletionPurger(ctx context.Context, interval time.Duration, onDeleted func(username string))
+	RequestExport(username string) (model.DataExport, error)
+	GetExport(username string, id uint) (model.DataExport, error)
+	RunExportBuilder(ctx context.Context, interval time.Duration)
+}
+
+type Block interface {
+	BlockUser(username, blockedUsername string) error
+	UnblockUser(username, blockedUsername string) error
+	GetBlockedUsers_ToResponse(username string) ([]model.UserResponse, error)
+	GetBlockedUsernames(username string) ([]string, error)
+	IsBlocked(blocker, blocked string) bool
+}
+
+type Contact interface {
+	AddContact(ownerUsername, username, nickname string) (model.Contact, error)
+	ModifyContact(ownerUsername, username, nickname string) (model.Contact, error)
+	RemoveContact(ownerUsername, username string) error
+	GetContacts(ownerUsername string) ([]model.Contact, error)
+	IsContact(ownerUsername, username string) bool
+	SetContactsOnlyChats(username string, contactsOnly bool) error
+	SearchUsers_ToResponse(ownerUsername, query string, offset, limit int) ([]model.UserResponse, error)
+}
+
+type Privacy interface {
+	GetPrivacy(username string) (model.PrivacySettings, error)
+	UpdatePrivacy(username string, settings model.PrivacySettings) error
+	GetViewer(username string) (model.Viewer, error)
+	SplitAudience(username, option string, recipients []string) ([]string, []string, error)
+}
+
+type Wallet interface {
+	GetBalance(username string) (int64, error)
+	Transfer(username string, transferDto model.TransferDto) (model.WalletTransaction, error)
+	Deposit(username string, amount int64, idempotencyKey string) (model.WalletTransaction, error)
+	GetTransactions(username string, offset, limit int) ([]model.WalletTransactionResponse, error)
+	GetTransaction(username string, id uint) (model.WalletTransactionResponse, error)
+	SendToChat(username string, transferDto model.CreateChatTransferDto) (model.Message, bool, error)
+	AcceptTransfer(username string, id uint) (model.ChatTransfer, error)
+	DeclineTransfer(username string, id uint) (model.ChatTransfer, error)
+}
+
+type Admin interface {
+	PromoteAdmins(usernames []string) error
+	ListUsers(adminUsername, query string, offset, limit int) ([]model.AdminUserResponse, error)
+	SuspendUser(adminUsername, username, reason string) error
+	UnsuspendUser(adminUsername, username string) error
+	ForceLogout(adminUsername, username string) error
+	SetRole(adminUsername, username, role string) error
+	DeleteMessage(adminUsername string, id uint, reason string) (model.Message, error)
+	GetChat(adminUsername string, id uint) (model.AdminChatResponse, error)
+	GetAuditLog(offset, limit int) ([]model.AdminAuditLogResponse, error)
+}
+
+type Report interface {
+	CreateReport(username string, createReportDto model.CreateReportDto) (model.Report, error)
+	GetReports(status string, offset, limit int) ([]model.ReportResponse, error)
+	GetReport(id uint) (model.Report, error)
+	ResolveReport(adminUsername string, id uint, resolveReportDto model.ResolveReportDto) (model.ReportResolution, error)
+}
+
+type Schedule interface {
+	ScheduleMessage(username string, createMessageDto model.CreateMessageDto) (model.ScheduledMessage, error)
+	GetScheduledMessages(username string, chatID uint) ([]model.ScheduledMessageResponse, error)
+	ModifyScheduledMessage(username string, id uint, modifyDto model.ModifyScheduledMessageDto) (model.ScheduledMessage, error)
+	CancelScheduledMessage(username string, id uint) error
+	SendDueMessages(onSent func(message model.Message), onFailed func(scheduled model.ScheduledMessage)) (int, error)
+	RunScheduler(ctx context.Context, interval time.Duration, onSent func(message model.Message), onFailed func(scheduled model.ScheduledMessage))
+}
+
+type Thread interface {
+	GetThread_ToResponse(username string, rootID uint, limit, offset int) (model.ThreadResponse, error)
+	GetSubscriptions_ToResponse(username string, offset, limit int) ([]model.ThreadSubscriptionResponse, error)
+	Subscribe(username string, rootID uint) error
+	Unsubscribe(username string, rootID uint) error
+	MarkThreadRead(username string, rootID uint) error
+	GetSubscribers(rootID uint) ([]string, error)
+}
+
+type Preview interface {
+	Enqueue(message model.Message)
+	RunPreviewWorkers(ctx context.Context, onUpdated func(message model.Message))
+}
+
+type Poll interface {
+	CreatePoll(username string, createPollDto model.CreatePollDto) (model.Message, error)
+	GetPollMessage(id uint) (model.Message, error)
+	Vote(username string, id uint, optionIDs []uint) (model.Poll, error)
+	RetractVote(username string, id uint) (model.Poll, error)
+	ClosePoll(username string, id uint) (model.Poll, error)
 }
 
 type Chat interface {
 	CreateChat(chat *model.Chat) error
 	GetChat(id uint) (model.Chat, error)
-	GetChat_ToResponse(id uint) (model.ChatResponse, error)
-	GetChats_ToResponse(username string, offset, limit int) ([]model.ChatResponse, error)
+	GetChat_ToResponse(username string, id uint) (model.ChatResponse, error)
+	GetChats_ToResponse(username string, archived bool, folderID uint, cursor string, limit int) ([]model.ChatResponse, string, error)
 	GetChats(username string) ([]model.Chat, error)
-	ModifyChatName(id uint, name string) error
-	ModifyChatUsers(id uint, users []model.User) error 
+	ModifyChatName(username string, id uint, name string) error
+	ModifyChatUsers(username string, id uint, users []model.User) error
 	IsUserInChat(username string, chatID uint) bool
+	GetCompanions(username string) ([]string, error)
+	GetBannedWords(id uint) ([]string, error)
+	SetBannedWords(id uint, words []string) ([]string, error)
+	SetSlowMode(username string, id uint, seconds int) error
+	SetAutoDelete(username string, id uint, seconds int) error
+	ReadMentions(username string, id uint) error
+	SetMemberRole(username string, id uint, memberUsername, role string) error
+	UpdateChatSettings(username string, id uint, settingsDto model.ChatSettingsDto) error
+	ReorderPinnedChats(username string, chatIDs []uint) error
+	MarkChatRead(username string, id uint) error
+	GetMutedUsernames(chatID uint) ([]string, error)
+	GetSavedChat(username string) (model.Chat, error)
+	UpdateChatInfo(username string, id uint, infoDto model.ChatInfoDto) (model.Chat, error)
+	GetChatByHandle(handle string) (model.Chat, error)
+}
+
+type Bookmark interface {
+	CreateBookmark(username string, createBookmarkDto model.CreateBookmarkDto) (model.Bookmark, error)
+	GetBookmarks_ToResponse(username, query, tag string, offset, limit int) ([]model.BookmarkResponse, error)
+	SetBookmarkTags(username string, id uint, tags []string) (model.Bookmark, error)
+	DeleteBookmark(username string, id uint) error
+}
+
+type Folder interface {
+	CreateFolder(username string, folderDto model.ChatFolderDto) (model.ChatFolderResponse, error)
+	GetFolders_ToResponse(username string) ([]model.ChatFolderResponse, error)
+	UpdateFolder(username string, id uint, folderDto model.ChatFolderDto) (model.ChatFolderResponse, error)
+	DeleteFolder(username string, id uint) error
 }
 
 type Message interface {
 	CreateMessage(message *model.Message) error 
+	CreateMessageWith(message *model.Message, also func(tx *gorm.DB) error) error
 	GetMessages(chatID uint, limit, offset int) ([]model.Message, error) 
-	GetMessages_ToResponse(chatID uint, limit, offset int) ([]model.MessageResponse, error)
+	GetMessages_ToResponse(username string, chatID uint, limit, offset int) ([]model.MessageResponse, error)
+	PurgeExpiredMessages(onExpired func(chatID uint, ids []uint)) (int, error)
+	RunExpiryPurger(ctx context.Context, interval time.Duration, onExpired func(chatID uint, ids []uint))
 }
 
 
@@ -88,7 +244,36 @@ func (s *Service) Start() error {
 
 	s.User = NewUserService(db, rdb, s.config.TokenKey)
 	s.Chat = NewChatService(db, rdb)
-	s.Message = NewMessageService(db,rdb)
+	filters, err := newFilters(db, s.config.Filters)
+	if err != nil {
+		return err
+	}
+	messageService := NewMessageService(db,rdb)
+	messageService.UseFilters(filters)
+	s.Preview = NewPreviewService(db, rdb, preview.NewFetcher(preview.Config{
+		Timeout:  s.config.Previews.Timeout,
+		MaxBytes: s.config.Previews.MaxBytes,
+	}), s.config.Previews.Workers)
+	messageService.UsePreviews(s.Preview)
+	s.Message = messageService
+	s.Password = NewPasswordService(db, rdb, s.configureMailer(), s.config.TokenKey)
+	s.Account = NewAccountService(db, rdb)
+	s.Block = NewBlockService(db, rdb)
+	s.Contact = NewContactService(db, rdb)
+	s.Privacy = NewPrivacyService(db, rdb)
+	s.Wallet = NewWalletService(db, rdb, s.Message)
+	s.Admin = NewAdminService(db, rdb)
+	s.Report = NewReportService(db, rdb)
+	s.Schedule = NewScheduleService(db, rdb, s.Message)
+	s.Thread = NewThreadService(db, rdb)
+	s.Poll = NewPollService(db, rdb, s.Message)
+	s.Folder = NewFolderService(db, rdb)
+	s.Bookmark = NewBookmarkService(db, rdb)
+	s.RateLimiter = ratelimit.NewRedisLimiter(rdb)
+
+	if err := s.Admin.PromoteAdmins(s.config.Admins); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -97,16 +282,77 @@ func (s *Service) migrateDatabase(db *gorm.DB) error {
 	// Отключаем проверку внешних ключей на время миграции
 	db.Config.DisableForeignKeyConstraintWhenMigrating = true
 
+	if err := setupJoinTables(db); err != nil {
+		return err
+	}
+
+	// Members of chats from before read state have read them
+	readStateMissing := !db.Migrator().HasColumn(&model.UserChat{}, "last_read_id")
+	// Groups from before roles need an admin to be managed at all
+	rolesMissing := !db.Migrator().HasColumn(&model.UserChat{}, "role")
+
 	// Порядок важен: сначала таблицы без зависимостей, затем зависимые
 	err := db.AutoMigrate(
 		&model.User{},
 		&model.Chat{},
 		&model.Message{},
+		&model.PasswordResetToken{},
+		&model.DataExport{},
+		&model.UserBlock{},
+		&model.Contact{},
+		&model.WalletAccount{},
+		&model.WalletTransaction{},
+		&model.WalletEntry{},
+		&model.ChatTransfer{},
+		&model.AdminAuditLog{},
+		&model.Report{},
+		&model.ChatBannedWord{},
+		&model.UserChat{},
+		&model.ScheduledMessage{},
+		&model.ThreadSubscription{},
+		&model.MessageMention{},
+		&model.MessageEntity{},
+		&model.LinkPreview{},
+		&model.Poll{},
+		&model.PollOption{},
+		&model.PollVote{},
+		&model.ChatFolder{},
+		&model.ChatFolderChat{},
+		&model.Bookmark{},
+		&model.BookmarkTag{},
 	)
 	if err != nil {
 		return fmt.Errorf("failed to migrate models: %v", err)
 	}
 
+	// Balance was replaced by the wallet ledger
+	if db.Migrator().HasColumn(&model.User{}, "balance") {
+		if err := db.Migrator().DropColumn(&model.User{}, "balance"); err != nil {
+			return fmt.Errorf("failed to drop users.balance: %v", err)
+		}
+	}
+
+	if err := backfillChatActivity(db); err != nil {
+		return fmt.Errorf("failed to backfill chats activity: %v", err)
+	}
+	if readStateMissing {
+		err := db.Exec("UPDATE user_chats SET last_read_id = " +
+			"COALESCE((SELECT chats.last_message_id FROM chats WHERE chats.id = user_chats.chat_id), 0)").Error
+		if err != nil {
+			return fmt.Errorf("failed to backfill read state of chats: %v", err)
+		}
+	}
+	if rolesMissing {
+		// The chat keeps no creator, the oldest account among the members takes it
+		err := db.Exec("UPDATE user_chats SET role = ? "+
+			"WHERE chat_id IN (SELECT chats.id FROM chats WHERE chats.is_group = ?) "+
+			"AND user_id = (SELECT MIN(uc.user_id) FROM user_chats uc WHERE uc.chat_id = user_chats.chat_id)",
+			model.ChatAdmin, true).Error
+		if err != nil {
+			return fmt.Errorf("failed to backfill admins of chats: %v", err)
+		}
+	}
+
 	// Включаем проверку внешних ключей обратно
 	db.Config.DisableForeignKeyConstraintWhenMigrating = false
 
@@ -171,6 +417,15 @@ func (s *Service) migrateDatabase(db *gorm.DB) error {
 	return nil
 }
 
+func (s *Service) configureMailer() mailer.Mailer {
+	smtpConfig := s.config.SMTP
+	if smtpConfig.Host == "" {
+		logrus.Warn("SMTP is not configured, emails are kept in memory")
+		return mailer.NewMemoryMailer()
+	}
+	return mailer.NewSMTPMailer(smtpConfig.Host, smtpConfig.Port, smtpConfig.Username, smtpConfig.Password, smtpConfig.From)
+}
+
 func (s *Service) configureRedis(addr string) (*redis.Client, error) {
 	rdb := redis.NewClient(
 		&redis.Options{
@@ -182,3 +437,40 @@ func (s *Service) configureRedis(addr string) (*redis.Client, error) {
 	_, err := rdb.Ping(ctx).Result()
 	return rdb, err
 }
+
+// backfillChatActivity sets the last message and the activity of chats created
+// before they were kept on chats
+func backfillChatActivity(db *gorm.DB) error {
+	timeline := "FROM messages WHERE messages.chat_id = chats.id AND messages.thread_root_id IS NULL AND messages.deleted_at IS NULL"
+	return db.Exec(
+		"UPDATE chats SET last_message_id = (SELECT MAX(messages.id) " + timeline + "), " +
+			"last_activity_at = COALESCE((SELECT MAX(messages.created_at) " + timeline + "), chats.created_at) " +
+			"WHERE last_activity_at IS NULL",
+	).Error
+}
+
+// setupJoinTables makes gorm use models with extra columns as join tables,
+// it has to be called for every connection before using the associations
+func setupJoinTables(db *gorm.DB) error {
+	if err := db.SetupJoinTable(&model.Chat{}, "Users", &model.UserChat{}); err != nil {
+		return fmt.Errorf("failed to set up user_chats: %v", err)
+	}
+	if err := db.SetupJoinTable(&model.User{}, "Chats", &model.UserChat{}); err != nil {
+		return fmt.Errorf("failed to set up user_chats: %v", err)
+	}
+	return nil
+}
+
+// withDeletedUsers keeps anonymized accounts of deleted users in preloads,
+// so their messages still have a sender
+func withDeletedUsers(db *gorm.DB) *gorm.DB {
+	return db.Unscoped()
+}
+
+// likeContains returns a LIKE pattern matching strings containing the query,
+// wildcards of the query match themselves. It goes with ESCAPE '\'.
+func likeContains(query string) string {
+	return "%" + likeEscaper.Replace(query) + "%"
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
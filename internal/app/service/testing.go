@@ -51,6 +51,12 @@ func TestService(t *testing.T) (*Service, func(db *gorm.DB) ,error){
 
 func setupTestDB() *gorm.DB {
 	db, _ := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
-	db.AutoMigrate(&model.User{}, &model.Chat{}, &model.Message{})
+	setupJoinTables(db)
+	db.AutoMigrate(&model.User{}, &model.Chat{}, &model.Message{}, &model.PasswordResetToken{}, &model.DataExport{}, &model.UserBlock{}, &model.Contact{},
+		&model.WalletAccount{}, &model.WalletTransaction{}, &model.WalletEntry{}, &model.ChatTransfer{}, &model.AdminAuditLog{}, &model.Report{},
+		&model.ChatBannedWord{}, &model.UserChat{}, &model.ScheduledMessage{}, &model.ThreadSubscription{},
+		&model.MessageMention{}, &model.MessageEntity{}, &model.LinkPreview{},
+		&model.Poll{}, &model.PollOption{}, &model.PollVote{}, &model.ChatFolder{}, &model.ChatFolderChat{},
+		&model.Bookmark{}, &model.BookmarkTag{})
 	return db
 }
\ No newline at end of file
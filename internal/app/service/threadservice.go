@@ -0,0 +1,355 @@
+package service
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Authors of the last replies shown on a root message
+const threadParticipantsLimit = 3
+
+var ErrThreadNotFound = errors.New("thread not found")
+
+type ThreadService struct {
+	db  *gorm.DB
+	rdb *redis.Client
+}
+
+func NewThreadService(db *gorm.DB, rdb *redis.Client) *ThreadService {
+	return &ThreadService{
+		db:  db,
+		rdb: rdb,
+	}
+}
+
+// GetThread_ToResponse returns the root message with a page of replies, oldest first
+func (s *ThreadService) GetThread_ToResponse(username string, rootID uint, limit, offset int) (model.ThreadResponse, error) {
+	viewer, err := getViewer(s.db, username)
+	if err != nil {
+		return model.ThreadResponse{}, err
+	}
+	root, err := s.getRoot(viewer.ID, rootID)
+	if err != nil {
+		return model.ThreadResponse{}, err
+	}
+
+	replies := make([]model.Message, 0)
+	resoult := s.db.
+		Preload("Sender", withDeletedUsers).
+		Scopes(preloadTransfer, preloadPoll, preloadMentions, preloadEntities, preloadPreview, notExpired).
+		Where("thread_root_id = ?", root.ID).
+		Order("id").
+		Offset(offset).
+		Limit(limit).
+		Find(&replies)
+	if resoult.Error != nil {
+		return model.ThreadResponse{}, resoult.Error
+	}
+
+	roots := []model.Message{root}
+	if err := loadThreadSummaries(s.db, roots); err != nil {
+		return model.ThreadResponse{}, err
+	}
+	unread, err := countUnreadReplies(s.db, viewer.ID, []uint{root.ID})
+	if err != nil {
+		return model.ThreadResponse{}, err
+	}
+	var count int64
+	s.db.Model(&model.ThreadSubscription{}).
+		Where("user_id = ? AND root_id = ?", viewer.ID, root.ID).
+		Count(&count)
+
+	threadResp := model.ThreadResponse{
+		Root:       roots[0].ToResponseFor(viewer),
+		Replies:    make([]model.MessageResponse, len(replies)),
+		Subscribed: count > 0,
+		Unread:     unread[root.ID],
+	}
+	for i := range replies {
+		threadResp.Replies[i] = replies[i].ToResponseFor(viewer)
+	}
+	return threadResp, nil
+}
+
+// GetSubscriptions_ToResponse returns threads the user is subscribed to in their chats,
+// the latest root messages first
+func (s *ThreadService) GetSubscriptions_ToResponse(username string, offset, limit int) ([]model.ThreadSubscriptionResponse, error) {
+	viewer, err := getViewer(s.db, username)
+	if err != nil {
+		return nil, err
+	}
+
+	roots := make([]model.Message, 0)
+	resoult := s.db.
+		Preload("Sender", withDeletedUsers).
+		Scopes(preloadTransfer, preloadPoll, preloadMentions, preloadEntities, preloadPreview, notExpired).
+		Joins("JOIN thread_subscriptions ON thread_subscriptions.root_id = messages.id").
+		Joins("JOIN user_chats ON user_chats.chat_id = messages.chat_id AND user_chats.user_id = thread_subscriptions.user_id").
+		Where("thread_subscriptions.user_id = ?", viewer.ID).
+		Order("messages.id DESC").
+		Offset(offset).
+		Limit(limit).
+		Find(&roots)
+	if resoult.Error != nil {
+		return nil, resoult.Error
+	}
+
+	if err := loadThreadSummaries(s.db, roots); err != nil {
+		return nil, err
+	}
+	rootIDs := make([]uint, len(roots))
+	for i := range roots {
+		rootIDs[i] = roots[i].ID
+	}
+	unread, err := countUnreadReplies(s.db, viewer.ID, rootIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	subscriptionsResp := make([]model.ThreadSubscriptionResponse, len(roots))
+	for i := range roots {
+		subscriptionsResp[i] = model.ThreadSubscriptionResponse{
+			Root:   roots[i].ToResponseFor(viewer),
+			Unread: unread[roots[i].ID],
+		}
+	}
+	return subscriptionsResp, nil
+}
+
+// Subscribe makes replies of the thread delivered to the user
+func (s *ThreadService) Subscribe(username string, rootID uint) error {
+	var user model.User
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return err
+	}
+	root, err := s.getRoot(user.ID, rootID)
+	if err != nil {
+		return err
+	}
+	return subscribeToThread(s.db, user.ID, root.ID, 0)
+}
+
+func (s *ThreadService) Unsubscribe(username string, rootID uint) error {
+	var user model.User
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return err
+	}
+	return s.db.
+		Where("user_id = ? AND root_id = ?", user.ID, rootID).
+		Delete(&model.ThreadSubscription{}).Error
+}
+
+// MarkThreadRead marks every reply of the thread as read by the user
+func (s *ThreadService) MarkThreadRead(username string, rootID uint) error {
+	var user model.User
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return err
+	}
+	root, err := s.getRoot(user.ID, rootID)
+	if err != nil {
+		return err
+	}
+
+	var lastID uint
+	resoult := s.db.Model(&model.Message{}).
+		Select("COALESCE(MAX(id), 0)").
+		Where("thread_root_id = ?", root.ID).
+		Scan(&lastID)
+	if resoult.Error != nil {
+		return resoult.Error
+	}
+	return s.db.Model(&model.ThreadSubscription{}).
+		Where("user_id = ? AND root_id = ? AND last_read_id < ?", user.ID, root.ID, lastID).
+		Update("last_read_id", lastID).Error
+}
+
+// GetSubscribers returns usernames of members of the chat subscribed to the thread
+func (s *ThreadService) GetSubscribers(rootID uint) ([]string, error) {
+	usernames := make([]string, 0)
+	resoult := s.db.Model(&model.User{}).
+		Joins("JOIN thread_subscriptions ON thread_subscriptions.user_id = users.id").
+		Joins("JOIN messages ON messages.id = thread_subscriptions.root_id").
+		Joins("JOIN user_chats ON user_chats.chat_id = messages.chat_id AND user_chats.user_id = users.id").
+		Where("thread_subscriptions.root_id = ?", rootID).
+		Pluck("users.username", &usernames)
+	return usernames, resoult.Error
+}
+
+// getRoot returns the root message of the thread if the user is a member of its chat
+func (s *ThreadService) getRoot(userID, rootID uint) (model.Message, error) {
+	var root model.Message
+	resoult := s.db.
+		Preload("Sender", withDeletedUsers).
+		Scopes(preloadTransfer, preloadPoll, preloadMentions, preloadEntities, preloadPreview, notExpired).
+		Where("thread_root_id IS NULL").
+		First(&root, rootID)
+	if resoult.Error != nil {
+		if errors.Is(resoult.Error, gorm.ErrRecordNotFound) {
+			return model.Message{}, ErrThreadNotFound
+		}
+		return model.Message{}, resoult.Error
+	}
+
+	var count int64
+	s.db.Model(&model.UserChat{}).
+		Where("chat_id = ? AND user_id = ?", root.ChatID, userID).
+		Count(&count)
+	if count == 0 {
+		return model.Message{}, ErrNotChatMember
+	}
+	return root, nil
+}
+
+// getThreadRoot returns the root of the thread a new message of the chat replies to.
+// Replies to replies go to the same thread.
+func getThreadRoot(db *gorm.DB, chat model.Chat, id uint) (model.Message, error) {
+	if !chat.IsGroup {
+		return model.Message{}, ErrNotGroupChat
+	}
+
+	var root model.Message
+	resoult := db.Scopes(notExpired).Where("chat_id = ?", chat.ID).First(&root, id)
+	if resoult.Error != nil {
+		if errors.Is(resoult.Error, gorm.ErrRecordNotFound) {
+			return model.Message{}, ErrThreadNotFound
+		}
+		return model.Message{}, resoult.Error
+	}
+	if root.ThreadRootID != nil {
+		return getThreadRoot(db, chat, *root.ThreadRootID)
+	}
+	return root, nil
+}
+
+// subscribeToThread subscribes the user to the thread, having read it up to lastReadID
+func subscribeToThread(db *gorm.DB, userID, rootID, lastReadID uint) error {
+	subscription := model.ThreadSubscription{UserID: userID, RootID: rootID, LastReadID: lastReadID}
+	onConflict := clause.OnConflict{DoNothing: true}
+	if lastReadID != 0 {
+		onConflict = clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "root_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"last_read_id": lastReadID}),
+		}
+	}
+	return db.Clauses(onConflict).Create(&subscription).Error
+}
+
+// countUnreadReplies returns the number of replies of others the user hasn't read
+// in every thread they are subscribed to among the roots
+func countUnreadReplies(db *gorm.DB, userID uint, rootIDs []uint) (map[uint]int, error) {
+	var counts []struct {
+		RootID uint
+		Unread int
+	}
+	resoult := db.Model(&model.Message{}).
+		Select("thread_subscriptions.root_id, COUNT(*) AS unread").
+		Joins("JOIN thread_subscriptions ON thread_subscriptions.root_id = messages.thread_root_id").
+		Scopes(notExpired).
+		Where("thread_subscriptions.user_id = ? AND thread_subscriptions.root_id IN ?", userID, rootIDs).
+		Where("messages.id > thread_subscriptions.last_read_id AND messages.sender_id <> ?", userID).
+		Group("thread_subscriptions.root_id").
+		Scan(&counts)
+	if resoult.Error != nil {
+		return nil, resoult.Error
+	}
+
+	unread := make(map[uint]int, len(counts))
+	for _, count := range counts {
+		unread[count.RootID] = count.Unread
+	}
+	return unread, nil
+}
+
+// loadThreadSummaries sets the thread of every root message which has replies
+func loadThreadSummaries(db *gorm.DB, messages []model.Message) error {
+	rootIDs := make([]uint, 0, len(messages))
+	for i := range messages {
+		if messages[i].ThreadRootID == nil {
+			rootIDs = append(rootIDs, messages[i].ID)
+		}
+	}
+	if len(rootIDs) == 0 {
+		return nil
+	}
+
+	var counts []struct {
+		ThreadRootID uint
+		Replies      int
+		LastReplyID  uint
+	}
+	resoult := db.Model(&model.Message{}).
+		Select("thread_root_id, COUNT(*) AS replies, MAX(id) AS last_reply_id").
+		Scopes(notExpired).
+		Where("thread_root_id IN ?", rootIDs).
+		Group("thread_root_id").
+		Scan(&counts)
+	if resoult.Error != nil {
+		return resoult.Error
+	}
+	if len(counts) == 0 {
+		return nil
+	}
+
+	lastReplyIDs := make([]uint, 0, len(counts))
+	for _, count := range counts {
+		lastReplyIDs = append(lastReplyIDs, count.LastReplyID)
+	}
+	var lastReplies []model.Message
+	if err := db.Select("id", "created_at").Find(&lastReplies, lastReplyIDs).Error; err != nil {
+		return err
+	}
+
+	// Senders of every thread, the latest first
+	var senders []struct {
+		ThreadRootID uint
+		SenderID     uint
+	}
+	resoult = db.Model(&model.Message{}).
+		Select("thread_root_id, sender_id").
+		Scopes(notExpired).
+		Where("thread_root_id IN ?", rootIDs).
+		Group("thread_root_id, sender_id").
+		Order("thread_root_id, MAX(id) DESC").
+		Scan(&senders)
+	if resoult.Error != nil {
+		return resoult.Error
+	}
+	senderIDs := make(map[uint][]uint, len(counts))
+	allSenderIDs := make([]uint, 0, len(senders))
+	for _, sender := range senders {
+		if len(senderIDs[sender.ThreadRootID]) < threadParticipantsLimit {
+			senderIDs[sender.ThreadRootID] = append(senderIDs[sender.ThreadRootID], sender.SenderID)
+			allSenderIDs = append(allSenderIDs, sender.SenderID)
+		}
+	}
+	var users []model.User
+	if err := db.Scopes(withDeletedUsers).Find(&users, allSenderIDs).Error; err != nil {
+		return err
+	}
+
+	for _, count := range counts {
+		participants := make([]model.User, 0, len(senderIDs[count.ThreadRootID]))
+		for _, senderID := range senderIDs[count.ThreadRootID] {
+			if j := slices.IndexFunc(users, func(u model.User) bool { return u.ID == senderID }); j != -1 {
+				participants = append(participants, users[j])
+			}
+		}
+		j := slices.IndexFunc(lastReplies, func(m model.Message) bool { return m.ID == count.LastReplyID })
+		if j == -1 {
+			continue
+		}
+
+		i := slices.IndexFunc(messages, func(m model.Message) bool { return m.ID == count.ThreadRootID })
+		messages[i].Thread = &model.ThreadSummary{
+			ReplyCount:   count.Replies,
+			LastReplyAt:  lastReplies[j].CreatedAt,
+			Participants: participants,
+		}
+	}
+	return nil
+}
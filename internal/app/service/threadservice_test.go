@@ -0,0 +1,140 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestThreads(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	messageService := NewMessageService(db, rdb)
+	service := NewThreadService(db, rdb)
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	carol := model.User{Username: "carol"}
+	eve := model.User{Username: "eve"}
+	db.Create(&alice)
+	db.Create(&bob)
+	db.Create(&carol)
+	db.Create(&eve)
+	group := model.Chat{Name: "group", IsGroup: true, Users: []model.User{alice, bob, carol}}
+	db.Create(&group)
+	private := model.Chat{Name: "private", Users: []model.User{alice, bob}}
+	db.Create(&private)
+
+	root := model.Message{Content: "lunch?", SenderID: alice.ID, ChatID: group.ID}
+	assert.NoError(t, messageService.CreateMessage(&root))
+	direct := model.Message{Content: "hi", SenderID: alice.ID, ChatID: private.ID}
+	assert.NoError(t, messageService.CreateMessage(&direct))
+
+	reply := model.Message{Content: "sure", SenderID: bob.ID, ChatID: group.ID, ThreadRootID: &root.ID}
+	assert.NoError(t, messageService.CreateMessage(&reply))
+	// replies to replies go to the same thread
+	nested := model.Message{Content: "me too", SenderID: carol.ID, ChatID: group.ID, ThreadRootID: &reply.ID}
+	assert.NoError(t, messageService.CreateMessage(&nested))
+	assert.Equal(t, root.ID, *nested.ThreadRootID)
+
+	err := messageService.CreateMessage(&model.Message{Content: "no", SenderID: bob.ID, ChatID: private.ID, ThreadRootID: &direct.ID})
+	assert.ErrorIs(t, err, ErrNotGroupChat)
+	err = messageService.CreateMessage(&model.Message{Content: "no", SenderID: bob.ID, ChatID: group.ID, ThreadRootID: &direct.ID})
+	assert.ErrorIs(t, err, ErrThreadNotFound)
+
+	// replies stay out of the chat timeline, the root shows the thread
+	messages, err := messageService.GetMessages_ToResponse("alice", group.ID, 10, 0)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 1)
+	assert.Equal(t, 2, messages[0].Thread.ReplyCount)
+	participants := make([]string, 0)
+	for _, participant := range messages[0].Thread.Participants {
+		participants = append(participants, participant.Username)
+	}
+	assert.Equal(t, []string{"carol", "bob"}, participants)
+
+	subscribers, err := service.GetSubscribers(root.ID)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"alice", "bob", "carol"}, subscribers)
+
+	thread, err := service.GetThread_ToResponse("alice", root.ID, 10, 0)
+	assert.NoError(t, err)
+	assert.Len(t, thread.Replies, 2)
+	assert.Equal(t, "sure", thread.Replies[0].Content)
+	assert.True(t, thread.Subscribed)
+	assert.Equal(t, 2, thread.Unread)
+
+	// bob has read up to his reply
+	threads, err := service.GetSubscriptions_ToResponse("bob", 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, threads, 1)
+	assert.Equal(t, 1, threads[0].Unread)
+
+	assert.NoError(t, service.MarkThreadRead("alice", root.ID))
+	thread, err = service.GetThread_ToResponse("alice", root.ID, 10, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, thread.Unread)
+
+	assert.NoError(t, service.Unsubscribe("carol", root.ID))
+	subscribers, err = service.GetSubscribers(root.ID)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"alice", "bob"}, subscribers)
+
+	_, err = service.GetThread_ToResponse("eve", root.ID, 10, 0)
+	assert.ErrorIs(t, err, ErrNotChatMember)
+	assert.ErrorIs(t, service.Subscribe("alice", reply.ID), ErrThreadNotFound)
+}
+
+func TestLoadThreadSummaries_Batched(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	messageService := NewMessageService(db, rdb)
+
+	users := make([]model.User, 0)
+	for _, username := range []string{"alice", "bob", "carol", "dave", "eve"} {
+		user := model.User{Username: username}
+		db.Create(&user)
+		users = append(users, user)
+	}
+	group := model.Chat{Name: "group", IsGroup: true, Users: users}
+	db.Create(&group)
+
+	roots := make([]model.Message, 0)
+	for i := 0; i < 3; i++ {
+		root := model.Message{Content: "root", SenderID: users[0].ID, ChatID: group.ID}
+		assert.NoError(t, messageService.CreateMessage(&root))
+		roots = append(roots, root)
+	}
+	// everybody replies to the first root, bob to the second, the third has no replies
+	for _, user := range users[1:] {
+		assert.NoError(t, messageService.CreateMessage(&model.Message{Content: "reply", SenderID: user.ID, ChatID: group.ID, ThreadRootID: &roots[0].ID}))
+	}
+	last := model.Message{Content: "reply", SenderID: users[1].ID, ChatID: group.ID, ThreadRootID: &roots[1].ID}
+	assert.NoError(t, messageService.CreateMessage(&last))
+
+	queries := 0
+	countQuery := func(*gorm.DB) { queries++ }
+	db.Callback().Query().After("gorm:query").Register("test:count_query", countQuery)
+	db.Callback().Row().After("gorm:row").Register("test:count_row", countQuery)
+
+	assert.NoError(t, loadThreadSummaries(db, roots))
+	assert.Equal(t, 4, queries)
+
+	participants := func(thread *model.ThreadSummary) []string {
+		usernames := make([]string, 0)
+		for _, participant := range thread.Participants {
+			usernames = append(usernames, participant.Username)
+		}
+		return usernames
+	}
+	assert.Equal(t, 4, roots[0].Thread.ReplyCount)
+	assert.Equal(t, []string{"eve", "dave", "carol"}, participants(roots[0].Thread))
+	assert.Equal(t, 1, roots[1].Thread.ReplyCount)
+	assert.Equal(t, []string{"bob"}, participants(roots[1].Thread))
+	assert.WithinDuration(t, last.CreatedAt, roots[1].Thread.LastReplyAt, time.Second)
+	assert.Nil(t, roots[2].Thread)
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 
+	"github.com/VitalyCone/websocket-messenger/internal/app/imageutil"
 	"github.com/VitalyCone/websocket-messenger/internal/app/model"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/redis/go-redis/v9"
@@ -17,11 +19,22 @@ import (
 const(
     bcryptSalt = 10
 	tokenTTL   = 12 * time.Hour
+	usernameChangeCooldown = 7 * 24 * time.Hour
+)
+
+var (
+	ErrTokenRevoked     = errors.New("token has been revoked")
+	ErrEmailTaken       = errors.New("email is already in use")
+	ErrUsernameTaken    = errors.New("username is already taken")
+	ErrUsernameCooldown = errors.New("username was changed recently")
+	ErrInvalidAvatar    = errors.New("invalid avatar")
 )
 
 type tokenClaims struct {
 	jwt.StandardClaims
 	UserUsername string `json:"user_username"`
+	TokenVersion uint   `json:"token_version"`
+	UserID       uint   `json:"user_id"`
 }
 
 type UserService struct {
@@ -45,11 +58,25 @@ func (s *UserService) RegisterUser(m model.User) (interface{}, error){
     }
 	m.PasswordHash = string(passHash)
 
+	if m.Email != ""{
+		taken, err := emailTaken(s.db, m.Email)
+		if err != nil{
+			return nil, err
+		}
+		if taken{
+			return nil, ErrEmailTaken
+		}
+	}
+
 	tx := s.db.Begin()
 
     resoult := tx.Create(&m)
 	if resoult.Error != nil{
 		tx.Rollback()
+		// the email may have been taken after the check above
+		if taken, _ := emailTaken(s.db, m.Email); m.Email != "" && taken{
+			return nil, ErrEmailTaken
+		}
 		return nil, resoult.Error
 	}
 
@@ -92,6 +119,9 @@ func (s *UserService) LoginUser(m model.User) (interface{}, error){
 	if err := verifyPassword(user.PasswordHash, m.Password);err != nil{
 		return nil, err
 	}
+	if user.SuspendedAt != nil{
+		return nil, ErrUserSuspended
+	}
 
 	mByte, err := json.Marshal(user)
 	if err != nil{
@@ -103,7 +133,7 @@ func (s *UserService) LoginUser(m model.User) (interface{}, error){
         return nil, err
     }
 
-	tokenString, err := createToken(m, s.tokenKey)
+	tokenString, err := createToken(user, s.tokenKey)
     if err != nil {
 		return nil, err
     }
@@ -112,26 +142,30 @@ func (s *UserService) LoginUser(m model.User) (interface{}, error){
 }
 
 func (s *UserService) GetUsernameFromToken(tokenString string) (string, error){
-	claims, err := verifyToken(tokenString, s.tokenKey)
+	user, err := s.GetUserData(tokenString)
 	if err != nil{
 		return  "", err
 	}
-	return claims.UserUsername, nil
+	return user.Username, nil
 }
 
+// GetUserData returns the user of the token, revoked tokens and suspended users are refused
 func (s *UserService) GetUserData(tokenString string) (model.User, error){
-	var user model.User
-
 	claims, err := verifyToken(tokenString, s.tokenKey)
 	if err != nil{
 		return model.User{}, err
 	}
 
-	user, err = getUserByUsername(claims.UserUsername, s.db, s.rdb)
+	user, err := getUserByUsername(claims.UserUsername, s.db, s.rdb)
 	if err != nil{
 		return model.User{}, err
 	}
-	
+	if user.ID != claims.UserID || user.TokenVersion != claims.TokenVersion{
+		return model.User{}, ErrTokenRevoked
+	}
+	if user.SuspendedAt != nil{
+		return model.User{}, ErrUserSuspended
+	}
 	return user, nil
 }
 
@@ -140,7 +174,7 @@ func (s *UserService) GetUsersWithQuery_ToResponse(username string, offset,limit
 	users := make([]model.User, 0)
 	respUsername := "%"+username+"%"
 
-	resoult := s.db.Unscoped().Where("username LIKE ?", respUsername).Offset(offset).Limit(limit).Find(&users)
+	resoult := s.db.Where("username LIKE ?", respUsername).Offset(offset).Limit(limit).Find(&users)
 	if resoult.Error != nil{
 		return nil, resoult.Error
 	}
@@ -150,6 +184,83 @@ func (s *UserService) GetUsersWithQuery_ToResponse(username string, offset,limit
 	return usersResp, nil
 }
 
+func (s *UserService) UpdateLastSeen(username string) error{
+	return s.db.Model(&model.User{}).
+		Where("username = ?", username).
+		Update("last_seen_at", time.Now()).
+		Error
+}
+
+// ModifyUser applies a partial profile update. When the username changes a new
+// token is returned, because tokens issued for the old username stop working.
+func (s *UserService) ModifyUser(username string, modifyUserDto model.ModifyUserDto) (model.User, interface{}, error){
+	var user model.User
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil{
+		return model.User{}, nil, err
+	}
+
+	updates := make(map[string]interface{})
+	if modifyUserDto.FirstName != nil{
+		updates["first_name"] = *modifyUserDto.FirstName
+	}
+	if modifyUserDto.SecondName != nil{
+		updates["second_name"] = *modifyUserDto.SecondName
+	}
+	if modifyUserDto.Avatar != nil{
+		if len(modifyUserDto.Avatar) == 0{
+			updates["avatar"] = nil
+			updates["avatar_thumb"] = nil
+		}else{
+			thumb, err := imageutil.Thumbnail(modifyUserDto.Avatar, imageutil.ThumbnailSize)
+			if err != nil{
+				return model.User{}, nil, fmt.Errorf("%w: %v", ErrInvalidAvatar, err)
+			}
+			updates["avatar"] = modifyUserDto.Avatar
+			updates["avatar_thumb"] = thumb
+		}
+	}
+
+	usernameChanged := modifyUserDto.Username != nil && *modifyUserDto.Username != user.Username
+	if usernameChanged{
+		if user.UsernameChangedAt != nil && time.Since(*user.UsernameChangedAt) < usernameChangeCooldown{
+			return model.User{}, nil, ErrUsernameCooldown
+		}
+
+		var count int64
+		if err := s.db.Unscoped().Model(&model.User{}).Where("username = ?", *modifyUserDto.Username).Count(&count).Error; err != nil{
+			return model.User{}, nil, err
+		}
+		if count > 0{
+			return model.User{}, nil, ErrUsernameTaken
+		}
+		updates["username"] = *modifyUserDto.Username
+		updates["username_changed_at"] = time.Now()
+	}
+
+	if len(updates) > 0{
+		if err := s.db.Model(&user).Updates(updates).Error; err != nil{
+			return model.User{}, nil, err
+		}
+	}
+
+	if err := s.db.First(&user, user.ID).Error; err != nil{
+		return model.User{}, nil, err
+	}
+
+	if err := invalidateUserCache(s.rdb, username, user.Username); err != nil{
+		return model.User{}, nil, err
+	}
+
+	if !usernameChanged{
+		return user, nil, nil
+	}
+	tokenString, err := createToken(user, s.tokenKey)
+	if err != nil{
+		return model.User{}, nil, err
+	}
+	return user, tokenString, nil
+}
+
 func createToken(user model.User, tokenSigned string)(string, error){
 
     claims := jwt.NewWithClaims(jwt.SigningMethodHS256, &tokenClaims{
@@ -158,6 +269,8 @@ func createToken(user model.User, tokenSigned string)(string, error){
 			IssuedAt:  time.Now().Unix(),
 		},
 		user.Username,
+		user.TokenVersion,
+		user.ID,
 	})
 
     return claims.SignedString([]byte(tokenSigned))
@@ -183,6 +296,13 @@ func verifyToken(tokenString string, tokenSignedString string) (*tokenClaims, er
 	return claims, nil
 }
 
+// emailTaken reports whether an account has the email, it is unique among non-empty ones
+func emailTaken(db *gorm.DB, email string) (bool, error){
+	var count int64
+	err := db.Model(&model.User{}).Where("email = ?", email).Count(&count).Error
+	return count > 0, err
+}
+
 func verifyPassword(hashPass, password string) error{
     return bcrypt.CompareHashAndPassword([]byte(hashPass), []byte(password))
 }
@@ -212,4 +332,14 @@ func getUserByUsername(username string, db *gorm.DB, rdb *redis.Client) (model.U
 		logrus.Printf("%s form redis", user.Username)
 	}
 	return user, nil
-}	
\ No newline at end of file
+}	
+
+// invalidateUserCache drops cached "user_<username>" entries so that the next
+// getUserByUsername call reloads them from the database
+func invalidateUserCache(rdb *redis.Client, usernames ...string) error{
+	keys := make([]string, len(usernames))
+	for i, username := range usernames{
+		keys[i] = "user_" + username
+	}
+	return rdb.Del(context.Background(), keys...).Err()
+}
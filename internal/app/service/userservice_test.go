@@ -3,6 +3,7 @@ package service
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/VitalyCone/websocket-messenger/internal/app/model"
 	"github.com/go-redis/redismock/v9"
@@ -49,6 +50,21 @@ func TestRegisterUser_DuplicateUser(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestRegisterUser_EmailTaken(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewUserService(db, rdb, testTokenKey)
+
+	db.Create(&model.User{Username: "alice", Email: "alice@example.com"})
+	_, err := service.RegisterUser(model.User{Username: "bob", Email: "alice@example.com", Password: "pass"})
+	assert.ErrorIs(t, err, ErrEmailTaken)
+
+	// the index refuses duplicates the check misses in a race, empty emails may repeat
+	assert.Error(t, db.Create(&model.User{Username: "carol", Email: "alice@example.com"}).Error)
+	assert.NoError(t, db.Create(&model.User{Username: "dave"}).Error)
+	assert.NoError(t, db.Create(&model.User{Username: "erin"}).Error)
+}
+
 func TestLoginUser_Success_DB(t *testing.T) {
 	db := setupTestDB()
 	rdb, mock := redismock.NewClientMock()
@@ -159,6 +175,32 @@ func TestGetUserData_InvalidToken(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestGetUserData_RevokedAndSuspended(t *testing.T) {
+	db := setupTestDB()
+	rdb, mock := redismock.NewClientMock()
+	service := NewUserService(db, rdb, testTokenKey)
+
+	user := model.User{Username: "ivan"}
+	db.Create(&user)
+	token, err := createToken(user, testTokenKey)
+	assert.NoError(t, err)
+
+	revoked := user
+	revoked.TokenVersion = 1
+	revokedBytes, _ := json.Marshal(revoked)
+	mock.ExpectGet("user_ivan").SetVal(string(revokedBytes))
+	_, err = service.GetUserData(token)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+
+	suspended := user
+	suspendedAt := time.Now()
+	suspended.SuspendedAt = &suspendedAt
+	suspendedBytes, _ := json.Marshal(suspended)
+	mock.ExpectGet("user_ivan").SetVal(string(suspendedBytes))
+	_, err = service.GetUserData(token)
+	assert.ErrorIs(t, err, ErrUserSuspended)
+}
+
 func TestGetUsersWithQuery_ToResponse_Empty(t *testing.T) {
 	db := setupTestDB()
 	rdb, _ := redismock.NewClientMock()
@@ -183,3 +225,69 @@ func TestGetUsersWithQuery_ToResponse_Success(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, len(resp) >= 2)
 }
+
+func TestModifyUser_Names(t *testing.T) {
+	db := setupTestDB()
+	rdb, mock := redismock.NewClientMock()
+	service := NewUserService(db, rdb, testTokenKey)
+
+	user := model.User{Username: "kate", FirstName: "Kate"}
+	db.Create(&user)
+	mock.ExpectDel("user_kate", "user_kate").SetVal(1)
+
+	firstName := "Katherine"
+	updated, token, err := service.ModifyUser("kate", model.ModifyUserDto{FirstName: &firstName})
+	assert.NoError(t, err)
+	assert.Nil(t, token)
+	assert.Equal(t, "Katherine", updated.FirstName)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestModifyUser_Username(t *testing.T) {
+	db := setupTestDB()
+	rdb, mock := redismock.NewClientMock()
+	service := NewUserService(db, rdb, testTokenKey)
+
+	user := model.User{Username: "leo"}
+	db.Create(&user)
+	mock.ExpectDel("user_leo", "user_leonardo").SetVal(1)
+
+	username := "leonardo"
+	updated, token, err := service.ModifyUser("leo", model.ModifyUserDto{Username: &username})
+	assert.NoError(t, err)
+	assert.Equal(t, "leonardo", updated.Username)
+	assert.NotNil(t, updated.UsernameChangedAt)
+
+	claims, err := verifyToken(token.(string), testTokenKey)
+	assert.NoError(t, err)
+	assert.Equal(t, "leonardo", claims.UserUsername)
+	assert.Equal(t, user.ID, claims.UserID)
+
+	again := "leo"
+	_, _, err = service.ModifyUser("leonardo", model.ModifyUserDto{Username: &again})
+	assert.ErrorIs(t, err, ErrUsernameCooldown)
+}
+
+func TestModifyUser_UsernameTaken(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewUserService(db, rdb, testTokenKey)
+
+	db.Create(&model.User{Username: "mia"})
+	db.Create(&model.User{Username: "nina"})
+
+	username := "nina"
+	_, _, err := service.ModifyUser("mia", model.ModifyUserDto{Username: &username})
+	assert.ErrorIs(t, err, ErrUsernameTaken)
+}
+
+func TestModifyUser_InvalidAvatar(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewUserService(db, rdb, testTokenKey)
+
+	db.Create(&model.User{Username: "olga"})
+
+	_, _, err := service.ModifyUser("olga", model.ModifyUserDto{Avatar: []byte("not an image")})
+	assert.ErrorIs(t, err, ErrInvalidAvatar)
+}
@@ -0,0 +1,495 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Name of the system account deposits come from, it is the only account
+	// allowed to go below zero
+	walletSystemAccount = "system"
+	// Name of the account holding pending chat transfers
+	walletEscrowAccount = "escrow"
+)
+
+const (
+	DefaultTransactionsLimit = 15
+	MaxTransactionsLimit     = 50
+)
+
+var (
+	ErrTransferSelf        = errors.New("you can't transfer money to yourself")
+	ErrInsufficientFunds   = errors.New("insufficient funds")
+	ErrIdempotencyConflict = errors.New("idempotency key was already used for another operation")
+	ErrTransactionNotFound = errors.New("transaction not found")
+	ErrTransferRecipient   = errors.New("recipient is not a member of the chat")
+	ErrTransferNotFound    = errors.New("transfer not found")
+	ErrTransferNotPending  = errors.New("transfer is already accepted or declined")
+)
+
+type WalletService struct {
+	db      *gorm.DB
+	rdb     *redis.Client
+	message Message
+}
+
+func NewWalletService(db *gorm.DB, rdb *redis.Client, message Message) *WalletService {
+	return &WalletService{
+		db:      db,
+		rdb:     rdb,
+		message: message,
+	}
+}
+
+func (s *WalletService) GetBalance(username string) (int64, error) {
+	var user model.User
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return 0, err
+	}
+
+	var account model.WalletAccount
+	resoult := s.db.Where("name = ?", userAccountName(user.ID)).Limit(1).Find(&account)
+	if resoult.Error != nil {
+		return 0, resoult.Error
+	}
+	return account.Balance, nil
+}
+
+// Transfer moves money between two users. Repeating a request with the same
+// idempotency key returns the transaction made by the first one.
+func (s *WalletService) Transfer(username string, transferDto model.TransferDto) (model.WalletTransaction, error) {
+	if username == transferDto.Username {
+		return model.WalletTransaction{}, ErrTransferSelf
+	}
+
+	var sender, recipient model.User
+	if err := s.db.Where(model.User{Username: username}).First(&sender).Error; err != nil {
+		return model.WalletTransaction{}, err
+	}
+	if err := s.db.Where(model.User{Username: transferDto.Username}).First(&recipient).Error; err != nil {
+		return model.WalletTransaction{}, err
+	}
+
+	blocked, err := isBlockedByAny(s.db, []uint{recipient.ID}, sender.ID)
+	if err != nil {
+		return model.WalletTransaction{}, err
+	}
+	if blocked {
+		return model.WalletTransaction{}, ErrUserBlocked
+	}
+
+	transaction := model.WalletTransaction{
+		InitiatorID:    sender.ID,
+		IdempotencyKey: transferDto.IdempotencyKey,
+		Kind:           model.WalletTransfer,
+		Amount:         transferDto.Amount,
+		Comment:        transferDto.Comment,
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		from, err := ensureAccount(tx, userAccountName(sender.ID), &sender.ID)
+		if err != nil {
+			return err
+		}
+		to, err := ensureAccount(tx, userAccountName(recipient.ID), &recipient.ID)
+		if err != nil {
+			return err
+		}
+		return postTransaction(tx, &transaction, from, to)
+	})
+	if err != nil {
+		return s.replay(transaction, err)
+	}
+	return s.getTransaction(transaction.ID)
+}
+
+// Deposit credits the user from the system account
+func (s *WalletService) Deposit(username string, amount int64, idempotencyKey string) (model.WalletTransaction, error) {
+	var user model.User
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return model.WalletTransaction{}, err
+	}
+
+	transaction := model.WalletTransaction{
+		InitiatorID:    user.ID,
+		IdempotencyKey: idempotencyKey,
+		Kind:           model.WalletDeposit,
+		Amount:         amount,
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		from, err := ensureAccount(tx, walletSystemAccount, nil)
+		if err != nil {
+			return err
+		}
+		to, err := ensureAccount(tx, userAccountName(user.ID), &user.ID)
+		if err != nil {
+			return err
+		}
+		return postTransaction(tx, &transaction, from, to)
+	})
+	if err != nil {
+		return s.replay(transaction, err)
+	}
+	return s.getTransaction(transaction.ID)
+}
+
+// SendToChat posts a transfer message to the chat. The comment goes through the
+// same filters and slow mode as any other message, the money goes to escrow in
+// the same database transaction the message is inserted in. Repeating a request
+// with the same idempotency key returns the message of the first one, created
+// is false then.
+func (s *WalletService) SendToChat(username string, transferDto model.CreateChatTransferDto) (model.Message, bool, error) {
+	var sender model.User
+	if err := s.db.Where(model.User{Username: username}).First(&sender).Error; err != nil {
+		return model.Message{}, false, err
+	}
+
+	transaction := model.WalletTransaction{
+		InitiatorID:    sender.ID,
+		IdempotencyKey: transferDto.IdempotencyKey,
+		Kind:           model.WalletChatTransfer,
+		Amount:         transferDto.Amount,
+		Comment:        transferDto.Comment,
+	}
+	// a repeated request must not be stopped by slow mode or the repeat filter
+	replayed, err := s.replayTransferMessage(transaction, nil)
+	if err != nil || replayed.ID != 0 {
+		return replayed, false, err
+	}
+
+	var chat model.Chat
+	if err := s.db.Preload("Users").First(&chat, transferDto.ChatID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return model.Message{}, false, ErrChatNotFound
+		}
+		return model.Message{}, false, err
+	}
+
+	var recipient *model.User
+	isMember := false
+	for i, user := range chat.Users {
+		switch {
+		case user.ID == sender.ID:
+			isMember = true
+		case !chat.IsGroup || user.Username == transferDto.Username:
+			recipient = &chat.Users[i]
+		}
+	}
+	if !isMember || recipient == nil {
+		return model.Message{}, false, ErrTransferRecipient
+	}
+
+	blocked, err := isBlockedByAny(s.db, []uint{recipient.ID}, sender.ID)
+	if err != nil {
+		return model.Message{}, false, err
+	}
+	if blocked {
+		return model.Message{}, false, ErrUserBlocked
+	}
+
+	message := model.Message{
+		Content:  transferDto.Comment,
+		Kind:     model.MessageTransfer,
+		SenderID: sender.ID,
+		ChatID:   chat.ID,
+	}
+	err = s.message.CreateMessageWith(&message, func(tx *gorm.DB) error {
+		from, err := ensureAccount(tx, userAccountName(sender.ID), &sender.ID)
+		if err != nil {
+			return err
+		}
+		escrow, err := ensureAccount(tx, walletEscrowAccount, nil)
+		if err != nil {
+			return err
+		}
+		if err := postTransaction(tx, &transaction, from, escrow); err != nil {
+			return err
+		}
+
+		transfer := model.ChatTransfer{
+			ChatID:        chat.ID,
+			SenderID:      sender.ID,
+			RecipientID:   recipient.ID,
+			Amount:        transferDto.Amount,
+			Status:        model.TransferPending,
+			TransactionID: transaction.ID,
+		}
+		if err := tx.Omit("Sender", "Recipient").Create(&transfer).Error; err != nil {
+			return err
+		}
+		message.TransferID = &transfer.ID
+		return tx.Model(&model.Message{}).Where("id = ?", message.ID).Update("transfer_id", transfer.ID).Error
+	})
+	if err != nil {
+		// a concurrent request with the same key may have made the transfer
+		replayed, err := s.replayTransferMessage(transaction, err)
+		return replayed, false, err
+	}
+	message, err = s.getTransferMessage(message.ID)
+	if err != nil {
+		return model.Message{}, false, err
+	}
+	return message, true, nil
+}
+
+// replayTransferMessage returns the message of the chat transfer made with the
+// idempotency key of the transaction, or cause if there is none
+func (s *WalletService) replayTransferMessage(transaction model.WalletTransaction, cause error) (model.Message, error) {
+	existing, err := s.replay(transaction, cause)
+	if err != nil || existing.ID == 0 {
+		return model.Message{}, err
+	}
+
+	var message model.Message
+	err = s.db.
+		Joins("JOIN chat_transfers ON chat_transfers.id = messages.transfer_id").
+		Where("chat_transfers.transaction_id = ?", existing.ID).
+		First(&message).Error
+	if err != nil {
+		return model.Message{}, err
+	}
+	return s.getTransferMessage(message.ID)
+}
+
+// AcceptTransfer moves a pending transfer from escrow to the recipient
+func (s *WalletService) AcceptTransfer(username string, id uint) (model.ChatTransfer, error) {
+	return s.settleTransfer(username, id, model.TransferAccepted)
+}
+
+// DeclineTransfer refunds a pending transfer to the sender
+func (s *WalletService) DeclineTransfer(username string, id uint) (model.ChatTransfer, error) {
+	return s.settleTransfer(username, id, model.TransferDeclined)
+}
+
+func (s *WalletService) settleTransfer(username string, id uint, status string) (model.ChatTransfer, error) {
+	var recipient model.User
+	if err := s.db.Where(model.User{Username: username}).First(&recipient).Error; err != nil {
+		return model.ChatTransfer{}, err
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var transfer model.ChatTransfer
+		resoult := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND recipient_id = ?", id, recipient.ID).
+			First(&transfer)
+		if resoult.Error != nil {
+			if errors.Is(resoult.Error, gorm.ErrRecordNotFound) {
+				return ErrTransferNotFound
+			}
+			return resoult.Error
+		}
+		if transfer.Status != model.TransferPending {
+			return ErrTransferNotPending
+		}
+
+		escrow, err := ensureAccount(tx, walletEscrowAccount, nil)
+		if err != nil {
+			return err
+		}
+		kind, beneficiaryID := model.WalletChatTransferAccept, transfer.RecipientID
+		if status == model.TransferDeclined {
+			kind, beneficiaryID = model.WalletChatTransferRefund, transfer.SenderID
+		}
+		to, err := ensureAccount(tx, userAccountName(beneficiaryID), &beneficiaryID)
+		if err != nil {
+			return err
+		}
+
+		settlement := model.WalletTransaction{
+			InitiatorID:    recipient.ID,
+			IdempotencyKey: fmt.Sprintf("chat_transfer:%d", transfer.ID),
+			Kind:           kind,
+			Amount:         transfer.Amount,
+		}
+		if err := postTransaction(tx, &settlement, escrow, to); err != nil {
+			return err
+		}
+
+		return tx.Model(&transfer).Updates(map[string]interface{}{
+			"status":        status,
+			"settlement_id": settlement.ID,
+		}).Error
+	})
+	if err != nil {
+		return model.ChatTransfer{}, err
+	}
+
+	var transfer model.ChatTransfer
+	resoult := s.db.
+		Preload("Sender", withDeletedUsers).
+		Preload("Recipient", withDeletedUsers).
+		First(&transfer, id)
+	return transfer, resoult.Error
+}
+
+func (s *WalletService) getTransferMessage(id uint) (model.Message, error) {
+	var message model.Message
+	resoult := s.db.
+		Preload("Sender", withDeletedUsers).
+		Preload("Chat.Users").
+		Scopes(preloadTransfer).
+		First(&message, id)
+	return message, resoult.Error
+}
+
+// preloadTransfer loads transfers of transfer messages with their users
+func preloadTransfer(db *gorm.DB) *gorm.DB {
+	return db.
+		Preload("Transfer.Sender", withDeletedUsers).
+		Preload("Transfer.Recipient", withDeletedUsers)
+}
+
+// GetTransactions returns the history of the user's account, newest first
+func (s *WalletService) GetTransactions(username string, offset, limit int) ([]model.WalletTransactionResponse, error) {
+	if limit <= 0 {
+		limit = DefaultTransactionsLimit
+	}
+	if limit > MaxTransactionsLimit {
+		limit = MaxTransactionsLimit
+	}
+
+	account, err := s.getUserAccount(username)
+	if err != nil {
+		return nil, err
+	}
+
+	transactions := make([]model.WalletTransaction, 0)
+	resoult := s.db.
+		Preload("Entries.Account.User", withDeletedUsers).
+		Where("id IN (?)", s.db.Model(&model.WalletEntry{}).Select("transaction_id").Where("account_id = ?", account.ID)).
+		Order("created_at DESC").
+		Order("id DESC").
+		Offset(offset).
+		Limit(limit).
+		Find(&transactions)
+	if resoult.Error != nil {
+		return nil, resoult.Error
+	}
+
+	transactionsResp := make([]model.WalletTransactionResponse, len(transactions))
+	for i := range transactions {
+		transactionsResp[i] = transactions[i].ToResponse(username)
+	}
+	return transactionsResp, nil
+}
+
+func (s *WalletService) GetTransaction(username string, id uint) (model.WalletTransactionResponse, error) {
+	account, err := s.getUserAccount(username)
+	if err != nil {
+		return model.WalletTransactionResponse{}, err
+	}
+
+	transaction, err := s.getTransaction(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return model.WalletTransactionResponse{}, ErrTransactionNotFound
+		}
+		return model.WalletTransactionResponse{}, err
+	}
+	for _, entry := range transaction.Entries {
+		if entry.AccountID == account.ID {
+			return transaction.ToResponse(username), nil
+		}
+	}
+	return model.WalletTransactionResponse{}, ErrTransactionNotFound
+}
+
+// getUserAccount returns the account of the user, an empty one if they never used the wallet
+func (s *WalletService) getUserAccount(username string) (model.WalletAccount, error) {
+	var user model.User
+	if err := s.db.Where(model.User{Username: username}).First(&user).Error; err != nil {
+		return model.WalletAccount{}, err
+	}
+
+	var account model.WalletAccount
+	resoult := s.db.Where("name = ?", userAccountName(user.ID)).Limit(1).Find(&account)
+	return account, resoult.Error
+}
+
+func (s *WalletService) getTransaction(id uint) (model.WalletTransaction, error) {
+	var transaction model.WalletTransaction
+	resoult := s.db.
+		Preload("Entries.Account.User", withDeletedUsers).
+		First(&transaction, id)
+	return transaction, resoult.Error
+}
+
+// replay returns the transaction stored under the idempotency key of a failed
+// one if it was posted with the same parameters, otherwise the original error
+func (s *WalletService) replay(transaction model.WalletTransaction, cause error) (model.WalletTransaction, error) {
+	var existing model.WalletTransaction
+	resoult := s.db.
+		Where("initiator_id = ? AND idempotency_key = ?", transaction.InitiatorID, transaction.IdempotencyKey).
+		Limit(1).
+		Find(&existing)
+	if resoult.Error != nil || resoult.RowsAffected == 0 {
+		return model.WalletTransaction{}, cause
+	}
+	if existing.Kind != transaction.Kind || existing.Amount != transaction.Amount {
+		return model.WalletTransaction{}, ErrIdempotencyConflict
+	}
+	return s.getTransaction(existing.ID)
+}
+
+func userAccountName(userID uint) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+// ensureAccount creates the account if it doesn't exist yet and returns its ID
+func ensureAccount(tx *gorm.DB, name string, userID *uint) (uint, error) {
+	account := model.WalletAccount{Name: name, UserID: userID}
+	err := tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "name"}}, DoNothing: true}).
+		Create(&account).
+		Error
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Where("name = ?", name).First(&account).Error; err != nil {
+		return 0, err
+	}
+	return account.ID, nil
+}
+
+// postTransaction debits transaction.Amount from one account and credits the
+// other. Both accounts are locked in the order of their IDs, so concurrent
+// transfers between the same accounts can't deadlock.
+func postTransaction(tx *gorm.DB, transaction *model.WalletTransaction, fromID, toID uint) error {
+	var accounts []model.WalletAccount
+	resoult := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id IN ?", []uint{fromID, toID}).
+		Order("id").
+		Find(&accounts)
+	if resoult.Error != nil {
+		return resoult.Error
+	}
+
+	for _, account := range accounts {
+		if account.ID == fromID && account.Name != walletSystemAccount && account.Balance < transaction.Amount {
+			return ErrInsufficientFunds
+		}
+	}
+
+	transaction.Entries = []model.WalletEntry{
+		{AccountID: fromID, Amount: -transaction.Amount},
+		{AccountID: toID, Amount: transaction.Amount},
+	}
+	if err := tx.Create(transaction).Error; err != nil {
+		return err
+	}
+
+	for _, entry := range transaction.Entries {
+		err := tx.Model(&model.WalletAccount{}).
+			Where("id = ?", entry.AccountID).
+			Update("balance", gorm.Expr("balance + ?", entry.Amount)).
+			Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
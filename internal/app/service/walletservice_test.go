@@ -0,0 +1,232 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/VitalyCone/websocket-messenger/internal/app/model"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransfer(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewWalletService(db, rdb, NewMessageService(db, rdb))
+
+	db.Create(&model.User{Username: "alice"})
+	db.Create(&model.User{Username: "bob"})
+
+	_, err := service.Transfer("alice", model.TransferDto{Username: "bob", Amount: 100, IdempotencyKey: "t1"})
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+
+	_, err = service.Deposit("alice", 500, "d1")
+	assert.NoError(t, err)
+
+	transaction, err := service.Transfer("alice", model.TransferDto{Username: "bob", Amount: 150, Comment: "lunch", IdempotencyKey: "t1"})
+	assert.NoError(t, err)
+	resp := transaction.ToResponse("alice")
+	assert.Equal(t, int64(-150), resp.Amount)
+	assert.Equal(t, "bob", resp.Counterparty)
+
+	// the same key doesn't transfer twice
+	repeated, err := service.Transfer("alice", model.TransferDto{Username: "bob", Amount: 150, Comment: "lunch", IdempotencyKey: "t1"})
+	assert.NoError(t, err)
+	assert.Equal(t, transaction.ID, repeated.ID)
+
+	_, err = service.Transfer("alice", model.TransferDto{Username: "bob", Amount: 1, IdempotencyKey: "t1"})
+	assert.ErrorIs(t, err, ErrIdempotencyConflict)
+
+	_, err = service.Transfer("alice", model.TransferDto{Username: "alice", Amount: 1, IdempotencyKey: "t2"})
+	assert.ErrorIs(t, err, ErrTransferSelf)
+
+	balance, err := service.GetBalance("alice")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(350), balance)
+	balance, err = service.GetBalance("bob")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(150), balance)
+
+	var sum int64
+	db.Model(&model.WalletEntry{}).Select("SUM(amount)").Scan(&sum)
+	assert.Equal(t, int64(0), sum)
+}
+
+func TestTransfer_Blocked(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewWalletService(db, rdb, NewMessageService(db, rdb))
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	db.Create(&alice)
+	db.Create(&bob)
+	db.Create(&model.UserBlock{BlockerID: bob.ID, BlockedID: alice.ID})
+	service.Deposit("alice", 100, "d1")
+
+	_, err := service.Transfer("alice", model.TransferDto{Username: "bob", Amount: 10, IdempotencyKey: "t1"})
+	assert.ErrorIs(t, err, ErrUserBlocked)
+}
+
+func TestGetTransactions(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewWalletService(db, rdb, NewMessageService(db, rdb))
+
+	db.Create(&model.User{Username: "alice"})
+	db.Create(&model.User{Username: "bob"})
+	db.Create(&model.User{Username: "carol"})
+
+	service.Deposit("alice", 100, "d1")
+	transfer, _ := service.Transfer("alice", model.TransferDto{Username: "bob", Amount: 40, IdempotencyKey: "t1"})
+
+	history, err := service.GetTransactions("bob", 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+	assert.Equal(t, int64(40), history[0].Amount)
+	assert.Equal(t, "alice", history[0].Counterparty)
+
+	history, err = service.GetTransactions("alice", 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+	assert.Equal(t, model.WalletDeposit, history[1].Kind)
+	assert.Equal(t, int64(100), history[1].Amount)
+
+	_, err = service.GetTransaction("carol", transfer.ID)
+	assert.ErrorIs(t, err, ErrTransactionNotFound)
+	got, err := service.GetTransaction("bob", transfer.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(40), got.Amount)
+}
+
+func TestGetTransactions_Limit(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewWalletService(db, rdb, NewMessageService(db, rdb))
+
+	db.Create(&model.User{Username: "alice"})
+	for i := 0; i <= MaxTransactionsLimit; i++ {
+		_, err := service.Deposit("alice", 1, fmt.Sprintf("d%d", i))
+		assert.NoError(t, err)
+	}
+
+	history, err := service.GetTransactions("alice", 0, 1000)
+	assert.NoError(t, err)
+	assert.Len(t, history, MaxTransactionsLimit)
+
+	history, err = service.GetTransactions("alice", 0, 0)
+	assert.NoError(t, err)
+	assert.Len(t, history, DefaultTransactionsLimit)
+}
+
+func TestSendToChat_AcceptAndDecline(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	service := NewWalletService(db, rdb, NewMessageService(db, rdb))
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	carol := model.User{Username: "carol"}
+	db.Create(&alice)
+	db.Create(&bob)
+	db.Create(&carol)
+	chat := model.Chat{Name: "private", Users: []model.User{alice, bob}}
+	db.Create(&chat)
+	service.Deposit("alice", 100, "d1")
+
+	_, _, err := service.SendToChat("carol", model.CreateChatTransferDto{ChatID: chat.ID, Amount: 10, IdempotencyKey: "c1"})
+	assert.ErrorIs(t, err, ErrTransferRecipient)
+
+	message, created, err := service.SendToChat("alice", model.CreateChatTransferDto{ChatID: chat.ID, Amount: 60, Comment: "rent", IdempotencyKey: "c1"})
+	assert.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, model.MessageTransfer, message.Kind)
+	resp := message.ToResponse()
+	assert.Equal(t, "rent", resp.Content)
+	assert.Equal(t, int64(60), resp.Transfer.Amount)
+	assert.Equal(t, "bob", resp.Transfer.Recipient)
+	assert.Equal(t, model.TransferPending, resp.Transfer.Status)
+
+	repeated, created, err := service.SendToChat("alice", model.CreateChatTransferDto{ChatID: chat.ID, Amount: 60, Comment: "rent", IdempotencyKey: "c1"})
+	assert.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, message.ID, repeated.ID)
+
+	_, _, err = service.SendToChat("alice", model.CreateChatTransferDto{ChatID: chat.ID, Amount: 50, IdempotencyKey: "c2"})
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+
+	_, err = service.AcceptTransfer("alice", *message.TransferID)
+	assert.ErrorIs(t, err, ErrTransferNotFound)
+
+	transfer, err := service.DeclineTransfer("bob", *message.TransferID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.TransferDeclined, transfer.Status)
+	_, err = service.AcceptTransfer("bob", *message.TransferID)
+	assert.ErrorIs(t, err, ErrTransferNotPending)
+
+	balance, _ := service.GetBalance("alice")
+	assert.Equal(t, int64(100), balance)
+
+	message, _, err = service.SendToChat("alice", model.CreateChatTransferDto{ChatID: chat.ID, Amount: 30, IdempotencyKey: "c3"})
+	assert.NoError(t, err)
+	transfer, err = service.AcceptTransfer("bob", *message.TransferID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.TransferAccepted, transfer.Status)
+
+	balance, _ = service.GetBalance("alice")
+	assert.Equal(t, int64(70), balance)
+	balance, _ = service.GetBalance("bob")
+	assert.Equal(t, int64(30), balance)
+}
+
+func TestSendToChat_Filters(t *testing.T) {
+	db := setupTestDB()
+	rdb, _ := redismock.NewClientMock()
+	messageService := NewMessageService(db, rdb)
+	service := NewWalletService(db, rdb, messageService)
+
+	filters, err := newFilters(db, FilterConfig{
+		BannedWords:       []string{"scam"},
+		BannedWordsAction: "reject",
+		RepeatLimit:       1,
+		RepeatWindow:      time.Minute,
+	})
+	assert.NoError(t, err)
+	messageService.UseFilters(filters)
+
+	alice := model.User{Username: "alice"}
+	bob := model.User{Username: "bob"}
+	db.Create(&alice)
+	db.Create(&bob)
+	chat := model.Chat{Name: "private", Users: []model.User{alice, bob}}
+	db.Create(&chat)
+	service.Deposit("alice", 100, "d1")
+
+	_, _, err = service.SendToChat("alice", model.CreateChatTransferDto{ChatID: chat.ID + 1, Amount: 10, IdempotencyKey: "c1"})
+	assert.ErrorIs(t, err, ErrChatNotFound)
+
+	_, _, err = service.SendToChat("alice", model.CreateChatTransferDto{ChatID: chat.ID, Amount: 10, Comment: "not a scam", IdempotencyKey: "c1"})
+	assert.ErrorIs(t, err, ErrMessageRejected)
+	balance, _ := service.GetBalance("alice")
+	assert.Equal(t, int64(100), balance)
+
+	// transfers without a comment are not repeats of each other
+	for _, key := range []string{"c2", "c3"} {
+		_, created, err := service.SendToChat("alice", model.CreateChatTransferDto{ChatID: chat.ID, Amount: 10, IdempotencyKey: key})
+		assert.NoError(t, err)
+		assert.True(t, created)
+	}
+
+	message, created, err := service.SendToChat("alice", model.CreateChatTransferDto{ChatID: chat.ID, Amount: 10, Comment: "rent", IdempotencyKey: "c4"})
+	assert.NoError(t, err)
+	assert.True(t, created)
+	// the repeat filter doesn't stop a repeated request
+	repeated, created, err := service.SendToChat("alice", model.CreateChatTransferDto{ChatID: chat.ID, Amount: 10, Comment: "rent", IdempotencyKey: "c4"})
+	assert.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, message.ID, repeated.ID)
+
+	balance, _ = service.GetBalance("alice")
+	assert.Equal(t, int64(70), balance)
+}